@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// runAgent registers with a coordinator and runs the scan jobs it hands
+// out, streaming each scan's records back as they are found.
+func runAgent(args []string) {
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s agent [flags] --coordinator <url> --root <directory> [--root <directory>...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	coordinatorURL := flags.String("coordinator", "", "coordinator URL, e.g. http://inventory.example.com:8070 (required)")
+	hostname, _ := os.Hostname()
+	name := flags.String("name", hostname, "name to register as; also recorded in the host column")
+	var roots stringList
+	flags.Var(&roots, "root", "directory jobs may scan (it and everything below it); repeatable, the first is the default")
+	token := flags.String("token", os.Getenv(fleetTokenEnv), "token the coordinator expects (default: from "+fleetTokenEnv+")")
+	workers := flags.Int("workers", 0, "number of record-building workers (default: number of CPUs)")
+	retries := flags.Int("retries", 2, "retries for transient filesystem errors")
+	flags.Parse(args)
+
+	if *coordinatorURL == "" || len(roots) == 0 || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	// The name also names the agent's output files on the coordinator
+	if err := checkAgentName(*name); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	a := &agent{
+		base:     strings.TrimSuffix(*coordinatorURL, "/"),
+		name:     *name,
+		hostname: hostname,
+		token:    *token,
+		opts: []scan.Option{
+			scan.WithWorkers(*workers),
+			scan.WithRetry(scan.RetryPolicy{Attempts: *retries, Backoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second}),
+		},
+	}
+	for _, root := range roots {
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Error: %s is not a directory\n", root)
+			os.Exit(1)
+		}
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		a.roots = append(a.roots, abs)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("Agent %s polling %s for scans of %v\n", a.name, a.base, a.roots)
+	a.run(ctx)
+}
+
+// agent polls a coordinator for tasks and runs them one at a time.
+type agent struct {
+	base     string // Coordinator URL without a trailing slash
+	name     string
+	hostname string
+	token    string
+	roots    []string // Absolute; tasks are confined to these trees
+	opts     []scan.Option
+	client   http.Client
+}
+
+// run polls until ctx is done, backing off while the coordinator can't
+// be reached.
+func (a *agent) run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		t, err := a.poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Warning: polling coordinator: %v; retrying in %s\n", err, backoff)
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, time.Minute)
+			continue
+		}
+		backoff = time.Second
+		if t != nil {
+			a.runTask(ctx, t)
+		}
+	}
+}
+
+// request sends a request to the coordinator and checks its status.
+func (a *agent) request(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, a.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Agent", a.name)
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var e struct{ Error string }
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&e)
+		return nil, fmt.Errorf("%s %s: %s %s", method, path, resp.Status, e.Error)
+	}
+	return resp, nil
+}
+
+// poll registers with the coordinator and waits for a task, returning
+// nil when none came.
+func (a *agent) poll(ctx context.Context) (*fleetTask, error) {
+	body, err := json.Marshal(agentHello{Hostname: a.hostname, Roots: a.roots})
+	if err != nil {
+		return nil, err
+	}
+	// The coordinator holds the poll open for fleetPollWait
+	ctx, cancel := context.WithTimeout(ctx, 2*fleetPollWait)
+	defer cancel()
+	resp, err := a.request(ctx, http.MethodPost, "/agents/"+url.PathEscape(a.name)+"/poll", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var t fleetTask
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// runTask scans the task's root, streaming the records to the coordinator
+// as JSON lines, and reports how the scan ended.
+func (a *agent) runTask(ctx context.Context, t *fleetTask) {
+	start := time.Now()
+	res := taskResult{}
+	err := a.scan(ctx, t, &res)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	body, _ := json.Marshal(res)
+	resp, reportErr := a.request(ctx, http.MethodPost, "/tasks/"+url.PathEscape(t.ID)+"/result", bytes.NewReader(body))
+	if reportErr == nil {
+		resp.Body.Close()
+	} else {
+		fmt.Fprintf(os.Stderr, "Error reporting task %s: %v\n", t.ID, reportErr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Task %s failed: %v\n", t.ID, err)
+		return
+	}
+	fmt.Printf("Task %s: sent %d records in %s\n", t.ID, res.Stats.Files, time.Since(start).Round(time.Millisecond))
+}
+
+// scan runs the task's scan, uploading records while they are written,
+// and fills in res.Stats.
+func (a *agent) scan(ctx context.Context, t *fleetTask, res *taskResult) error {
+	root, err := confine(a.roots, t.Scan.Root)
+	if err != nil {
+		return err
+	}
+	reqOpts, err := t.Scan.options()
+	if err != nil {
+		return err
+	}
+	// Records from every host land in one output, so each says where it
+	// came from
+	host := scan.ComputedColumn("host", func(*scan.Record) any { return a.name })
+	if len(t.Scan.Columns) > 0 && !slices.Contains(t.Scan.Columns, "host") {
+		reqOpts = append(reqOpts, scan.WithColumns(append(slices.Clone(t.Scan.Columns), "host")...))
+	}
+
+	pr, pw := io.Pipe()
+	opts := append(slices.Clone(a.opts), reqOpts...)
+	opts = append(opts,
+		scan.WithTransform(host),
+		scan.WithSink(scan.NewJSONSink(pw)),
+		// The coordinator records failures as a whole; skipped entries
+		// are only counted
+		scan.WithWarnFunc(func(string, error) {}),
+	)
+	scanner := scan.New(root, opts...)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	scanErr := make(chan error, 1)
+	var result scan.Result
+	go func() {
+		var err error
+		result, err = scanner.Run(ctx)
+		pw.CloseWithError(err)
+		scanErr <- err
+	}()
+
+	resp, uploadErr := a.request(ctx, http.MethodPut, "/tasks/"+url.PathEscape(t.ID)+"/output", pr)
+	if uploadErr == nil {
+		resp.Body.Close()
+	} else {
+		// Stop a scan with nowhere to send its records
+		cancel()
+	}
+	pr.Close()
+	err = <-scanErr
+	res.Stats = &result.Stats
+	if uploadErr != nil && (err == nil || errors.Is(err, context.Canceled)) {
+		return uploadErr
+	}
+	return err
+}
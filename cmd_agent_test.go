@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAgentRefusesTasksOutsideRoots(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{root, outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skip("no symlinks:", err)
+	}
+	a := &agent{roots: []string{root}}
+	for _, dir := range []string{outside, filepath.Join(root, "link")} {
+		task := &fleetTask{ID: "t1", Scan: scanRequest{Root: dir}}
+		if err := a.scan(context.Background(), task, &taskResult{}); err == nil {
+			t.Errorf("agent ran a task rooted at %s", dir)
+		}
+	}
+}
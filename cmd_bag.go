@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// bagVersion is the BagIt version written, per RFC 8493.
+const bagVersion = "1.0"
+
+// runBag turns a directory into a BagIt bag in place: its contents move
+// into data/ and the tag files are written beside it.
+func runBag(args []string) {
+	flags := flag.NewFlagSet("bag", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s bag [flags] <directory>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Makes the directory a BagIt bag: its contents move into data/ and bagit.txt,\n")
+		fmt.Fprintf(os.Stderr, "bag-info.txt, manifest-<hash>.txt and tagmanifest-<hash>.txt are written.\n")
+		flags.PrintDefaults()
+	}
+	hashName := flags.String("hash", "sha256", "manifest algorithm: md5, sha1, sha256")
+	workers := flags.Int("workers", 0, "number of hashing workers (default: number of CPUs)")
+	var info stringList
+	flags.Var(&info, "info", "add a LABEL=VALUE line to bag-info.txt, e.g. Source-Organization=Example Archive; repeatable")
+	positional := parseArgs(flags, args)
+
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	dir := positional[0]
+	algo, err := scan.ParseHashAlgorithm(*hashName)
+	if err == nil && algo == scan.NoHash {
+		err = errors.New("a bag needs a hash algorithm")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *workers <= 0 {
+		*workers = runtime.NumCPU()
+	}
+	var infoLines []string
+	for _, kv := range info {
+		label, value, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(label) == "" || strings.ContainsAny(label, ":\r\n") || strings.ContainsAny(value, "\r\n") {
+			fmt.Fprintf(os.Stderr, "Error: --info wants LABEL=VALUE on one line, not %q\n", kv)
+			os.Exit(1)
+		}
+		infoLines = append(infoLines, strings.TrimSpace(label)+": "+value)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: %s is not an accessible directory\n", dir)
+		os.Exit(1)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "bagit.txt")); err == nil {
+		fmt.Fprintf(os.Stderr, "Error: %s is already a bag\n", dir)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	payload, err := hashPayload(ctx, dir, algo, *workers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	// Nothing has moved yet, so a failure up to here leaves the tree alone
+	if err := moveIntoData(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error moving the contents of %s into data/: %v\n", dir, err)
+		os.Exit(1)
+	}
+	if err := writeTagFiles(dir, algo, payload, infoLines); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing tag files: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Bagged %d files (%s) in %s\n", len(payload.lines), scan.FormatBytes(payload.bytes), dir)
+}
+
+// bagPayload is the manifest of a bag's payload.
+type bagPayload struct {
+	lines []string // Manifest lines, sorted by path
+	bytes int64
+}
+
+// hashPayload hashes every file under dir and returns manifest lines for
+// them as they will be once moved into data/. Any entry that can't be
+// read fails the bag, since a bag must list all of its payload.
+func hashPayload(ctx context.Context, dir string, algo scan.HashAlgorithm, workers int) (*bagPayload, error) {
+	p := &bagPayload{}
+	var problems []string
+	observer := scan.ObserverFunc(func(r *scan.Record) {
+		rel, err := filepath.Rel(dir, r.Path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", r.Path, err))
+			return
+		}
+		if r.Hash == "" {
+			// Symlinks and devices have no content a validator could check
+			problems = append(problems, fmt.Sprintf("%s: not a regular file", r.Path))
+			return
+		}
+		p.lines = append(p.lines, r.Hash+" "+bagPath("data/"+filepath.ToSlash(rel)))
+		p.bytes += r.Size
+	})
+	scanner := scan.New(dir,
+		scan.WithHash(algo),
+		scan.WithWorkers(workers),
+		scan.WithColumns("file_path", "size", "hash"),
+		scan.WithSink(scan.Discard),
+		scan.WithObserver(observer),
+		scan.WithWarnFunc(func(path string, err error) {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+		}),
+	)
+	stopSpinner := spinner("Hashing...", scanner.Count, 0)
+	_, err := scanner.Run(ctx)
+	stopSpinner()
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		for _, msg := range problems {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		return nil, fmt.Errorf("%d entries can't be bagged; %s was left as it was", len(problems), dir)
+	}
+	sort.Slice(p.lines, func(i, j int) bool {
+		return p.lines[i][strings.IndexByte(p.lines[i], ' '):] < p.lines[j][strings.IndexByte(p.lines[j], ' '):]
+	})
+	return p, nil
+}
+
+// bagPath escapes a path for a manifest line as RFC 8493 requires.
+var bagPath = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace
+
+// moveIntoData moves everything in dir into dir/data. Entries are first
+// gathered in a temporary directory, so an entry already named data is
+// moved like any other.
+func moveIntoData(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(dir, ".bag-data-")
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(dir, e.Name()), filepath.Join(tmp, e.Name())); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, "data"))
+}
+
+// writeTagFiles writes the bag declaration, bag-info.txt, the payload
+// manifest and a tag manifest covering the other three.
+func writeTagFiles(dir string, algo scan.HashAlgorithm, payload *bagPayload, info []string) error {
+	name := string(algo)
+	manifest := "manifest-" + name + ".txt"
+	bagInfo := append([]string{
+		"Bagging-Date: " + time.Now().Format(time.DateOnly),
+		"Bag-Software-Agent: read_file_paths",
+		fmt.Sprintf("Payload-Oxum: %d.%d", payload.bytes, len(payload.lines)),
+		"Bag-Size: " + scan.FormatBytes(payload.bytes),
+	}, info...)
+	tags := []struct {
+		name  string
+		lines []string
+	}{
+		{"bagit.txt", []string{"BagIt-Version: " + bagVersion, "Tag-File-Character-Encoding: UTF-8"}},
+		{"bag-info.txt", bagInfo},
+		{manifest, payload.lines},
+	}
+	var tagLines []string
+	for _, t := range tags {
+		path := filepath.Join(dir, t.name)
+		if err := writeLines(path, t.lines); err != nil {
+			return err
+		}
+		sum, _, err := scan.HashFile(path, algo)
+		if err != nil {
+			return err
+		}
+		tagLines = append(tagLines, sum+" "+t.name)
+	}
+	return writeLines(filepath.Join(dir, "tagmanifest-"+name+".txt"), tagLines)
+}
+
+func writeLines(path string, lines []string) error {
+	f, err := scan.CreateAtomic(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		w.WriteString(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
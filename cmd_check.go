@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/pcoelho00/read_file_paths/report"
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// checkExtra is check's status for a file the manifest doesn't expect.
+const checkExtra = "extra"
+
+// runCheck validates a directory against a list of the files expected
+// in it, for CI jobs that check a build or delivery is complete.
+func runCheck(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s check [flags] --manifest <expected> <directory>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "The manifest is a scan output with a file_path column, and optionally size and hash.\n")
+		fmt.Fprintf(os.Stderr, "Exits 0 when the directory matches, 1 when something differs, 2 on errors.\n")
+		flags.PrintDefaults()
+	}
+	manifest := flags.String("manifest", "", "the expected files (required)")
+	strip := flags.String("strip", "", "remove this prefix from the manifest's paths, e.g. the root it was scanned from")
+	allowExtra := flags.Bool("allow-extra", false, "don't report files the manifest doesn't list")
+	hashName := flags.String("hash", "", "hash algorithm of the manifest: md5, sha1, sha256 (default: from the digest length)")
+	workers := flags.Int("workers", 0, "number of hashing workers (default: number of CPUs)")
+	format := flags.String("format", "csv", "output format: csv or json")
+	outPath := flags.String("o", "", "write the problems to this file instead of stdout")
+	positional := parseArgs(flags, args)
+
+	if len(positional) != 1 || *manifest == "" {
+		flags.Usage()
+		os.Exit(exitTrouble)
+	}
+	dir := positional[0]
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unsupported format %q (want csv or json)\n", *format)
+		os.Exit(exitTrouble)
+	}
+	algo, err := scan.ParseHashAlgorithm(*hashName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitTrouble)
+	}
+	if *workers <= 0 {
+		*workers = runtime.NumCPU()
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: %s is not an accessible directory\n", dir)
+		os.Exit(exitTrouble)
+	}
+
+	entries, err := readManifest(*manifest, false)
+	if err == nil {
+		err = locateEntries(entries, dir, *strip)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitTrouble)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	problems := verifyEntries(ctx, entries, algo, *workers)
+	if !*allowExtra {
+		listed := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			listed[e.file] = true
+		}
+		found, err := unlistedFiles(ctx, dir, listed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error scanning %s: %v\n", dir, err)
+			os.Exit(exitTrouble)
+		}
+		for _, path := range found {
+			rel, _ := filepath.Rel(dir, path)
+			problems = append(problems, []any{checkExtra, filepath.ToSlash(rel), "", "", ""})
+		}
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Error: interrupted")
+		os.Exit(exitTrouble)
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i][1].(string) < problems[j][1].(string) })
+
+	t := report.Table{Name: "check", Title: "Check problems", Columns: problemColumns, Rows: problems}
+	if err := writeProblems(t, *format, *outPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing problems: %v\n", err)
+		os.Exit(exitTrouble)
+	}
+
+	counts := make(map[string]int)
+	for _, p := range problems {
+		counts[p[0].(string)]++
+	}
+	fmt.Fprintf(os.Stderr, "%d files expected: %d ok, %d missing, %d mismatched, %d unreadable, %d extra\n",
+		len(entries), len(entries)-counts[verifyMismatch]-counts[verifyMissing]-counts[verifyUnreadable],
+		counts[verifyMissing], counts[verifyMismatch], counts[verifyUnreadable], counts[checkExtra])
+	if len(problems) > 0 {
+		os.Exit(exitChanged)
+	}
+}
+
+// locateEntries points each entry at its file under dir. Manifest paths
+// are relative to dir once strip is removed; a manifest from a scan of
+// dir itself, whose paths all start with dir, works as it is. Entries
+// are reported by their path relative to dir.
+func locateEntries(entries []manifestEntry, dir, strip string) error {
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	scanned := prefix != "."+string(filepath.Separator) && len(entries) > 0
+	for i := range entries {
+		p := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(entries[i].path, strip)))
+		entries[i].path = p
+		scanned = scanned && strings.HasPrefix(p, prefix)
+	}
+	for i := range entries {
+		p := entries[i].path
+		if scanned {
+			p = strings.TrimPrefix(p, prefix)
+		}
+		if !filepath.IsLocal(p) {
+			return fmt.Errorf("manifest path %s is not inside %s (see --strip)", p, dir)
+		}
+		entries[i].path = filepath.ToSlash(p)
+		entries[i].file = filepath.Join(dir, p)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// runConvert rewrites a scan output in another format without rescanning.
+func runConvert(args []string) {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s convert [flags] <input> --to <format>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	to := flags.String("to", "", "output format: "+strings.Join(formatNames(), ", ")+" (default: from -o's extension)")
+	outPath := flags.String("o", "", "output file (default: the input with the new format's extension)")
+	inputs := parseArgs(flags, args)
+
+	if len(inputs) != 1 || (*to == "" && *outPath == "") {
+		flags.Usage()
+		os.Exit(1)
+	}
+	input := inputs[0]
+	format := *to
+	if format == "" {
+		var err error
+		if format, err = formatForPath(*outPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if _, ok := sinkFormats[format]; !ok {
+		fmt.Fprintf(os.Stderr, "Error: unsupported format %q (available: %s)\n", format, strings.Join(formatNames(), ", "))
+		os.Exit(1)
+	}
+	if *outPath == "" {
+		*outPath = strings.TrimSuffix(input, filepath.Ext(input)) + "." + format
+	}
+	inAbs, _ := filepath.Abs(input)
+	outAbs, _ := filepath.Abs(*outPath)
+	if inAbs == outAbs {
+		fmt.Fprintf(os.Stderr, "Error: output %s would overwrite the input\n", *outPath)
+		os.Exit(1)
+	}
+
+	r, err := scan.OpenOutput(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer r.Close()
+
+	f, err := scan.CreateAtomic(*outPath)
+	var n int
+	if err == nil {
+		if n, err = writeRows(format, f, r.Columns(), scan.SafeCSV(r), r.Next); err != nil {
+			f.Abort()
+		} else {
+			err = f.Commit()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error converting %s: %v\n", input, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d rows to %s\n", n, *outPath)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runCoordinator hands scan jobs to agents on many hosts and collects
+// their records into fleet-wide outputs.
+func runCoordinator(args []string) {
+	flags := flag.NewFlagSet("coordinator", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s coordinator [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Runs scan jobs on the hosts running '%s agent' and collects their records.\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", ":8070", "address to listen on")
+	dataDir := flags.String("data-dir", "fleet", "directory holding the records agents send")
+	keep := flags.Int("keep", 20, "finished jobs kept before the oldest are deleted")
+	token := flags.String("token", os.Getenv(fleetTokenEnv), "shared token agents and clients must send as a bearer token (default: from "+fleetTokenEnv+")")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *keep <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: keep must be positive\n")
+		os.Exit(1)
+	}
+	if *token == "" {
+		fmt.Fprintf(os.Stderr, "Warning: no --token; anyone reaching %s can register agents and start scans\n", *addr)
+	}
+	c, err := newCoordinator(*dataDir, *keep, *token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	httpServer := &http.Server{Addr: *addr, Handler: c.handler()}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdown)
+	}()
+	fmt.Printf("Coordinating agents on %s\n", *addr)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "Error serving: %v\n", err)
+		os.Exit(1)
+	}
+}
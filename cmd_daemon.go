@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/cron"
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// daemonConfig is the --config file of the daemon command.
+type daemonConfig struct {
+	SMTP *smtpConfig `json:"smtp"` // Used by email notifiers
+	Jobs []daemonJob `json:"jobs"`
+}
+
+// daemonJob is one scheduled scan.
+type daemonJob struct {
+	Name      string           `json:"name"`
+	Root      string           `json:"root"`
+	Schedule  string           `json:"schedule"`
+	OutputDir string           `json:"output_dir"`
+	Keep      int              `json:"keep"`
+	Format    string           `json:"format"`
+	Hash      string           `json:"hash"`
+	Columns   []string         `json:"columns"`
+	Exclude   []string         `json:"exclude"` // Base name globs
+	Workers   int              `json:"workers"`
+	Retries   *int             `json:"retries"`
+	NotifyURL string           `json:"notify_url"`
+	Notify    []notifierConfig `json:"notify"`
+
+	schedule  *cron.Schedule
+	opts      []scan.Option
+	notifiers []*notifier
+}
+
+// prepare validates the job and fills in defaults. smtp is the config
+// file's mail server, nil when there is none.
+func (j *daemonJob) prepare(smtp *smtpConfig) error {
+	if j.Root == "" {
+		return errors.New("root is required")
+	}
+	if j.Name == "" {
+		j.Name = filepath.Base(filepath.Clean(j.Root))
+	}
+	var err error
+	if j.schedule, err = cron.Parse(j.Schedule); err != nil {
+		return err
+	}
+	if j.OutputDir == "" {
+		j.OutputDir = "."
+	}
+	if j.Keep <= 0 {
+		j.Keep = 7
+	}
+	if j.Format == "" {
+		j.Format = "csv"
+	}
+	if _, err := newSink(j.Format, nil); err != nil {
+		return err
+	}
+	algo, err := scan.ParseHashAlgorithm(j.Hash)
+	if err != nil {
+		return err
+	}
+	retries := 2
+	if j.Retries != nil {
+		retries = *j.Retries
+	}
+	j.opts = []scan.Option{
+		scan.WithWorkers(j.Workers),
+		scan.WithHash(algo),
+		scan.WithColumns(j.Columns...),
+		scan.WithRetry(scan.RetryPolicy{Attempts: retries, Backoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second}),
+	}
+	if len(j.Exclude) > 0 {
+		f, err := scan.ExcludeNames(j.Exclude...)
+		if err != nil {
+			return err
+		}
+		j.opts = append(j.opts, scan.WithFilter(f))
+	}
+	if _, err := scan.New(j.Root, j.opts...).Columns(); err != nil {
+		return err
+	}
+	for _, cfg := range j.Notify {
+		n, err := newNotifier(cfg, smtp)
+		if err != nil {
+			return err
+		}
+		j.notifiers = append(j.notifiers, n)
+	}
+	return nil
+}
+
+// runDaemon runs scans on cron schedules until interrupted, keeping the
+// last few outputs of each job.
+func runDaemon(args []string) {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s daemon --config jobs.json\n       %s daemon --schedule SPEC [flags] <directory>\n", os.Args[0], os.Args[0])
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "", "JSON file listing jobs")
+	schedule := flags.String("schedule", "", `cron schedule for a single job, e.g. "0 2 * * *"`)
+	outputDir := flags.String("output-dir", ".", "where a single job's outputs are written")
+	keep := flags.Int("keep", 7, "outputs a single job keeps; older ones are deleted")
+	format := flags.String("format", "csv", "output format: "+strings.Join(formatNames(), ", "))
+	hashName := flags.String("hash", "none", "content hash: none, md5, sha1, sha256")
+	columnList := flags.String("columns", "", "comma-separated output columns")
+	notifyURL := flags.String("notify-url", "", "POST a JSON summary to this URL after each run of a single job")
+	runNow := flags.Bool("run-now", false, "also run every job once at startup")
+	flags.Parse(args)
+
+	var cfg daemonConfig
+	switch {
+	case *configPath != "" && flags.NArg() == 0:
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing %s: %v\n", *configPath, err)
+			os.Exit(1)
+		}
+	case *configPath == "" && flags.NArg() == 1 && *schedule != "":
+		cfg.Jobs = []daemonJob{{
+			Root:      flags.Arg(0),
+			Schedule:  *schedule,
+			OutputDir: *outputDir,
+			Keep:      *keep,
+			Format:    *format,
+			Hash:      *hashName,
+			Columns:   scan.ParseColumns(*columnList),
+			NotifyURL: *notifyURL,
+		}}
+	default:
+		flags.Usage()
+		os.Exit(1)
+	}
+	if len(cfg.Jobs) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no jobs configured\n")
+		os.Exit(1)
+	}
+	names := make(map[string]bool)
+	for i := range cfg.Jobs {
+		j := &cfg.Jobs[i]
+		if err := j.prepare(cfg.SMTP); err != nil {
+			fmt.Fprintf(os.Stderr, "Error in job %d (%s): %v\n", i+1, j.Name, err)
+			os.Exit(1)
+		}
+		if names[j.Name] {
+			fmt.Fprintf(os.Stderr, "Error: duplicate job name %q\n", j.Name)
+			os.Exit(1)
+		}
+		names[j.Name] = true
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var wg sync.WaitGroup
+	for i := range cfg.Jobs {
+		j := &cfg.Jobs[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.loop(ctx, *runNow)
+		}()
+	}
+	wg.Wait()
+}
+
+// loop runs the job at each scheduled time. A run that overlaps the next
+// slot simply delays it; runs never stack.
+func (j *daemonJob) loop(ctx context.Context, runNow bool) {
+	if runNow {
+		j.run(ctx)
+	}
+	for {
+		next := j.schedule.Next(time.Now())
+		if next.IsZero() {
+			j.logf("schedule %q never fires, stopping", j.Schedule)
+			return
+		}
+		j.logf("next run at %s", next.Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		j.run(ctx)
+	}
+}
+
+// run performs one scan into a timestamped output and prunes old ones.
+func (j *daemonJob) run(ctx context.Context) {
+	if err := os.MkdirAll(j.OutputDir, 0o755); err != nil {
+		j.logf("error: %v", err)
+		j.notify("", scan.Stats{Start: time.Now()}, err)
+		return
+	}
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	path := filepath.Join(j.OutputDir, fmt.Sprintf("%s_%s.%s", j.Name, stamp, j.Format))
+	out, err := scan.CreateAtomic(path)
+	if err != nil {
+		j.logf("error: %v", err)
+		j.notify("", scan.Stats{Start: time.Now()}, err)
+		return
+	}
+	sink, _ := newSink(j.Format, out)
+	opts := append(j.opts[:len(j.opts):len(j.opts)],
+		scan.WithSink(sink),
+		scan.WithFilter(excludeOwnFiles(path)),
+		scan.WithWarnFunc(func(p string, err error) {
+			if !errors.Is(err, scan.ErrVanished) {
+				j.logf("warning: skipping %s: %v", p, err)
+			}
+		}),
+	)
+	scanner := scan.New(j.Root, opts...)
+	j.logf("scan of %s started", j.Root)
+	res, err := scanner.Run(ctx)
+	st := res.Stats
+	if err != nil {
+		out.Abort()
+		j.logf("scan failed: %v", err)
+		j.notify(path, st, err)
+		return
+	}
+	if err := out.Commit(); err != nil {
+		j.logf("error finalizing %s: %v", path, err)
+		j.notify(path, st, err)
+		return
+	}
+	if err := writeSummaryJSON(strings.TrimSuffix(path, "."+j.Format)+scanSummaryExt, newScanSummary(res, []string{j.Root}, path, "", nil)); err != nil {
+		j.logf("error writing summary: %v", err)
+	}
+	j.logf("scan finished: %d files, %d skipped, %s -> %s", st.Files, st.Skipped, st.Elapsed.Round(time.Millisecond), path)
+	j.notify(path, st, nil)
+	j.prune()
+}
+
+// notify sends the job's completion notifications, if any are configured.
+func (j *daemonJob) notify(output string, st scan.Stats, scanErr error) {
+	n := newScanNotice(j.Root, output, "", st, scanErr)
+	n.Job = j.Name
+	if j.NotifyURL != "" {
+		if err := notify(j.NotifyURL, n); err != nil {
+			j.logf("error sending notification: %v", err)
+		}
+	}
+	for _, nt := range j.notifiers {
+		if err := nt.send(n); err != nil {
+			j.logf("error sending notification: %v", err)
+		}
+	}
+}
+
+// prune deletes all but the newest Keep outputs (and their summaries).
+func (j *daemonJob) prune() {
+	matches, err := filepath.Glob(filepath.Join(j.OutputDir, globEscape(j.Name)+"_*."+j.Format))
+	if err != nil {
+		return
+	}
+	var outputs []string
+	for _, m := range matches {
+		// JSON outputs share the extension of the summaries
+		if !strings.HasSuffix(m, ".summary.json") {
+			outputs = append(outputs, m)
+		}
+	}
+	if len(outputs) <= j.Keep {
+		return
+	}
+	// Timestamps sort lexically
+	sort.Strings(outputs)
+	for _, path := range outputs[:len(outputs)-j.Keep] {
+		if err := os.Remove(path); err != nil {
+			j.logf("error removing old output: %v", err)
+			continue
+		}
+		os.Remove(strings.TrimSuffix(path, "."+j.Format) + ".summary.json")
+		j.logf("removed old output %s", path)
+	}
+}
+
+func (j *daemonJob) logf(format string, args ...any) {
+	fmt.Printf("%s [%s] %s\n", time.Now().Format(time.RFC3339), j.Name, fmt.Sprintf(format, args...))
+}
+
+// globEscape quotes glob metacharacters in a literal name.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pcoelho00/read_file_paths/report"
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Exit codes of diff, following diff(1).
+const (
+	exitSame    = 0
+	exitChanged = 1
+	exitTrouble = 2
+)
+
+// runDiff compares two scan outputs and lists added, removed and changed
+// files.
+func runDiff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s diff [flags] <old> <new>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "With --baseline, changes are also classified against a known-good scan.\n")
+		fmt.Fprintf(os.Stderr, "Exits 0 when the outputs match, 1 when they differ, 2 on errors.\n")
+		flags.PrintDefaults()
+	}
+	compareList := flags.String("compare", "", "comma-separated columns to compare (default: every column in both, e.g. size,mtime,hash)")
+	format := flags.String("format", "csv", "output format: csv or json")
+	outPath := flags.String("o", "", "write the changes to this file instead of stdout")
+	baseline := flags.String("baseline", "", "also compare with this baseline scan, reporting restored and recreated files")
+	positional := parseArgs(flags, args)
+
+	if len(positional) != 2 {
+		flags.Usage()
+		os.Exit(exitTrouble)
+	}
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unsupported format %q (want csv or json)\n", *format)
+		os.Exit(exitTrouble)
+	}
+
+	res, err := diffOutputs(*baseline, positional[0], positional[1], scan.ParseColumns(*compareList))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitTrouble)
+	}
+
+	t := diffTable(res)
+	write := func(w io.Writer) error {
+		if *format == "json" {
+			return report.WriteTableJSON(w, t)
+		}
+		return report.WriteCSV(w, t)
+	}
+	if *outPath == "" {
+		err = write(os.Stdout)
+	} else {
+		var f *scan.AtomicFile
+		if f, err = scan.CreateAtomic(*outPath); err == nil {
+			if err = write(f); err != nil {
+				f.Abort()
+			} else {
+				err = f.Commit()
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing changes: %v\n", err)
+		os.Exit(exitTrouble)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d added, %d removed, %d changed",
+		res.Count(scan.ChangeAdded), res.Count(scan.ChangeRemoved), res.Count(scan.ChangeModified))
+	if res.Baseline {
+		fmt.Fprintf(os.Stderr, ", %d restored, %d recreated", res.Count(scan.ChangeRestored), res.Count(scan.ChangeRecreated))
+	}
+	fmt.Fprintln(os.Stderr)
+	if len(res.Changes) > 0 {
+		os.Exit(exitChanged)
+	}
+}
+
+// diffOutputs compares two outputs, or three when basePath is set.
+func diffOutputs(basePath, oldPath, newPath string, compare []string) (*scan.DiffResult, error) {
+	old, err := scan.OpenOutput(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	defer old.Close()
+	cur, err := scan.OpenOutput(newPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+	if basePath == "" {
+		return scan.Diff(old, cur, compare)
+	}
+	base, err := scan.OpenOutput(basePath)
+	if err != nil {
+		return nil, err
+	}
+	defer base.Close()
+	return scan.Diff3(base, old, cur, compare)
+}
+
+// diffTable lays changes out as change, file_path, changed_fields and an
+// old_/new_ pair per compared column, led by base_ for three-way diffs.
+func diffTable(res *scan.DiffResult) report.Table {
+	t := report.Table{Name: "diff", Title: "Changes", Columns: []string{"change", "file_path", "changed_fields"}}
+	for _, col := range res.Columns {
+		if res.Baseline {
+			t.Columns = append(t.Columns, "base_"+col)
+		}
+		t.Columns = append(t.Columns, "old_"+col, "new_"+col)
+	}
+	value := func(values []string, i int) any {
+		if values == nil {
+			return nil
+		}
+		return values[i]
+	}
+	for _, c := range res.Changes {
+		row := []any{c.Kind, c.Path, c.Fields}
+		for i := range res.Columns {
+			if res.Baseline {
+				row = append(row, value(c.Base, i))
+			}
+			row = append(row, value(c.Old, i), value(c.New, i))
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
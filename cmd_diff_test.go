@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffFlagsAfterOutputs(t *testing.T) {
+	dir := scanFixture(t)
+	out, ok := runMain(t, dir, "diff", "file_paths.csv", "file_paths.csv", "--format", "json", "-o", "changes.json")
+	if !ok {
+		t.Fatalf("diff of a scan with itself failed:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "changes.json")); err != nil {
+		t.Fatalf("diff wrote no output: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/report"
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// runDupes finds duplicate files under a directory. Only files sharing a
+// size are hashed, so it reads far less than a scan with --hash.
+func runDupes(args []string) {
+	flags := flag.NewFlagSet("dupes", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s dupes [flags] <directory>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	workers := flags.Int("workers", 0, "number of walking and hashing workers (default: number of CPUs)")
+	hashName := flags.String("hash", "sha256", "content hash used to confirm duplicates: md5, sha1, sha256")
+	minSize := flags.Int64("min-size", 1, "ignore files smaller than this many bytes")
+	retries := flags.Int("retries", 2, "retries for transient filesystem errors")
+	reportFormat := flags.String("report-format", "text", "how the result is emitted: text (stdout), csv or json (files)")
+	reportDir := flags.String("report-dir", ".", "directory for csv/json report files")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	dirPath := flags.Arg(0)
+
+	hashAlgo, err := scan.ParseHashAlgorithm(*hashName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error accessing path: %v\n", err)
+		os.Exit(1)
+	}
+	if !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: %s is not a directory\n", dirPath)
+		os.Exit(1)
+	}
+
+	var skipped atomic.Int64 // warn runs on worker goroutines
+	warn := func(path string, err error) {
+		if !errors.Is(err, scan.ErrVanished) {
+			fmt.Fprintf(os.Stderr, "\r\033[KWarning: skipping %s: %s\n", path, classified(err))
+		}
+		skipped.Add(1)
+	}
+	dupes := report.NewDupes(hashAlgo, *workers, *minSize, warn)
+	scanner := scan.New(dirPath,
+		scan.WithWorkers(*workers),
+		scan.WithSink(scan.Discard),
+		scan.WithObserver(dupes),
+		scan.WithRetry(scan.RetryPolicy{Attempts: *retries, Backoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second}),
+		scan.WithWarnFunc(warn),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopSpinner := spinner("Scanning...", scanner.Count, 0)
+	_, err = scanner.Run(ctx)
+	stopSpinner()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error walking directory: %s\n", classified(err))
+		os.Exit(1)
+	}
+	fmt.Printf("Scanned %d files, hashing candidates...\n", scanner.Count())
+	if err := dupes.Finish(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error hashing files: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *reportFormat == "text" {
+		report.WriteText(os.Stdout, dupes)
+	} else {
+		paths, err := report.WriteFiles(*reportDir, *reportFormat, dupes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Report dupes: %s\n", strings.Join(paths, ", "))
+	}
+	if n := skipped.Load(); n > 0 {
+		fmt.Printf("Skipped %d unreadable entries\n", n)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Precedence rules for paths that appear in more than one input.
+const (
+	preferFirst  = "first"
+	preferLast   = "last"
+	preferNewest = "newest"
+)
+
+// runMerge combines scan outputs into one, keeping a single row per path.
+func runMerge(args []string) {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s merge [flags] -o <output> <input>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	outPath := flags.String("o", "", "output file; the format comes from its extension (required)")
+	prefer := flags.String("prefer", preferLast, "which row wins when a path is in several inputs: first, last or newest (by mtime)")
+	union := flags.Bool("union", false, "allow inputs with different columns, leaving missing values empty")
+	inputs := parseArgs(flags, args)
+
+	if *outPath == "" || len(inputs) == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *prefer != preferFirst && *prefer != preferLast && *prefer != preferNewest {
+		fmt.Fprintf(os.Stderr, "Error: unsupported --prefer %q (want first, last or newest)\n", *prefer)
+		os.Exit(1)
+	}
+	format, err := formatForPath(*outPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	m, err := mergeOutputs(inputs, *prefer, *union)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	f, err := scan.CreateAtomic(*outPath)
+	if err == nil {
+		if err = m.write(format, f); err != nil {
+			f.Abort()
+		} else {
+			err = f.Commit()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *outPath, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Merged %d rows from %d files into %d (%d duplicate paths)\n",
+		m.read, len(inputs), len(m.rows), m.read-len(m.rows))
+}
+
+// merged holds the surviving row for each path, aligned with columns.
+type merged struct {
+	columns []string
+	rows    map[string][]string // By root and path, when there is a root column
+	read    int
+	safe    bool // An input was a safe CSV, so the output is one too
+}
+
+// mergeOutputs reads every input into memory. Without union every input
+// must have the same set of columns, in any order; the output uses the
+// first input's order, with union adding new columns as they appear.
+func mergeOutputs(paths []string, prefer string, union bool) (*merged, error) {
+	readers := make([]scan.OutputReader, 0, len(paths))
+	defer func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}()
+	m := &merged{rows: make(map[string][]string)}
+	for _, path := range paths {
+		r, err := scan.OpenOutput(path)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, r)
+		m.safe = m.safe || scan.SafeCSV(r)
+		cols := r.Columns()
+		if !slices.Contains(cols, "file_path") {
+			return nil, fmt.Errorf("%s: no file_path column", path)
+		}
+		if prefer == preferNewest && !slices.Contains(cols, "mtime") {
+			return nil, fmt.Errorf("%s: --prefer newest needs an mtime column", path)
+		}
+		if m.columns == nil {
+			m.columns = slices.Clone(cols)
+			continue
+		}
+		if !union && !sameColumns(m.columns, cols) {
+			return nil, fmt.Errorf("%s: columns %s do not match %s: %s (use --union to merge anyway)",
+				path, strings.Join(cols, ","), paths[0], strings.Join(m.columns, ","))
+		}
+		for _, col := range cols {
+			if !slices.Contains(m.columns, col) {
+				m.columns = append(m.columns, col)
+			}
+		}
+	}
+
+	pathIdx := slices.Index(m.columns, "file_path")
+	rootIdx := slices.Index(m.columns, "root")
+	mtimeIdx := slices.Index(m.columns, "mtime")
+	for i, r := range readers {
+		idx := make([]int, len(r.Columns()))
+		for j, col := range r.Columns() {
+			idx[j] = slices.Index(m.columns, col)
+		}
+		for {
+			in, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", paths[i], err)
+			}
+			m.read++
+			row := make([]string, len(m.columns))
+			for j, v := range in {
+				row[idx[j]] = v
+			}
+			// Relative paths from different roots are different files
+			key := row[pathIdx]
+			if rootIdx >= 0 {
+				key = row[rootIdx] + "\x00" + key
+			}
+			prev, seen := m.rows[key]
+			if seen && !replaces(prev, row, prefer, mtimeIdx) {
+				continue
+			}
+			m.rows[key] = row
+		}
+	}
+	return m, nil
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, col := range b {
+		if !slices.Contains(a, col) {
+			return false
+		}
+	}
+	return true
+}
+
+// replaces reports whether row, read after prev, takes its place. Under
+// newest, ties and unparseable times go to the later input.
+func replaces(prev, row []string, prefer string, mtimeIdx int) bool {
+	switch prefer {
+	case preferFirst:
+		return false
+	case preferNewest:
+		old, err1 := time.Parse(time.RFC3339, prev[mtimeIdx])
+		cur, err2 := time.Parse(time.RFC3339, row[mtimeIdx])
+		if err1 == nil && err2 == nil {
+			return !cur.Before(old)
+		}
+	}
+	return true
+}
+
+// write writes the rows sorted by root and path.
+func (m *merged) write(format string, w io.Writer) error {
+	paths := make([]string, 0, len(m.rows))
+	for path := range m.rows {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	next := func() ([]string, error) {
+		if len(paths) == 0 {
+			return nil, io.EOF
+		}
+		row := m.rows[paths[0]]
+		paths = paths[1:]
+		return row, nil
+	}
+	_, err := writeRows(format, w, m.columns, m.safe, next)
+	return err
+}
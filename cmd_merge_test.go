@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeFlagsAfterInputs(t *testing.T) {
+	dir := scanFixture(t)
+	out, ok := runMain(t, dir, "merge", "file_paths.csv", "file_paths.csv", "-o", "all.csv", "--prefer", "first")
+	if !ok {
+		t.Fatalf("merge failed:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "all.csv")); err != nil {
+		t.Fatalf("merge wrote no output: %v\n%s", err, out)
+	}
+}
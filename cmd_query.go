@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pcoelho00/read_file_paths/query"
+	"github.com/pcoelho00/read_file_paths/report"
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// runQuery runs a SQL query over a scan output.
+func runQuery(args []string) {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s query [flags] <output> <sql>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Example: %s query scan.csv \"SELECT ext, sum(size) FROM files GROUP BY ext ORDER BY 2 DESC\"\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", "text", "result format: text, csv or json")
+	outPath := flags.String("o", "", "write the result to this file instead of stdout")
+	positional := parseArgs(flags, args)
+
+	if len(positional) != 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *format != "text" && *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unsupported format %q (want text, csv or json)\n", *format)
+		os.Exit(1)
+	}
+	q, err := query.Parse(positional[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	r, err := scan.OpenOutput(positional[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	res, err := q.Run(r)
+	r.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	t := report.Table{Name: "query", Title: "Query", Columns: res.Columns, Rows: res.Rows}
+	write := func(w io.Writer) error {
+		switch *format {
+		case "csv":
+			return report.WriteCSV(w, t)
+		case "json":
+			return report.WriteTableJSON(w, t)
+		}
+		return report.WriteTableText(w, t)
+	}
+	if *outPath == "" {
+		err = write(os.Stdout)
+	} else {
+		var f *scan.AtomicFile
+		if f, err = scan.CreateAtomic(*outPath); err == nil {
+			if err = write(f); err != nil {
+				f.Abort()
+			} else {
+				err = f.Commit()
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing result: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// refreshColumn flags rows whose file no longer exists.
+const refreshColumn = "missing"
+
+// refreshCounts tallies what a refresh found.
+type refreshCounts struct {
+	rows, changed, missing, unreadable int
+}
+
+// runRefresh re-stats the paths listed in a scan output and rewrites it
+// with their current metadata, without walking the tree again.
+func runRefresh(args []string) {
+	flags := flag.NewFlagSet("refresh", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s refresh [flags] <output>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Rewrites a scan output with the current size, mode, mtime, owner and link target of each path.\n")
+		flags.PrintDefaults()
+	}
+	outPath := flags.String("o", "", "write the refreshed output here instead of replacing the input; the format comes from its extension")
+	dropMissing := flags.Bool("drop-missing", false, "leave out paths that no longer exist instead of flagging them in a missing column")
+	rehash := flags.Bool("rehash", false, "re-hash every file, not just those whose size or mtime changed")
+	hashName := flags.String("hash", "", "hash algorithm of the output: md5, sha1, sha256 (default: from the digest length)")
+	workers := flags.Int("workers", 0, "number of stat and hashing workers (default: number of CPUs)")
+	inputs := parseArgs(flags, args)
+
+	if len(inputs) != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	input := inputs[0]
+	if *outPath == "" {
+		*outPath = input
+	}
+	format, err := formatForPath(*outPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	algo, err := scan.ParseHashAlgorithm(*hashName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *workers <= 0 {
+		*workers = runtime.NumCPU()
+	}
+
+	r, err := scan.OpenOutput(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer r.Close()
+	if !slices.Contains(r.Columns(), "file_path") {
+		fmt.Fprintf(os.Stderr, "Error: %s: no file_path column\n", input)
+		os.Exit(1)
+	}
+
+	rf := &refresher{columns: r.Columns(), dropMissing: *dropMissing, rehash: *rehash, algo: algo, workers: *workers, safe: scan.SafeCSV(r)}
+	// The input is read to the end before the rename, so it can be the output
+	f, err := scan.CreateAtomic(*outPath)
+	var counts refreshCounts
+	if err == nil {
+		if counts, err = rf.run(format, f, r.Next); err != nil {
+			f.Abort()
+		} else {
+			err = f.Commit()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error refreshing %s: %v\n", input, err)
+		os.Exit(1)
+	}
+	verb := "flagged"
+	if *dropMissing {
+		verb = "dropped"
+	}
+	fmt.Fprintf(os.Stderr, "Refreshed %d rows into %s: %d changed, %d missing (%s), %d unreadable (kept as they were)\n",
+		counts.rows, *outPath, counts.changed, counts.missing, verb, counts.unreadable)
+}
+
+// refresher updates records read from an output.
+type refresher struct {
+	columns     []string
+	dropMissing bool
+	rehash      bool
+	algo        scan.HashAlgorithm
+	workers     int
+	safe        bool // The input is a safe CSV, so the output is one too
+}
+
+// refreshResult is what refreshing one record found.
+type refreshResult int
+
+const (
+	refreshSame refreshResult = iota
+	refreshChanged
+	refreshMissing
+	refreshUnreadable
+)
+
+// run writes the refreshed rows of next to a sink of format, in their
+// original order. Rows are refreshed a batch at a time on the workers.
+func (rf *refresher) run(format string, w io.Writer, next func() ([]string, error)) (refreshCounts, error) {
+	var counts refreshCounts
+	sink, err := newSink(format, w)
+	if err != nil {
+		return counts, err
+	}
+	keepSafe(sink, rf.safe)
+	cols := scan.OutputColumns(rf.columns)
+	// An output refreshed before already has the column
+	hasFlag := slices.Contains(rf.columns, refreshColumn)
+	if !rf.dropMissing && !hasFlag {
+		cols = append(cols, scan.ExtraColumn(refreshColumn))
+	}
+	if err := sink.WriteHeader(cols); err != nil {
+		return counts, err
+	}
+
+	const batchSize = 1000
+	batch := make([]scan.Record, 0, batchSize)
+	results := make([]refreshResult, batchSize)
+	flush := func() error {
+		rf.refreshBatch(batch, results)
+		kept := batch[:0]
+		for i := range batch {
+			switch results[i] {
+			case refreshChanged:
+				counts.changed++
+			case refreshMissing:
+				counts.missing++
+			case refreshUnreadable:
+				counts.unreadable++
+			}
+			if rf.dropMissing && results[i] == refreshMissing {
+				continue
+			}
+			if !rf.dropMissing || hasFlag {
+				batch[i].SetExtra(refreshColumn, results[i] == refreshMissing)
+			}
+			kept = append(kept, batch[i])
+		}
+		err := sink.WriteBatch(kept)
+		batch = batch[:0]
+		return err
+	}
+	for {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return counts, err
+		}
+		rec, err := scan.RecordFromRow(rf.columns, row)
+		if err != nil {
+			return counts, fmt.Errorf("row %d: %w", counts.rows+1, err)
+		}
+		batch = append(batch, rec)
+		counts.rows++
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return counts, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return counts, err
+	}
+	if err := sink.Flush(); err != nil {
+		return counts, err
+	}
+	return counts, scan.CloseSink(sink)
+}
+
+// refreshBatch refreshes records in place, storing each outcome in the
+// matching element of results.
+func (rf *refresher) refreshBatch(records []scan.Record, results []refreshResult) {
+	var wg sync.WaitGroup
+	work := make(chan int)
+	for range min(rf.workers, len(records)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = rf.refresh(&records[i])
+			}
+		}()
+	}
+	for i := range records {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
+
+// refresh updates the columns of rec that the output has. A file that
+// can't be examined keeps its old values and is warned about.
+func (rf *refresher) refresh(rec *scan.Record) refreshResult {
+	info, err := os.Lstat(rec.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return refreshMissing
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", rec.Path, err)
+		return refreshUnreadable
+	}
+
+	old := *rec
+	has := func(col string) bool { return slices.Contains(rf.columns, col) }
+	if has("size") {
+		rec.Size = info.Size()
+	}
+	if has("mode") {
+		rec.Mode = info.Mode()
+	}
+	if has("mtime") {
+		rec.MTime = info.ModTime().Truncate(time.Second)
+	}
+	if uid, gid, ok := scan.Owner(info); ok && (has("uid") || has("gid")) {
+		rec.UID, rec.GID = uid, gid
+	}
+	if has("allocated") || has("sparse") {
+		rec.Allocated, rec.Sparse = scan.Allocation(info)
+	}
+	if has("link_target") || has("link_status") {
+		if err := refreshLink(rec, info); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", rec.Path, err)
+			*rec = old
+			return refreshUnreadable
+		}
+	}
+	contentChanged := !info.Mode().IsRegular() || rec.Size != old.Size || !rec.MTime.Equal(old.MTime)
+	if has("hash") && (rf.rehash || contentChanged) {
+		if err := rf.refreshHash(rec, info, old.Hash); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", rec.Path, err)
+			*rec = old
+			return refreshUnreadable
+		}
+	}
+
+	changed := rec.Size != old.Size || rec.Mode != old.Mode || !rec.MTime.Equal(old.MTime) ||
+		rec.UID != old.UID || rec.GID != old.GID || rec.Hash != old.Hash ||
+		rec.Allocated != old.Allocated || rec.Sparse != old.Sparse ||
+		rec.LinkTarget != old.LinkTarget || rec.LinkStatus != old.LinkStatus
+	if changed {
+		return refreshChanged
+	}
+	return refreshSame
+}
+
+// refreshLink updates the target of a symlink. Whether a live link points
+// outside the scan root can't be told without the root, so link_status
+// only moves between broken and its recorded value.
+func refreshLink(rec *scan.Record, info fs.FileInfo) error {
+	if info.Mode()&fs.ModeSymlink == 0 {
+		rec.LinkTarget, rec.LinkStatus = "", ""
+		return nil
+	}
+	target, err := os.Readlink(rec.Path)
+	if err != nil {
+		return err
+	}
+	rec.LinkTarget = target
+	_, err = filepath.EvalSymlinks(rec.Path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		rec.LinkStatus = scan.LinkBroken
+	case err != nil:
+		return err
+	case rec.LinkStatus == scan.LinkBroken || rec.LinkStatus == "":
+		rec.LinkStatus = scan.LinkOK
+	}
+	return nil
+}
+
+// refreshHash re-hashes a regular file with the output's algorithm, or
+// clears the hash of anything else, as a scan would have left it.
+func (rf *refresher) refreshHash(rec *scan.Record, info fs.FileInfo, oldHash string) error {
+	if !info.Mode().IsRegular() {
+		rec.Hash = ""
+		return nil
+	}
+	algo := rf.algo
+	if algo == scan.NoHash {
+		var ok bool
+		if algo, ok = digestAlgorithm(oldHash); !ok {
+			return errors.New("cannot tell the hash algorithm; use --hash")
+		}
+	}
+	sum, _, err := scan.HashFile(rec.Path, algo)
+	if err != nil {
+		return err
+	}
+	rec.Hash = sum
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pcoelho00/read_file_paths/report"
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// renameColumns are the columns of rename's old→new mapping.
+var renameColumns = []string{"old_path", "new_path", "issues", "status"}
+
+// rename is one suggested rename. Paths use the original names of the
+// parent directories, which are renamed after their contents.
+type rename struct {
+	old, new string
+	issues   []string
+	status   string
+}
+
+// runRename suggests compliant names for entries Windows or SharePoint
+// would reject or whose names or paths are too long, and writes a script
+// making the renames, or makes them itself.
+func runRename(args []string) {
+	flags := flag.NewFlagSet("rename", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s rename [flags] <directory>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Suggests names Windows and SharePoint accept for entries with illegal characters,\n")
+		fmt.Fprintf(os.Stderr, "reserved names or excessive length, writing a rename script and an old→new mapping.\n")
+		flags.PrintDefaults()
+	}
+	outPath := flags.String("o", "renames.csv", "write the old→new mapping here")
+	format := flags.String("format", "csv", "mapping format: csv or json")
+	scriptPath := flags.String("script", "rename.sh", "write a POSIX shell script making the renames here")
+	apply := flags.Bool("apply", false, "make the renames instead of writing a script")
+	maxName := flags.Int("max-name", scan.MaxNameLength, "longest name allowed, in UTF-16 code units")
+	maxPath := flags.Int("max-path", 0, "longest file path allowed below the directory, in UTF-16 code units; longer file names are shortened to fit (0 disables)")
+	positional := parseArgs(flags, args)
+
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	dir := positional[0]
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unsupported format %q (available: csv, json)\n", *format)
+		os.Exit(1)
+	}
+	if *maxName <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: --max-name must be positive")
+		os.Exit(1)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: %s is not an accessible directory\n", dir)
+		os.Exit(1)
+	}
+
+	p := &renamePlanner{maxName: *maxName, maxPath: *maxPath}
+	p.plan(dir, "")
+
+	failed := 0
+	if *apply {
+		for i := range p.renames {
+			r := &p.renames[i]
+			if err := renameNoReplace(r.old, r.new); err != nil {
+				r.status = "error: " + err.Error()
+				failed++
+				continue
+			}
+			r.status = "renamed"
+		}
+	} else {
+		for i := range p.renames {
+			p.renames[i].status = "suggested"
+		}
+		if err := writeRenameScript(*scriptPath, p.renames); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing rename script: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	rows := make([][]any, len(p.renames))
+	for i, r := range p.renames {
+		rows[i] = []any{r.old, r.new, r.issues, r.status}
+	}
+	t := report.Table{Name: "rename", Title: "Renames", Columns: renameColumns, Rows: rows}
+	if err := writeProblems(t, *format, *outPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing mapping: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case *apply:
+		fmt.Printf("Renamed %d of %d entries; mapping: %s\n", len(p.renames)-failed, len(p.renames), *outPath)
+	default:
+		fmt.Printf("Suggested %d renames; script: %s, mapping: %s\n", len(p.renames), *scriptPath, *outPath)
+	}
+	if p.unreadable > 0 {
+		fmt.Fprintf(os.Stderr, "%d directories could not be read\n", p.unreadable)
+	}
+	if failed > 0 || p.unreadable > 0 {
+		os.Exit(1)
+	}
+}
+
+// renamePlanner walks a tree collecting renames, children before their
+// directory so that every rename still finds its parent under its
+// original name.
+type renamePlanner struct {
+	maxName, maxPath int
+	renames          []rename
+	unreadable       int
+}
+
+// plan collects the renames below dir, whose path relative to the root
+// will be newRel once renamed.
+func (p *renamePlanner) plan(dir, newRel string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", dir, err)
+		p.unreadable++
+		return
+	}
+	// Windows names are case-insensitive, so suggestions must not clash
+	// with any sibling ignoring case
+	taken := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		taken[strings.ToLower(e.Name())] = true
+	}
+	var mine []rename
+	for _, e := range entries {
+		name := e.Name()
+		suggested := scan.SuggestName(name, p.maxName)
+		var issues []string
+		if suggested != name {
+			issues = scan.WindowsNameIssues(name)
+			if !utf8.ValidString(name) {
+				issues = append(issues, "invalid UTF-8")
+			}
+			if scan.UTF16Len(name) > p.maxName {
+				issues = append(issues, fmt.Sprintf("name longer than %d", p.maxName))
+			}
+		}
+		if p.maxPath > 0 && !e.IsDir() {
+			if room := p.maxPath - scan.UTF16Len(filepath.Join(newRel, "x")) + 1; scan.UTF16Len(suggested) > room && room > 0 {
+				suggested = scan.ShortenName(suggested, room)
+				issues = append(issues, fmt.Sprintf("path longer than %d", p.maxPath))
+			}
+		}
+		if suggested != name {
+			suggested = uniqueName(suggested, taken, p.maxName)
+			taken[strings.ToLower(suggested)] = true
+			mine = append(mine, rename{old: filepath.Join(dir, name), new: filepath.Join(dir, suggested), issues: issues})
+		}
+		if e.IsDir() {
+			p.plan(filepath.Join(dir, name), filepath.Join(newRel, suggested))
+		}
+	}
+	p.renames = append(p.renames, mine...)
+}
+
+// uniqueName adds a _2, _3, ... suffix to name's stem until it matches
+// nothing taken.
+func uniqueName(name string, taken map[string]bool, maxLen int) string {
+	if !taken[strings.ToLower(name)] {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+	for n := 2; ; n++ {
+		suffix := "_" + strconv.Itoa(n)
+		candidate := scan.ShortenName(stem, maxLen-scan.UTF16Len(suffix+ext)) + suffix + ext
+		if !taken[strings.ToLower(candidate)] {
+			return candidate
+		}
+	}
+}
+
+// renameNoReplace renames old to new unless new already exists.
+func renameNoReplace(old, new string) error {
+	if _, err := os.Lstat(new); err == nil {
+		return fmt.Errorf("%s already exists", new)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Rename(old, new)
+}
+
+// writeRenameScript writes renames as a shell script of mv commands that
+// never overwrite.
+func writeRenameScript(path string, renames []rename) error {
+	f, err := scan.CreateAtomic(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString("#!/bin/sh\n# Renames suggested by read_file_paths rename; review before running.\n# Entries are renamed before their directories.\n")
+	for _, r := range renames {
+		fmt.Fprintf(w, "mv -n -- %s %s\n", shellQuote(r.old), shellQuote(r.new))
+	}
+	if err := w.Flush(); err != nil {
+		f.Abort()
+		return err
+	}
+	if err := f.Chmod(0o755); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,1273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/encrypt"
+	"github.com/pcoelho00/read_file_paths/publish"
+	"github.com/pcoelho00/read_file_paths/report"
+	"github.com/pcoelho00/read_file_paths/scan"
+	"github.com/pcoelho00/read_file_paths/sign"
+	"github.com/pcoelho00/read_file_paths/source"
+)
+
+// checksumFormat writes a manifest sha256sum -c can check.
+const checksumFormat = "sha256sum"
+
+// actionJournalExt names the journal of --action, next to the output.
+const actionJournalExt = ".actions.csv"
+
+// scanOutputPath is where scan writes format: file_paths.csv,
+// file_paths.json and so on.
+func scanOutputPath(format string) string {
+	if format == checksumFormat {
+		return "file_paths.sha256"
+	}
+	return "file_paths." + format
+}
+
+// runScan is the default command: walk a directory and write the CSV.
+func runScan(args []string) {
+	flags := flag.NewFlagSet("scan", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [scan] [flags] <directory> [batch_size]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "   or: %s [scan] [flags] <directory> <directory>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "The directory may also be a URL: sftp://[user@]host[:port]/path, s3://bucket/prefix,\n")
+		fmt.Fprintf(os.Stderr, "gs://bucket/prefix, azblob://account/container/prefix, dav[s]://[user@]host/path\n")
+		fmt.Fprintf(os.Stderr, "ftp[s|es]://[user@]host[:port]/path, docker://image[:tag] (or docker://image.tar)\n")
+		fmt.Fprintf(os.Stderr, "git://path/to/repo#ref or disk://path/to/image (raw, qcow2 or ISO)\n")
+		flags.PrintDefaults()
+	}
+	workers := flags.Int("workers", 0, "number of record-building workers (default: --max-procs or the number of CPUs, or 32 for remote sources)")
+	maxProcs := flags.Int("max-procs", 0, "most CPUs the scan uses at once, as GOMAXPROCS (default: all)")
+	memLimit := flags.String("mem-limit", "", "soft memory limit such as 2GiB, as GOMEMLIMIT, which also sizes the scan's buffers to fit")
+	scanID := flags.String("scan-id", "", "ID recorded in the scan_id column, the summary and the metadata; pass the ID of an interrupted scan to rerun it under the same one (default a new UUID)")
+	orderName := flags.String("order", "dfs", "walk order: dfs (each directory's subtree before its next sibling) or bfs (level by level, the top of the tree first)")
+	maxOpenDirs := flags.Int("max-open-dirs", 1, "directories listed at once; above 1, subdirectories are listed ahead in parallel, which speeds up network filesystems")
+	batchFlag := flags.Int("batch-size", scan.DefaultBatchSize, "records per write batch")
+	hashName := flags.String("hash", "none", "content hash to record: none, md5, sha1, sha256")
+	format := flags.String("format", "csv", "output format, written to file_paths.<ext>: "+strings.Join(formatNames(), ", ")+" or "+checksumFormat)
+	columnList := flags.String("columns", "", "comma-separated output columns (default: file_path,path_length[,hash])")
+	deterministic := flags.Bool("deterministic", false, "write records in stable sorted order so unchanged trees produce identical output")
+	archives := flags.Bool("archives", false, "also record the files inside zip, tar, tar.gz, tar.bz2 and 7z archives as archive!/inner/path")
+	noStat := flags.Bool("no-stat", false, "record only what directory listings tell (path and type), guaranteeing no per-file stat calls; columns and options needing more are refused")
+	noHydrate := flags.Bool("no-hydrate", false, "don't read cloud placeholders and offline files, so they aren't downloaded (Windows)")
+	flushEvery := flags.Int("flush-every", 1, "flush output every N batches (0 disables)")
+	flushInterval := flags.Duration("flush-interval", 0, "also flush pending records at this interval, e.g. 5s (0 disables)")
+	fsync := flags.Bool("fsync", false, "fsync the output on every flush so a crash loses at most one flush window")
+	retries := flags.Int("retries", 2, "retries for transient filesystem errors (EINTR, EAGAIN, ESTALE, dropped network shares)")
+	retryBackoff := flags.Duration("retry-backoff", 100*time.Millisecond, "delay before the first retry; doubles on each attempt up to 5s")
+	collisionPath := flags.String("case-report", "", "write sibling names that differ only by case to this CSV file")
+	namePath := flags.String("windows-report", "", "write files whose names Windows or SharePoint would reject to this CSV file")
+	var reportNames stringList
+	flags.Var(&reportNames, "report", "build a secondary report: "+strings.Join(report.Names(), ", ")+"; repeatable")
+	reportTop := flags.Int("top", report.DefaultTop, "entries kept by top-N reports")
+	rollupDepth := flags.Int("depth", report.DefaultRollupDepth, "directory levels below the root shown by the rollup report")
+	ageBuckets := flags.String("age-buckets", "30,365,1095", "bucket edges in days for the age report")
+	policyPath := flags.String("perm-policy", "", "JSON policy file for the permissions report")
+	largeDir := flags.Int("large-dir", report.DefaultLargeDirEntries, "entry count at which the large-dirs report flags a directory")
+	reportFormat := flags.String("report-format", "text", "how reports are emitted: text (stdout), csv or json (files)")
+	reportDir := flags.String("report-dir", ".", "directory for csv/json report files")
+	summaryPath := flags.String("summary-json", "", "where to write the JSON scan summary with the totals, skips by class, time per stage and settings of the scan, for pipelines to gate on")
+	scanSummary := flags.Bool("scan-summary", false, "write the JSON scan summary next to the output, as <output>"+scanSummaryExt)
+	escapeName := flags.String("invalid-utf8", "raw", "how to record non-UTF-8 file names: raw, percent, hex")
+	errorsPath := flags.String("errors-file", "", "where to record skipped paths (default: named after the output, e.g. file_paths.errors.csv)")
+	errorsFormat := flags.String("errors-format", "csv", "format of the skipped-paths manifest: csv or json")
+	metricsAddr := flags.String("metrics-addr", "", "serve Prometheus metrics on this address (e.g. :9090) while scanning")
+	otlpEndpoint := flags.String("otlp-endpoint", otlpEndpointFromEnv(), "export a trace of the scan stages to this OTLP/HTTP endpoint (default: from OTEL_EXPORTER_OTLP_ENDPOINT)")
+	notifyURL := flags.String("notify-url", "", "POST a JSON summary to this URL when the scan finishes or fails")
+	traceSlow := flags.Duration("trace-slow", scan.DefaultSlowSpan, "give single directory listings, hashes and writes at least this slow their own span")
+	realpath := flags.Bool("realpath", false, "record canonical absolute paths with symlinked directories resolved")
+	clean := flags.Bool("clean", false, "record paths in filepath.Clean form")
+	relative := flags.Bool("relative", false, "record paths relative to the directory they were found under; pair with the root column")
+	var publishURLs stringList
+	flags.Var(&publishURLs, "publish", "also publish records to nats://host/subject or amqp://host/vhost?exchange=X&routing_key=K; repeatable")
+	publishMode := flags.String("publish-mode", "batch", "what one published message holds: batch (JSON lines) or record (one JSON object)")
+	var detectSpecs stringList
+	flags.Var(&detectSpecs, "detect", "search file contents with a detector: "+strings.Join(scan.DetectorNames(), ", ")+", or NAME=REGEX; repeatable, adds the findings column")
+	detectLimit := flags.Int64("detect-limit", scan.DefaultDetectLimit, "bytes of each file searched by detectors")
+	execCommand := flags.String("exec", "", "run this command on every file, e.g. 'clamscan --no-summary {}', recording its exit status in exec_status")
+	execWorkers := flags.Int("exec-workers", 0, "most --exec commands running at once (default: --max-procs or the number of CPUs)")
+	execTimeout := flags.Duration("exec-timeout", 0, "kill --exec commands running longer than this (0 disables), recording status -1")
+	execOutput := flags.Bool("exec-output", false, "also record the --exec command's output in exec_output")
+	var pluginCommands stringList
+	flags.Var(&pluginCommands, "plugin", "pass every record through this program as JSON lines, adding the columns it names; repeatable")
+	var wasmModules stringList
+	flags.Var(&wasmModules, "wasm-plugin", "pass every record through this WASI module, sandboxed, as with --plugin; repeatable")
+	var excludes, namePatterns stringList
+	flags.Var(&excludes, "exclude", "skip entries whose name matches this glob, and everything below matching directories; repeatable")
+	trashMode := flags.String("trash", "keep", "trash and recycle bin directories (.Trash, $RECYCLE.BIN, lost+found and the like): keep, skip, or tag with a trash column")
+	skipMacFiles := flags.Bool("skip-mac-files", false, "skip the .DS_Store and ._* AppleDouble files macOS leaves behind")
+	flags.Var(&namePatterns, "name", "only record files whose name matches this glob; repeatable")
+	includeDirs := flags.Bool("include-dirs", false, "also record directories, with type, child_files and child_dirs columns, each ahead of its entries")
+	includeSnapshots := flags.Bool("include-snapshots", false, "also walk the .snapshot, ~snapshot and .zfs/snapshot directories of NetApp and ZFS shares, skipped by default")
+	aliases := flags.Bool("aliases", false, "record directories reached again through a bind mount or overlapping root as rows of type alias, with alias_of naming where they were walked, instead of cycle errors")
+	var skipFSTypes stringList
+	flags.Var(&skipFSTypes, "skip-fstype", "don't descend into mount points of these filesystem types, comma-separated globs such as proc,sysfs,tmpfs,fuse.* (Linux and macOS); repeatable")
+	maxPerDir := flags.Int("max-per-dir", 0, "record at most this many files from each directory, replacing the rest with one row of type truncated; 0 records all")
+	var firstPatterns stringList
+	flags.Var(&firstPatterns, "first", "record files whose path below the root matches this glob (** for any directories) before all others, at the cost of a second walk; repeatable")
+	action := flags.String("action", "", "act on every recorded file: move, copy or delete, recorded in action, action_dest and action_status")
+	dest := flags.String("dest", "", "destination directory for --action move or copy; files keep their path below the scanned directory")
+	dryRun := flags.Bool("dry-run", false, "print the scan plan (root, filters, outputs and file counts below the root) and exit without writing anything; with --action, record what it would do without doing it")
+	encryptSpec := flags.String("encrypt", "", "encrypt the output and errors manifest as they are written: age:FILE, age:age1... or gpg:USER-ID")
+	outputTemplate := flags.String("output-template", "", "name the output with this Go template, e.g. 'scan_{{.Root | base}}_{{.Start.Format \"20060102T150405\"}}.csv'; fields .Root, .Start, .Format, .Host")
+	excel := flags.Bool("excel", false, "write CSV for Excel: a UTF-8 byte order mark, CRLF line endings and timestamps Excel reads")
+	safeCSV := flags.Bool("safe-csv", false, "escape values a spreadsheet would run as formulas (leading =, +, -, @) and end lines in CRLF")
+	schemaPath := flags.String("emit-schema", "", "also write a schema of the selected columns to this file, for validating the output downstream")
+	schemaFormat := flags.String("schema-format", scan.SchemaJSON, "format of the --emit-schema file: "+strings.Join(scan.SchemaFormats, ", "))
+	metadataMode := flags.String("metadata", "", "record the tool and schema versions, root, host and start/end times: sidecar (<output>.meta.json), embed (a CSV # preamble or Parquet key/value metadata) or both")
+	heartbeatPath := flags.String("heartbeat", "", "rewrite this JSON file with the scan's progress every --heartbeat-interval, so monitors can spot hung scans")
+	heartbeatInterval := flags.Duration("heartbeat-interval", 30*time.Second, "how often --heartbeat is rewritten")
+	dashboardFlag := flags.Bool("dashboard", false, "show a live dashboard of progress, throughput, errors and per-directory counts instead of the spinner")
+	lockWait := flags.Duration("lock-wait", 0, "wait up to this long for another scan writing the same output to finish, instead of failing at once")
+	estimate := flags.Bool("estimate", false, "count the files to scan in a quick pre-pass, so progress shows a percentage and ETA")
+	estimateFrom := flags.String("estimate-from", "", "expect as many files as this previous scan output has, instead of a pre-pass")
+	cachePath := flags.String("cache", "", "reuse hashes of unchanged files from this cache file, and update it after the scan")
+	cacheDirs := flags.Bool("cache-dirs", false, "also keep directory listings in the --cache file, and replay those of directories whose mtime and link count are unchanged instead of listing them again")
+	signKey := flags.String("sign", "", "write a detached signature of the output to <output>.sig with this PEM private key (RSA, ECDSA or Ed25519)")
+	var transformSpecs stringList
+	flags.Var(&transformSpecs, "transform", "apply a transform NAME[=ARG] to each record; repeatable, applied in order")
+	var rewriteSpecs stringList
+	flags.Var(&rewriteSpecs, "rewrite", "map paths under one prefix to another, as in '/mnt/nfs/projects=>P:/projects'; repeatable, longest match wins")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	// Applied first, so the defaults sized by CPU count follow them
+	if *maxProcs > 0 {
+		runtime.GOMAXPROCS(*maxProcs)
+	}
+	if *execWorkers <= 0 {
+		*execWorkers = runtime.GOMAXPROCS(0)
+	}
+	var memBytes int64
+	if *memLimit != "" {
+		n, err := scan.ParseBytes(*memLimit)
+		if err == nil && n == 0 {
+			err = fmt.Errorf("invalid size %q: must be positive", *memLimit)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --mem-limit: %v\n", err)
+			os.Exit(1)
+		}
+		memBytes = n
+		debug.SetMemoryLimit(memBytes)
+	}
+	// Chosen up front, so the metadata written before the scan has it
+	if *scanID == "" {
+		*scanID = scan.NewScanID()
+	}
+
+	// A second argument that is a number is the original batch_size;
+	// otherwise every argument is a directory to scan
+	dirPath := flags.Arg(0)
+	batchSize := *batchFlag
+	var moreRoots []string
+	if flags.NArg() == 2 {
+		if size, err := strconv.Atoi(flags.Arg(1)); err == nil {
+			if size <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: batch_size must be a positive integer\n")
+				os.Exit(1)
+			}
+			batchSize = size
+		} else {
+			moreRoots = flags.Args()[1:]
+		}
+	} else {
+		moreRoots = flags.Args()[1:]
+	}
+	if len(moreRoots) > 0 && slices.ContainsFunc(flags.Args(), source.IsURL) {
+		fmt.Fprintln(os.Stderr, "Error: several directories can only be scanned locally; scan a URL on its own")
+		os.Exit(1)
+	}
+	// With --action, --dry-run scans and records what the action would do
+	planOnly := *dryRun && *action == ""
+
+	if batchSize <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: batch_size must be a positive integer\n")
+		os.Exit(1)
+	}
+
+	hashAlgo, err := scan.ParseHashAlgorithm(*hashName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *format == checksumFormat {
+		// The format implies its hash
+		if hashAlgo != scan.NoHash && hashAlgo != scan.SHA256 {
+			fmt.Fprintf(os.Stderr, "Error: --format %s needs --hash sha256\n", checksumFormat)
+			os.Exit(1)
+		}
+		hashAlgo = scan.SHA256
+	} else if _, ok := sinkFormats[*format]; !ok {
+		fmt.Fprintf(os.Stderr, "Error: unsupported format %q (available: %s or %s)\n", *format, strings.Join(formatNames(), ", "), checksumFormat)
+		os.Exit(1)
+	}
+	outputPath := scanOutputPath(*format)
+	if *outputTemplate != "" {
+		host, _ := os.Hostname()
+		data := outputNameData{Root: source.Redact(dirPath), Start: time.Now(), Format: strings.TrimPrefix(filepath.Ext(outputPath), "."), Host: host}
+		// Absolute, so {{.Root | base}} names the directory for "." too
+		if !source.IsURL(dirPath) {
+			if abs, err := filepath.Abs(dirPath); err == nil {
+				data.Root = abs
+			}
+		}
+		if outputPath, err = renderOutputName(*outputTemplate, data); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --output-template: %v\n", err)
+			os.Exit(1)
+		}
+		if dir := filepath.Dir(outputPath); dir != "." && !planOnly {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	if *excel && *format != "csv" {
+		fmt.Fprintln(os.Stderr, "Error: --excel needs --format csv")
+		os.Exit(1)
+	}
+	if *safeCSV && *format != "csv" {
+		fmt.Fprintln(os.Stderr, "Error: --safe-csv needs --format csv")
+		os.Exit(1)
+	}
+	if *heartbeatInterval <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: --heartbeat-interval must be positive")
+		os.Exit(1)
+	}
+	if !slices.Contains(scan.SchemaFormats, *schemaFormat) {
+		fmt.Fprintf(os.Stderr, "Error: unknown --schema-format %q (want one of %s)\n", *schemaFormat, strings.Join(scan.SchemaFormats, ", "))
+		os.Exit(1)
+	}
+	if *excel && (*metadataMode == metadataEmbed || *metadataMode == metadataBoth) {
+		fmt.Fprintln(os.Stderr, "Error: --excel can't embed metadata, which Excel would show as rows; use --metadata sidecar")
+		os.Exit(1)
+	}
+	var meta *scanMetadata
+	switch *metadataMode {
+	case "":
+	case metadataSidecar, metadataEmbed, metadataBoth:
+		if *metadataMode != metadataSidecar && *format != "csv" && *format != "parquet" {
+			fmt.Fprintf(os.Stderr, "Error: --metadata %s needs --format csv or parquet; use sidecar\n", *metadataMode)
+			os.Exit(1)
+		}
+		host, _ := os.Hostname()
+		meta = &scanMetadata{Tool: "read_file_paths", Version: toolVersion(), SchemaVersion: schemaVersion, ScanID: *scanID, Root: source.Redact(dirPath), Host: host, Start: time.Now().UTC(), Format: *format}
+		if hashAlgo != scan.NoHash {
+			meta.Hash = string(hashAlgo)
+		}
+		if len(moreRoots) > 0 {
+			meta.Roots = flags.Args()
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unsupported --metadata %q (want sidecar, embed or both)\n", *metadataMode)
+		os.Exit(1)
+	}
+	// Companion files are named after the output
+	outputStem := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+	var enc *encrypt.Encrypter
+	if *encryptSpec != "" {
+		if *fsync {
+			// A partial chunk can't be written until the next one starts
+			fmt.Fprintln(os.Stderr, "Error: --fsync can't be combined with --encrypt")
+			os.Exit(1)
+		}
+		if enc, err = encrypt.New(*encryptSpec); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		outputPath += enc.Ext()
+	}
+	var signer *sign.Signer
+	if *signKey != "" {
+		if signer, err = sign.LoadSigner(*signKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var cache *scan.HashCache
+	if *cacheDirs && *cachePath == "" {
+		fmt.Fprintln(os.Stderr, "Error: --cache-dirs needs --cache")
+		os.Exit(1)
+	}
+	if *cachePath != "" {
+		if hashAlgo == scan.NoHash && !*cacheDirs {
+			fmt.Fprintln(os.Stderr, "Error: --cache needs --hash or --cache-dirs")
+			os.Exit(1)
+		}
+		if cache, err = scan.OpenHashCache(*cachePath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	escapeMode, err := scan.ParseEscapeMode(*escapeName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	order, err := scan.ParseOrder(*orderName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	pubMode, err := publish.ParseMode(*publishMode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	otherScans := scan.Filter(excludeOtherScans)
+	if *noStat {
+		otherScans = excludeOtherLocks
+	}
+	opts := []scan.Option{
+		scan.WithWorkers(*workers),
+		scan.WithScanID(*scanID),
+		scan.WithIncludeDirs(*includeDirs),
+		scan.WithAliases(*aliases),
+		scan.WithSnapshotDirs(*includeSnapshots),
+		scan.WithMaxPerDir(*maxPerDir),
+		scan.WithOrder(order),
+		scan.WithMaxOpenDirs(*maxOpenDirs),
+		scan.WithMemoryLimit(memBytes),
+		scan.WithNoStat(*noStat),
+		scan.WithBatchSize(batchSize),
+		scan.WithHash(hashAlgo),
+		scan.WithColumns(scan.ParseColumns(*columnList)...),
+		scan.WithEscapeMode(escapeMode),
+		scan.WithDeterministic(*deterministic),
+		scan.WithArchives(*archives),
+		scan.WithNoHydrate(*noHydrate),
+		scan.WithFlush(*flushEvery, *flushInterval, *fsync),
+		scan.WithRetry(scan.RetryPolicy{Attempts: *retries, Backoff: *retryBackoff, MaxBackoff: 5 * time.Second}),
+		scan.WithRoots(moreRoots...),
+	}
+	if cache != nil {
+		opts = append(opts, scan.WithHashCache(cache))
+		if *cacheDirs {
+			opts = append(opts, scan.WithDirCache(cache))
+		}
+	}
+	// The command sees the path as walked, before any rewriting
+	if *execCommand != "" {
+		if source.IsURL(dirPath) {
+			fmt.Fprintln(os.Stderr, "Error: --exec needs a local directory")
+			os.Exit(1)
+		}
+		hook, err := scan.NewExecHook(*execCommand, *execWorkers, *execTimeout, *execOutput)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, scan.WithTransform(hook))
+	}
+	var plugins []*scan.PluginHook
+	closePlugins := func() {
+		for _, p := range plugins {
+			if err := p.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+	}
+	for _, command := range pluginCommands {
+		p, err := scan.NewPluginHook(command)
+		if err != nil {
+			closePlugins()
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		plugins = append(plugins, p)
+		opts = append(opts, scan.WithTransform(p))
+	}
+	for _, module := range wasmModules {
+		p, err := scan.NewWasmPluginHook(module)
+		if err != nil {
+			closePlugins()
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		plugins = append(plugins, p)
+		opts = append(opts, scan.WithTransform(p))
+	}
+	if *skipMacFiles {
+		excludes = append(excludes, scan.AppleMetadataNames...)
+	}
+	switch *trashMode {
+	case "keep":
+	case "skip":
+		excludes = append(excludes, scan.TrashNames...)
+	case "tag":
+		// Ahead of the path transforms, so the components it looks at are
+		// still there
+		opts = append(opts, scan.WithTransform(scan.TagTrash()))
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unsupported --trash %q (want keep, skip or tag)\n", *trashMode)
+		os.Exit(1)
+	}
+	if len(excludes) > 0 {
+		f, err := scan.ExcludeNames(excludes...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, scan.WithFilter(f))
+	}
+	if len(namePatterns) > 0 {
+		f, err := scan.MatchNames(namePatterns...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, scan.WithFilter(f))
+	}
+	if len(skipFSTypes) > 0 {
+		var types []string
+		for _, list := range skipFSTypes {
+			types = append(types, scan.ParseColumns(list)...)
+		}
+		for _, t := range types {
+			if _, err := filepath.Match(t, ""); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: --skip-fstype %q: %v\n", t, err)
+				os.Exit(1)
+			}
+		}
+		opts = append(opts, scan.WithSkipFSTypes(types...))
+	}
+	if len(firstPatterns) > 0 {
+		m, err := scan.MatchPaths(firstPatterns...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, scan.WithFirst(m))
+	}
+	var actionHook *scan.ActionHook
+	if *action != "" {
+		if source.IsURL(dirPath) {
+			fmt.Fprintln(os.Stderr, "Error: --action needs a local directory")
+			os.Exit(1)
+		}
+		if len(moreRoots) > 0 {
+			fmt.Fprintln(os.Stderr, "Error: --action needs a single directory")
+			os.Exit(1)
+		}
+		if actionHook, err = scan.NewActionHook(*action, dirPath, *dest, *dryRun); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		// After --exec, so a scanner's verdict is recorded before a move
+		opts = append(opts, scan.WithTransform(actionHook))
+	} else if *dest != "" {
+		fmt.Fprintln(os.Stderr, "Error: --dest needs --action")
+		os.Exit(1)
+	}
+	// Normalization runs before user transforms so they see final paths
+	if *realpath {
+		opts = append(opts, scan.WithTransform(scan.RealPaths()))
+	}
+	if *clean {
+		opts = append(opts, scan.WithTransform(scan.CleanPaths()))
+	}
+	if *relative {
+		if *realpath {
+			fmt.Fprintln(os.Stderr, "Error: --relative can't be combined with --realpath")
+			os.Exit(1)
+		}
+		opts = append(opts, scan.WithTransform(scan.RelativePaths()))
+	}
+	var detectors []scan.Detector
+	for _, spec := range detectSpecs {
+		d, err := scan.ParseDetector(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		detectors = append(detectors, d)
+	}
+	opts = append(opts, scan.WithDetectors(*detectLimit, detectors...))
+	for _, spec := range transformSpecs {
+		t, err := scan.ParseTransform(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, scan.WithTransform(t))
+	}
+	// Last, so the mappings see the paths as they will be written
+	if len(rewriteSpecs) > 0 {
+		rules := make([]scan.PrefixRewrite, len(rewriteSpecs))
+		for i, spec := range rewriteSpecs {
+			if rules[i], err = scan.ParsePrefixRewrite(spec); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		opts = append(opts, scan.WithTransform(scan.RewritePrefixes(rules...)))
+	}
+	// Roots written outside the records, and the errors naming them, are
+	// redacted as the records are
+	redacter := scan.New(dirPath, opts...)
+	redactRoots := func(roots []string) []string {
+		redacted := make([]string, len(roots))
+		for i, root := range roots {
+			redacted[i] = redacter.RedactPath(root)
+		}
+		return redacted
+	}
+	// Only absolute roots, as a short relative one such as "." could be
+	// any part of the message
+	redactError := func(msg string) string {
+		for _, root := range flags.Args() {
+			if filepath.IsAbs(root) {
+				msg = strings.ReplaceAll(msg, root, redacter.RedactPath(root))
+			}
+		}
+		return msg
+	}
+	if meta != nil {
+		meta.Root = redacter.RedactPath(meta.Root)
+		meta.Roots = redactRoots(meta.Roots)
+	}
+
+	// From here on failures are the scan's rather than the command line's,
+	// so --notify-url hears of them
+	notifyDone := func(st scan.Stats, err error) {
+		if *notifyURL == "" {
+			return
+		}
+		n := newScanNotice(redacter.RedactPath(source.Redact(dirPath)), outputPath, *errorsPath, st, err)
+		n.Error = redactError(n.Error)
+		if err := notify(*notifyURL, n); err != nil {
+			fmt.Fprintf(os.Stderr, "Error sending notification: %v\n", err)
+		}
+	}
+	started := scan.Stats{Start: time.Now()}
+
+	// Sources are opened first since they may add columns
+	var src scan.Source
+	var srcDir string
+	if source.IsURL(dirPath) {
+		if src, srcDir, err = source.Open(dirPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			notifyDone(started, err)
+			os.Exit(1)
+		}
+		defer source.Close(src)
+		dirPath = source.Redact(dirPath)
+		opts = append(opts, scan.WithSource(src, srcDir))
+		if *workers == 0 {
+			opts = append(opts, scan.WithWorkers(source.RemoteWorkers))
+		}
+	}
+	if _, err := scan.New(dirPath, opts...).Columns(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Verify the path is a directory
+	var info os.FileInfo
+	if src != nil {
+		// Open resolves the directory, so it is never a symlink here
+		info, err = src.Lstat(srcDir)
+	} else {
+		info, err = os.Stat(dirPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error accessing path: %v\n", err)
+		notifyDone(started, err)
+		os.Exit(1)
+	}
+	if !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: %s is not a directory\n", dirPath)
+		notifyDone(started, fmt.Errorf("%s is not a directory", dirPath))
+		os.Exit(1)
+	}
+	for _, root := range moreRoots {
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			err = fmt.Errorf("%s is not a directory", root)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			notifyDone(started, err)
+			os.Exit(1)
+		}
+	}
+	roots := append([]string{dirPath}, moreRoots...)
+
+	if *errorsPath == "" {
+		*errorsPath = outputStem + ".errors." + *errorsFormat
+		if enc != nil {
+			*errorsPath += enc.Ext()
+		}
+	}
+	errLog, err := scan.NewErrorLog(*errorsPath, *errorsFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if enc != nil {
+		errLog.WrapWith(enc.Wrap)
+	}
+
+	var dash *dashboard
+	if *dashboardFlag {
+		if isTerminal(os.Stdout) {
+			dash = newDashboard(os.Stdout, roots)
+			opts = append(opts, scan.WithObserver(dash))
+		} else {
+			fmt.Fprintln(os.Stderr, "Warning: --dashboard needs a terminal; showing progress as usual")
+		}
+	}
+	warn := func(path string, err error) {
+		switch {
+		case dash != nil:
+			// Printed warnings would scroll the dashboard away
+			dash.warn(path, err)
+		// Files vanishing mid-scan are expected in active trees; they only
+		// go to the manifest
+		case !errors.Is(err, scan.ErrVanished):
+			fmt.Fprintf(os.Stderr, "\r\033[KWarning: skipping %s: %s\n", path, classified(err))
+		}
+		errLog.Add(path, err)
+	}
+
+	var reportPaths []string
+	if meta != nil && *metadataMode != metadataEmbed {
+		reportPaths = append(reportPaths, outputPath+metadataExt)
+	}
+	if cache != nil {
+		reportPaths = append(reportPaths, *cachePath)
+	}
+	if signer != nil {
+		reportPaths = append(reportPaths, outputPath+sign.Ext)
+	}
+	if *scanSummary && *summaryPath == "" {
+		*summaryPath = outputStem + scanSummaryExt
+	}
+	if *summaryPath != "" {
+		reportPaths = append(reportPaths, *summaryPath)
+	}
+	if *schemaPath != "" {
+		reportPaths = append(reportPaths, *schemaPath)
+	}
+	if *heartbeatPath != "" {
+		reportPaths = append(reportPaths, *heartbeatPath)
+	}
+	// Written as files are acted on, so it outlives a failed scan whose
+	// output is discarded
+	journalPath := outputStem + actionJournalExt
+	if actionHook != nil && !*dryRun {
+		reportPaths = append(reportPaths, journalPath)
+	}
+
+	ageEdges, err := report.ParseAgeBuckets(*ageBuckets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	var policy *report.PermPolicy
+	if *policyPath != "" {
+		p, err := report.LoadPermPolicy(*policyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		policy = &p
+	}
+	var reports []report.Report
+	for _, name := range reportNames {
+		r, err := report.New(name, report.Options{
+			Top:     *reportTop,
+			Root:    dirPath,
+			Depth:   *rollupDepth,
+			Hash:    hashAlgo,
+			Workers: *workers,
+			Warn:    warn,
+
+			AgeBuckets: ageEdges,
+			PermPolicy: policy,
+
+			LargeDirEntries: *largeDir,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		reports = append(reports, r)
+		opts = append(opts, scan.WithObserver(r))
+	}
+	if len(reports) > 0 {
+		// Reports may need columns the options rule out
+		if _, err := scan.New(dirPath, opts...).Columns(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if planOnly {
+		plan := scanPlan{Roots: roots, Source: "local", Hash: string(hashAlgo), Workers: *workers, Output: outputPath, Errors: *errorsPath}
+		if src != nil {
+			plan.Source = strings.SplitN(flags.Arg(0), "://", 2)[0]
+		}
+		if hashAlgo == scan.NoHash {
+			plan.Hash = ""
+		}
+		if plan.Workers == 0 {
+			plan.Workers = runtime.GOMAXPROCS(0)
+			if src != nil {
+				plan.Workers = source.RemoteWorkers
+			}
+		}
+		if len(excludes) > 0 {
+			plan.Filters = append(plan.Filters, "skip names matching "+strings.Join(excludes, ", "))
+		}
+		if len(namePatterns) > 0 {
+			plan.Filters = append(plan.Filters, "only files named "+strings.Join(namePatterns, ", "))
+		}
+		if !*includeSnapshots {
+			plan.Filters = append(plan.Filters, "skip snapshot directories ("+strings.Join(scan.SnapshotDirs, ", ")+")")
+		}
+		switch *trashMode {
+		case "skip":
+			plan.Filters = append(plan.Filters, "skip trash and recycle bin directories")
+		case "tag":
+			plan.Transforms = append(plan.Transforms, "tag files in trash directories")
+		}
+		if len(skipFSTypes) > 0 {
+			plan.Filters = append(plan.Filters, "skip mounts of type "+strings.Join(skipFSTypes, ","))
+		}
+		if *maxPerDir > 0 {
+			plan.Filters = append(plan.Filters, fmt.Sprintf("at most %d files per directory", *maxPerDir))
+		}
+		if *includeDirs {
+			plan.Filters = append(plan.Filters, "directories recorded as rows too")
+		}
+		if len(firstPatterns) > 0 {
+			plan.Filters = append(plan.Filters, "files matching "+strings.Join(firstPatterns, ", ")+" first, in a walk of their own")
+		}
+		plan.Filters = append(plan.Filters, "skip this scan's own files and other scans' lock and temp files")
+		if *archives {
+			plan.Filters = append(plan.Filters, "also record the members of archives")
+		}
+		if *noHydrate {
+			plan.Filters = append(plan.Filters, "leave cloud placeholders and offline files unread")
+		}
+		if *execCommand != "" {
+			plan.Transforms = append(plan.Transforms, "exec "+*execCommand)
+		}
+		for _, command := range pluginCommands {
+			plan.Transforms = append(plan.Transforms, "plugin "+command)
+		}
+		for _, module := range wasmModules {
+			plan.Transforms = append(plan.Transforms, "WebAssembly plugin "+module)
+		}
+		if *realpath {
+			plan.Transforms = append(plan.Transforms, "realpath")
+		}
+		if *clean {
+			plan.Transforms = append(plan.Transforms, "clean")
+		}
+		plan.Transforms = append(plan.Transforms, transformSpecs...)
+		for _, spec := range rewriteSpecs {
+			plan.Transforms = append(plan.Transforms, "rewrite "+spec)
+		}
+		plan.Companions = append(plan.Companions, reportPaths...)
+		for _, path := range []string{*collisionPath, *namePath} {
+			if path != "" {
+				plan.Companions = append(plan.Companions, path)
+			}
+		}
+		if len(reportNames) > 0 && *reportFormat != "text" {
+			plan.Companions = append(plan.Companions, fmt.Sprintf("%s reports (%s) in %s", *reportFormat, strings.Join(reportNames, ", "), *reportDir))
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		planner := scan.New(dirPath, append(opts,
+			scan.WithSink(scan.Discard),
+			scan.WithFilter(excludeOwnFiles(append(reportPaths, outputPath, errLog.Path())...)),
+			scan.WithFilter(otherScans),
+		)...)
+		err := printPlan(ctx, os.Stdout, plan, planner)
+		closePlugins()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", classified(err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Concurrent scans of one output would share its temp file
+	lock, err := scan.LockOutput(outputPath, *lockWait)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		notifyDone(started, err)
+		os.Exit(1)
+	}
+	var (
+		outputFile *scan.AtomicFile
+		encOut     io.WriteCloser
+		collisions *collisionReport
+		names      *nameReport
+	)
+	// From here on every failure goes through abortOutput, so no temp
+	// file or lock outlives the scan
+	abortOutput := func() {
+		if encOut != nil {
+			encOut.Close()
+		}
+		if outputFile != nil {
+			outputFile.Abort()
+		}
+		if collisions != nil {
+			collisions.file.Abort()
+		}
+		if names != nil {
+			names.file.Abort()
+		}
+		lock.Unlock()
+	}
+	if *collisionPath != "" {
+		reportPaths = append(reportPaths, *collisionPath)
+		if collisions, err = newCollisionReport(*collisionPath); err != nil {
+			abortOutput()
+			fmt.Fprintf(os.Stderr, "Error creating case report: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, scan.WithCaseCollisions(collisions.Add))
+	}
+	if *namePath != "" {
+		reportPaths = append(reportPaths, *namePath)
+		if names, err = newNameReport(*namePath); err != nil {
+			abortOutput()
+			fmt.Fprintf(os.Stderr, "Error creating Windows name report: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, scan.WithObserver(names))
+	}
+
+	// Write to a temp file and rename on success so consumers never see a
+	// half-written output
+	if outputFile, err = scan.CreateAtomic(outputPath); err != nil {
+		abortOutput()
+		fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *metricsAddr != "" {
+		metrics := scan.NewMetrics()
+		opts = append(opts, scan.WithMetrics(metrics))
+		serveMetrics(*metricsAddr, metrics)
+	}
+	var tracer *scan.Tracer
+	if *otlpEndpoint != "" {
+		tracer = scan.NewTracer(*traceSlow)
+		if parent := os.Getenv("TRACEPARENT"); parent != "" {
+			if err := tracer.SetParent(parent); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: ignoring TRACEPARENT: %v\n", err)
+			}
+		}
+		opts = append(opts, scan.WithTracer(tracer))
+	}
+
+	// Encrypted output is encrypted before it reaches the temp file
+	var out io.Writer = outputFile
+	if enc != nil {
+		if encOut, err = enc.Wrap(outputFile); err != nil {
+			abortOutput()
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		out = encOut
+	}
+
+	var scanner *scan.Scanner
+	var sink scan.Sink
+	if *format == checksumFormat {
+		sink = scan.NewChecksumSink(out)
+	} else {
+		sink, _ = newSink(*format, out)
+	}
+	if csvSink, ok := sink.(*scan.CSVSink); ok {
+		if *excel {
+			csvSink.Excel()
+		}
+		if *safeCSV {
+			csvSink.Safe()
+		}
+	}
+	if meta != nil && *metadataMode != metadataSidecar {
+		switch sink := sink.(type) {
+		case *scan.ParquetSink:
+			// The footer is written as the scan ends, so it can say when
+			sink.SetKeyValue("read_file_paths", func() string {
+				st := scanner.Stats()
+				st.Elapsed = time.Since(st.Start)
+				return meta.finished(st).jsonString()
+			})
+		default:
+			if err := meta.writePreamble(out); err != nil {
+				abortOutput()
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	if len(publishURLs) > 0 {
+		sinks := []scan.Sink{sink}
+		for _, u := range publishURLs {
+			pub, err := publish.Open(u, pubMode)
+			if err != nil {
+				abortOutput()
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			sinks = append(sinks, pub)
+		}
+		sink = scan.MultiSink(sinks...)
+	}
+
+	opts = append(opts,
+		scan.WithSink(sink),
+		// Never record our own output, even when the scan covers the CWD
+		scan.WithFilter(excludeOwnFiles(append(reportPaths, outputPath, errLog.Path())...)),
+		scan.WithFilter(otherScans),
+		scan.WithWarnFunc(warn),
+	)
+	scanner = scan.New(dirPath, opts...)
+	if meta != nil {
+		columns, _ := scanner.Columns()
+		for _, col := range columns {
+			meta.Columns = append(meta.Columns, col.Name)
+		}
+	}
+	if *schemaPath != "" {
+		// Written before the scan, so pipelines can prepare for the output
+		if err := writeSchema(*schemaPath, *schemaFormat, scanner); err != nil {
+			abortOutput()
+			fmt.Fprintf(os.Stderr, "Error: writing schema: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Interrupts cancel the scan so the temp file is cleaned up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var total int64
+	switch {
+	case *estimateFrom != "":
+		if total, err = expectedFiles(*estimateFrom); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: no estimate from %s: %v\n", *estimateFrom, err)
+		}
+	case *estimate:
+		fmt.Print("Estimating...")
+		total, err = scanner.Estimate(ctx)
+		fmt.Print("\r\033[K")
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Warning: no estimate: %v\n", err)
+		}
+	}
+	var stopSpinner func()
+	if dash != nil {
+		dash.total = total
+		stopSpinner = dash.run()
+	} else {
+		stopSpinner = spinner("Scanning...", scanner.Count, total)
+	}
+	if actionHook != nil && !*dryRun {
+		if err := actionHook.Journal(journalPath); err != nil {
+			abortOutput()
+			fmt.Fprintf(os.Stderr, "Error: action journal: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	stopHeartbeat := func() {}
+	if *heartbeatPath != "" {
+		stopHeartbeat = newHeartbeat(scanner).run(*heartbeatPath, *heartbeatInterval)
+	}
+	result, scanErr := scanner.Run(ctx)
+	closePlugins()
+	// Reports with post-scan work (dupes hashing) finish before the
+	// errors manifest is closed so their failures land in it
+	if scanErr == nil {
+		for _, r := range reports {
+			if err := report.Finish(ctx, r); err != nil {
+				scanErr = err
+				break
+			}
+		}
+	}
+	stopSpinner()
+	stopHeartbeat()
+	if actionHook != nil {
+		if err := actionHook.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing action journal: %v\n", err)
+		}
+	}
+
+	if tracer != nil {
+		// Sent even for a failed scan, whose trace says where it failed
+		exportCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := tracer.Export(exportCtx, newOTLPExporter(*otlpEndpoint)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error exporting trace: %v\n", err)
+		}
+		cancel()
+	}
+	if err := errLog.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing errors manifest: %v\n", err)
+	}
+	if collisions != nil {
+		if err := collisions.Close(scanErr == nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing case report: %v\n", err)
+		}
+	}
+	if names != nil {
+		if err := names.Close(scanErr == nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing Windows name report: %v\n", err)
+		}
+	}
+
+	if *summaryPath != "" {
+		summary := newScanSummary(result, redactRoots(roots), outputPath, errLog.Path(), summaryConfig(flags))
+		summary.Error = redactError(summary.Error)
+		if err := writeSummaryJSON(*summaryPath, summary); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing summary: %v\n", err)
+		}
+	}
+
+	if scanErr != nil {
+		abortOutput()
+		fmt.Fprintf(os.Stderr, "Error walking directory: %s\n", classified(scanErr))
+		if actionHook != nil && !*dryRun {
+			if done, failed := actionHook.Counts(); done+failed > 0 {
+				fmt.Fprintf(os.Stderr, "Actions taken before the failure are recorded in %s\n", journalPath)
+			}
+		}
+		notifyDone(result.Stats, scanErr)
+		os.Exit(1)
+	}
+	if encOut != nil {
+		err := encOut.Close()
+		encOut = nil
+		if err != nil {
+			abortOutput()
+			fmt.Fprintf(os.Stderr, "Error encrypting output: %v\n", err)
+			notifyDone(result.Stats, err)
+			os.Exit(1)
+		}
+	}
+	// Signed before the rename, so the signature covers exactly what
+	// this scan wrote
+	var signature []byte
+	if signer != nil {
+		if signature, err = signer.SignFile(outputFile.Name()); err != nil {
+			abortOutput()
+			fmt.Fprintf(os.Stderr, "Error signing output: %v\n", err)
+			notifyDone(result.Stats, err)
+			os.Exit(1)
+		}
+	}
+	if err := outputFile.Commit(); err != nil {
+		abortOutput()
+		fmt.Fprintf(os.Stderr, "Error finalizing output file: %v\n", err)
+		notifyDone(result.Stats, err)
+		os.Exit(1)
+	}
+	if signer != nil {
+		if err := writeSignature(outputPath+sign.Ext, signature); err != nil {
+			lock.Unlock()
+			fmt.Fprintf(os.Stderr, "Error writing signature: %v\n", err)
+			notifyDone(result.Stats, err)
+			os.Exit(1)
+		}
+	}
+
+	if meta != nil && *metadataMode != metadataEmbed {
+		if err := writeJSONFile(outputPath+metadataExt, meta.finished(result.Stats)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing metadata: %v\n", err)
+		}
+	}
+	// Only a complete scan says which cached files are gone
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving hash cache: %v\n", err)
+		}
+	}
+	lock.Unlock()
+
+	fmt.Printf("Done! Processed %d files.\n", scanner.Count())
+	printSummary(os.Stdout, result)
+	if *format == "csv" {
+		fmt.Println("CSV file created: " + outputPath)
+	} else {
+		fmt.Println("Output file created: " + outputPath)
+	}
+	if signer != nil {
+		fmt.Println("Signature: " + outputPath + sign.Ext)
+	}
+	if meta != nil && *metadataMode != metadataEmbed {
+		fmt.Println("Metadata: " + outputPath + metadataExt)
+	}
+	if *summaryPath != "" {
+		fmt.Println("Scan summary: " + *summaryPath)
+	}
+	for _, r := range reports {
+		if *reportFormat == "text" {
+			report.WriteText(os.Stdout, r)
+			continue
+		}
+		paths, err := report.WriteFiles(*reportDir, *reportFormat, r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s report: %v\n", r.Name(), err)
+			continue
+		}
+		fmt.Printf("Report %s: %s\n", r.Name(), strings.Join(paths, ", "))
+	}
+	if cache != nil {
+		if hashAlgo != scan.NoHash {
+			hits, misses := cache.Counts()
+			fmt.Printf("Hash cache: %d hits, %d misses\n", hits, misses)
+		}
+		if *cacheDirs {
+			hits, misses := cache.DirCounts()
+			fmt.Printf("Directory cache: %d replayed, %d listed\n", hits, misses)
+		}
+	}
+	if actionHook != nil {
+		done, failed := actionHook.Counts()
+		verb := "Actions"
+		if *dryRun {
+			verb = "Dry-run actions"
+		}
+		fmt.Printf("%s: %d %s, %d failed\n", verb, done, *action, failed)
+	}
+	if collisions != nil {
+		fmt.Printf("Case collisions: %d groups, see %s\n", collisions.groups, collisions.file.Path())
+	}
+	if names != nil {
+		fmt.Printf("Windows name issues: %d files, see %s\n", names.count, names.file.Path())
+	}
+	if n := errLog.Count(); n > 0 {
+		fmt.Printf("Skipped %d entries (%s), see %s\n", n, formatClassCounts(errLog.ClassCounts()), errLog.Path())
+	}
+	notifyDone(result.Stats, nil)
+}
+
+// serveMetrics exposes metrics at /metrics on addr in the background.
+func serveMetrics(addr string, metrics *scan.Metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "\nError serving metrics: %v\n", err)
+		}
+	}()
+}
+
+// otlpEndpointFromEnv returns the traces endpoint the standard OTel
+// variables configure, or "".
+func otlpEndpointFromEnv() string {
+	if e := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); e != "" {
+		return e
+	}
+	if e := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); e != "" {
+		return strings.TrimSuffix(e, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// newOTLPExporter returns an exporter to endpoint, with the headers and
+// service name of the standard OTel variables.
+func newOTLPExporter(endpoint string) *scan.OTLPExporter {
+	exp := &scan.OTLPExporter{Endpoint: endpoint, Service: os.Getenv("OTEL_SERVICE_NAME"), Headers: map[string]string{}}
+	for _, env := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		// Comma-separated key=value pairs with URL-encoded values
+		for _, pair := range strings.Split(os.Getenv(env), ",") {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				continue
+			}
+			if unescaped, err := url.PathUnescape(strings.TrimSpace(v)); err == nil {
+				v = unescaped
+			}
+			exp.Headers[strings.TrimSpace(k)] = v
+		}
+	}
+	return exp
+}
+
+// formatClassCounts renders skipped entries by class, most common first,
+// e.g. "3 permission, 1 io".
+func formatClassCounts(counts map[string]int) string {
+	classes := slices.Collect(maps.Keys(counts))
+	sort.Slice(classes, func(a, b int) bool {
+		if counts[classes[a]] != counts[classes[b]] {
+			return counts[classes[a]] > counts[classes[b]]
+		}
+		return classes[a] < classes[b]
+	})
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = fmt.Sprintf("%d %s", counts[class], class)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// excludeOtherScans filters the lock files of scans writing into the tree
+// and the temp files of the outputs they hold, which are only half
+// written.
+func excludeOtherScans(path string, d fs.DirEntry) bool {
+	return excludeOtherLocks(path, d) && !isOtherScanTemp(path, d)
+}
+
+// excludeOtherLocks is excludeOtherScans for --no-stat scans, which can't
+// afford the lstat telling other scans' temp files apart and so record
+// them.
+func excludeOtherLocks(path string, d fs.DirEntry) bool {
+	return !strings.HasSuffix(d.Name(), scan.LockSuffix)
+}
+
+// isOtherScanTemp reports whether path is the temp file of an output that
+// another scan holds locked.
+func isOtherScanTemp(path string, d fs.DirEntry) bool {
+	output, ok := strings.CutSuffix(path, scan.TempSuffix)
+	if !ok || d.IsDir() {
+		return false
+	}
+	_, err := os.Lstat(output + scan.LockSuffix)
+	return err == nil
+}
+
+// excludeOwnFiles filters the files this run writes, and their temp files,
+// out of the scan.
+func excludeOwnFiles(paths ...string) scan.Filter {
+	var all []string
+	for _, p := range paths {
+		all = append(all, p, p+scan.TempSuffix)
+	}
+	return scan.ExcludePaths(all...)
+}
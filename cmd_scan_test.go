@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanFailureLeavesNoLockOrTemp(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"bad age buckets", []string{"--age-buckets", "soon"}},
+		{"case report dir missing", []string{"--case-report", filepath.Join("missing", "case.csv")}},
+		{"schema dir missing", []string{"--emit-schema", filepath.Join("missing", "schema.json")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			out, ok := runMain(t, dir, append(tc.args, ".")...)
+			if ok {
+				t.Fatalf("scan succeeded:\n%s", out)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != "a.txt" {
+					t.Errorf("failed scan left %s behind:\n%s", e.Name(), out)
+				}
+			}
+		})
+	}
+}
+
+func TestScanSummaryIsOptIn(t *testing.T) {
+	dir := scanFixture(t)
+	if _, err := os.Stat(filepath.Join(dir, "file_paths"+scanSummaryExt)); !os.IsNotExist(err) {
+		t.Fatalf("a plain scan wrote a summary: %v", err)
+	}
+	if out, ok := runMain(t, dir, "--scan-summary", "tree"); !ok {
+		t.Fatalf("scan failed:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file_paths"+scanSummaryExt)); err != nil {
+		t.Fatalf("--scan-summary wrote no summary: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/rpc"
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// runServe runs the HTTP API: scans on request or on a schedule, with
+// status, summaries, live streams and downloads.
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s serve [flags] --root <directory> [--root <directory>...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "localhost:8080", "address to listen on; the API has no authentication, so only listen beyond localhost behind a proxy that adds it")
+	grpcAddr := flags.String("grpc-addr", "", "also serve the gRPC Scanner service (h2c) on this address, e.g. :9090")
+	var roots stringList
+	flags.Var(&roots, "root", "directory scans may cover (it and everything below it); repeatable, the first is the default")
+	dataDir := flags.String("data-dir", "scans", "directory holding scan outputs")
+	schedule := flags.Duration("schedule", 0, "also scan every root at this interval, e.g. 1h (0 disables)")
+	maxScans := flags.Int("max-scans", 1, "scans allowed to run at once; others queue")
+	keep := flags.Int("keep", 20, "finished scans kept before the oldest are deleted")
+	workers := flags.Int("workers", 0, "number of record-building workers per scan (default: number of CPUs)")
+	hashName := flags.String("hash", "none", "default content hash: none, md5, sha1, sha256")
+	columnList := flags.String("columns", "", "default output columns")
+	retries := flags.Int("retries", 2, "retries for transient filesystem errors")
+	stallAfter := flags.Duration("stall-after", 10*time.Minute, "fail GET /healthz when a running scan has made no progress for this long (0 never fails it)")
+	flags.Parse(args)
+
+	if len(roots) == 0 || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *maxScans <= 0 || *keep <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: max-scans and keep must be positive\n")
+		os.Exit(1)
+	}
+	for _, root := range roots {
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Error: %s is not a directory\n", root)
+			os.Exit(1)
+		}
+	}
+	hashAlgo, err := scan.ParseHashAlgorithm(*hashName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	opts := []scan.Option{
+		scan.WithWorkers(*workers),
+		scan.WithHash(hashAlgo),
+		scan.WithColumns(scan.ParseColumns(*columnList)...),
+		scan.WithRetry(scan.RetryPolicy{Attempts: *retries, Backoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second}),
+		// Outputs live under the data dir, which may sit inside a root
+		scan.WithFilter(scan.ExcludePaths(*dataDir)),
+	}
+	srv, err := newServer(roots, *dataDir, *maxScans, *keep, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	srv.stallAfter = *stallAfter
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *schedule > 0 {
+		go srv.schedule(ctx, *schedule)
+	}
+	httpServer := &http.Server{Addr: *addr, Handler: srv.handler()}
+	servers := []*http.Server{httpServer}
+	if *grpcAddr != "" {
+		// gRPC clients speak HTTP/2 with prior knowledge, without TLS
+		var protocols http.Protocols
+		protocols.SetUnencryptedHTTP2(true)
+		grpcServer := &http.Server{Addr: *grpcAddr, Handler: rpc.Handler(srv.grpcScan), Protocols: &protocols}
+		servers = append(servers, grpcServer)
+		go func() {
+			if err := grpcServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Fprintf(os.Stderr, "Error serving gRPC: %v\n", err)
+				os.Exit(1)
+			}
+		}()
+		fmt.Printf("Serving gRPC on %s\n", *grpcAddr)
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, s := range servers {
+			s.Shutdown(shutdown)
+		}
+	}()
+	fmt.Printf("Serving scans of %v on %s\n", []string(roots), *addr)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "Error serving: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/pcoelho00/read_file_paths/report"
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Statuses reported by verify.
+const (
+	verifyMismatch   = "mismatch"
+	verifyMissing    = "missing"
+	verifyUnreadable = "unreadable"
+	verifyNew        = "new"
+)
+
+// runVerify re-hashes the files listed in a scan output and reports
+// those that changed, disappeared or appeared since.
+func runVerify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s verify [flags] <manifest>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "The manifest is a scan output with file_path and hash columns.\n")
+		fmt.Fprintf(os.Stderr, "Exits 0 when every file matches, 1 when something differs, 2 on errors.\n")
+		flags.PrintDefaults()
+	}
+	hashName := flags.String("hash", "", "hash algorithm of the manifest: md5, sha1, sha256 (default: from the digest length)")
+	root := flags.String("root", "", "also scan this directory, the root the manifest was made from, to report new files")
+	workers := flags.Int("workers", 0, "number of hashing workers (default: number of CPUs)")
+	format := flags.String("format", "csv", "output format: csv or json")
+	outPath := flags.String("o", "", "write the problems to this file instead of stdout")
+	positional := parseArgs(flags, args)
+
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(exitTrouble)
+	}
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unsupported format %q (want csv or json)\n", *format)
+		os.Exit(exitTrouble)
+	}
+	algo, err := scan.ParseHashAlgorithm(*hashName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitTrouble)
+	}
+	if *workers <= 0 {
+		*workers = runtime.NumCPU()
+	}
+
+	entries, err := readManifest(positional[0], true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitTrouble)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	problems := verifyEntries(ctx, entries, algo, *workers)
+	if *root != "" {
+		listed := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			listed[e.path] = true
+		}
+		found, err := unlistedFiles(ctx, *root, listed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error scanning %s: %v\n", *root, err)
+			os.Exit(exitTrouble)
+		}
+		for _, path := range found {
+			problems = append(problems, []any{verifyNew, path, "", "", ""})
+		}
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Error: interrupted")
+		os.Exit(exitTrouble)
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i][1].(string) < problems[j][1].(string) })
+
+	t := report.Table{Name: "verify", Title: "Verification problems", Columns: problemColumns, Rows: problems}
+	if err := writeProblems(t, *format, *outPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing problems: %v\n", err)
+		os.Exit(exitTrouble)
+	}
+
+	counts := make(map[string]int)
+	for _, p := range problems {
+		counts[p[0].(string)]++
+	}
+	fmt.Fprintf(os.Stderr, "%d files checked: %d ok, %d mismatched, %d missing, %d unreadable, %d new\n",
+		len(entries), len(entries)-counts[verifyMismatch]-counts[verifyMissing]-counts[verifyUnreadable],
+		counts[verifyMismatch], counts[verifyMissing], counts[verifyUnreadable], counts[verifyNew])
+	if len(problems) > 0 {
+		os.Exit(exitChanged)
+	}
+}
+
+// problemColumns are the columns of verify's and check's reports.
+var problemColumns = []string{"status", "file_path", "expected_hash", "actual_hash", "detail"}
+
+// writeProblems writes a report of problems as csv or json, to outPath
+// or else stdout.
+func writeProblems(t report.Table, format, outPath string) error {
+	write := func(w io.Writer) error {
+		if format == "json" {
+			return report.WriteTableJSON(w, t)
+		}
+		return report.WriteCSV(w, t)
+	}
+	if outPath == "" {
+		return write(os.Stdout)
+	}
+	f, err := scan.CreateAtomic(outPath)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
+
+// manifestEntry is one file listed in a manifest. Size is -1 when the
+// manifest has no size column, and hash empty when it has no hash.
+type manifestEntry struct {
+	path string
+	hash string
+	size int64
+
+	// file is where to find it, when that isn't path
+	file string
+}
+
+// osPath is the file to examine for e.
+func (e manifestEntry) osPath() string {
+	if e.file != "" {
+		return e.file
+	}
+	return e.path
+}
+
+// readManifest reads the entries of a scan output. needHash makes the
+// hash column required.
+func readManifest(path string, needHash bool) ([]manifestEntry, error) {
+	r, err := scan.OpenOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cols := r.Columns()
+	pathIdx, hashIdx, sizeIdx := slices.Index(cols, "file_path"), slices.Index(cols, "hash"), slices.Index(cols, "size")
+	if pathIdx < 0 || (needHash && hashIdx < 0) {
+		return nil, fmt.Errorf("%s: a manifest needs file_path and hash columns (scan with --hash)", path)
+	}
+	var entries []manifestEntry
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		e := manifestEntry{path: row[pathIdx], size: -1}
+		if hashIdx >= 0 {
+			e.hash = row[hashIdx]
+		}
+		if sizeIdx >= 0 && row[sizeIdx] != "" {
+			if e.size, err = strconv.ParseInt(row[sizeIdx], 10, 64); err != nil {
+				return nil, fmt.Errorf("%s: %s: bad size %q", path, e.path, row[sizeIdx])
+			}
+		}
+		entries = append(entries, e)
+	}
+}
+
+// digestAlgorithm guesses the algorithm of a hex digest from its length.
+func digestAlgorithm(digest string) (scan.HashAlgorithm, bool) {
+	switch len(digest) {
+	case 32:
+		return scan.MD5, true
+	case 40:
+		return scan.SHA1, true
+	case 64:
+		return scan.SHA256, true
+	}
+	return scan.NoHash, false
+}
+
+// verifyEntries re-hashes every entry on workers goroutines and returns
+// a row per problem. Entries without a hash, such as symlinks, are
+// only checked to exist.
+func verifyEntries(ctx context.Context, entries []manifestEntry, algo scan.HashAlgorithm, workers int) [][]any {
+	var (
+		mu       sync.Mutex
+		problems [][]any
+		wg       sync.WaitGroup
+	)
+	add := func(status string, e manifestEntry, actual, detail string) {
+		mu.Lock()
+		problems = append(problems, []any{status, e.path, e.hash, actual, detail})
+		mu.Unlock()
+	}
+	work := make(chan manifestEntry)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range work {
+				info, err := os.Lstat(e.osPath())
+				if errors.Is(err, fs.ErrNotExist) {
+					add(verifyMissing, e, "", "")
+					continue
+				}
+				if err != nil {
+					add(verifyUnreadable, e, "", err.Error())
+					continue
+				}
+				if info.Mode()&fs.ModeSymlink != 0 && e.hash == "" {
+					// Scans record links without following them
+					continue
+				}
+				if !info.Mode().IsRegular() {
+					add(verifyMismatch, e, "", "no longer a regular file")
+					continue
+				}
+				if e.size >= 0 && info.Size() != e.size {
+					add(verifyMismatch, e, "", fmt.Sprintf("size %d, was %d", info.Size(), e.size))
+					continue
+				}
+				if e.hash == "" {
+					continue
+				}
+				a := algo
+				if a == scan.NoHash {
+					var ok bool
+					if a, ok = digestAlgorithm(e.hash); !ok {
+						add(verifyUnreadable, e, "", "cannot tell the hash algorithm; use --hash")
+						continue
+					}
+				}
+				actual, _, err := scan.HashFile(e.osPath(), a)
+				switch {
+				case err != nil:
+					add(verifyUnreadable, e, "", err.Error())
+				case actual != e.hash:
+					add(verifyMismatch, e, actual, "content changed")
+				}
+			}
+		}()
+	}
+	for _, e := range entries {
+		select {
+		case work <- e:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(work)
+	wg.Wait()
+	return problems
+}
+
+// unlistedFiles scans root and returns the paths of the files not in
+// listed. Paths are compared as the scan writes them, so root must be
+// given the way it was when the listed paths were made.
+func unlistedFiles(ctx context.Context, root string, listed map[string]bool) ([]string, error) {
+	var found []string
+	observer := scan.ObserverFunc(func(r *scan.Record) {
+		if !listed[r.Path] {
+			found = append(found, r.Path)
+		}
+	})
+	scanner := scan.New(root,
+		scan.WithSink(scan.Discard),
+		scan.WithObserver(observer),
+		scan.WithWarnFunc(func(path string, err error) {
+			if !errors.Is(err, scan.ErrVanished) {
+				fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", path, err)
+			}
+		}),
+	)
+	if _, err := scanner.Run(ctx); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+	"github.com/pcoelho00/read_file_paths/sign"
+)
+
+// runVerifySig checks a scan output against the detached signature scan
+// --sign wrote for it.
+func runVerifySig(args []string) {
+	flags := flag.NewFlagSet("verify-sig", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s verify-sig --key public.pem [flags] <output>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Exits 0 for a good signature, 1 for a bad one, 2 on errors.\n")
+		flags.PrintDefaults()
+	}
+	keyPath := flags.String("key", "", "PEM public key, certificate or private key of the signer")
+	sigPath := flags.String("sig", "", "signature file (default: <output>"+sign.Ext+")")
+	positional := parseArgs(flags, args)
+
+	if len(positional) != 1 || *keyPath == "" {
+		flags.Usage()
+		os.Exit(exitTrouble)
+	}
+	output := positional[0]
+	if *sigPath == "" {
+		*sigPath = output + sign.Ext
+	}
+	v, err := sign.LoadVerifier(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitTrouble)
+	}
+	sig, err := os.ReadFile(*sigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitTrouble)
+	}
+	err = v.VerifyFile(output, sig)
+	switch {
+	case errors.Is(err, sign.ErrBadSignature):
+		fmt.Printf("BAD signature: %s\n", output)
+		os.Exit(exitChanged)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitTrouble)
+	}
+	fmt.Printf("Good signature: %s\n", output)
+}
+
+// writeSignature saves a detached signature.
+func writeSignature(path string, sig []byte) error {
+	f, err := scan.CreateAtomic(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(sig); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
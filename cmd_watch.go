@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+const eventsPath = "file_paths.events.csv"
+
+// runWatch appends create/modify/delete events for a directory to a CSV
+// until interrupted.
+func runWatch(args []string) {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s watch [flags] <directory>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	interval := flags.Duration("interval", 10*time.Second, "how often the tree is rescanned for changes where the system can't report them (anywhere but Linux, or past the inotify watch limit)")
+	output := flags.String("output", eventsPath, "CSV file events are appended to")
+	workers := flags.Int("workers", 0, "number of record-building workers (default: number of CPUs)")
+	hashName := flags.String("hash", "none", "content hash to record and compare: none, md5, sha1, sha256")
+	columnList := flags.String("columns", "", "comma-separated output columns (default: file_path,path_length[,hash])")
+	retries := flags.Int("retries", 2, "retries for transient filesystem errors")
+	fsync := flags.Bool("fsync", false, "fsync the output after every batch of events")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	dirPath := flags.Arg(0)
+	if *interval <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: interval must be positive\n")
+		os.Exit(1)
+	}
+
+	hashAlgo, err := scan.ParseHashAlgorithm(*hashName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error accessing path: %v\n", err)
+		os.Exit(1)
+	}
+	if !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: %s is not a directory\n", dirPath)
+		os.Exit(1)
+	}
+
+	// Events accumulate across runs, so the file is appended to rather
+	// than replaced
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening events file: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	sink := scan.NewCSVSink(f)
+	if st, err := f.Stat(); err == nil && st.Size() > 0 {
+		sink.SkipHeader()
+	}
+
+	scanner := scan.New(dirPath,
+		scan.WithWorkers(*workers),
+		scan.WithHash(hashAlgo),
+		scan.WithColumns(scan.ParseColumns(*columnList)...),
+		scan.WithRetry(scan.RetryPolicy{Attempts: *retries, Backoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second}),
+		scan.WithFlush(0, 0, *fsync),
+		scan.WithSink(sink),
+		scan.WithFilter(excludeOwnFiles(*output)),
+		scan.WithWarnFunc(func(path string, err error) {
+			if !errors.Is(err, scan.ErrVanished) {
+				fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", path, err)
+			}
+		}),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if scan.NotifyChanges {
+		fmt.Printf("Watching %s for changes, appending events to %s (Ctrl+C to stop)\n", dirPath, *output)
+	} else {
+		fmt.Printf("Watching %s every %s, appending events to %s (Ctrl+C to stop)\n", dirPath, *interval, *output)
+	}
+	if err := scanner.Watch(ctx, *interval); err != nil {
+		fmt.Fprintf(os.Stderr, "Error watching directory: %v\n", err)
+		os.Exit(1)
+	}
+}
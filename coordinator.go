@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// fleetPollWait is how long the coordinator holds an agent's poll open
+// waiting for a task. An agent not heard from for twice as long, and not
+// running a task, is offline.
+const fleetPollWait = 30 * time.Second
+
+// fleetTokenEnv holds the shared token when --token isn't given.
+const fleetTokenEnv = "RFP_FLEET_TOKEN"
+
+// fleetRequest is the body of POST /jobs: a scan for every agent listed,
+// or every online agent. Root defaults to each agent's first root.
+type fleetRequest struct {
+	scanRequest
+	Agents []string `json:"agents"`
+}
+
+// agentHello is what an agent sends with each poll.
+type agentHello struct {
+	Hostname string   `json:"hostname"`
+	Roots    []string `json:"roots"`
+}
+
+// fleetTask is one agent's part of a job, as handed to the agent.
+type fleetTask struct {
+	ID    string      `json:"id"`
+	Job   string      `json:"job"`
+	Agent string      `json:"agent"`
+	Scan  scanRequest `json:"scan"`
+}
+
+// taskResult is how an agent reports the end of a task. Error is empty
+// for a scan that succeeded.
+type taskResult struct {
+	Error string      `json:"error,omitempty"`
+	Stats *scan.Stats `json:"stats,omitempty"`
+}
+
+// task tracks a fleetTask on the coordinator. Its fields other than files
+// are guarded by the coordinator's mutex.
+type task struct {
+	fleetTask
+	status    string
+	err       string
+	uploading bool
+	stats     *scan.Stats
+	files     atomic.Int64 // Records received so far
+}
+
+func (t *task) finished() bool {
+	return t.status == jobDone || t.status == jobFailed || t.status == jobCancelled
+}
+
+// fleetJob is one fleet-wide scan: a task per agent, their outputs kept
+// in dir as <agent>.json.
+type fleetJob struct {
+	id      string
+	dir     string
+	created time.Time
+	tasks   []*task
+}
+
+// agentInfo is a registered agent. Agents register by polling.
+type agentInfo struct {
+	name     string
+	hello    agentHello
+	lastSeen time.Time
+	wake     chan struct{} // Signalled when a task is queued for the agent
+}
+
+// coordinator hands scan jobs to agents and collects their outputs.
+type coordinator struct {
+	dataDir string
+	keep    int
+	token   string
+
+	mu     sync.Mutex
+	agents map[string]*agentInfo
+	jobs   map[string]*fleetJob
+	tasks  map[string]*task
+	queue  []*task // Queued tasks, oldest first
+	seq    int
+}
+
+func newCoordinator(dataDir string, keep int, token string) (*coordinator, error) {
+	c := &coordinator{
+		dataDir: dataDir,
+		keep:    keep,
+		token:   token,
+		agents:  make(map[string]*agentInfo),
+		jobs:    make(map[string]*fleetJob),
+		tasks:   make(map[string]*task),
+	}
+	return c, os.MkdirAll(dataDir, 0o755)
+}
+
+func (c *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /agents/{name}/poll", c.handlePoll)
+	mux.HandleFunc("GET /agents", c.handleAgents)
+	mux.HandleFunc("PUT /tasks/{id}/output", c.handleUpload)
+	mux.HandleFunc("POST /tasks/{id}/result", c.handleResult)
+	mux.HandleFunc("POST /jobs", c.handleStart)
+	mux.HandleFunc("GET /jobs", c.handleList)
+	mux.HandleFunc("GET /jobs/{id}", c.handleJob(c.handleStatus))
+	mux.HandleFunc("DELETE /jobs/{id}", c.handleJob(c.handleCancel))
+	mux.HandleFunc("GET /jobs/{id}/output", c.handleJob(c.handleOutput))
+	if c.token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(c.token)) != 1 {
+			httpError(w, http.StatusUnauthorized, errors.New("missing or wrong token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// online reports whether an agent is polling or running a task. The
+// caller holds c.mu.
+func (c *coordinator) online(a *agentInfo) bool {
+	if time.Since(a.lastSeen) < 2*fleetPollWait {
+		return true
+	}
+	for _, t := range c.tasks {
+		if t.Agent == a.name && t.status == jobRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// start queues a task for each agent the request names.
+func (c *coordinator) start(req fleetRequest) (*fleetJob, error) {
+	if _, err := req.options(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	agents := req.Agents
+	if len(agents) == 0 {
+		for name, a := range c.agents {
+			if c.online(a) {
+				agents = append(agents, name)
+			}
+		}
+		if len(agents) == 0 {
+			return nil, errors.New("no agents are online")
+		}
+	}
+	for _, name := range agents {
+		if _, ok := c.agents[name]; !ok {
+			return nil, fmt.Errorf("unknown agent %q", name)
+		}
+	}
+
+	c.seq++
+	j := &fleetJob{id: fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405"), c.seq), created: time.Now()}
+	j.dir = filepath.Join(c.dataDir, j.id)
+	if err := os.MkdirAll(j.dir, 0o755); err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Compact(slices.Sorted(slices.Values(agents))) {
+		t := &task{fleetTask: fleetTask{ID: j.id + "." + name, Job: j.id, Agent: name, Scan: req.scanRequest}, status: jobQueued}
+		j.tasks = append(j.tasks, t)
+		c.tasks[t.ID] = t
+		c.queue = append(c.queue, t)
+		select {
+		case c.agents[name].wake <- struct{}{}:
+		default:
+		}
+	}
+	c.jobs[j.id] = j
+	return j, nil
+}
+
+// next hands the agent its oldest queued task, if any.
+func (c *coordinator) next(agent string) *task {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, t := range c.queue {
+		if t.Agent == agent {
+			c.queue = slices.Delete(c.queue, i, i+1)
+			t.status = jobRunning
+			return t
+		}
+	}
+	return nil
+}
+
+// checkAgentName rejects agent names that can't name files in the data
+// directory: empty ones, those starting with a dot (such as ".."), and
+// those containing a path separator, a drive colon or a NUL.
+func checkAgentName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\:\x00") {
+		return fmt.Errorf("agent name %q must not be empty, start with '.' or contain slashes, colons or NULs", name)
+	}
+	return nil
+}
+
+// output is where a task's records are kept. Agent names are checked when
+// they register, so it stays inside the job's directory.
+func (c *coordinator) output(t *task) string {
+	return filepath.Join(c.dataDir, t.Job, t.Agent+".json")
+}
+
+// prune removes the oldest finished jobs beyond the retention limit. The
+// caller holds c.mu.
+func (c *coordinator) prune() {
+	var finished []*fleetJob
+	for _, j := range c.jobs {
+		if !slices.ContainsFunc(j.tasks, func(t *task) bool { return !t.finished() }) {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= c.keep {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].created.Before(finished[b].created) })
+	for _, j := range finished[:len(finished)-c.keep] {
+		for _, t := range j.tasks {
+			delete(c.tasks, t.ID)
+		}
+		delete(c.jobs, j.id)
+		os.RemoveAll(j.dir)
+	}
+}
+
+// finish records the end of a task. The caller holds c.mu.
+func (c *coordinator) finish(t *task, status, errMsg string) {
+	t.status, t.err, t.uploading = status, errMsg, false
+	if status != jobDone {
+		os.Remove(c.output(t))
+	}
+	c.prune()
+}
+
+// handlePoll registers the agent, or refreshes its registration, and
+// waits up to fleetPollWait for a task for it. It answers 204 when none
+// came.
+func (c *coordinator) handlePoll(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := checkAgentName(name); err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	var hello agentHello
+	if err := json.NewDecoder(r.Body).Decode(&hello); err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	c.mu.Lock()
+	a, ok := c.agents[name]
+	if !ok {
+		a = &agentInfo{name: name, wake: make(chan struct{}, 1)}
+		c.agents[name] = a
+		fmt.Printf("Agent %s registered (%s, roots %v)\n", name, hello.Hostname, hello.Roots)
+	}
+	a.hello, a.lastSeen = hello, time.Now()
+	// An agent only polls when idle, so a task it was handed but never
+	// sent results for was lost, e.g. to a restart
+	for _, t := range c.tasks {
+		if t.Agent == name && t.status == jobRunning && !t.uploading {
+			c.finish(t, jobFailed, "agent polled again without sending results")
+		}
+	}
+	c.mu.Unlock()
+
+	timer := time.NewTimer(fleetPollWait)
+	defer timer.Stop()
+	for {
+		if t := c.next(name); t != nil {
+			writeJSON(w, http.StatusOK, t.fleetTask)
+			return
+		}
+		select {
+		case <-a.wake:
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// lookupTask resolves the {id} path value to a running task of the
+// agent named by the X-Agent header.
+func (c *coordinator) lookupTask(r *http.Request) (*task, error) {
+	t, ok := c.tasks[r.PathValue("id")]
+	if !ok || t.Agent != r.Header.Get("X-Agent") {
+		return nil, errors.New("no such task")
+	}
+	return t, nil
+}
+
+// handleUpload receives a task's records as JSON lines, streamed while
+// the agent scans. They are kept only if the whole body arrives.
+func (c *coordinator) handleUpload(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	t, err := c.lookupTask(r)
+	if err == nil && (t.status != jobRunning || t.uploading) {
+		err = fmt.Errorf("task is %s", t.status)
+	}
+	if err != nil {
+		c.mu.Unlock()
+		httpError(w, http.StatusConflict, err)
+		return
+	}
+	t.uploading = true
+	c.mu.Unlock()
+
+	f, err := scan.CreateAtomic(c.output(t))
+	if err == nil {
+		if _, err = io.Copy(f, &lineCounter{r: r.Body, n: &t.files}); err != nil {
+			f.Abort()
+		} else {
+			err = f.Commit()
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.finish(t, jobFailed, "receiving records: "+err.Error())
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	t.uploading = false
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lineCounter counts the newlines read through it.
+type lineCounter struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (l *lineCounter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n.Add(int64(bytes.Count(p[:n], []byte{'\n'})))
+	return n, err
+}
+
+// handleResult ends a task. A scan that failed discards whatever records
+// were received.
+func (c *coordinator) handleResult(w http.ResponseWriter, r *http.Request) {
+	var res taskResult
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, err := c.lookupTask(r)
+	if err != nil {
+		httpError(w, http.StatusNotFound, err)
+		return
+	}
+	if t.status != jobRunning && t.status != jobFailed {
+		httpError(w, http.StatusConflict, fmt.Errorf("task is %s", t.status))
+		return
+	}
+	t.stats = res.Stats
+	switch {
+	case res.Error != "":
+		c.finish(t, jobFailed, res.Error)
+	case t.status == jobFailed:
+		// The upload already failed
+	default:
+		if _, err := os.Stat(c.output(t)); err != nil {
+			c.finish(t, jobFailed, "no records received")
+		} else {
+			c.finish(t, jobDone, "")
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *coordinator) handleAgents(w http.ResponseWriter, r *http.Request) {
+	type agentStatus struct {
+		Name     string    `json:"name"`
+		Hostname string    `json:"hostname"`
+		Roots    []string  `json:"roots"`
+		Online   bool      `json:"online"`
+		LastSeen time.Time `json:"last_seen"`
+	}
+	c.mu.Lock()
+	list := make([]agentStatus, 0, len(c.agents))
+	for _, a := range c.agents {
+		list = append(list, agentStatus{Name: a.name, Hostname: a.hello.Hostname, Roots: a.hello.Roots, Online: c.online(a), LastSeen: a.lastSeen})
+	}
+	c.mu.Unlock()
+	sort.Slice(list, func(a, b int) bool { return list[a].Name < list[b].Name })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (c *coordinator) handleStart(w http.ResponseWriter, r *http.Request) {
+	var req fleetRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	j, err := c.start(req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+j.id)
+	c.handleStatus(w, r, j)
+}
+
+func (c *coordinator) handleList(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	jobs := make([]*fleetJob, 0, len(c.jobs))
+	for _, j := range c.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].created.After(jobs[b].created) })
+	list := make([]any, len(jobs))
+	for i, j := range jobs {
+		list[i] = c.jobStatus(j)
+	}
+	c.mu.Unlock()
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleJob resolves the {id} path value before calling fn.
+func (c *coordinator) handleJob(fn func(http.ResponseWriter, *http.Request, *fleetJob)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		j, ok := c.jobs[r.PathValue("id")]
+		c.mu.Unlock()
+		if !ok {
+			httpError(w, http.StatusNotFound, errors.New("no such job"))
+			return
+		}
+		fn(w, r, j)
+	}
+}
+
+// jobStatus renders a job and its tasks. The caller holds c.mu.
+func (c *coordinator) jobStatus(j *fleetJob) any {
+	type taskStatus struct {
+		Agent  string      `json:"agent"`
+		Status string      `json:"status"`
+		Error  string      `json:"error,omitempty"`
+		Files  int64       `json:"files"`
+		Stats  *scan.Stats `json:"stats,omitempty"`
+	}
+	out := struct {
+		ID      string         `json:"id"`
+		Status  string         `json:"status"`
+		Created time.Time      `json:"created"`
+		Files   int64          `json:"files"`
+		Tasks   []taskStatus   `json:"tasks"`
+		Counts  map[string]int `json:"counts"`
+	}{ID: j.id, Created: j.created, Counts: make(map[string]int)}
+	for _, t := range j.tasks {
+		out.Tasks = append(out.Tasks, taskStatus{Agent: t.Agent, Status: t.status, Error: t.err, Files: t.files.Load(), Stats: t.stats})
+		out.Files += t.files.Load()
+		out.Counts[t.status]++
+	}
+	// Running while any task is unfinished, then done only if all are
+	switch {
+	case out.Counts[jobQueued]+out.Counts[jobRunning] > 0:
+		out.Status = jobRunning
+	case out.Counts[jobDone] == len(j.tasks):
+		out.Status = jobDone
+	case out.Counts[jobCancelled] == len(j.tasks):
+		out.Status = jobCancelled
+	default:
+		out.Status = jobFailed
+	}
+	return out
+}
+
+func (c *coordinator) handleStatus(w http.ResponseWriter, r *http.Request, j *fleetJob) {
+	c.mu.Lock()
+	status := c.jobStatus(j)
+	c.mu.Unlock()
+	code := http.StatusOK
+	if r.Method == http.MethodPost {
+		code = http.StatusAccepted
+	}
+	writeJSON(w, code, status)
+}
+
+// handleCancel cancels the job's tasks no agent has picked up yet.
+// Running tasks finish.
+func (c *coordinator) handleCancel(w http.ResponseWriter, r *http.Request, j *fleetJob) {
+	c.mu.Lock()
+	c.queue = slices.DeleteFunc(c.queue, func(t *task) bool { return t.Job == j.id })
+	for _, t := range j.tasks {
+		if t.status == jobQueued {
+			c.finish(t, jobCancelled, "")
+		}
+	}
+	c.mu.Unlock()
+	c.handleStatus(w, r, j)
+}
+
+// handleOutput sends the records of the job's finished tasks as JSON
+// lines, one agent after another, or only those of ?agent=.
+func (c *coordinator) handleOutput(w http.ResponseWriter, r *http.Request, j *fleetJob) {
+	only := r.URL.Query().Get("agent")
+	var paths []string
+	c.mu.Lock()
+	for _, t := range j.tasks {
+		if t.status == jobDone && (only == "" || t.Agent == only) {
+			paths = append(paths, c.output(t))
+		}
+	}
+	c.mu.Unlock()
+	if only != "" && len(paths) == 0 {
+		httpError(w, http.StatusNotFound, fmt.Errorf("no finished task for agent %q", only))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "file_paths_"+j.id+".json"))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			// Pruned meanwhile
+			continue
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+const (
+	dashboardRedraw  = 250 * time.Millisecond
+	dashboardHistory = 60 // Throughput samples kept, one a second
+	dashboardTopDirs = 8
+)
+
+// sparkBlocks draw the throughput graph, lowest to highest.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// dashboard redraws live scan progress in place of the spinner: where the
+// walk is, how fast it is going, per top-level directory counts, errors,
+// and the deepest and longest paths seen so far. It observes the scan and
+// keeps its own counts, as the scanner's statistics are only safe to read
+// once it has finished.
+type dashboard struct {
+	out      io.Writer
+	roots    []string
+	prefixes []string // Of record paths below each root
+	width    int
+	start    time.Time
+	total    int64 // Files expected, when estimated
+
+	mu           sync.Mutex
+	files, bytes int64
+	listed       int64
+	errors       int64
+	lastError    string
+	tops         map[string]*dirProgress // By first path element below the root
+	current      string                  // Directory listed last
+	longest      string
+	deepest      string
+	deepestDepth int
+	rates        []float64 // Files per second, oldest first
+	lines        int       // Lines drawn by the last frame
+}
+
+type dirProgress struct {
+	files, bytes int64
+}
+
+// newDashboard returns a dashboard for a scan of roots drawn on out.
+func newDashboard(out io.Writer, roots []string) *dashboard {
+	width, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	if width <= 0 {
+		width = 100
+	}
+	d := &dashboard{out: out, roots: roots, width: width, tops: make(map[string]*dirProgress)}
+	for _, root := range roots {
+		d.prefixes = append(d.prefixes, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+	}
+	return d
+}
+
+// isTerminal reports whether f looks like an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (d *dashboard) Observe(r *scan.Record) {
+	// Files directly in the root count under "."; paths rewritten out of
+	// the root's form under "(other)". With several roots, directories
+	// are shown by their path and files directly in a root by the root.
+	top, rel := "(other)", r.Path
+	for i, root := range d.roots {
+		if root != r.Root {
+			continue
+		}
+		var ok bool
+		rel, ok = strings.CutPrefix(r.Path, d.prefixes[i])
+		// --relative paths are already relative to the root
+		if !ok && root != "." && (filepath.IsAbs(r.Path) || !filepath.IsAbs(root)) {
+			break
+		}
+		first, _, nested := strings.Cut(rel, string(filepath.Separator))
+		switch {
+		case len(d.roots) == 1 && nested:
+			top = first
+		case len(d.roots) == 1:
+			top = "."
+		case nested:
+			top = filepath.Join(root, first)
+		default:
+			top = root
+		}
+		break
+	}
+	depth := strings.Count(rel, string(filepath.Separator)) + 1
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.files++
+	d.bytes += r.Size
+	p := d.tops[top]
+	if p == nil {
+		p = &dirProgress{}
+		d.tops[top] = p
+	}
+	p.files++
+	p.bytes += r.Size
+	if len(r.Path) > len(d.longest) {
+		d.longest = r.Path
+	}
+	if depth > d.deepestDepth {
+		d.deepest, d.deepestDepth = r.Path, depth
+	}
+}
+
+func (d *dashboard) ObserveDir(dir *scan.DirRecord) {
+	d.mu.Lock()
+	d.current = dir.Path
+	d.listed++
+	d.mu.Unlock()
+}
+
+// warn counts a skipped entry, shown instead of printing a warning that
+// would scroll the dashboard.
+func (d *dashboard) warn(path string, err error) {
+	d.mu.Lock()
+	d.errors++
+	d.lastError = fmt.Sprintf("%s: %v", path, err)
+	d.mu.Unlock()
+}
+
+// run redraws the dashboard until the returned function is called,
+// which also erases it.
+func (d *dashboard) run() (stop func()) {
+	d.start = time.Now()
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(dashboardRedraw)
+		defer ticker.Stop()
+		var lastFiles int64
+		lastSample := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if elapsed := now.Sub(lastSample); elapsed >= time.Second {
+					lastFiles = d.sample(lastFiles, elapsed)
+					lastSample = now
+				}
+				d.draw()
+			}
+		}
+	}()
+	return func() {
+		done <- true
+		wg.Wait()
+		d.erase()
+	}
+}
+
+// sample adds the throughput since the last sample, when lastFiles had
+// been recorded, to the graph, returning the files recorded now.
+func (d *dashboard) sample(lastFiles int64, elapsed time.Duration) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rates = append(d.rates, float64(d.files-lastFiles)/elapsed.Seconds())
+	if len(d.rates) > dashboardHistory {
+		d.rates = d.rates[len(d.rates)-dashboardHistory:]
+	}
+	return d.files
+}
+
+// draw replaces the last frame.
+func (d *dashboard) draw() {
+	frame := d.frame()
+	var b strings.Builder
+	if d.lines > 0 {
+		fmt.Fprintf(&b, "\033[%dA", d.lines)
+	}
+	for _, line := range frame {
+		b.WriteString("\r\033[K")
+		b.WriteString(truncateMiddle(printable(line), d.width-1))
+		b.WriteByte('\n')
+	}
+	// Clear what is left of a taller previous frame
+	b.WriteString("\033[J")
+	d.lines = len(frame)
+	io.WriteString(d.out, b.String())
+}
+
+// erase removes the last frame.
+func (d *dashboard) erase() {
+	if d.lines > 0 {
+		fmt.Fprintf(d.out, "\033[%dA\r\033[J", d.lines)
+		d.lines = 0
+	}
+}
+
+// frame renders the dashboard's lines.
+func (d *dashboard) frame() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Sizes are only collected for some columns and reports
+	sizes := d.bytes > 0
+	size := "not collected"
+	if sizes {
+		size = scan.FormatBytes(d.bytes)
+	}
+	lines := []string{
+		fmt.Sprintf("Scanning %s  %s", strings.Join(d.roots, ", "), time.Since(d.start).Round(time.Second)),
+		fmt.Sprintf("  Files %d   Directories %d   Size %s   Errors %d", d.files, d.listed, size, d.errors),
+		"  Rate  " + d.rateLine(),
+		"  Now   " + d.current,
+		fmt.Sprintf("  Longest path (%d)  %s", len(d.longest), d.longest),
+		fmt.Sprintf("  Deepest path (%d)  %s", d.deepestDepth, d.deepest),
+	}
+	if d.total > 0 {
+		lines = slices.Insert(lines, 2, "  Progress "+progressText(d.files, d.total, time.Since(d.start)))
+	}
+	if d.lastError != "" {
+		lines = append(lines, "  Last error  "+d.lastError)
+	}
+	lines = append(lines, "")
+
+	names := make([]string, 0, len(d.tops))
+	for name := range d.tops {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(a, b int) bool {
+		if d.tops[names[a]].files != d.tops[names[b]].files {
+			return d.tops[names[a]].files > d.tops[names[b]].files
+		}
+		return names[a] < names[b]
+	})
+	header := fmt.Sprintf("  %-40s %12s", "Directory", "Files")
+	if sizes {
+		header += fmt.Sprintf(" %12s", "Size")
+	}
+	lines = append(lines, header)
+	for i, name := range names {
+		if i == dashboardTopDirs {
+			lines = append(lines, fmt.Sprintf("  ... and %d more", len(names)-i))
+			break
+		}
+		p := d.tops[name]
+		line := fmt.Sprintf("  %-40s %12d", truncateMiddle(name, 40), p.files)
+		if sizes {
+			line += fmt.Sprintf(" %12s", scan.FormatBytes(p.bytes))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// rateLine renders the current throughput and a graph of the last
+// minute's. The caller holds d.mu.
+func (d *dashboard) rateLine() string {
+	if len(d.rates) == 0 {
+		return "measuring..."
+	}
+	peak := 0.0
+	for _, r := range d.rates {
+		peak = max(peak, r)
+	}
+	var graph strings.Builder
+	for _, r := range d.rates {
+		i := 0
+		if peak > 0 {
+			i = int(r / peak * float64(len(sparkBlocks)-1))
+		}
+		graph.WriteRune(sparkBlocks[i])
+	}
+	return fmt.Sprintf("%.0f files/s  %s  peak %.0f/s", d.rates[len(d.rates)-1], graph.String(), peak)
+}
+
+// printable replaces control characters, which would break the layout.
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+// truncateMiddle shortens s to width runes, eliding its middle, which
+// keeps both the start and the file name of a path.
+func truncateMiddle(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width || width < 5 {
+		return s
+	}
+	keep := width - 1
+	return string(r[:keep/2]) + "…" + string(r[len(r)-(keep-keep/2):])
+}
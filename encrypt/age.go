@@ -0,0 +1,272 @@
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Writing follows the age v1 format (https://age-encryption.org/v1) with
+// X25519 recipients: a text header wrapping a random file key for each
+// recipient, then the payload in 64 KiB ChaCha20-Poly1305 chunks.
+
+const (
+	ageIntro     = "age-encryption.org/v1\n"
+	ageX25519    = "age-encryption.org/v1/X25519"
+	ageFileKey   = 16
+	ageChunk     = 64 << 10
+	ageNonceSize = 16
+	ageColumns   = 64 // Width of wrapped stanza body lines
+)
+
+// b64 is the unpadded base64 age uses throughout its header.
+var b64 = base64.RawStdEncoding
+
+// parseAgeRecipients reads X25519 recipients from spec: an age1...
+// recipient itself, or a file of them, one per line, with # comments.
+func parseAgeRecipients(spec string) ([]*ecdh.PublicKey, error) {
+	if strings.HasPrefix(spec, "age1") {
+		k, err := parseAgeRecipient(spec)
+		if err != nil {
+			return nil, err
+		}
+		return []*ecdh.PublicKey{k}, nil
+	}
+	f, err := os.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys []*ecdh.PublicKey
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, err := parseAgeRecipient(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", spec, n, err)
+		}
+		keys = append(keys, k)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no age recipients", spec)
+	}
+	return keys, nil
+}
+
+// parseAgeRecipient decodes an age1... X25519 recipient.
+func parseAgeRecipient(s string) (*ecdh.PublicKey, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad age recipient %q: %w", s, err)
+	}
+	if hrp != "age" || len(data) != 32 {
+		return nil, fmt.Errorf("bad age recipient %q: not an X25519 public key", s)
+	}
+	return ecdh.X25519().NewPublicKey(data)
+}
+
+// ageWriter encrypts what is written to it as an age payload. A chunk is
+// only sealed once more data follows it, since the last chunk is marked.
+type ageWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	nonce   [chachaNonceSize]byte
+	err     error
+}
+
+// newAgeWriter writes an age header for recipients to w and returns a
+// writer for the payload.
+func newAgeWriter(w io.Writer, recipients []*ecdh.PublicKey) (*ageWriter, error) {
+	fileKey := make([]byte, ageFileKey)
+	rand.Read(fileKey)
+	header, err := ageHeader(fileKey, recipients)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, ageNonceSize)
+	rand.Read(nonce)
+	payloadKey, err := hkdf.Key(sha256.New, fileKey, nonce, "payload", chachaKeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newChaCha20Poly1305(payloadKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(header, nonce...)); err != nil {
+		return nil, err
+	}
+	return &ageWriter{w: w, aead: aead, buf: make([]byte, 0, ageChunk+poly1305TagSize)}, nil
+}
+
+// ageHeader builds the header: a stanza wrapping fileKey for each
+// recipient and a MAC keyed by fileKey.
+func ageHeader(fileKey []byte, recipients []*ecdh.PublicKey) ([]byte, error) {
+	var h bytes.Buffer
+	h.WriteString(ageIntro)
+	for _, r := range recipients {
+		eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := eph.ECDH(r)
+		if err != nil {
+			return nil, err
+		}
+		share := eph.PublicKey().Bytes()
+		salt := append(append([]byte(nil), share...), r.Bytes()...)
+		wrapKey, err := hkdf.Key(sha256.New, shared, salt, ageX25519, chachaKeySize)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newChaCha20Poly1305(wrapKey)
+		if err != nil {
+			return nil, err
+		}
+		body := b64.EncodeToString(aead.Seal(nil, make([]byte, chachaNonceSize), fileKey, nil))
+		h.WriteString("-> X25519 " + b64.EncodeToString(share) + "\n")
+		// The body's last line is always short, empty if need be
+		for len(body) >= ageColumns {
+			h.WriteString(body[:ageColumns] + "\n")
+			body = body[ageColumns:]
+		}
+		h.WriteString(body + "\n")
+	}
+	h.WriteString("---")
+	macKey, err := hkdf.Key(sha256.New, fileKey, nil, "header", sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(h.Bytes())
+	h.WriteString(" " + b64.EncodeToString(mac.Sum(nil)) + "\n")
+	return h.Bytes(), nil
+}
+
+func (a *ageWriter) Write(p []byte) (int, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if len(a.buf) == ageChunk {
+			if a.err = a.seal(false); a.err != nil {
+				return n - len(p), a.err
+			}
+		}
+		k := min(len(p), ageChunk-len(a.buf))
+		a.buf = append(a.buf, p[:k]...)
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// Close seals the last chunk. It doesn't close the underlying writer.
+func (a *ageWriter) Close() error {
+	if a.err != nil {
+		return a.err
+	}
+	a.err = a.seal(true)
+	if a.err == nil {
+		a.err = errors.New("age: write after close")
+		return nil
+	}
+	return a.err
+}
+
+// seal encrypts and writes the buffered chunk.
+func (a *ageWriter) seal(last bool) error {
+	binary.BigEndian.PutUint64(a.nonce[3:11], a.counter)
+	if last {
+		a.nonce[11] = 1
+	}
+	a.counter++
+	out := a.aead.Seal(a.buf[:0], a.nonce[:], a.buf, nil)
+	a.buf = a.buf[:0]
+	_, err := a.w.Write(out)
+	return err
+}
+
+// bech32Decode decodes a BIP 173 Bech32 string, returning its
+// human-readable part and data converted to bytes.
+func bech32Decode(s string) (string, []byte, error) {
+	const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, errors.New("bad separator position")
+	}
+	hrp := s[:sep]
+	values := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		v := strings.IndexByte(charset, s[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("bad character %q", s[i])
+		}
+		values = append(values, byte(v))
+	}
+	expanded := make([]byte, 0, 2*len(hrp)+1+len(values))
+	for i := range len(hrp) {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := range len(hrp) {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	if bech32Polymod(append(expanded, values...)) != 1 {
+		return "", nil, errors.New("bad checksum")
+	}
+	// Regroup the 5-bit values, minus the checksum, into bytes
+	var data []byte
+	var acc, bitCount uint
+	for _, v := range values[:len(values)-6] {
+		acc = acc<<5 | uint(v)
+		bitCount += 5
+		if bitCount >= 8 {
+			bitCount -= 8
+			data = append(data, byte(acc>>bitCount))
+		}
+	}
+	if bitCount >= 5 || acc&(1<<bitCount-1) != 0 {
+		return "", nil, errors.New("bad padding")
+	}
+	return hrp, data, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := range 5 {
+			if top>>i&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
@@ -0,0 +1,233 @@
+package encrypt
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// The standard library only has ChaCha20-Poly1305 internally, so this is
+// the RFC 8439 construction written out. Only sealing is needed, but Open
+// is implemented too so the type is a complete cipher.AEAD.
+
+const (
+	chachaKeySize   = 32
+	chachaNonceSize = 12
+	poly1305TagSize = 16
+)
+
+type chacha20Poly1305 struct {
+	key [8]uint32
+}
+
+// newChaCha20Poly1305 returns the ChaCha20-Poly1305 AEAD of RFC 8439.
+func newChaCha20Poly1305(key []byte) (cipher.AEAD, error) {
+	if len(key) != chachaKeySize {
+		return nil, errors.New("chacha20poly1305: bad key length")
+	}
+	c := &chacha20Poly1305{}
+	for i := range c.key {
+		c.key[i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	return c, nil
+}
+
+func (c *chacha20Poly1305) NonceSize() int { return chachaNonceSize }
+func (c *chacha20Poly1305) Overhead() int  { return poly1305TagSize }
+
+func (c *chacha20Poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != chachaNonceSize {
+		panic("chacha20poly1305: bad nonce length")
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+poly1305TagSize)
+	var polyKey [64]byte
+	c.block(&polyKey, 0, nonce)
+	c.xorKeyStream(out[:len(plaintext)], plaintext, nonce)
+	tag := aeadTag(polyKey[:32], additionalData, out[:len(plaintext)])
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+func (c *chacha20Poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != chachaNonceSize {
+		panic("chacha20poly1305: bad nonce length")
+	}
+	if len(ciphertext) < poly1305TagSize {
+		return nil, errors.New("chacha20poly1305: message authentication failed")
+	}
+	body, tag := ciphertext[:len(ciphertext)-poly1305TagSize], ciphertext[len(ciphertext)-poly1305TagSize:]
+	var polyKey [64]byte
+	c.block(&polyKey, 0, nonce)
+	want := aeadTag(polyKey[:32], additionalData, body)
+	if subtle.ConstantTimeCompare(want[:], tag) != 1 {
+		return nil, errors.New("chacha20poly1305: message authentication failed")
+	}
+	ret, out := sliceForAppend(dst, len(body))
+	c.xorKeyStream(out, body, nonce)
+	return ret, nil
+}
+
+// xorKeyStream encrypts src into dst with the key stream starting at
+// block 1, block 0 being the Poly1305 key.
+func (c *chacha20Poly1305) xorKeyStream(dst, src, nonce []byte) {
+	var ks [64]byte
+	for counter := uint32(1); len(src) > 0; counter++ {
+		c.block(&ks, counter, nonce)
+		n := subtle.XORBytes(dst, src, ks[:])
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+// block computes one 64-byte ChaCha20 key stream block.
+func (c *chacha20Poly1305) block(out *[64]byte, counter uint32, nonce []byte) {
+	s := [16]uint32{
+		0x61707865, 0x3320646e, 0x79622d32, 0x6b206574,
+		c.key[0], c.key[1], c.key[2], c.key[3], c.key[4], c.key[5], c.key[6], c.key[7],
+		counter,
+		binary.LittleEndian.Uint32(nonce[0:]), binary.LittleEndian.Uint32(nonce[4:]), binary.LittleEndian.Uint32(nonce[8:]),
+	}
+	x := s
+	for range 10 {
+		quarterRound(&x, 0, 4, 8, 12)
+		quarterRound(&x, 1, 5, 9, 13)
+		quarterRound(&x, 2, 6, 10, 14)
+		quarterRound(&x, 3, 7, 11, 15)
+		quarterRound(&x, 0, 5, 10, 15)
+		quarterRound(&x, 1, 6, 11, 12)
+		quarterRound(&x, 2, 7, 8, 13)
+		quarterRound(&x, 3, 4, 9, 14)
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[4*i:], x[i]+s[i])
+	}
+}
+
+func quarterRound(x *[16]uint32, a, b, c, d int) {
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+}
+
+// aeadTag is the Poly1305 tag over the additional data and ciphertext,
+// each padded to 16 bytes, followed by their lengths.
+func aeadTag(key, ad, ct []byte) [poly1305TagSize]byte {
+	p := newPoly1305(key)
+	p.writePadded(ad)
+	p.writePadded(ct)
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:], uint64(len(ad)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(ct)))
+	p.blocks(lengths[:])
+	return p.sum()
+}
+
+// poly1305 is the one-time authenticator of RFC 8439 section 2.5, with
+// the accumulator h in three 64-bit limbs (h2 holds just a few bits).
+type poly1305 struct {
+	r0, r1     uint64
+	s0, s1     uint64
+	h0, h1, h2 uint64
+}
+
+func newPoly1305(key []byte) *poly1305 {
+	return &poly1305{
+		r0: binary.LittleEndian.Uint64(key[0:]) & 0x0ffffffc0fffffff,
+		r1: binary.LittleEndian.Uint64(key[8:]) & 0x0ffffffc0ffffffc,
+		s0: binary.LittleEndian.Uint64(key[16:]),
+		s1: binary.LittleEndian.Uint64(key[24:]),
+	}
+}
+
+// writePadded adds msg zero-padded to a multiple of 16 bytes.
+func (p *poly1305) writePadded(msg []byte) {
+	full := len(msg) &^ 15
+	p.blocks(msg[:full])
+	if rest := msg[full:]; len(rest) > 0 {
+		var buf [16]byte
+		copy(buf[:], rest)
+		p.blocks(buf[:])
+	}
+}
+
+// blocks adds whole 16-byte blocks, each with the 2^128 bit set.
+func (p *poly1305) blocks(msg []byte) {
+	h0, h1, h2 := p.h0, p.h1, p.h2
+	r0, r1 := p.r0, p.r1
+	for ; len(msg) >= 16; msg = msg[16:] {
+		var c uint64
+		h0, c = bits.Add64(h0, binary.LittleEndian.Uint64(msg[0:]), 0)
+		h1, c = bits.Add64(h1, binary.LittleEndian.Uint64(msg[8:]), c)
+		h2 += c + 1
+
+		// h *= r, as a 4-limb product; r's clamping keeps h2*r small
+		h0r0hi, h0r0lo := bits.Mul64(h0, r0)
+		h1r0hi, h1r0lo := bits.Mul64(h1, r0)
+		h0r1hi, h0r1lo := bits.Mul64(h0, r1)
+		h1r1hi, h1r1lo := bits.Mul64(h1, r1)
+		h2r0 := h2 * r0
+		h2r1 := h2 * r1
+
+		t0 := h0r0lo
+		m1lo, c1 := bits.Add64(h1r0lo, h0r1lo, 0)
+		m1hi, _ := bits.Add64(h1r0hi, h0r1hi, c1)
+		m2lo, c2 := bits.Add64(h2r0, h1r1lo, 0)
+		m2hi, _ := bits.Add64(0, h1r1hi, c2)
+		m3 := h2r1
+
+		t1, c := bits.Add64(m1lo, h0r0hi, 0)
+		t2, c := bits.Add64(m2lo, m1hi, c)
+		t3, _ := bits.Add64(m3, m2hi, c)
+
+		// Reduce modulo 2^130-5: the part above 2^130, times 5, is
+		// added back as itself plus itself shifted right by 2
+		h0, h1, h2 = t0, t1, t2&3
+		cLo, cHi := t2&^3, t3
+		h0, c = bits.Add64(h0, cLo, 0)
+		h1, c = bits.Add64(h1, cHi, c)
+		h2 += c
+		cLo, cHi = cLo>>2|cHi<<62, cHi>>2
+		h0, c = bits.Add64(h0, cLo, 0)
+		h1, c = bits.Add64(h1, cHi, c)
+		h2 += c
+	}
+	p.h0, p.h1, p.h2 = h0, h1, h2
+}
+
+// sum returns the tag: h fully reduced, plus s, modulo 2^128.
+func (p *poly1305) sum() [poly1305TagSize]byte {
+	h0, h1, h2 := p.h0, p.h1, p.h2
+	g0, b := bits.Sub64(h0, 0xfffffffffffffffb, 0)
+	g1, b := bits.Sub64(h1, 0xffffffffffffffff, b)
+	_, b = bits.Sub64(h2, 3, b)
+	// Without a borrow h >= p, so h-p is the reduced value
+	mask := b - 1
+	h0 = h0&^mask | g0&mask
+	h1 = h1&^mask | g1&mask
+
+	var c uint64
+	h0, c = bits.Add64(h0, p.s0, 0)
+	h1, _ = bits.Add64(h1, p.s1, c)
+	var tag [poly1305TagSize]byte
+	binary.LittleEndian.PutUint64(tag[0:], h0)
+	binary.LittleEndian.PutUint64(tag[8:], h1)
+	return tag
+}
+
+// sliceForAppend extends in by n bytes, returning the whole slice and
+// the new tail.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	return head, head[len(in):]
+}
@@ -0,0 +1,65 @@
+// Package encrypt encrypts scan outputs as they are written, so an
+// inventory of sensitive path names never reaches disk in plaintext.
+// age X25519 recipients are supported natively; GPG through the gpg
+// binary.
+package encrypt
+
+import (
+	"crypto/ecdh"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Encrypter encrypts outputs to a set of recipients.
+type Encrypter struct {
+	age []*ecdh.PublicKey
+	gpg string
+}
+
+// New parses a recipient spec:
+//
+//	age:FILE       age recipients (age1...) listed one per line in FILE
+//	age:age1...    a single age recipient
+//	gpg:USER-ID    a public key in gpg's keyring
+//
+// Recipients are checked up front, so a bad spec fails before anything
+// is written.
+func New(spec string) (*Encrypter, error) {
+	scheme, arg, _ := strings.Cut(spec, ":")
+	if arg == "" {
+		return nil, fmt.Errorf("bad encryption spec %q (want age:FILE, age:age1... or gpg:USER-ID)", spec)
+	}
+	switch scheme {
+	case "age":
+		keys, err := parseAgeRecipients(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &Encrypter{age: keys}, nil
+	case "gpg":
+		if err := checkGPGKey(arg); err != nil {
+			return nil, err
+		}
+		return &Encrypter{gpg: arg}, nil
+	}
+	return nil, fmt.Errorf("unsupported encryption %q (want age or gpg)", scheme)
+}
+
+// Ext is the extension conventional for the encrypted files: ".age" or
+// ".gpg".
+func (e *Encrypter) Ext() string {
+	if e.gpg != "" {
+		return ".gpg"
+	}
+	return ".age"
+}
+
+// Wrap returns a writer encrypting to w. Close finishes the encrypted
+// stream, without which it can't be decrypted; it doesn't close w.
+func (e *Encrypter) Wrap(w io.Writer) (io.WriteCloser, error) {
+	if e.gpg != "" {
+		return newGPGWriter(w, e.gpg)
+	}
+	return newAgeWriter(w, e.age)
+}
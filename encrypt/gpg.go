@@ -0,0 +1,77 @@
+package encrypt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// gpgWriter pipes what is written to it through gpg, which encrypts to
+// the underlying writer.
+type gpgWriter struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+	waited bool
+	err    error
+}
+
+// checkGPGKey fails unless gpg has a public key for recipient.
+func checkGPGKey(recipient string) error {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return err
+	}
+	out, err := exec.Command("gpg", "--batch", "--list-keys", "--", recipient).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("gpg has no public key for %q: %s", recipient, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func newGPGWriter(w io.Writer, recipient string) (*gpgWriter, error) {
+	g := &gpgWriter{}
+	g.cmd = exec.Command("gpg", "--batch", "--yes", "--encrypt", "--recipient", recipient, "--output", "-")
+	g.cmd.Stdout = w
+	g.cmd.Stderr = &g.stderr
+	stdin, err := g.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	g.stdin = stdin
+	if err := g.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *gpgWriter) Write(p []byte) (int, error) {
+	n, err := g.stdin.Write(p)
+	if err != nil {
+		// gpg exiting early is the likelier story than the pipe
+		return n, g.wait()
+	}
+	return n, nil
+}
+
+// Close ends the input and waits for gpg to finish writing.
+func (g *gpgWriter) Close() error {
+	g.stdin.Close()
+	return g.wait()
+}
+
+func (g *gpgWriter) wait() error {
+	if g.waited {
+		return g.err
+	}
+	g.waited = true
+	if err := g.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(g.stderr.String()); msg != "" {
+			g.err = fmt.Errorf("gpg: %s", msg)
+		} else {
+			g.err = fmt.Errorf("gpg: %w", err)
+		}
+	}
+	return g.err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// expectedFiles returns how many files a previous scan recorded, from its
+// metadata sidecar when it has one, or else by counting its rows.
+func expectedFiles(output string) (int64, error) {
+	if b, err := os.ReadFile(output + metadataExt); err == nil {
+		var m scanMetadata
+		if json.Unmarshal(b, &m) == nil && m.Stats != nil && m.Stats.Files > 0 {
+			return m.Stats.Files, nil
+		}
+	}
+	r, err := scan.OpenOutput(output)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	var n int64
+	for {
+		if _, err := r.Next(); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// progressText renders n files of an expected total with a percentage
+// and, once there is a rate to go by, the time left. Past the estimate
+// it holds at 99% rather than claim the scan is done.
+func progressText(n, total int64, elapsed time.Duration) string {
+	pct := min(n*100/total, 99)
+	text := fmt.Sprintf("%d of ~%d files (%d%%", n, total, pct)
+	if n > 0 && n < total && elapsed >= time.Second {
+		left := time.Duration(float64(elapsed) * float64(total-n) / float64(n))
+		text += ", ETA " + left.Round(time.Second).String()
+	}
+	return text + ")"
+}
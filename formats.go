@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// sinkFormats builds a sink for each output format, keyed by the name used
+// in flags and as the file extension.
+var sinkFormats = map[string]func(w io.Writer) scan.Sink{
+	"csv":     func(w io.Writer) scan.Sink { return scan.NewCSVSink(w) },
+	"json":    func(w io.Writer) scan.Sink { return scan.NewJSONSink(w) },
+	"parquet": func(w io.Writer) scan.Sink { return scan.NewParquetSink(w) },
+}
+
+// newSink returns a sink writing format to w.
+func newSink(format string, w io.Writer) (scan.Sink, error) {
+	factory, ok := sinkFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported output format %q (available: %s)", format, strings.Join(formatNames(), ", "))
+	}
+	return factory(w), nil
+}
+
+// formatNames lists the output formats.
+func formatNames() []string {
+	names := make([]string, 0, len(sinkFormats))
+	for name := range sinkFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keepSafe puts a CSV sink in safe mode when safe is set.
+func keepSafe(sink scan.Sink, safe bool) {
+	if csvSink, ok := sink.(*scan.CSVSink); ok && safe {
+		csvSink.Safe()
+	}
+}
+
+// formatForPath picks the output format from a file extension, accepting
+// the same extensions scan.OpenOutput reads.
+func formatForPath(path string) (string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	switch ext {
+	case "jsonl", "ndjson":
+		ext = "json"
+	}
+	if _, ok := sinkFormats[ext]; !ok {
+		return "", fmt.Errorf("%s: cannot tell the output format from the extension (available: %s)", path, strings.Join(formatNames(), ", "))
+	}
+	return ext, nil
+}
+
+// writeRows writes text rows, as read by scan.OpenOutput, to a sink of
+// format until next returns io.EOF. Rows are parsed back into records so
+// the sink types the values as a scan would have. With safe, CSV is
+// written in safe mode, as rows read from a safe output should be.
+func writeRows(format string, w io.Writer, columns []string, safe bool, next func() ([]string, error)) (int, error) {
+	sink, err := newSink(format, w)
+	if err != nil {
+		return 0, err
+	}
+	keepSafe(sink, safe)
+	if err := sink.WriteHeader(scan.OutputColumns(columns)); err != nil {
+		return 0, err
+	}
+	const batchSize = 1000
+	batch := make([]scan.Record, 0, batchSize)
+	n := 0
+	for {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		rec, err := scan.RecordFromRow(columns, row)
+		if err != nil {
+			return n, fmt.Errorf("row %d: %w", n+1, err)
+		}
+		batch = append(batch, rec)
+		n++
+		if len(batch) == batchSize {
+			if err := sink.WriteBatch(batch); err != nil {
+				return n, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := sink.WriteBatch(batch); err != nil {
+		return n, err
+	}
+	if err := sink.Flush(); err != nil {
+		return n, err
+	}
+	return n, scan.CloseSink(sink)
+}
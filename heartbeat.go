@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// heartbeat tracks whether a running scan is still making progress, so
+// monitors can tell a long scan from one hung on a stuck mount.
+type heartbeat struct {
+	scanner *scan.Scanner
+	start   time.Time
+
+	mu                   sync.Mutex
+	files, dirs, skipped int64
+	lastProgress         time.Time
+	finished             bool
+}
+
+// heartbeatStatus is what heartbeat files and the serve liveness endpoint
+// report.
+type heartbeatStatus struct {
+	State          string    `json:"state"` // "running" or "finished"
+	PID            int       `json:"pid"`
+	Time           time.Time `json:"time"`
+	Start          time.Time `json:"start"`
+	Files          int64     `json:"files"`
+	Dirs           int64     `json:"directories"`
+	Skipped        int64     `json:"skipped"`
+	LastProgress   time.Time `json:"last_progress"`
+	StalledSeconds float64   `json:"stalled_seconds"` // Since the counts last moved
+}
+
+func newHeartbeat(scanner *scan.Scanner) *heartbeat {
+	now := time.Now()
+	return &heartbeat{scanner: scanner, start: now, lastProgress: now}
+}
+
+// status samples the scan's progress.
+func (h *heartbeat) status() heartbeatStatus {
+	files, dirs, skipped := h.scanner.Progress()
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if files != h.files || dirs != h.dirs || skipped != h.skipped {
+		h.files, h.dirs, h.skipped = files, dirs, skipped
+		h.lastProgress = now
+	}
+	st := heartbeatStatus{
+		State:          "running",
+		PID:            os.Getpid(),
+		Time:           now,
+		Start:          h.start,
+		Files:          files,
+		Dirs:           dirs,
+		Skipped:        skipped,
+		LastProgress:   h.lastProgress,
+		StalledSeconds: now.Sub(h.lastProgress).Seconds(),
+	}
+	if h.finished {
+		st.State = "finished"
+		st.StalledSeconds = 0
+	}
+	return st
+}
+
+// stalled reports whether the scan is running but hasn't moved for limit.
+func (h *heartbeat) stalled(limit time.Duration) bool {
+	st := h.status()
+	return st.State == "running" && st.Time.Sub(st.LastProgress) >= limit
+}
+
+// run rewrites path with the scan's status now and every interval until
+// the returned function is called, which writes it a last time as
+// finished.
+func (h *heartbeat) run(path string, interval time.Duration) (stop func()) {
+	write := func() {
+		if err := writeJSONFile(path, h.status()); err != nil {
+			fmt.Fprintf(os.Stderr, "\r\033[KWarning: writing heartbeat: %v\n", err)
+		}
+	}
+	write()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				write()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+		h.mu.Lock()
+		h.finished = true
+		h.mu.Unlock()
+		write()
+	}
+}
@@ -1,72 +1,80 @@
 package main
 
 import (
-	"encoding/csv"
+	"flag"
 	"fmt"
-	"io/fs"
 	"os"
-	"path/filepath"
-	"strconv"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
-const defaultBatchSize = 100
+// commands maps subcommand names to their entry points. Anything else on
+// the command line is treated as arguments to scan, so the original
+// `file_paths <directory> [batch_size]` form keeps working.
+var commands = map[string]func(args []string){
+	"scan":        runScan,
+	"dupes":       runDupes,
+	"watch":       runWatch,
+	"serve":       runServe,
+	"daemon":      runDaemon,
+	"diff":        runDiff,
+	"merge":       runMerge,
+	"convert":     runConvert,
+	"query":       runQuery,
+	"verify":      runVerify,
+	"refresh":     runRefresh,
+	"check":       runCheck,
+	"bag":         runBag,
+	"rename":      runRename,
+	"verify-sig":  runVerifySig,
+	"agent":       runAgent,
+	"coordinator": runCoordinator,
+}
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <directory> [batch_size]\n", os.Args[0])
-		os.Exit(1)
-	}
-
-	dirPath := os.Args[1]
-
-	batchSize := defaultBatchSize
-	if len(os.Args) >= 3 {
-		size, err := strconv.Atoi(os.Args[2])
-		if err != nil || size <= 0 {
-			fmt.Fprintf(os.Stderr, "Error: batch_size must be a positive integer\n")
-			os.Exit(1)
+	if len(os.Args) >= 2 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+		if os.Args[1] == "help" {
+			usage()
+			return
 		}
-		batchSize = size
-	}
-
-	// Verify the path is a directory
-	info, err := os.Stat(dirPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error accessing path: %v\n", err)
-		os.Exit(1)
-	}
-	if !info.IsDir() {
-		fmt.Fprintf(os.Stderr, "Error: %s is not a directory\n", dirPath)
-		os.Exit(1)
-	}
-
-	outputFile, err := os.Create("file_paths.csv")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating CSV file: %v\n", err)
-		os.Exit(1)
-	}
-	defer outputFile.Close()
-
-	writer := csv.NewWriter(outputFile)
-	defer writer.Flush()
-
-	if err := writer.Write([]string{"file_path", "path_length"}); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing CSV header: %v\n", err)
-		os.Exit(1)
 	}
+	runScan(os.Args[1:])
+}
 
-	// Channels and Sync
-	// Buffer allows producer to continue scanning while consumer is writing
-	pathChan := make(chan string, 1000)
-	var fileCount int64 // Atomic counter
-	var scanErr error
-	var wg sync.WaitGroup
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags] <args>\n\nCommands:\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "  scan        scan a directory into file_paths.csv (default)")
+	fmt.Fprintln(os.Stderr, "  dupes       find duplicate files by size and content hash")
+	fmt.Fprintln(os.Stderr, "  watch       append create/modify/delete events to a CSV as a tree changes")
+	fmt.Fprintln(os.Stderr, "  serve       run scans over an HTTP API")
+	fmt.Fprintln(os.Stderr, "  daemon      run scans on cron schedules, keeping the last N outputs")
+	fmt.Fprintln(os.Stderr, "  coordinator hand scan jobs to agents on many hosts and collect their records")
+	fmt.Fprintln(os.Stderr, "  agent       run the scan jobs a coordinator hands out on this host")
+	fmt.Fprintln(os.Stderr, "  diff        compare two scan outputs")
+	fmt.Fprintln(os.Stderr, "  merge       combine scan outputs, keeping one row per path")
+	fmt.Fprintln(os.Stderr, "  convert     rewrite a scan output in another format")
+	fmt.Fprintln(os.Stderr, "  query       run SQL over a scan output")
+	fmt.Fprintln(os.Stderr, "  verify      re-hash the files in a scan output and report changes")
+	fmt.Fprintln(os.Stderr, "  refresh     re-stat the paths in a scan output and rewrite it with current metadata")
+	fmt.Fprintln(os.Stderr, "  check       validate a directory against a list of expected files")
+	fmt.Fprintln(os.Stderr, "  bag         make a directory a BagIt bag, with payload manifests")
+	fmt.Fprintln(os.Stderr, "  rename      suggest or apply Windows-safe names for problematic files")
+	fmt.Fprintln(os.Stderr, "  verify-sig  check a scan output against its --sign signature")
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for command flags.\n", os.Args[0])
+}
 
-	// 1. Spinner Goroutine
+// spinner shows label and a running file count until the returned
+// function is called, which also clears the line. With the total
+// expected, it also shows a percentage and ETA.
+func spinner(label string, count func() int64, total int64) (stop func()) {
+	start := time.Now()
 	done := make(chan bool)
+	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -77,69 +85,41 @@ func main() {
 			case <-done:
 				return
 			default:
-				// Atomic load for thread safety
-				count := atomic.LoadInt64(&fileCount)
-				fmt.Printf("\r%c Scanning... %d files found", spinChars[i%len(spinChars)], count)
+				if total > 0 {
+					fmt.Printf("\r%c %s %s\033[K", spinChars[i%len(spinChars)], label, progressText(count(), total, time.Since(start)))
+				} else {
+					fmt.Printf("\r%c %s %d files found", spinChars[i%len(spinChars)], label, count())
+				}
 				i++
 				time.Sleep(100 * time.Millisecond)
 			}
 		}
 	}()
-
-	// 2. Producer Goroutine (Scanner)
-	// Runs concurrently with the writer
-	go func() {
-		defer close(pathChan)
-		// optimization: Use WalkDir instead of Walk (avoids extra os.Stat calls)
-		scanErr = filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			// d.IsDir() checks the directory entry directly, no extra syscall needed
-			if !d.IsDir() {
-				pathChan <- path
-			}
-			return nil
-		})
-	}()
-
-	// 3. Consumer (Writer)
-	// Main goroutine consumes paths from channel and writes to CSV
-	batch := make([][]string, 0, batchSize)
-	for path := range pathChan {
-		pathLength := len(path)
-		batch = append(batch, []string{path, strconv.Itoa(pathLength)})
-
-		if len(batch) >= batchSize {
-			if err := writer.WriteAll(batch); err != nil {
-				fmt.Fprintf(os.Stderr, "\nError writing batch: %v\n", err)
-				os.Exit(1)
-			}
-			// Atomic add
-			atomic.AddInt64(&fileCount, int64(len(batch)))
-			batch = batch[:0] // Reset batch
-		}
+	return func() {
+		done <- true
+		wg.Wait()
+		fmt.Print("\r\033[K") // Clear line
 	}
+}
 
-	// Write remaining records
-	if len(batch) > 0 {
-		if err := writer.WriteAll(batch); err != nil {
-			fmt.Fprintf(os.Stderr, "\nError writing final batch: %v\n", err)
-			os.Exit(1)
-		}
-		atomic.AddInt64(&fileCount, int64(len(batch)))
-	}
+// stringList is a repeatable string flag.
+type stringList []string
 
-	// Stop spinner
-	done <- true
-	wg.Wait()
-	fmt.Print("\r\033[K") // Clear line
+func (l *stringList) String() string { return strings.Join(*l, ",") }
 
-	if scanErr != nil {
-		fmt.Fprintf(os.Stderr, "Error walking directory: %v\n", scanErr)
-		os.Exit(1)
-	}
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
 
-	fmt.Printf("Done! Processed %d files.\n", atomic.LoadInt64(&fileCount))
-	fmt.Println("CSV file created: file_paths.csv")
+// parseArgs parses flags that may appear before, between or after the
+// positional arguments, as in `convert scan.csv --to parquet`, and
+// returns the positional ones.
+func parseArgs(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for flags.Parse(args); flags.NArg() > 0; flags.Parse(args) {
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	return positional
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain lets tests run the command in a child process, since commands
+// exit on errors
+func TestMain(m *testing.M) {
+	if os.Getenv("RFP_TEST_MAIN") == "1" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the command with args in dir, and returns its combined
+// output and whether it succeeded.
+func runMain(t *testing.T, dir string, args ...string) (string, bool) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^$"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "RFP_TEST_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	return string(out), err == nil
+}
+
+// scanFixture scans a small tree into file_paths.csv in a new directory,
+// which it returns.
+func scanFixture(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	tree := filepath.Join(dir, "tree")
+	if err := os.MkdirAll(tree, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(tree, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out, ok := runMain(t, dir, "tree"); !ok {
+		t.Fatalf("scan failed:\n%s", out)
+	}
+	return dir
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// version is the tool's version, set at build time with
+// -ldflags "-X main.version=v1.2.3". Without it, the module version go
+// install recorded is used.
+var version string
+
+// schemaVersion versions the meaning of the output columns. Bump it when
+// an existing column changes; new columns don't need a bump.
+const schemaVersion = 1
+
+// metadataExt names the sidecar written next to an output.
+const metadataExt = ".meta.json"
+
+// Ways --metadata records a scan's metadata.
+const (
+	metadataSidecar = "sidecar"
+	metadataEmbed   = "embed"
+	metadataBoth    = "both"
+)
+
+// scanMetadata describes how an output was made, so it can be understood
+// long after the scan.
+type scanMetadata struct {
+	Tool          string      `json:"tool"`
+	Version       string      `json:"version"`
+	SchemaVersion int         `json:"schema_version"`
+	ScanID        string      `json:"scan_id"`
+	Root          string      `json:"root"`
+	Roots         []string    `json:"roots,omitempty"` // Every root, when several were scanned
+	Host          string      `json:"host"`
+	Start         time.Time   `json:"start"`
+	End           *time.Time  `json:"end,omitempty"`
+	Format        string      `json:"format"`
+	Columns       []string    `json:"columns"`
+	Hash          string      `json:"hash,omitempty"`
+	Stats         *scan.Stats `json:"stats,omitempty"`
+}
+
+// toolVersion returns version, or the module version, or "devel".
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+// finished returns a copy of m completed with the end of the scan.
+func (m scanMetadata) finished(st scan.Stats) scanMetadata {
+	end := st.Start.Add(st.Elapsed).UTC()
+	m.End, m.Stats = &end, &st
+	return m
+}
+
+// writePreamble writes the metadata known before the scan as # comment
+// lines, which scan outputs read back skip.
+func (m scanMetadata) writePreamble(w io.Writer) error {
+	// A line break in the root would end its comment line
+	oneLine := strings.NewReplacer("\n", `\n`, "\r", `\r`)
+	_, err := fmt.Fprintf(w, "# tool: %s\n# version: %s\n# schema_version: %d\n# scan_id: %s\n# root: %s\n# host: %s\n# start: %s\n",
+		m.Tool, m.Version, m.SchemaVersion, m.ScanID, oneLine.Replace(m.Root), m.Host, m.Start.Format(time.RFC3339))
+	return err
+}
+
+// jsonString renders m as compact JSON.
+func (m scanMetadata) jsonString() string {
+	b, _ := json.Marshal(m)
+	return string(b)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// scanNotice is the JSON body POSTed to --notify-url when a scan ends.
+type scanNotice struct {
+	Event      string    `json:"event"`  // scan.finished or scan.failed
+	Status     string    `json:"status"` // ok or failed
+	Job        string    `json:"job,omitempty"`
+	Root       string    `json:"root"`
+	Output     string    `json:"output,omitempty"`
+	ErrorsFile string    `json:"errors_file,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Start      time.Time `json:"start"`
+	Duration   float64   `json:"duration_seconds"`
+	Files      int64     `json:"files"`
+	Dirs       int64     `json:"directories"`
+	Skipped    int64     `json:"skipped"`
+	Bytes      *int64    `json:"total_bytes,omitempty"` // Only when sizes were collected
+}
+
+// newScanNotice describes a finished scan. Output and errorsFile are
+// given as written and recorded as absolute paths; output is dropped when
+// the scan failed, since nothing was written there.
+func newScanNotice(root, output, errorsFile string, st scan.Stats, scanErr error) *scanNotice {
+	n := &scanNotice{
+		Event:    "scan.finished",
+		Status:   "ok",
+		Root:     root,
+		Start:    st.Start.UTC(),
+		Duration: st.Elapsed.Seconds(),
+		Files:    st.Files,
+		Dirs:     st.Dirs,
+		Skipped:  st.Skipped,
+	}
+	if st.BytesKnown {
+		n.Bytes = &st.Bytes
+	}
+	if scanErr != nil {
+		n.Event, n.Status, n.Error = "scan.failed", "failed", scanErr.Error()
+		output = ""
+	}
+	if output != "" {
+		n.Output, _ = filepath.Abs(output)
+	}
+	if errorsFile != "" && st.Skipped > 0 {
+		n.ErrorsFile, _ = filepath.Abs(errorsFile)
+	}
+	return n
+}
+
+// notify POSTs n to url, retrying network errors, 429 and 5xx responses
+// twice.
+func notify(url string, n *scanNotice) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := postNotice(client, url, body)
+		if err == nil {
+			return nil
+		}
+		if _, permanent := err.(permanentError); permanent || attempt == 2 {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// permanentError is a rejection retrying won't change.
+type permanentError struct{ error }
+
+func postNotice(client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// Size is the total size for templates, or "unknown" when sizes weren't
+// collected.
+func (n *scanNotice) Size() string {
+	if n.Bytes == nil {
+		return "unknown"
+	}
+	return scan.FormatBytes(*n.Bytes)
+}
+
+// Elapsed is the duration for templates, rounded for reading.
+func (n *scanNotice) Elapsed() string {
+	return time.Duration(n.Duration * float64(time.Second)).Round(time.Second / 10).String()
+}
+
+// Default notification templates, executed with a *scanNotice.
+const (
+	defaultSubject = `[file_paths] {{if .Job}}{{.Job}}{{else}}{{.Root}}{{end}}: scan {{if eq .Status "ok"}}finished{{else}}failed{{end}}`
+	defaultMessage = `{{if .Job}}Job {{.Job}}: s{{else}}S{{end}}can of {{.Root}} {{if eq .Status "ok"}}finished{{else}}failed: {{.Error}}{{end}}
+Files: {{.Files}}, directories: {{.Dirs}}, skipped: {{.Skipped}}, size: {{.Size}}
+Elapsed: {{.Elapsed}}{{with .Output}}
+Output: {{.}}{{end}}{{with .ErrorsFile}}
+Skipped entries: {{.}}{{end}}
+`
+)
+
+// smtpConfig is the mail server email notifiers send through.
+type smtpConfig struct {
+	Host        string `json:"host"`
+	Port        int    `json:"port"` // Default 587; 465 uses implicit TLS
+	Username    string `json:"username"`
+	PasswordEnv string `json:"password_env"` // Variable holding the password
+	From        string `json:"from"`
+}
+
+// notifierConfig is one entry of a job's "notify" list: an email to
+// some addresses or a message to a Slack incoming webhook.
+type notifierConfig struct {
+	Email    []string `json:"email"`
+	Slack    string   `json:"slack"`
+	On       string   `json:"on"`       // always (default), failure or success
+	Subject  string   `json:"subject"`  // Email subject template
+	Template string   `json:"template"` // Message template
+}
+
+// notifier sends templated notices as configured.
+type notifier struct {
+	notifierConfig
+	smtp    *smtpConfig
+	subject *template.Template
+	message *template.Template
+}
+
+// newNotifier validates cfg and parses its templates. Email needs the
+// config file's smtp section.
+func newNotifier(cfg notifierConfig, smtpCfg *smtpConfig) (*notifier, error) {
+	if (len(cfg.Email) > 0) == (cfg.Slack != "") {
+		return nil, errors.New(`notify: each entry needs exactly one of "email" or "slack"`)
+	}
+	switch cfg.On {
+	case "":
+		cfg.On = "always"
+	case "always", "failure", "success":
+	default:
+		return nil, fmt.Errorf(`notify: "on" must be always, failure or success, not %q`, cfg.On)
+	}
+	if len(cfg.Email) > 0 && (smtpCfg == nil || smtpCfg.Host == "" || smtpCfg.From == "") {
+		return nil, errors.New(`notify: email needs an "smtp" section with host and from`)
+	}
+	n := &notifier{notifierConfig: cfg, smtp: smtpCfg}
+	subject, message := cfg.Subject, cfg.Template
+	if subject == "" {
+		subject = defaultSubject
+	}
+	if message == "" {
+		message = defaultMessage
+	}
+	var err error
+	if n.subject, err = template.New("subject").Parse(subject); err != nil {
+		return nil, fmt.Errorf("notify: subject: %w", err)
+	}
+	if n.message, err = template.New("template").Parse(message); err != nil {
+		return nil, fmt.Errorf("notify: template: %w", err)
+	}
+	return n, nil
+}
+
+// send delivers notice unless the notifier isn't interested in it.
+func (n *notifier) send(notice *scanNotice) error {
+	failed := notice.Status != "ok"
+	if (n.On == "failure" && !failed) || (n.On == "success" && failed) {
+		return nil
+	}
+	var msg strings.Builder
+	if err := n.message.Execute(&msg, notice); err != nil {
+		return err
+	}
+	if n.Slack != "" {
+		body, err := json.Marshal(map[string]string{"text": msg.String()})
+		if err != nil {
+			return err
+		}
+		return postNotice(&http.Client{Timeout: 10 * time.Second}, n.Slack, body)
+	}
+	var subject strings.Builder
+	if err := n.subject.Execute(&subject, notice); err != nil {
+		return err
+	}
+	return n.mail(strings.TrimSpace(subject.String()), msg.String())
+}
+
+// mail sends a plain-text email to the notifier's addresses.
+func (n *notifier) mail(subject, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.smtp.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.Email, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+
+	port := n.smtp.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(n.smtp.Host, strconv.Itoa(port))
+	var auth smtp.Auth
+	if n.smtp.Username != "" {
+		auth = smtp.PlainAuth("", n.smtp.Username, os.Getenv(n.smtp.PasswordEnv), n.smtp.Host)
+	}
+	if port != 465 {
+		// SendMail upgrades with STARTTLS when the server offers it
+		return smtp.SendMail(addr, auth, n.smtp.From, n.Email, msg.Bytes())
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", addr, &tls.Config{ServerName: n.smtp.Host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, n.smtp.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.smtp.From); err != nil {
+		return err
+	}
+	for _, to := range n.Email {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// outputNameData is what an --output-template can refer to.
+type outputNameData struct {
+	Root   string    // The directory scanned as an absolute path, or the URL without credentials
+	Start  time.Time // When the scan started, in local time
+	Format string    // The output's extension, e.g. csv or sha256
+	Host   string    // This machine's host name
+}
+
+// outputNameFuncs are the functions an --output-template may call.
+var outputNameFuncs = template.FuncMap{
+	"base":  filepath.Base,
+	"dir":   filepath.Dir,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"utc":   func(t time.Time) time.Time { return t.UTC() },
+	"replace": func(old, new, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+}
+
+// renderOutputName expands an --output-template such as
+// 'scan_{{.Root | base}}_{{.Start.Format "20060102T150405"}}.csv'.
+func renderOutputName(text string, data outputNameData) (string, error) {
+	tmpl, err := template.New("output-template").Funcs(outputNameFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(b.String())
+	if name == "" || strings.HasSuffix(name, "/") || strings.HasSuffix(name, string(filepath.Separator)) {
+		return "", errors.New("output template gives no file name")
+	}
+	return name, nil
+}
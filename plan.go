@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// planTopDirs is how many directories below the root a plan lists.
+const planTopDirs = 20
+
+// scanPlan is what a scan would do, printed by --dry-run without --action.
+type scanPlan struct {
+	Roots      []string
+	Source     string // "local", or the URL scheme
+	Hash       string
+	Workers    int
+	Filters    []string
+	Transforms []string
+	Output     string
+	Errors     string
+	Companions []string // Other files the scan would write
+}
+
+// printPlan prints p, then counts the files the scan would record below
+// each of the root's directories.
+func printPlan(ctx context.Context, w io.Writer, p scanPlan, scanner *scan.Scanner) error {
+	columns, err := scanner.Columns()
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.Name
+	}
+	fmt.Fprintln(w, "Scan plan (dry run: nothing is written)")
+	label := "Root:"
+	if len(p.Roots) > 1 {
+		label = "Roots:"
+	}
+	fmt.Fprintf(w, "  %-12s %s (%s)\n", label, strings.Join(p.Roots, ", "), p.Source)
+	fmt.Fprintf(w, "  Columns:     %s\n", strings.Join(names, ", "))
+	if p.Hash != "" {
+		fmt.Fprintf(w, "  Hash:        %s\n", p.Hash)
+	}
+	fmt.Fprintf(w, "  Workers:     %d\n", p.Workers)
+	printPlanList(w, "Filters:", p.Filters)
+	printPlanList(w, "Transforms:", p.Transforms)
+	output := p.Output
+	if _, err := os.Stat(p.Output); err == nil {
+		output += " (replacing the existing file)"
+	}
+	fmt.Fprintf(w, "  Output:      %s\n", output)
+	fmt.Fprintf(w, "  Errors:      %s, if anything is skipped\n", p.Errors)
+	printPlanList(w, "Also writes:", p.Companions)
+
+	fmt.Fprint(w, "\nCounting files...")
+	counts, err := scanner.EstimateTop(ctx)
+	fmt.Fprint(w, "\r\033[K")
+	if err != nil {
+		return err
+	}
+	tops := make([]string, 0, len(counts))
+	var total int64
+	for top, n := range counts {
+		tops = append(tops, top)
+		total += n
+	}
+	sort.Slice(tops, func(a, b int) bool {
+		if counts[tops[a]] != counts[tops[b]] {
+			return counts[tops[a]] > counts[tops[b]]
+		}
+		return tops[a] < tops[b]
+	})
+	fmt.Fprintln(w, "Files expected, by directory below the root (estimated):")
+	for i, top := range tops {
+		if i == planTopDirs {
+			var rest int64
+			for _, t := range tops[i:] {
+				rest += counts[t]
+			}
+			fmt.Fprintf(w, "  %-40s %12d\n", fmt.Sprintf("... %d more", len(tops)-i), rest)
+			break
+		}
+		name := top
+		if top == "." || slices.Contains(p.Roots, top) && len(p.Roots) > 1 {
+			name = top + " (the root itself)"
+		}
+		fmt.Fprintf(w, "  %-40s %12d\n", truncateMiddle(printable(name), 40), counts[top])
+	}
+	fmt.Fprintf(w, "  %-40s %12d\n", "Total", total)
+	return nil
+}
+
+// printPlanList prints a labelled list, one item a line, or "none".
+func printPlanList(w io.Writer, label string, items []string) {
+	if len(items) == 0 {
+		fmt.Fprintf(w, "  %-12s none\n", label)
+		return
+	}
+	for i, item := range items {
+		if i > 0 {
+			label = ""
+		}
+		fmt.Fprintf(w, "  %-12s %s\n", label, item)
+	}
+}
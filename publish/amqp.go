@@ -0,0 +1,425 @@
+package publish
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AMQP 0-9-1 frame types.
+const (
+	amqpFrameMethod    = 1
+	amqpFrameHeader    = 2
+	amqpFrameBody      = 3
+	amqpFrameHeartbeat = 8
+	amqpFrameEnd       = 0xce
+)
+
+// AMQP 0-9-1 methods, as class<<16 | method.
+const (
+	amqpConnectionStart    = 10<<16 | 10
+	amqpConnectionStartOk  = 10<<16 | 11
+	amqpConnectionTune     = 10<<16 | 30
+	amqpConnectionTuneOk   = 10<<16 | 31
+	amqpConnectionOpen     = 10<<16 | 40
+	amqpConnectionOpenOk   = 10<<16 | 41
+	amqpConnectionClose    = 10<<16 | 50
+	amqpConnectionCloseOk  = 10<<16 | 51
+	amqpChannelOpen        = 20<<16 | 10
+	amqpChannelOpenOk      = 20<<16 | 11
+	amqpChannelFlow        = 20<<16 | 20
+	amqpChannelFlowOk      = 20<<16 | 21
+	amqpChannelClose       = 20<<16 | 40
+	amqpChannelCloseOk     = 20<<16 | 41
+	amqpBasicPublish       = 60<<16 | 40
+	amqpBasicReturn        = 60<<16 | 50
+	amqpBasicAck           = 60<<16 | 80
+	amqpBasicNack          = 60<<16 | 120
+	amqpConfirmSelect      = 85<<16 | 10
+	amqpConfirmSelectOk    = 85<<16 | 11
+	amqpDefaultFrameMax    = 131072
+	amqpPersistentDelivery = 2
+)
+
+// amqp publishes to an exchange over AMQP 0-9-1, as RabbitMQ speaks it.
+// The channel is in confirm mode, so confirm waits for the broker's acks;
+// messages are published as mandatory, so ones no queue would receive
+// come back and fail the scan instead of being dropped.
+type amqp struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	w        *bufio.Writer
+	frameMax int
+
+	exchange, routingKey string
+
+	tag     uint64          // Delivery tag of the last message published
+	pending map[uint64]bool // Tags the broker hasn't acked
+	err     error           // Returned or rejected message, or a closed channel
+}
+
+func dialAMQP(u *url.URL) (*amqp, error) {
+	q := u.Query()
+	a := &amqp{
+		exchange:   q.Get("exchange"),
+		routingKey: q.Get("routing_key"),
+		pending:    make(map[uint64]bool),
+	}
+	if a.exchange == "" && a.routingKey == "" {
+		return nil, errors.New("amqp: want ?exchange=NAME and/or &routing_key=KEY (a queue name for the default exchange)")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "5672"
+		if u.Scheme == "amqps" {
+			port = "5671"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", host, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("amqp: %w", err)
+	}
+	if u.Scheme == "amqps" {
+		conn = tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+	}
+	a.conn, a.r, a.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+	if err := a.handshake(u); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("amqp: %s: %w", u.Host, err)
+	}
+	return a, nil
+}
+
+// handshake opens the connection, one channel, and confirm mode.
+func (a *amqp) handshake(u *url.URL) error {
+	a.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer a.conn.SetDeadline(time.Time{})
+	user, pass := "guest", "guest"
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+	// The vhost is the path without its leading slash; none means "/"
+	vhost := "/"
+	if p := strings.TrimPrefix(u.Path, "/"); p != "" {
+		vhost = p
+	}
+
+	a.w.WriteString("AMQP\x00\x00\x09\x01")
+	if err := a.w.Flush(); err != nil {
+		return err
+	}
+	args, err := a.expect(0, amqpConnectionStart)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 || args[0] != 0 || args[1] != 9 {
+		return errors.New("server doesn't speak AMQP 0-9-1")
+	}
+
+	var b amqpArgs
+	b.table(map[string]string{"product": "read_file_paths"})
+	b.shortstr("PLAIN")
+	b.longstr("\x00" + user + "\x00" + pass)
+	b.shortstr("en_US")
+	a.method(0, amqpConnectionStartOk, b.Bytes())
+	if args, err = a.expect(0, amqpConnectionTune); err != nil {
+		return err
+	}
+	if len(args) < 8 {
+		return errors.New("short Connection.Tune")
+	}
+	a.frameMax = int(binary.BigEndian.Uint32(args[2:]))
+	if a.frameMax == 0 || a.frameMax > amqpDefaultFrameMax {
+		a.frameMax = amqpDefaultFrameMax
+	}
+	b.Reset()
+	b.short(1) // One channel
+	b.long(uint32(a.frameMax))
+	b.short(0) // No heartbeats; the connection is busy or briefly idle
+	a.method(0, amqpConnectionTuneOk, b.Bytes())
+
+	b.Reset()
+	b.shortstr(vhost)
+	b.shortstr("")
+	b.octet(0)
+	a.method(0, amqpConnectionOpen, b.Bytes())
+	if _, err := a.expect(0, amqpConnectionOpenOk); err != nil {
+		return err
+	}
+	a.method(1, amqpChannelOpen, []byte{0})
+	if _, err := a.expect(1, amqpChannelOpenOk); err != nil {
+		return err
+	}
+	a.method(1, amqpConfirmSelect, []byte{0})
+	_, err = a.expect(1, amqpConfirmSelectOk)
+	return err
+}
+
+func (a *amqp) publish(body []byte, contentType string) error {
+	if a.err != nil {
+		return a.err
+	}
+	var b amqpArgs
+	b.short(0)
+	b.shortstr(a.exchange)
+	b.shortstr(a.routingKey)
+	b.octet(1) // Mandatory
+	a.method(1, amqpBasicPublish, b.Bytes())
+
+	b.Reset()
+	b.short(60) // Basic class
+	b.short(0)
+	b.longlong(uint64(len(body)))
+	b.short(0x8000 | 0x1000) // Content type and delivery mode present
+	b.shortstr(contentType)
+	b.octet(amqpPersistentDelivery)
+	a.frame(amqpFrameHeader, 1, b.Bytes())
+
+	for len(body) > 0 {
+		n := min(len(body), a.frameMax-8)
+		a.frame(amqpFrameBody, 1, body[:n])
+		body = body[n:]
+	}
+	a.tag++
+	a.pending[a.tag] = true
+	return nil
+}
+
+func (a *amqp) confirm() error {
+	if err := a.w.Flush(); err != nil {
+		return fmt.Errorf("amqp: %w", err)
+	}
+	a.conn.SetReadDeadline(time.Now().Add(time.Minute))
+	defer a.conn.SetReadDeadline(time.Time{})
+	for len(a.pending) > 0 && a.err == nil {
+		if err := a.handle(); err != nil {
+			return fmt.Errorf("amqp: %w", err)
+		}
+	}
+	return a.err
+}
+
+// handle reads and acts on one frame from the broker.
+func (a *amqp) handle() error {
+	typ, _, payload, err := a.readFrame()
+	if err != nil {
+		return err
+	}
+	if typ != amqpFrameMethod || len(payload) < 4 {
+		return nil
+	}
+	id, args := binary.BigEndian.Uint32(payload), payload[4:]
+	switch id {
+	case amqpBasicAck:
+		if len(args) < 9 {
+			return errors.New("short Basic.Ack")
+		}
+		tag, multiple := binary.BigEndian.Uint64(args), args[8]&1 != 0
+		for t := range a.pending {
+			if t == tag || (multiple && t < tag) {
+				delete(a.pending, t)
+			}
+		}
+	case amqpBasicNack:
+		a.err = errors.New("amqp: the broker rejected a message")
+	case amqpBasicReturn:
+		r := amqpReader{b: args}
+		code, text := r.short(), r.shortstr()
+		exchange, key := r.shortstr(), r.shortstr()
+		a.err = fmt.Errorf("amqp: message not routed to any queue (exchange %q, routing key %q): %d %s", exchange, key, code, text)
+		return a.skipContent()
+	case amqpChannelClose:
+		r := amqpReader{b: args}
+		code, text := r.short(), r.shortstr()
+		a.err = fmt.Errorf("amqp: channel closed by the broker: %d %s", code, text)
+		a.method(1, amqpChannelCloseOk, nil)
+		return a.w.Flush()
+	case amqpConnectionClose:
+		r := amqpReader{b: args}
+		code, text := r.short(), r.shortstr()
+		a.err = fmt.Errorf("amqp: connection closed by the broker: %d %s", code, text)
+		a.method(0, amqpConnectionCloseOk, nil)
+		return a.w.Flush()
+	case amqpChannelFlow:
+		a.method(1, amqpChannelFlowOk, args[:min(len(args), 1)])
+		return a.w.Flush()
+	}
+	return nil
+}
+
+// skipContent reads past the header and body frames of a returned
+// message.
+func (a *amqp) skipContent() error {
+	typ, _, payload, err := a.readFrame()
+	if err != nil {
+		return err
+	}
+	if typ != amqpFrameHeader || len(payload) < 12 {
+		return errors.New("expected a content header")
+	}
+	for left := binary.BigEndian.Uint64(payload[4:]); left > 0; {
+		typ, _, payload, err := a.readFrame()
+		if err != nil {
+			return err
+		}
+		if typ != amqpFrameBody {
+			return errors.New("expected a content body")
+		}
+		left -= min(left, uint64(len(payload)))
+	}
+	return nil
+}
+
+func (a *amqp) maxMessage() int { return 0 }
+
+// close closes the connection politely, waiting briefly for the broker
+// to agree.
+func (a *amqp) close() error {
+	defer a.conn.Close()
+	var b amqpArgs
+	b.short(200)
+	b.shortstr("scan finished")
+	b.short(0)
+	b.short(0)
+	a.method(0, amqpConnectionClose, b.Bytes())
+	if a.w.Flush() != nil {
+		return nil // Already gone
+	}
+	a.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		typ, _, payload, err := a.readFrame()
+		if err != nil || (typ == amqpFrameMethod && len(payload) >= 4 && binary.BigEndian.Uint32(payload) == amqpConnectionCloseOk) {
+			return nil
+		}
+	}
+}
+
+// expect reads frames until the method id arrives on channel, failing
+// on a close from the broker.
+func (a *amqp) expect(channel uint16, id uint32) ([]byte, error) {
+	if err := a.w.Flush(); err != nil {
+		return nil, err
+	}
+	for {
+		typ, ch, payload, err := a.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if typ != amqpFrameMethod || len(payload) < 4 {
+			continue
+		}
+		got, args := binary.BigEndian.Uint32(payload), payload[4:]
+		switch {
+		case got == id && ch == channel:
+			return args, nil
+		case got == amqpConnectionClose || got == amqpChannelClose:
+			r := amqpReader{b: args}
+			code, text := r.short(), r.shortstr()
+			return nil, fmt.Errorf("%d %s", code, text)
+		}
+	}
+}
+
+func (a *amqp) readFrame() (typ byte, channel uint16, payload []byte, err error) {
+	var hdr [7]byte
+	if _, err := io.ReadFull(a.r, hdr[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[3:])
+	if size > uint32(max(a.frameMax, amqpDefaultFrameMax)) {
+		return 0, 0, nil, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+	payload = make([]byte, size+1)
+	if _, err := io.ReadFull(a.r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	if payload[size] != amqpFrameEnd {
+		return 0, 0, nil, errors.New("bad frame end")
+	}
+	if hdr[0] == amqpFrameHeartbeat {
+		return a.readFrame()
+	}
+	return hdr[0], binary.BigEndian.Uint16(hdr[1:]), payload[:size], nil
+}
+
+func (a *amqp) method(channel uint16, id uint32, args []byte) {
+	payload := binary.BigEndian.AppendUint32(nil, id)
+	a.frame(amqpFrameMethod, channel, append(payload, args...))
+}
+
+// frame buffers a frame; write errors surface on the next flush.
+func (a *amqp) frame(typ byte, channel uint16, payload []byte) {
+	var hdr [7]byte
+	hdr[0] = typ
+	binary.BigEndian.PutUint16(hdr[1:], channel)
+	binary.BigEndian.PutUint32(hdr[3:], uint32(len(payload)))
+	a.w.Write(hdr[:])
+	a.w.Write(payload)
+	a.w.WriteByte(amqpFrameEnd)
+}
+
+// amqpArgs encodes method arguments.
+type amqpArgs struct{ bytes.Buffer }
+
+func (b *amqpArgs) octet(v byte)   { b.WriteByte(v) }
+func (b *amqpArgs) short(v uint16) { b.Write(binary.BigEndian.AppendUint16(nil, v)) }
+func (b *amqpArgs) long(v uint32)  { b.Write(binary.BigEndian.AppendUint32(nil, v)) }
+
+func (b *amqpArgs) longlong(v uint64) { b.Write(binary.BigEndian.AppendUint64(nil, v)) }
+
+func (b *amqpArgs) shortstr(s string) {
+	s = s[:min(len(s), 255)]
+	b.octet(byte(len(s)))
+	b.WriteString(s)
+}
+
+func (b *amqpArgs) longstr(s string) {
+	b.long(uint32(len(s)))
+	b.WriteString(s)
+}
+
+// table encodes a field table of string values.
+func (b *amqpArgs) table(fields map[string]string) {
+	var t amqpArgs
+	for k, v := range fields {
+		t.shortstr(k)
+		t.octet('S')
+		t.longstr(v)
+	}
+	b.longstr(t.String())
+}
+
+// amqpReader decodes method arguments, yielding zero values past the end.
+type amqpReader struct {
+	b []byte
+}
+
+func (r *amqpReader) short() uint16 {
+	if len(r.b) < 2 {
+		r.b = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+func (r *amqpReader) shortstr() string {
+	if len(r.b) < 1 || len(r.b) < 1+int(r.b[0]) {
+		r.b = nil
+		return ""
+	}
+	s := string(r.b[1 : 1+int(r.b[0])])
+	r.b = r.b[1+int(r.b[0]):]
+	return s
+}
@@ -0,0 +1,153 @@
+package publish
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// nats publishes to a subject with the NATS client protocol. Core NATS
+// doesn't acknowledge messages; confirm round-trips a PING, which the
+// server answers after processing everything sent before it.
+type nats struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	subject string
+	maxSize int
+}
+
+// natsInfo is the part of the server's INFO the client uses.
+type natsInfo struct {
+	MaxPayload  int  `json:"max_payload"`
+	TLSRequired bool `json:"tls_required"`
+}
+
+func dialNATS(u *url.URL) (*nats, error) {
+	subject := strings.Trim(u.Path, "/")
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n/") {
+		return nil, fmt.Errorf("nats: want a subject such as nats://host/files.scanned, not %q", u.Path)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	conn, err := net.DialTimeout("tcp", host, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+	n := &nats{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), subject: subject}
+	if err := n.handshake(u); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats: %s: %w", u.Host, err)
+	}
+	return n, nil
+}
+
+// handshake reads INFO, upgrades to TLS when asked to, and logs in.
+func (n *nats) handshake(u *url.URL) error {
+	n.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer n.conn.SetDeadline(time.Time{})
+	line, err := n.readLine()
+	if err != nil {
+		return err
+	}
+	payload, ok := strings.CutPrefix(line, "INFO ")
+	if !ok {
+		return fmt.Errorf("not a NATS server: %q", line)
+	}
+	var info natsInfo
+	if err := json.Unmarshal([]byte(payload), &info); err != nil {
+		return fmt.Errorf("bad INFO: %w", err)
+	}
+	n.maxSize = info.MaxPayload
+	if u.Scheme == "tls" || info.TLSRequired {
+		tc := tls.Client(n.conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			return err
+		}
+		n.conn = tc
+		n.r.Reset(tc)
+		n.w.Reset(tc)
+	}
+
+	connect := map[string]any{
+		"verbose": false, "pedantic": false, "name": "read_file_paths",
+		"lang": "go", "version": "1", "protocol": 1,
+	}
+	// A user without a password is a token, as in nats://token@host
+	if user := u.User.Username(); user != "" {
+		if pass, ok := u.User.Password(); ok {
+			connect["user"], connect["pass"] = user, pass
+		} else {
+			connect["auth_token"] = user
+		}
+	}
+	b, err := json.Marshal(connect)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(n.w, "CONNECT %s\r\n", b)
+	// The PONG confirms the login; a failed one gets -ERR instead
+	return n.ping()
+}
+
+func (n *nats) publish(body []byte, contentType string) error {
+	fmt.Fprintf(n.w, "PUB %s %d\r\n", n.subject, len(body))
+	n.w.Write(body)
+	_, err := n.w.WriteString("\r\n")
+	return err
+}
+
+func (n *nats) confirm() error {
+	if err := n.ping(); err != nil {
+		return fmt.Errorf("nats: %w", err)
+	}
+	return nil
+}
+
+// ping sends a PING and reads up to its PONG.
+func (n *nats) ping() error {
+	n.w.WriteString("PING\r\n")
+	if err := n.w.Flush(); err != nil {
+		return err
+	}
+	n.conn.SetReadDeadline(time.Now().Add(time.Minute))
+	defer n.conn.SetReadDeadline(time.Time{})
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			// The server checking on us while we wait on it
+			n.w.WriteString("PONG\r\n")
+			if err := n.w.Flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.Trim(strings.TrimSpace(line[4:]), "'"))
+		}
+		// +OK and INFO updates need no answer
+	}
+}
+
+func (n *nats) readLine() (string, error) {
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (n *nats) maxMessage() int { return n.maxSize }
+
+func (n *nats) close() error { return n.conn.Close() }
@@ -0,0 +1,155 @@
+// Package publish sends scan records to message brokers named by URLs,
+// such as nats://host/subject, as scan.Sink values.
+package publish
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Mode chooses what one message carries.
+type Mode int
+
+const (
+	// PerBatch publishes each batch as one message of JSON lines.
+	PerBatch Mode = iota
+	// PerRecord publishes each record as one JSON object.
+	PerRecord
+)
+
+// ParseMode parses a mode name: "batch" or "record".
+func ParseMode(name string) (Mode, error) {
+	switch name {
+	case "batch":
+		return PerBatch, nil
+	case "record":
+		return PerRecord, nil
+	}
+	return 0, fmt.Errorf("unknown publish mode %q (want batch or record)", name)
+}
+
+// Content types of the messages of each mode.
+const (
+	contentRecord = "application/json"
+	contentBatch  = "application/x-ndjson"
+)
+
+// publisher is a connection to a broker.
+type publisher interface {
+	// publish sends one message. It may return before the broker has it.
+	publish(body []byte, contentType string) error
+	// confirm waits until the broker has every message published so far.
+	confirm() error
+	// maxMessage is the largest body the broker takes, 0 for no limit.
+	maxMessage() int
+	close() error
+}
+
+// Open connects to the broker named by rawURL:
+//
+//	nats://[user:pass@]host[:4222]/subject (tls:// for TLS)
+//	amqp://[user:pass@]host[:5672]/[vhost]?exchange=x&routing_key=k (amqps:// for TLS)
+//
+// The returned sink encodes records as JSONSink does and implements
+// io.Closer.
+func Open(rawURL string, mode Mode) (scan.Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var p publisher
+	switch u.Scheme {
+	case "nats", "tls":
+		p, err = dialNATS(u)
+	case "amqp", "amqps":
+		p, err = dialAMQP(u)
+	default:
+		return nil, fmt.Errorf("unsupported publish URL %q (want nats, tls, amqp or amqps)", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &sink{p: p, mode: mode}
+	s.enc = scan.NewJSONSink(&s.buf)
+	return s, nil
+}
+
+// sink publishes batches or records. Flush waits for the broker to
+// accept what was published, so the scanner's flush settings bound what
+// a broken connection can lose.
+type sink struct {
+	p    publisher
+	mode Mode
+	buf  bytes.Buffer
+	enc  *scan.JSONSink
+}
+
+func (s *sink) WriteHeader(columns []scan.Column) error {
+	return s.enc.WriteHeader(columns)
+}
+
+func (s *sink) WriteBatch(records []scan.Record) error {
+	if s.mode == PerRecord {
+		for i := range records {
+			body, err := s.encode(records[i : i+1])
+			if err != nil {
+				return err
+			}
+			if err := s.p.publish(body, contentRecord); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.publishBatch(records)
+}
+
+// publishBatch publishes records as one message, split in halves while
+// it is too large for the broker.
+func (s *sink) publishBatch(records []scan.Record) error {
+	body, err := s.encode(records)
+	if err != nil {
+		return err
+	}
+	if max := s.p.maxMessage(); max > 0 && len(body) > max {
+		if len(records) == 1 {
+			return fmt.Errorf("publish: record of %s is %d bytes, over the broker's %d byte limit", records[0].Path, len(body), max)
+		}
+		half := len(records) / 2
+		if err := s.publishBatch(records[:half]); err != nil {
+			return err
+		}
+		return s.publishBatch(records[half:])
+	}
+	return s.p.publish(body, contentBatch)
+}
+
+// encode renders records as JSON lines. The bytes are only valid until
+// the next call.
+func (s *sink) encode(records []scan.Record) ([]byte, error) {
+	s.buf.Reset()
+	if err := s.enc.WriteBatch(records); err != nil {
+		return nil, err
+	}
+	if err := s.enc.Flush(); err != nil {
+		return nil, err
+	}
+	if s.mode == PerRecord {
+		return bytes.TrimSuffix(s.buf.Bytes(), []byte("\n")), nil
+	}
+	return s.buf.Bytes(), nil
+}
+
+func (s *sink) Flush() error { return s.p.confirm() }
+
+// Close waits for outstanding messages and disconnects.
+func (s *sink) Close() error {
+	err := s.p.confirm()
+	if cerr := s.p.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
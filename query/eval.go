@@ -0,0 +1,597 @@
+package query
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Values are nil (NULL), int64, float64, string or bool. NULL propagates
+// through operators and comparisons as in SQL; WHERE and HAVING treat it
+// as false.
+
+// env is what an expression is evaluated against: the current row and,
+// for grouped queries, the group's finished aggregates.
+type env struct {
+	row  []any
+	aggs []any
+}
+
+func eval(e expr, en *env) (any, error) {
+	switch e := e.(type) {
+	case *literal:
+		return e.v, nil
+	case *column:
+		return en.row[e.idx], nil
+	case *unary:
+		x, err := eval(e.x, en)
+		if err != nil || x == nil {
+			return nil, err
+		}
+		if e.op == "not" {
+			return !truthy(x), nil
+		}
+		switch x := numeric(x).(type) {
+		case int64:
+			return -x, nil
+		case float64:
+			return -x, nil
+		}
+		return nil, fmt.Errorf("cannot negate %s", describe(x))
+	case *binary:
+		return evalBinary(e, en)
+	case *call:
+		if e.agg >= 0 {
+			return en.aggs[e.agg], nil
+		}
+		return evalCall(e, en)
+	case *isNull:
+		x, err := eval(e.x, en)
+		if err != nil {
+			return nil, err
+		}
+		return (x == nil) != e.not, nil
+	case *inList:
+		x, err := eval(e.x, en)
+		if err != nil || x == nil {
+			return nil, err
+		}
+		found, sawNull := false, false
+		for _, item := range e.list {
+			v, err := eval(item, en)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				sawNull = true
+			} else if compare(x, v) == 0 {
+				found = true
+				break
+			}
+		}
+		if !found && sawNull {
+			return nil, nil
+		}
+		return found != e.not, nil
+	case *between:
+		x, err := eval(e.x, en)
+		if err != nil {
+			return nil, err
+		}
+		lo, err := eval(e.lo, en)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := eval(e.hi, en)
+		if err != nil || x == nil || lo == nil || hi == nil {
+			return nil, err
+		}
+		return (compare(x, lo) >= 0 && compare(x, hi) <= 0) != e.not, nil
+	case *like:
+		x, err := eval(e.x, en)
+		if err != nil {
+			return nil, err
+		}
+		pat, err := eval(e.pattern, en)
+		if err != nil || x == nil || pat == nil {
+			return nil, err
+		}
+		return matchLike(scan.FormatValue(pat), scan.FormatValue(x)) != e.not, nil
+	case *caseExpr:
+		var operand any
+		if e.operand != nil {
+			var err error
+			if operand, err = eval(e.operand, en); err != nil {
+				return nil, err
+			}
+		}
+		for _, w := range e.whens {
+			cond, err := eval(w.cond, en)
+			if err != nil {
+				return nil, err
+			}
+			var hit bool
+			if e.operand != nil {
+				hit = operand != nil && cond != nil && compare(operand, cond) == 0
+			} else {
+				hit = cond != nil && truthy(cond)
+			}
+			if hit {
+				return eval(w.result, en)
+			}
+		}
+		if e.els != nil {
+			return eval(e.els, en)
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown expression %T", e)
+}
+
+func evalBinary(e *binary, en *env) (any, error) {
+	l, err := eval(e.l, en)
+	if err != nil {
+		return nil, err
+	}
+	// AND and OR are three-valued and may decide without the right side
+	switch e.op {
+	case "and":
+		if l != nil && !truthy(l) {
+			return false, nil
+		}
+		r, err := eval(e.r, en)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil && !truthy(r) {
+			return false, nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return true, nil
+	case "or":
+		if l != nil && truthy(l) {
+			return true, nil
+		}
+		r, err := eval(e.r, en)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil && truthy(r) {
+			return true, nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return false, nil
+	}
+
+	r, err := eval(e.r, en)
+	if err != nil || l == nil || r == nil {
+		return nil, err
+	}
+	switch e.op {
+	case "=":
+		return compare(l, r) == 0, nil
+	case "!=":
+		return compare(l, r) != 0, nil
+	case "<":
+		return compare(l, r) < 0, nil
+	case "<=":
+		return compare(l, r) <= 0, nil
+	case ">":
+		return compare(l, r) > 0, nil
+	case ">=":
+		return compare(l, r) >= 0, nil
+	case "||":
+		return scan.FormatValue(l) + scan.FormatValue(r), nil
+	}
+	return arithmetic(e.op, l, r)
+}
+
+// arithmetic follows SQLite: integer operands give integer results
+// (so 7 / 2 is 3), and dividing by zero gives NULL.
+func arithmetic(op string, l, r any) (any, error) {
+	ln, rn := numeric(l), numeric(r)
+	li, lok := ln.(int64)
+	ri, rok := rn.(int64)
+	if lok && rok {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "/":
+			if ri == 0 {
+				return nil, nil
+			}
+			return li / ri, nil
+		case "%":
+			if ri == 0 {
+				return nil, nil
+			}
+			return li % ri, nil
+		}
+	}
+	lf, lok := toFloat(ln)
+	rf, rok := toFloat(rn)
+	if !lok || !rok {
+		return nil, fmt.Errorf("cannot apply %s to %s and %s", op, describe(l), describe(r))
+	}
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, nil
+		}
+		return lf / rf, nil
+	case "%":
+		if rf == 0 {
+			return nil, nil
+		}
+		return math.Mod(lf, rf), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// numeric converts numeric-looking strings and bools to numbers, leaving
+// other values as they are.
+func numeric(v any) any {
+	switch v := v.(type) {
+	case string:
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return f
+		}
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	}
+	return v
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func truthy(v any) bool {
+	switch v := numeric(v).(type) {
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	}
+	return false
+}
+
+// compare orders two non-NULL values. Two strings compare as text;
+// otherwise numbers compare numerically, with strings that look like
+// numbers converted, and anything left over compares as text.
+func compare(a, b any) int {
+	_, aStr := a.(string)
+	_, bStr := b.(string)
+	if !aStr || !bStr {
+		an, bn := numeric(a), numeric(b)
+		if ai, ok := an.(int64); ok {
+			if bi, ok := bn.(int64); ok {
+				return cmp.Compare(ai, bi)
+			}
+		}
+		if af, ok := toFloat(an); ok {
+			if bf, ok := toFloat(bn); ok {
+				return cmp.Compare(af, bf)
+			}
+		}
+	}
+	return strings.Compare(scan.FormatValue(a), scan.FormatValue(b))
+}
+
+// compareNullsFirst orders NULL before everything else, for ORDER BY.
+func compareNullsFirst(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return compare(a, b)
+}
+
+func describe(v any) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return scan.FormatValue(v)
+}
+
+// matchLike implements LIKE: % matches any run, _ one character, and
+// ASCII letters match case-insensitively.
+func matchLike(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '%':
+			for len(pattern) > 0 && pattern[0] == '%' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); {
+				if matchLike(pattern, s[i:]) {
+					return true
+				}
+				if i == len(s) {
+					break
+				}
+				_, n := utf8.DecodeRuneInString(s[i:])
+				i += n
+			}
+			return false
+		case '_':
+			if s == "" {
+				return false
+			}
+			_, n := utf8.DecodeRuneInString(s)
+			pattern, s = pattern[1:], s[n:]
+		default:
+			pr, pn := utf8.DecodeRuneInString(pattern)
+			sr, sn := utf8.DecodeRuneInString(s)
+			if s == "" || (pr != sr && !(pr < utf8.RuneSelf && sr < utf8.RuneSelf && strings.EqualFold(string(pr), string(sr)))) {
+				return false
+			}
+			pattern, s = pattern[pn:], s[sn:]
+		}
+	}
+	return s == ""
+}
+
+// scalars are the non-aggregate functions, with their argument counts.
+var scalars = map[string]struct {
+	min, max int
+	fn       func(args []any) (any, error)
+}{
+	"lower": {1, 1, strFunc(strings.ToLower)},
+	"upper": {1, 1, strFunc(strings.ToUpper)},
+	"trim":  {1, 1, strFunc(strings.TrimSpace)},
+	"length": {1, 1, func(a []any) (any, error) {
+		return nullOr(a[0], int64(utf8.RuneCountInString(scan.FormatValue(a[0])))), nil
+	}},
+	"substr":   {2, 3, substr},
+	"replace":  {3, 3, replace},
+	"coalesce": {1, -1, coalesce},
+	"ifnull":   {2, 2, coalesce},
+	"abs":      {1, 1, abs},
+	"round":    {1, 2, round},
+}
+
+func evalCall(c *call, en *env) (any, error) {
+	f := scalars[c.name]
+	args := make([]any, len(c.args))
+	for i, a := range c.args {
+		v, err := eval(a, en)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return f.fn(args)
+}
+
+func nullOr(v, result any) any {
+	if v == nil {
+		return nil
+	}
+	return result
+}
+
+func strFunc(fn func(string) string) func([]any) (any, error) {
+	return func(a []any) (any, error) {
+		if a[0] == nil {
+			return nil, nil
+		}
+		return fn(scan.FormatValue(a[0])), nil
+	}
+}
+
+// substr(s, start[, length]) counts characters from 1, as in SQL.
+func substr(a []any) (any, error) {
+	if a[0] == nil || a[1] == nil {
+		return nil, nil
+	}
+	r := []rune(scan.FormatValue(a[0]))
+	start, ok := numeric(a[1]).(int64)
+	if !ok {
+		return nil, fmt.Errorf("substr: start must be an integer")
+	}
+	if start > 0 {
+		start--
+	}
+	if start < 0 {
+		start = max(int64(len(r))+start, 0)
+	}
+	end := int64(len(r))
+	if len(a) == 3 && a[2] != nil {
+		n, ok := numeric(a[2]).(int64)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("substr: length must be a non-negative integer")
+		}
+		end = min(start+n, end)
+	}
+	if start >= end {
+		return "", nil
+	}
+	return string(r[start:end]), nil
+}
+
+func replace(a []any) (any, error) {
+	if a[0] == nil || a[1] == nil || a[2] == nil {
+		return nil, nil
+	}
+	return strings.ReplaceAll(scan.FormatValue(a[0]), scan.FormatValue(a[1]), scan.FormatValue(a[2])), nil
+}
+
+func coalesce(a []any) (any, error) {
+	for _, v := range a {
+		if v != nil {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+func abs(a []any) (any, error) {
+	switch v := numeric(a[0]).(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		if v < 0 {
+			return -v, nil
+		}
+		return v, nil
+	case float64:
+		return math.Abs(v), nil
+	}
+	return nil, fmt.Errorf("abs: %s is not a number", describe(a[0]))
+}
+
+func round(a []any) (any, error) {
+	if a[0] == nil {
+		return nil, nil
+	}
+	f, ok := toFloat(numeric(a[0]))
+	if !ok {
+		return nil, fmt.Errorf("round: %s is not a number", describe(a[0]))
+	}
+	var digits int64
+	if len(a) == 2 && a[1] != nil {
+		if digits, ok = numeric(a[1]).(int64); !ok {
+			return nil, fmt.Errorf("round: digits must be an integer")
+		}
+	}
+	scale := math.Pow(10, float64(digits))
+	return math.Round(f*scale) / scale, nil
+}
+
+// aggregate accumulates one aggregate call over a group.
+type aggregate struct {
+	call  *call
+	count int64
+	sum   any // int64 until a float is added
+	best  any // min or max
+	parts []string
+	seen  map[string]bool // DISTINCT
+}
+
+// aggregates lists the aggregate functions and their argument counts.
+var aggregates = map[string]struct{ min, max int }{
+	"count":        {1, 1},
+	"sum":          {1, 1},
+	"total":        {1, 1},
+	"avg":          {1, 1},
+	"min":          {1, 1},
+	"max":          {1, 1},
+	"group_concat": {1, 2},
+}
+
+func (a *aggregate) add(en *env) error {
+	if a.call.star {
+		a.count++
+		return nil
+	}
+	v, err := eval(a.call.args[0], en)
+	if err != nil || v == nil {
+		return err
+	}
+	if a.call.distinct {
+		key := fmt.Sprintf("%T:%v", numeric(v), numeric(v))
+		if a.seen[key] {
+			return nil
+		}
+		a.seen[key] = true
+	}
+	a.count++
+	switch a.call.name {
+	case "sum", "total", "avg":
+		n := numeric(v)
+		if _, ok := toFloat(n); !ok {
+			return fmt.Errorf("%s: %s is not a number", a.call.name, describe(v))
+		}
+		if a.sum == nil {
+			a.sum = n
+		} else if s, err := arithmetic("+", a.sum, n); err != nil {
+			return err
+		} else {
+			a.sum = s
+		}
+	case "min":
+		if a.best == nil || compare(v, a.best) < 0 {
+			a.best = v
+		}
+	case "max":
+		if a.best == nil || compare(v, a.best) > 0 {
+			a.best = v
+		}
+	case "group_concat":
+		a.parts = append(a.parts, scan.FormatValue(v))
+	}
+	return nil
+}
+
+func (a *aggregate) result(en *env) (any, error) {
+	switch a.call.name {
+	case "count":
+		return a.count, nil
+	case "sum":
+		return a.sum, nil
+	case "total":
+		f, _ := toFloat(a.sum)
+		return f, nil
+	case "avg":
+		if a.count == 0 {
+			return nil, nil
+		}
+		f, _ := toFloat(a.sum)
+		return f / float64(a.count), nil
+	case "min", "max":
+		return a.best, nil
+	case "group_concat":
+		if a.parts == nil {
+			return nil, nil
+		}
+		sep := ","
+		if len(a.call.args) == 2 {
+			v, err := eval(a.call.args[1], en)
+			if err != nil {
+				return nil, err
+			}
+			sep = scan.FormatValue(v)
+		}
+		return strings.Join(a.parts, sep), nil
+	}
+	return nil, fmt.Errorf("unknown aggregate %s", a.call.name)
+}
@@ -0,0 +1,114 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string // Identifiers keep their case; keywords are compared folded
+	pos  int    // Byte offset in the source
+	end  int
+
+	quoted bool // A double-quoted identifier, never a keyword
+}
+
+var operators = map[string]bool{
+	"(": true, ")": true, ",": true, "*": true, "+": true, "-": true, "/": true, "%": true, ";": true,
+	"=": true, "==": true, "!=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true, "||": true,
+}
+
+// lex splits src into tokens. Identifiers may be double-quoted; strings
+// are single-quoted with ” for a literal quote.
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && strings.HasPrefix(src[i:], "--"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '\'':
+			var b strings.Builder
+			start := i
+			i++
+			for {
+				if i >= len(src) {
+					return nil, fmt.Errorf("unterminated string at offset %d", start)
+				}
+				if src[i] == '\'' {
+					if i+1 < len(src) && src[i+1] == '\'' {
+						b.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(src[i])
+				i++
+			}
+			toks = append(toks, token{kind: tokString, text: b.String(), pos: start, end: i})
+		case c == '"':
+			start := i
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated identifier at offset %d", start)
+			}
+			i += end + 2
+			toks = append(toks, token{kind: tokIdent, text: src[start+1 : i-1], pos: start, end: i, quoted: true})
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+					i++
+				}
+			}
+			toks = append(toks, token{kind: tokNumber, text: src[start:i], pos: start, end: i})
+		case c == '_' || unicode.IsLetter(rune(c)) || c >= 0x80:
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 0x80 || unicode.IsLetter(rune(src[i])) || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: src[start:i], pos: start, end: i})
+		default:
+			start := i
+			op := string(c)
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "<=", ">=", "<>", "!=", "||", "==":
+					op = two
+				}
+			}
+			if !operators[op] {
+				return nil, fmt.Errorf("unexpected %q at offset %d", op, start)
+			}
+			i += len(op)
+			toks = append(toks, token{kind: tokOp, text: op, pos: start, end: i})
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(src), end: len(src)}), nil
+}
@@ -0,0 +1,574 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query is a parsed SELECT statement.
+type Query struct {
+	items    []selectItem
+	distinct bool
+	table    string
+	where    expr
+	groupBy  []expr
+	having   expr
+	orderBy  []orderItem
+	limit    int64 // -1 for none
+	offset   int64
+}
+
+type selectItem struct {
+	expr  expr
+	name  string // Alias, or the expression's source text
+	alias bool
+	star  bool
+}
+
+type orderItem struct {
+	expr expr
+	desc bool
+}
+
+// Expression nodes.
+type (
+	expr interface{}
+
+	literal struct{ v any }
+
+	column struct {
+		name string
+		idx  int // Position in the row, set when the query is bound
+	}
+
+	unary struct {
+		op string // "-" or "not"
+		x  expr
+	}
+
+	binary struct {
+		op   string // Lower-case operator or keyword
+		l, r expr
+	}
+
+	call struct {
+		name     string // Lower-case
+		args     []expr
+		star     bool // count(*)
+		distinct bool
+		agg      int // Index into the aggregate list, -1 for scalar calls
+	}
+
+	inList struct {
+		x    expr
+		list []expr
+		not  bool
+	}
+
+	between struct {
+		x, lo, hi expr
+		not       bool
+	}
+
+	isNull struct {
+		x   expr
+		not bool
+	}
+
+	like struct {
+		x, pattern expr
+		not        bool
+	}
+
+	caseExpr struct {
+		operand expr // nil for searched CASE
+		whens   []whenClause
+		els     expr
+	}
+
+	whenClause struct{ cond, result expr }
+)
+
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+}
+
+// Parse parses a SELECT statement:
+//
+//	SELECT [DISTINCT] expr [[AS] alias], ... | *
+//	FROM files
+//	[WHERE expr] [GROUP BY expr, ...] [HAVING expr]
+//	[ORDER BY expr [ASC|DESC], ...] [LIMIT n [OFFSET m]]
+func Parse(src string) (*Query, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	p := &parser{src: src, toks: toks}
+	q, err := p.query()
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return q, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// back undoes next when it returned t.
+func (p *parser) back(t token) {
+	if t.kind != tokEOF {
+		p.pos--
+	}
+}
+
+// isKeyword reports whether t is the unquoted keyword kw.
+func isKeyword(t token, kw string) bool {
+	return t.kind == tokIdent && !t.quoted && strings.EqualFold(t.text, kw)
+}
+
+func (p *parser) accept(kw string) bool {
+	t := p.peek()
+	if isKeyword(t, kw) || t.kind == tokOp && t.text == kw {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kw string) error {
+	if !p.accept(kw) {
+		return p.errorf("expected %s", strings.ToUpper(kw))
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	t := p.peek()
+	near := "end of query"
+	if t.kind != tokEOF {
+		near = fmt.Sprintf("%q", p.src[t.pos:t.end])
+	}
+	return fmt.Errorf("%s near %s (offset %d)", fmt.Sprintf(format, args...), near, t.pos)
+}
+
+// reserved words can't be used as bare aliases.
+var reserved = map[string]bool{
+	"select": true, "from": true, "where": true, "group": true, "by": true, "having": true,
+	"order": true, "limit": true, "offset": true, "and": true, "or": true, "not": true,
+	"as": true, "asc": true, "desc": true, "distinct": true, "like": true, "in": true,
+	"is": true, "null": true, "between": true, "case": true, "when": true, "then": true,
+	"else": true, "end": true,
+}
+
+func (p *parser) query() (*Query, error) {
+	q := &Query{limit: -1}
+	if err := p.expect("select"); err != nil {
+		return nil, err
+	}
+	q.distinct = p.accept("distinct")
+	for {
+		start := p.peek().pos
+		if p.accept("*") {
+			q.items = append(q.items, selectItem{star: true, name: "*"})
+		} else {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			item := selectItem{expr: e, name: strings.TrimSpace(p.src[start:p.toks[p.pos-1].end])}
+			if c, ok := e.(*column); ok {
+				item.name = c.name
+			}
+			if p.accept("as") {
+				t := p.next()
+				if t.kind != tokIdent && t.kind != tokString {
+					return nil, p.errorf("expected an alias after AS")
+				}
+				item.name, item.alias = t.text, true
+			} else if t := p.peek(); t.kind == tokIdent && (t.quoted || !reserved[strings.ToLower(t.text)]) {
+				p.pos++
+				item.name, item.alias = t.text, true
+			}
+			q.items = append(q.items, item)
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if err := p.expect("from"); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, p.errorf("expected a table name")
+	}
+	q.table = t.text
+
+	var err error
+	if p.accept("where") {
+		if q.where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("group") {
+		if err := p.expect("by"); err != nil {
+			return nil, err
+		}
+		if q.groupBy, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("having") {
+		if q.having, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("order") {
+		if err := p.expect("by"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			item := orderItem{expr: e}
+			if p.accept("desc") {
+				item.desc = true
+			} else {
+				p.accept("asc")
+			}
+			q.orderBy = append(q.orderBy, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("limit") {
+		if q.limit, err = p.count(); err != nil {
+			return nil, err
+		}
+		if p.accept("offset") {
+			if q.offset, err = p.count(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	p.accept(";")
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected input")
+	}
+	return q, nil
+}
+
+func (p *parser) count() (int64, error) {
+	t := p.next()
+	n, err := strconv.ParseInt(t.text, 10, 64)
+	if t.kind != tokNumber || err != nil || n < 0 {
+		p.back(t)
+		return 0, p.errorf("expected a non-negative integer")
+	}
+	return n, nil
+}
+
+func (p *parser) exprList() ([]expr, error) {
+	var list []expr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if !p.accept(",") {
+			return list, nil
+		}
+	}
+}
+
+// Precedence, lowest first: OR, AND, NOT, comparisons, + - ||, * / %,
+// unary minus.
+func (p *parser) expr() (expr, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "or", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (expr, error) {
+	l, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "and", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) not() (expr, error) {
+	if p.accept("not") {
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "not", x: x}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	l, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokOp && (t.text == "=" || t.text == "==" || t.text == "!=" || t.text == "<>" ||
+			t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+			p.pos++
+			r, err := p.additive()
+			if err != nil {
+				return nil, err
+			}
+			op := t.text
+			switch op {
+			case "==":
+				op = "="
+			case "<>":
+				op = "!="
+			}
+			l = &binary{op: op, l: l, r: r}
+		case isKeyword(t, "is"):
+			p.pos++
+			not := p.accept("not")
+			if err := p.expect("null"); err != nil {
+				return nil, err
+			}
+			l = &isNull{x: l, not: not}
+		case isKeyword(t, "not") || isKeyword(t, "like") || isKeyword(t, "in") || isKeyword(t, "between"):
+			p.pos++
+			not := isKeyword(t, "not")
+			if not {
+				t = p.next()
+			}
+			switch {
+			case isKeyword(t, "like"):
+				pat, err := p.additive()
+				if err != nil {
+					return nil, err
+				}
+				l = &like{x: l, pattern: pat, not: not}
+			case isKeyword(t, "in"):
+				if err := p.expect("("); err != nil {
+					return nil, err
+				}
+				list, err := p.exprList()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+				l = &inList{x: l, list: list, not: not}
+			case isKeyword(t, "between"):
+				lo, err := p.additive()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect("and"); err != nil {
+					return nil, err
+				}
+				hi, err := p.additive()
+				if err != nil {
+					return nil, err
+				}
+				l = &between{x: l, lo: lo, hi: hi, not: not}
+			default:
+				p.back(t)
+				return nil, p.errorf("expected LIKE, IN or BETWEEN after NOT")
+			}
+		default:
+			return l, nil
+		}
+	}
+}
+
+func (p *parser) additive() (expr, error) {
+	l, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "+" && t.text != "-" && t.text != "||") {
+			return l, nil
+		}
+		p.pos++
+		r, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: t.text, l: l, r: r}
+	}
+}
+
+func (p *parser) multiplicative() (expr, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "*" && t.text != "/" && t.text != "%") {
+			return l, nil
+		}
+		p.pos++
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: t.text, l: l, r: r}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.accept("-") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "-", x: x}, nil
+	}
+	if p.accept("+") {
+		return p.unary()
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &literal{n}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			p.back(t)
+			return nil, p.errorf("bad number")
+		}
+		return &literal{f}, nil
+	case tokString:
+		return &literal{t.text}, nil
+	case tokOp:
+		if t.text == "(" {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	case tokIdent:
+		if !t.quoted {
+			switch strings.ToLower(t.text) {
+			case "null":
+				return &literal{nil}, nil
+			case "true":
+				return &literal{true}, nil
+			case "false":
+				return &literal{false}, nil
+			case "case":
+				return p.caseExpr()
+			}
+			if reserved[strings.ToLower(t.text)] {
+				p.back(t)
+				return nil, p.errorf("unexpected keyword")
+			}
+		}
+		if !t.quoted && p.accept("(") {
+			return p.call(strings.ToLower(t.text))
+		}
+		return &column{name: t.text, idx: -1}, nil
+	}
+	p.back(t)
+	return nil, p.errorf("expected an expression")
+}
+
+func (p *parser) call(name string) (expr, error) {
+	c := &call{name: name, agg: -1}
+	if p.accept("*") {
+		c.star = true
+		return c, p.expect(")")
+	}
+	if p.accept(")") {
+		return c, nil
+	}
+	c.distinct = p.accept("distinct")
+	args, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	c.args = args
+	return c, p.expect(")")
+}
+
+func (p *parser) caseExpr() (expr, error) {
+	c := &caseExpr{}
+	if !isKeyword(p.peek(), "when") {
+		operand, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		c.operand = operand
+	}
+	for p.accept("when") {
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		result, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, whenClause{cond, result})
+	}
+	if len(c.whens) == 0 {
+		return nil, p.errorf("expected WHEN")
+	}
+	if p.accept("else") {
+		els, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		c.els = els
+	}
+	return c, p.expect("end")
+}
@@ -0,0 +1,566 @@
+// Package query runs a small SQL dialect over scan outputs. A query reads
+// one table, files, whose columns are the output's columns plus ext,
+// name, dir and depth derived from file_path when the output lacks them.
+//
+// Supported: SELECT [DISTINCT] with expressions and aliases, WHERE,
+// GROUP BY, HAVING, ORDER BY (by expression, alias or position) and
+// LIMIT/OFFSET. Expressions have the usual arithmetic, comparison, AND,
+// OR, NOT, ||, LIKE, IN, BETWEEN, IS NULL and CASE; the aggregates count,
+// sum, total, avg, min, max and group_concat (each allowing DISTINCT);
+// and the functions lower, upper, trim, length, substr, replace,
+// coalesce, ifnull, abs and round. Semantics follow SQLite where they
+// differ between databases.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Table is the name queries select from.
+const Table = "files"
+
+// Result is the output of a query.
+type Result struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// Column types for typing text values read from an output. Columns not
+// listed hold text, except unknown (transform) columns, whose values are
+// read as numbers when they look like one.
+var (
+	intColumns  = []string{"size", "path_length", "uid", "gid", "allocated", "resource_fork", "depth"}
+	boolColumns = []string{"long_path", "invalid_utf8", "case_collision", "hard_link", "sparse"}
+	textColumns = []string{"file_path", "mode", "hash", "windows_issues", "link_target", "link_status", "reparse", "finder_flags", "quarantine", "ext", "name", "dir"}
+)
+
+// virtual columns are derived from file_path when the output lacks them.
+var virtual = []struct {
+	name string
+	fn   func(path string) any
+}{
+	{"ext", func(p string) any { return strings.ToLower(filepath.Ext(p)) }},
+	{"name", func(p string) any { return filepath.Base(p) }},
+	{"dir", func(p string) any { return filepath.Dir(p) }},
+	{"depth", func(p string) any { return int64(strings.Count(filepath.ToSlash(p), "/")) }},
+}
+
+// Run executes q over the rows of r.
+func (q *Query) Run(r scan.OutputReader) (*Result, error) {
+	if !strings.EqualFold(q.table, Table) {
+		return nil, fmt.Errorf("query: unknown table %q: the scan output is the table %s", q.table, Table)
+	}
+	src := r.Columns()
+	columns := slices.Clone(src)
+	pathIdx := slices.Index(src, "file_path")
+	var derived []int
+	if pathIdx >= 0 {
+		for i, v := range virtual {
+			if !slices.Contains(columns, v.name) {
+				columns = append(columns, v.name)
+				derived = append(derived, i)
+			}
+		}
+	}
+	b, err := q.bind(columns, src)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	kinds := make([]func(string) any, len(src))
+	for i, name := range src {
+		kinds[i] = typer(name)
+	}
+
+	ex := newExecution(q, b)
+	for {
+		text, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make([]any, len(columns))
+		for i, v := range text {
+			row[i] = kinds[i](v)
+		}
+		for j, i := range derived {
+			row[len(src)+j] = virtual[i].fn(text[pathIdx])
+		}
+		done, err := ex.add(row)
+		if err != nil {
+			return nil, fmt.Errorf("query: %w", err)
+		}
+		if done {
+			break
+		}
+	}
+	res, err := ex.finish()
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return res, nil
+}
+
+// typer returns how text values of a column become typed values.
+func typer(name string) func(string) any {
+	switch {
+	case slices.Contains(intColumns, name):
+		return func(s string) any {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return n
+			}
+			return nil
+		}
+	case slices.Contains(boolColumns, name):
+		return func(s string) any {
+			if b, err := strconv.ParseBool(s); err == nil {
+				return b
+			}
+			return nil
+		}
+	case slices.Contains(textColumns, name):
+		return func(s string) any { return s }
+	case name == "mtime":
+		return func(s string) any {
+			if s == "" {
+				return nil
+			}
+			return s
+		}
+	}
+	return func(s string) any {
+		if s == "" {
+			return nil
+		}
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+		return s
+	}
+}
+
+// bound is a query resolved against the columns of an output.
+type bound struct {
+	items      []selectItem // With * expanded
+	names      []string
+	aggs       []*call
+	aggregated bool
+	width      int // Columns in a row
+}
+
+func (q *Query) bind(columns, src []string) (*bound, error) {
+	b := &bound{width: len(columns)}
+	for _, it := range q.items {
+		if it.star {
+			for _, name := range src {
+				b.items = append(b.items, selectItem{expr: &column{name: name, idx: -1}, name: name})
+			}
+			continue
+		}
+		b.items = append(b.items, it)
+	}
+	for _, it := range b.items {
+		b.names = append(b.names, it.name)
+	}
+
+	// Aliases and positions may stand in for select expressions in GROUP
+	// BY, HAVING and ORDER BY. ORDER BY prefers an alias over a column of
+	// the same name; the others prefer the column.
+	subst := func(e expr, positional, preferAlias bool) (expr, error) {
+		if lit, ok := e.(*literal); ok && positional {
+			if n, ok := lit.v.(int64); ok {
+				if n < 1 || int(n) > len(b.items) {
+					return nil, fmt.Errorf("position %d is not in the select list", n)
+				}
+				return b.items[n-1].expr, nil
+			}
+		}
+		if c, ok := e.(*column); ok && (preferAlias || !slices.Contains(columns, c.name)) {
+			for _, it := range b.items {
+				if it.alias && it.name == c.name {
+					return it.expr, nil
+				}
+			}
+		}
+		return e, nil
+	}
+	var err error
+	for i := range q.groupBy {
+		if q.groupBy[i], err = subst(q.groupBy[i], true, false); err != nil {
+			return nil, err
+		}
+	}
+	for i := range q.orderBy {
+		if q.orderBy[i].expr, err = subst(q.orderBy[i].expr, true, true); err != nil {
+			return nil, err
+		}
+	}
+	if q.having != nil {
+		q.having = replaceAliases(q.having, func(e expr) expr { e, _ = subst(e, false, false); return e })
+	}
+
+	resolve := func(e expr, aggsAllowed bool) error {
+		return walk(e, func(e expr, inAgg bool) error {
+			switch e := e.(type) {
+			case *column:
+				e.idx = slices.Index(columns, e.name)
+				if e.idx < 0 {
+					return fmt.Errorf("unknown column %q (available: %s)", e.name, strings.Join(columns, ", "))
+				}
+			case *call:
+				if spec, ok := aggregates[e.name]; ok {
+					if !aggsAllowed {
+						return fmt.Errorf("aggregate %s() is not allowed here", e.name)
+					}
+					if inAgg {
+						return fmt.Errorf("aggregate %s() inside another aggregate", e.name)
+					}
+					if e.star && e.name != "count" {
+						return fmt.Errorf("%s(*) is not supported", e.name)
+					}
+					if n := len(e.args); !e.star && (n < spec.min || n > spec.max) {
+						return fmt.Errorf("%s() takes %s", e.name, argCount(spec.min, spec.max))
+					}
+					if e.agg < 0 {
+						e.agg = len(b.aggs)
+						b.aggs = append(b.aggs, e)
+					}
+					return errInAggregate
+				}
+				f, ok := scalars[e.name]
+				if !ok {
+					return fmt.Errorf("unknown function %s()", e.name)
+				}
+				if e.star || e.distinct {
+					return fmt.Errorf("%s() is not an aggregate", e.name)
+				}
+				if n := len(e.args); n < f.min || f.max >= 0 && n > f.max {
+					return fmt.Errorf("%s() takes %s", e.name, argCount(f.min, f.max))
+				}
+			}
+			return nil
+		})
+	}
+	if q.where != nil {
+		if err := resolve(q.where, false); err != nil {
+			return nil, fmt.Errorf("WHERE: %w", err)
+		}
+	}
+	for _, e := range q.groupBy {
+		if err := resolve(e, false); err != nil {
+			return nil, fmt.Errorf("GROUP BY: %w", err)
+		}
+	}
+	for _, it := range b.items {
+		if err := resolve(it.expr, true); err != nil {
+			return nil, err
+		}
+	}
+	if q.having != nil {
+		if err := resolve(q.having, true); err != nil {
+			return nil, fmt.Errorf("HAVING: %w", err)
+		}
+	}
+	for _, o := range q.orderBy {
+		if err := resolve(o.expr, true); err != nil {
+			return nil, fmt.Errorf("ORDER BY: %w", err)
+		}
+	}
+	b.aggregated = len(q.groupBy) > 0 || len(b.aggs) > 0
+	if q.having != nil && !b.aggregated {
+		return nil, errors.New("HAVING needs GROUP BY or an aggregate")
+	}
+	return b, nil
+}
+
+func argCount(lo, hi int) string {
+	switch {
+	case hi < 0:
+		return fmt.Sprintf("at least %d arguments", lo)
+	case lo == hi && lo == 1:
+		return "1 argument"
+	case lo == hi:
+		return fmt.Sprintf("%d arguments", lo)
+	}
+	return fmt.Sprintf("%d to %d arguments", lo, hi)
+}
+
+// errInAggregate tells walk that the node is an aggregate call, whose
+// arguments are checked with inAgg set.
+var errInAggregate = errors.New("in aggregate")
+
+// walk calls fn on e and its subexpressions, depth first.
+func walk(e expr, fn func(e expr, inAgg bool) error) error {
+	var visit func(e expr, inAgg bool) error
+	visit = func(e expr, inAgg bool) error {
+		if e == nil {
+			return nil
+		}
+		err := fn(e, inAgg)
+		if err == errInAggregate {
+			inAgg, err = true, nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, sub := range children(e) {
+			if err := visit(sub, inAgg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return visit(e, false)
+}
+
+func children(e expr) []expr {
+	switch e := e.(type) {
+	case *unary:
+		return []expr{e.x}
+	case *binary:
+		return []expr{e.l, e.r}
+	case *call:
+		return e.args
+	case *inList:
+		return append([]expr{e.x}, e.list...)
+	case *between:
+		return []expr{e.x, e.lo, e.hi}
+	case *isNull:
+		return []expr{e.x}
+	case *like:
+		return []expr{e.x, e.pattern}
+	case *caseExpr:
+		list := []expr{e.operand, e.els}
+		for _, w := range e.whens {
+			list = append(list, w.cond, w.result)
+		}
+		return list
+	}
+	return nil
+}
+
+// replaceAliases rebuilds column references in e through fn.
+func replaceAliases(e expr, fn func(expr) expr) expr {
+	switch e := e.(type) {
+	case *column:
+		return fn(e)
+	case *unary:
+		e.x = replaceAliases(e.x, fn)
+	case *binary:
+		e.l, e.r = replaceAliases(e.l, fn), replaceAliases(e.r, fn)
+	case *call:
+		for i := range e.args {
+			e.args[i] = replaceAliases(e.args[i], fn)
+		}
+	case *inList:
+		e.x = replaceAliases(e.x, fn)
+		for i := range e.list {
+			e.list[i] = replaceAliases(e.list[i], fn)
+		}
+	case *between:
+		e.x, e.lo, e.hi = replaceAliases(e.x, fn), replaceAliases(e.lo, fn), replaceAliases(e.hi, fn)
+	case *isNull:
+		e.x = replaceAliases(e.x, fn)
+	case *like:
+		e.x, e.pattern = replaceAliases(e.x, fn), replaceAliases(e.pattern, fn)
+	case *caseExpr:
+		if e.operand != nil {
+			e.operand = replaceAliases(e.operand, fn)
+		}
+		if e.els != nil {
+			e.els = replaceAliases(e.els, fn)
+		}
+		for i := range e.whens {
+			e.whens[i].cond = replaceAliases(e.whens[i].cond, fn)
+			e.whens[i].result = replaceAliases(e.whens[i].result, fn)
+		}
+	}
+	return e
+}
+
+// execution consumes rows and produces the result.
+type execution struct {
+	q      *Query
+	b      *bound
+	out    []outRow
+	groups map[string]*group
+	order  []*group // Groups in order of first appearance
+}
+
+type outRow struct {
+	values, keys []any
+}
+
+type group struct {
+	row  []any // First row of the group, for grouped columns
+	aggs []*aggregate
+}
+
+func newExecution(q *Query, b *bound) *execution {
+	return &execution{q: q, b: b, groups: make(map[string]*group)}
+}
+
+// add processes one input row and reports whether the query needs no
+// more rows.
+func (ex *execution) add(row []any) (bool, error) {
+	en := &env{row: row}
+	if ex.q.where != nil {
+		v, err := eval(ex.q.where, en)
+		if err != nil {
+			return false, err
+		}
+		if v == nil || !truthy(v) {
+			return false, nil
+		}
+	}
+	if !ex.b.aggregated {
+		if err := ex.emit(en); err != nil {
+			return false, err
+		}
+		// Without sorting or DISTINCT the first LIMIT+OFFSET rows are final
+		return ex.q.limit >= 0 && len(ex.q.orderBy) == 0 && !ex.q.distinct &&
+			int64(len(ex.out)) >= ex.q.limit+ex.q.offset, nil
+	}
+
+	var key strings.Builder
+	for _, e := range ex.q.groupBy {
+		v, err := eval(e, en)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(&key, "%T:%v\x00", v, v)
+	}
+	g := ex.groups[key.String()]
+	if g == nil {
+		g = ex.newGroup(row)
+		ex.groups[key.String()] = g
+	}
+	for _, a := range g.aggs {
+		if err := a.add(en); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (ex *execution) newGroup(row []any) *group {
+	g := &group{row: row}
+	for _, c := range ex.b.aggs {
+		a := &aggregate{call: c}
+		if c.distinct {
+			a.seen = make(map[string]bool)
+		}
+		g.aggs = append(g.aggs, a)
+	}
+	ex.order = append(ex.order, g)
+	return g
+}
+
+// emit evaluates the select list and sort keys for one row or group.
+func (ex *execution) emit(en *env) error {
+	r := outRow{values: make([]any, len(ex.b.items))}
+	for i, it := range ex.b.items {
+		v, err := eval(it.expr, en)
+		if err != nil {
+			return err
+		}
+		r.values[i] = v
+	}
+	for _, o := range ex.q.orderBy {
+		v, err := eval(o.expr, en)
+		if err != nil {
+			return err
+		}
+		r.keys = append(r.keys, v)
+	}
+	ex.out = append(ex.out, r)
+	return nil
+}
+
+func (ex *execution) finish() (*Result, error) {
+	if ex.b.aggregated {
+		// An aggregate without GROUP BY always yields one row
+		if len(ex.order) == 0 && len(ex.q.groupBy) == 0 {
+			ex.newGroup(nil)
+		}
+		for _, g := range ex.order {
+			en := &env{row: g.row}
+			for _, a := range g.aggs {
+				v, err := a.result(en)
+				if err != nil {
+					return nil, err
+				}
+				en.aggs = append(en.aggs, v)
+			}
+			if en.row == nil {
+				// Column references in an empty aggregate are NULL
+				en.row = make([]any, ex.b.width)
+			}
+			if ex.q.having != nil {
+				v, err := eval(ex.q.having, en)
+				if err != nil {
+					return nil, err
+				}
+				if v == nil || !truthy(v) {
+					continue
+				}
+			}
+			if err := ex.emit(en); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	rows := ex.out
+	if ex.q.distinct {
+		seen := make(map[string]bool)
+		kept := rows[:0]
+		for _, r := range rows {
+			key := fmt.Sprintf("%#v", r.values)
+			if !seen[key] {
+				seen[key] = true
+				kept = append(kept, r)
+			}
+		}
+		rows = kept
+	}
+	if len(ex.q.orderBy) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			for k, o := range ex.q.orderBy {
+				c := compareNullsFirst(rows[i].keys[k], rows[j].keys[k])
+				if o.desc {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+	if off := ex.q.offset; off > 0 {
+		rows = rows[min(off, int64(len(rows))):]
+	}
+	if ex.q.limit >= 0 && int64(len(rows)) > ex.q.limit {
+		rows = rows[:ex.q.limit]
+	}
+
+	res := &Result{Columns: ex.b.names, Rows: make([][]any, len(rows))}
+	for i, r := range rows {
+		res.Rows[i] = r.values
+	}
+	return res, nil
+}
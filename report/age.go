@@ -0,0 +1,106 @@
+package report
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// DefaultAgeBuckets are the bucket edges, in days, used by the age report:
+// under 30 days, 30 days to a year, one to three years, and older.
+var DefaultAgeBuckets = []int{30, 365, 3 * 365}
+
+// Age buckets files by time since last modification, by count and bytes,
+// for sizing archive tiers.
+type Age struct {
+	now   time.Time
+	edges []int // Days, ascending
+	files []int64
+	bytes []int64
+}
+
+// NewAge buckets ages measured from now at the given day edges.
+func NewAge(now time.Time, edges []int) *Age {
+	return &Age{now: now, edges: edges, files: make([]int64, len(edges)+1), bytes: make([]int64, len(edges)+1)}
+}
+
+// ParseAgeBuckets parses a comma-separated list of ascending day counts
+// such as "30,365,1095".
+func ParseAgeBuckets(list string) ([]int, error) {
+	var edges []int
+	for _, f := range strings.Split(list, ",") {
+		days, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || days <= 0 {
+			return nil, fmt.Errorf("invalid age bucket %q: want a positive number of days", f)
+		}
+		if len(edges) > 0 && days <= edges[len(edges)-1] {
+			return nil, fmt.Errorf("age buckets must be ascending: %s", list)
+		}
+		edges = append(edges, days)
+	}
+	return edges, nil
+}
+
+func (a *Age) Name() string { return "age" }
+
+// Requires turns on stat collection.
+func (a *Age) Requires() []string { return []string{"size", "mtime"} }
+
+func (a *Age) Observe(r *scan.Record) {
+	// Files dated in the future land in the newest bucket
+	days := int(a.now.Sub(r.MTime).Hours() / 24)
+	i := 0
+	for i < len(a.edges) && days >= a.edges[i] {
+		i++
+	}
+	a.files[i]++
+	a.bytes[i] += r.DiskSize()
+}
+
+func (a *Age) Tables() []Table {
+	var files, bytes int64
+	for i := range a.files {
+		files += a.files[i]
+		bytes += a.bytes[i]
+	}
+	t := Table{
+		Name:    "age",
+		Title:   "Files by age (last modified)",
+		Columns: []string{"age", "files", "total_bytes", "size_human", "percent_bytes", "bytes_at_least_this_old"},
+	}
+	// Running total from the oldest bucket down: what an archive tier with
+	// this cutoff would hold
+	older := make([]int64, len(a.bytes)+1)
+	for i := len(a.bytes) - 1; i >= 0; i-- {
+		older[i] = older[i+1] + a.bytes[i]
+	}
+	for i := range a.files {
+		t.Rows = append(t.Rows, []any{a.label(i), a.files[i], a.bytes[i], scan.FormatBytes(a.bytes[i]),
+			percent(a.bytes[i], bytes), older[i]})
+	}
+	return []Table{t}
+}
+
+func (a *Age) label(i int) string {
+	switch {
+	case len(a.edges) == 0:
+		return "all"
+	case i == 0:
+		return "<" + days(a.edges[0])
+	case i == len(a.edges):
+		return ">=" + days(a.edges[i-1])
+	default:
+		return days(a.edges[i-1]) + "-" + days(a.edges[i])
+	}
+}
+
+// days renders a day count, using years when it divides evenly.
+func days(n int) string {
+	if n%365 == 0 {
+		return strconv.Itoa(n/365) + "y"
+	}
+	return strconv.Itoa(n) + "d"
+}
@@ -0,0 +1,206 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// DupePrefixSize is how much of each candidate is hashed before deciding
+// whether a full hash is worth it.
+const DupePrefixSize = 64 << 10
+
+// Dupes finds duplicate files. During the scan files are only grouped by
+// size; Finish then hashes files whose size is shared, first over a prefix
+// and then in full, so unique files are never read. Paths must be openable
+// as recorded, so don't combine it with path-rewriting transforms.
+type Dupes struct {
+	algo    scan.HashAlgorithm
+	workers int
+	minSize int64
+	warn    scan.WarnFunc
+
+	bySize map[int64][]string
+	sets   []dupeSet
+}
+
+type dupeSet struct {
+	size  int64
+	hash  string
+	paths []string
+}
+
+// NewDupes hashes with algo (SHA-256 when unset) on workers goroutines and
+// ignores files smaller than minSize. Files that can't be hashed are passed
+// to warn, if set, and left out.
+func NewDupes(algo scan.HashAlgorithm, workers int, minSize int64, warn scan.WarnFunc) *Dupes {
+	if algo == scan.NoHash {
+		algo = scan.SHA256
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &Dupes{algo: algo, workers: workers, minSize: minSize, warn: warn, bySize: make(map[int64][]string)}
+}
+
+func (d *Dupes) Name() string { return "dupes" }
+
+// Requires turns on size collection.
+func (d *Dupes) Requires() []string { return []string{"size"} }
+
+func (d *Dupes) Observe(r *scan.Record) {
+	// Hashing a placeholder would download it
+	if !r.Mode.IsRegular() || r.Size < d.minSize || r.Offline() {
+		return
+	}
+	d.bySize[r.Size] = append(d.bySize[r.Size], r.Path)
+}
+
+// Finish hashes the candidates and builds the duplicate sets.
+func (d *Dupes) Finish(ctx context.Context) error {
+	var groups []dupeSet
+	for size, paths := range d.bySize {
+		if len(paths) > 1 {
+			groups = append(groups, dupeSet{size: size, paths: paths})
+		}
+	}
+	d.bySize = nil
+
+	groups, err := d.refine(ctx, groups, DupePrefixSize)
+	if err != nil {
+		return err
+	}
+	// A prefix hash of a small file already covers all of it
+	var done, large []dupeSet
+	for _, g := range groups {
+		if g.size <= DupePrefixSize {
+			done = append(done, g)
+		} else {
+			large = append(large, g)
+		}
+	}
+	large, err = d.refine(ctx, large, -1)
+	if err != nil {
+		return err
+	}
+	d.sets = append(done, large...)
+
+	for _, s := range d.sets {
+		sort.Strings(s.paths)
+	}
+	sort.Slice(d.sets, func(i, j int) bool {
+		a, b := d.sets[i], d.sets[j]
+		if wa, wb := a.wasted(), b.wasted(); wa != wb {
+			return wa > wb
+		}
+		if a.size != b.size {
+			return a.size > b.size
+		}
+		return a.hash < b.hash
+	})
+	return nil
+}
+
+// wasted is the space freed by keeping one copy.
+func (s dupeSet) wasted() int64 { return s.size * int64(len(s.paths)-1) }
+
+// refine splits each group by the hash of its first limit bytes (all of
+// them when limit is negative) and drops groups left with one file.
+func (d *Dupes) refine(ctx context.Context, groups []dupeSet, limit int64) ([]dupeSet, error) {
+	var paths []string
+	for _, g := range groups {
+		paths = append(paths, g.paths...)
+	}
+	sums := d.hashAll(ctx, paths, limit)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []dupeSet
+	for _, g := range groups {
+		byHash := make(map[string][]string)
+		var order []string
+		for _, p := range g.paths {
+			sum, ok := sums[p]
+			if !ok {
+				continue
+			}
+			if _, seen := byHash[sum]; !seen {
+				order = append(order, sum)
+			}
+			byHash[sum] = append(byHash[sum], p)
+		}
+		for _, sum := range order {
+			if len(byHash[sum]) > 1 {
+				out = append(out, dupeSet{size: g.size, hash: sum, paths: byHash[sum]})
+			}
+		}
+	}
+	return out, nil
+}
+
+// hashAll hashes paths on the worker pool. Failed files are missing from
+// the result.
+func (d *Dupes) hashAll(ctx context.Context, paths []string, limit int64) map[string]string {
+	sums := make(map[string]string, len(paths))
+	var mu sync.Mutex
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < d.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				sum, _, err := scan.HashFilePrefix(p, d.algo, limit)
+				if err != nil {
+					if d.warn != nil {
+						d.warn(p, err)
+					}
+					continue
+				}
+				mu.Lock()
+				sums[p] = sum
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, p := range paths {
+		select {
+		case jobs <- p:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return sums
+}
+
+func (d *Dupes) Tables() []Table {
+	sets := Table{
+		Name:    "sets",
+		Title:   "Duplicate files",
+		Columns: []string{"set", "size", "hash", "copies", "savings_bytes", "file_path"},
+	}
+	var files, redundant, savings int64
+	for i, s := range d.sets {
+		for _, p := range s.paths {
+			sets.Rows = append(sets.Rows, []any{i + 1, s.size, s.hash, len(s.paths), s.wasted(), p})
+		}
+		files += int64(len(s.paths))
+		redundant += int64(len(s.paths) - 1)
+		savings += s.wasted()
+	}
+	summary := Table{
+		Name:    "summary",
+		Title:   fmt.Sprintf("Duplicates (%s)", d.algo),
+		Columns: []string{"sets", "files", "redundant_files", "savings_bytes", "savings_human"},
+		Rows:    [][]any{{len(d.sets), files, redundant, savings, scan.FormatBytes(savings)}},
+	}
+	return []Table{sets, summary}
+}
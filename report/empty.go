@@ -0,0 +1,92 @@
+package report
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Empty lists zero-byte files and directories that hold no files at any
+// depth. Only the outermost of nested empty directories is listed, since
+// removing it removes the rest.
+type Empty struct {
+	dirs     []string        // Filled by ObserveDir on the walker goroutine
+	nonEmpty map[string]bool // Filled by Observe on the consumer goroutine
+	files    []string
+}
+
+// NewEmpty returns an empty-entries report.
+func NewEmpty() *Empty {
+	return &Empty{nonEmpty: make(map[string]bool)}
+}
+
+func (e *Empty) Name() string { return "empty" }
+
+// Requires turns on size collection.
+func (e *Empty) Requires() []string { return []string{"size"} }
+
+func (e *Empty) ObserveDir(d *scan.DirRecord) {
+	e.dirs = append(e.dirs, d.Path)
+}
+
+func (e *Empty) Observe(r *scan.Record) {
+	if r.Mode.IsRegular() && r.Size == 0 {
+		e.files = append(e.files, r.Path)
+	}
+	// Any file, even an empty one, makes its ancestors non-empty
+	for dir := filepath.Dir(r.Path); !e.nonEmpty[dir]; {
+		e.nonEmpty[dir] = true
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+}
+
+func (e *Empty) Tables() []Table {
+	empty := make(map[string]bool)
+	for _, dir := range e.dirs {
+		if !e.nonEmpty[dir] {
+			empty[dir] = true
+		}
+	}
+	// Fold nested empty directories into their outermost empty ancestor
+	nested := make(map[string]int)
+	for dir := range empty {
+		top := dir
+		for parent := filepath.Dir(top); parent != top && empty[parent]; parent = filepath.Dir(top) {
+			top = parent
+		}
+		if top != dir {
+			nested[top]++
+		}
+	}
+	var tops []string
+	for dir := range empty {
+		if parent := filepath.Dir(dir); parent == dir || !empty[parent] {
+			tops = append(tops, dir)
+		}
+	}
+	sort.Strings(tops)
+	sort.Strings(e.files)
+
+	dirs := Table{
+		Name:    "dirs",
+		Title:   "Empty directories (no files at any depth)",
+		Columns: []string{"directory", "empty_subdirs"},
+	}
+	for _, dir := range tops {
+		dirs.Rows = append(dirs.Rows, []any{dir, nested[dir]})
+	}
+	files := Table{
+		Name:    "files",
+		Title:   "Zero-byte files",
+		Columns: []string{"file_path"},
+	}
+	for _, p := range e.files {
+		files.Rows = append(files.Rows, []any{p})
+	}
+	return []Table{files, dirs}
+}
@@ -0,0 +1,84 @@
+package report
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// noExt labels files without an extension.
+const noExt = "(none)"
+
+// Extensions aggregates file count and total size by extension.
+type Extensions struct {
+	stats map[string]*extStats
+	files int64
+	bytes int64
+}
+
+type extStats struct {
+	files, bytes int64
+}
+
+// NewExtensions returns an empty per-extension breakdown.
+func NewExtensions() *Extensions {
+	return &Extensions{stats: make(map[string]*extStats)}
+}
+
+func (e *Extensions) Name() string { return "extensions" }
+
+// Requires turns on size collection.
+func (e *Extensions) Requires() []string { return []string{"size"} }
+
+func (e *Extensions) Observe(r *scan.Record) {
+	ext := extension(r.Path)
+	st, ok := e.stats[ext]
+	if !ok {
+		st = &extStats{}
+		e.stats[ext] = st
+	}
+	st.files++
+	st.bytes += r.DiskSize()
+	e.files++
+	e.bytes += r.DiskSize()
+}
+
+func (e *Extensions) Tables() []Table {
+	exts := make([]string, 0, len(e.stats))
+	for ext := range e.stats {
+		exts = append(exts, ext)
+	}
+	// Biggest consumers first
+	sort.Slice(exts, func(i, j int) bool {
+		a, b := e.stats[exts[i]], e.stats[exts[j]]
+		if a.bytes != b.bytes {
+			return a.bytes > b.bytes
+		}
+		return exts[i] < exts[j]
+	})
+
+	t := Table{
+		Name:    "extensions",
+		Title:   "Files by extension",
+		Columns: []string{"ext", "files", "total_bytes", "size_human", "percent_files", "percent_bytes"},
+	}
+	for _, ext := range exts {
+		st := e.stats[ext]
+		t.Rows = append(t.Rows, []any{ext, st.files, st.bytes, scan.FormatBytes(st.bytes),
+			percent(st.files, e.files), percent(st.bytes, e.bytes)})
+	}
+	return []Table{t}
+}
+
+// extension returns the lowercased extension of path, treating dotfiles
+// such as ".bashrc" as having none.
+func extension(path string) string {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	if ext == "" || ext == name {
+		return noExt
+	}
+	return strings.ToLower(ext)
+}
@@ -0,0 +1,54 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// DefaultLargeDirEntries is the entry count at which the large-dirs report
+// flags a directory.
+const DefaultLargeDirEntries = 100000
+
+// LargeDirs lists directories holding at least a threshold of direct
+// entries. Huge flat directories slow down listing on most filesystems and
+// break tools that load a directory at once.
+type LargeDirs struct {
+	threshold int
+	dirs      []scan.DirRecord // Filled by ObserveDir on the walker goroutine
+}
+
+// NewLargeDirs flags directories with at least threshold entries.
+func NewLargeDirs(threshold int) *LargeDirs {
+	return &LargeDirs{threshold: threshold}
+}
+
+func (l *LargeDirs) Name() string { return "large-dirs" }
+
+func (l *LargeDirs) ObserveDir(d *scan.DirRecord) {
+	if d.Entries >= l.threshold {
+		l.dirs = append(l.dirs, *d)
+	}
+}
+
+// Observe ignores files; entry counts come from the directory listings.
+func (l *LargeDirs) Observe(*scan.Record) {}
+
+func (l *LargeDirs) Tables() []Table {
+	sort.Slice(l.dirs, func(i, j int) bool {
+		if l.dirs[i].Entries != l.dirs[j].Entries {
+			return l.dirs[i].Entries > l.dirs[j].Entries
+		}
+		return l.dirs[i].Path < l.dirs[j].Path
+	})
+	t := Table{
+		Name:    "large_dirs",
+		Title:   fmt.Sprintf("Directories with at least %d entries", l.threshold),
+		Columns: []string{"directory", "entries"},
+	}
+	for _, d := range l.dirs {
+		t.Rows = append(t.Rows, []any{d.Path, d.Entries})
+	}
+	return []Table{t}
+}
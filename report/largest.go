@@ -0,0 +1,38 @@
+package report
+
+import (
+	"fmt"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Largest reports the biggest files seen.
+type Largest struct {
+	top *topN
+}
+
+// NewLargest keeps the top largest files.
+func NewLargest(top int) *Largest {
+	return &Largest{top: newTopN(top)}
+}
+
+func (l *Largest) Name() string { return "largest" }
+
+// Requires turns on size collection.
+func (l *Largest) Requires() []string { return []string{"size"} }
+
+func (l *Largest) Observe(r *scan.Record) {
+	l.top.offer(r.Size, r.Path)
+}
+
+func (l *Largest) Tables() []Table {
+	t := Table{
+		Name:    "largest",
+		Title:   fmt.Sprintf("Top %d largest files", l.top.limit),
+		Columns: []string{"file_path", "size", "size_human"},
+	}
+	for _, item := range l.top.sorted() {
+		t.Rows = append(t.Rows, []any{item.path, item.value, scan.FormatBytes(item.value)})
+	}
+	return []Table{t}
+}
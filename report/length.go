@@ -0,0 +1,124 @@
+package report
+
+import (
+	"container/heap"
+	"fmt"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Path length bucket boundaries. 260 is the Windows MAX_PATH limit, so it
+// gets its own edge.
+var lengthEdges = []int{50, 100, 150, 200, 250, scan.MaxPath, 300, 400, 500, 1000}
+
+// LengthHistogram reports the distribution of path lengths and the longest
+// paths seen.
+type LengthHistogram struct {
+	counts  []int64 // One per bucket; the last is open-ended
+	total   int64
+	longest *topN
+}
+
+// NewLengthHistogram keeps the top longest paths.
+func NewLengthHistogram(top int) *LengthHistogram {
+	return &LengthHistogram{
+		counts:  make([]int64, len(lengthEdges)+1),
+		longest: newTopN(top),
+	}
+}
+
+func (h *LengthHistogram) Name() string { return "length-histogram" }
+
+func (h *LengthHistogram) Observe(r *scan.Record) {
+	n := len(r.Path)
+	i := 0
+	for i < len(lengthEdges) && n >= lengthEdges[i] {
+		i++
+	}
+	h.counts[i]++
+	h.total++
+	h.longest.offer(int64(n), r.Path)
+}
+
+func (h *LengthHistogram) Tables() []Table {
+	hist := Table{
+		Name:    "histogram",
+		Title:   "Path length distribution",
+		Columns: []string{"length", "files", "percent"},
+	}
+	lo := 0
+	for i, count := range h.counts {
+		label := fmt.Sprintf("%d+", lo)
+		if i < len(lengthEdges) {
+			label = fmt.Sprintf("%d-%d", lo, lengthEdges[i]-1)
+			lo = lengthEdges[i]
+		}
+		hist.Rows = append(hist.Rows, []any{label, count, percent(count, h.total)})
+	}
+
+	top := Table{
+		Name:    "longest",
+		Title:   fmt.Sprintf("Top %d longest paths", h.longest.limit),
+		Columns: []string{"file_path", "path_length"},
+	}
+	for _, item := range h.longest.sorted() {
+		top.Rows = append(top.Rows, []any{item.path, item.value})
+	}
+	return []Table{hist, top}
+}
+
+// topN keeps the n largest values seen, using a min-heap so each offer is
+// O(log n).
+type topN struct {
+	limit int
+	items topHeap
+}
+
+type topItem struct {
+	value int64
+	path  string
+}
+
+func newTopN(n int) *topN {
+	return &topN{limit: n}
+}
+
+func (t *topN) offer(value int64, path string) {
+	if len(t.items) < t.limit {
+		heap.Push(&t.items, topItem{value, path})
+		return
+	}
+	if t.limit > 0 && value > t.items[0].value {
+		t.items[0] = topItem{value, path}
+		heap.Fix(&t.items, 0)
+	}
+}
+
+// sorted returns the kept items, largest first.
+func (t *topN) sorted() []topItem {
+	h := append(topHeap(nil), t.items...)
+	out := make([]topItem, len(h))
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(&h).(topItem)
+	}
+	return out
+}
+
+type topHeap []topItem
+
+func (h topHeap) Len() int { return len(h) }
+func (h topHeap) Less(i, j int) bool {
+	// Ties break on path so output is stable
+	if h[i].value != h[j].value {
+		return h[i].value < h[j].value
+	}
+	return h[i].path > h[j].path
+}
+func (h topHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *topHeap) Push(x any)   { *h = append(*h, x.(topItem)) }
+func (h *topHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,40 @@
+package report
+
+import (
+	"sort"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// BrokenLinks lists symlinks whose target is missing or resolves outside
+// the scanned root.
+type BrokenLinks struct {
+	links [][3]string // path, target, status
+}
+
+// NewBrokenLinks returns a broken symlink report.
+func NewBrokenLinks() *BrokenLinks { return &BrokenLinks{} }
+
+func (b *BrokenLinks) Name() string { return "broken-links" }
+
+// Requires turns on symlink resolution.
+func (b *BrokenLinks) Requires() []string { return []string{"link_target", "link_status"} }
+
+func (b *BrokenLinks) Observe(r *scan.Record) {
+	if r.LinkStatus == scan.LinkBroken || r.LinkStatus == scan.LinkOutside {
+		b.links = append(b.links, [3]string{r.Path, r.LinkTarget, r.LinkStatus})
+	}
+}
+
+func (b *BrokenLinks) Tables() []Table {
+	sort.Slice(b.links, func(i, j int) bool { return b.links[i][0] < b.links[j][0] })
+	t := Table{
+		Name:    "broken_links",
+		Title:   "Broken or escaping symlinks",
+		Columns: []string{"file_path", "target", "status"},
+	}
+	for _, l := range b.links {
+		t.Rows = append(t.Rows, []any{l[0], l[1], l[2]})
+	}
+	return []Table{t}
+}
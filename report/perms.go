@@ -0,0 +1,157 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// PermPolicy configures the permissions report. It is loaded from JSON:
+//
+//	{
+//	  "world_writable": true,
+//	  "allow_sticky_dirs": true,
+//	  "setuid": true,
+//	  "setgid": true,
+//	  "allowed_uids": [0, 1000],
+//	  "allowed_gids": [],
+//	  "allow": ["/usr/bin/sudo", "*.sock"]
+//	}
+type PermPolicy struct {
+	WorldWritable   bool     `json:"world_writable"`    // Flag files and directories anyone can write
+	AllowStickyDirs bool     `json:"allow_sticky_dirs"` // Except directories with the sticky bit, like /tmp
+	Setuid          bool     `json:"setuid"`
+	Setgid          bool     `json:"setgid"`
+	AllowedUIDs     []int    `json:"allowed_uids"` // When set, flag entries owned by anyone else
+	AllowedGIDs     []int    `json:"allowed_gids"`
+	Allow           []string `json:"allow"` // Glob patterns, matched against the path and the base name, exempt from every check
+}
+
+// DefaultPermPolicy flags world-writable entries (outside sticky
+// directories) and setuid/setgid files, without checking owners.
+var DefaultPermPolicy = PermPolicy{WorldWritable: true, AllowStickyDirs: true, Setuid: true, Setgid: true}
+
+// LoadPermPolicy reads a policy file. Fields missing from the file keep
+// their DefaultPermPolicy values.
+func LoadPermPolicy(path string) (PermPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PermPolicy{}, err
+	}
+	p := DefaultPermPolicy
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return PermPolicy{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, pattern := range p.Allow {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return PermPolicy{}, fmt.Errorf("parsing %s: bad allow pattern %q", path, pattern)
+		}
+	}
+	return p, nil
+}
+
+// check returns the policy violations of one entry.
+func (p PermPolicy) check(path string, mode fs.FileMode, uid, gid int, hasOwner bool) []string {
+	for _, pattern := range p.Allow {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return nil
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return nil
+		}
+	}
+	var issues []string
+	if p.WorldWritable && mode.Perm()&0o002 != 0 && !(mode.IsDir() && mode&fs.ModeSticky != 0 && p.AllowStickyDirs) {
+		issues = append(issues, "world-writable")
+	}
+	if p.Setuid && mode&fs.ModeSetuid != 0 {
+		issues = append(issues, "setuid")
+	}
+	if p.Setgid && mode&fs.ModeSetgid != 0 {
+		issues = append(issues, "setgid")
+	}
+	if hasOwner && len(p.AllowedUIDs) > 0 && !slices.Contains(p.AllowedUIDs, uid) {
+		issues = append(issues, fmt.Sprintf("unexpected uid %d", uid))
+	}
+	if hasOwner && len(p.AllowedGIDs) > 0 && !slices.Contains(p.AllowedGIDs, gid) {
+		issues = append(issues, fmt.Sprintf("unexpected gid %d", gid))
+	}
+	return issues
+}
+
+// Permissions flags files and directories that break a PermPolicy.
+// Symlinks are skipped, since their own mode bits mean nothing.
+type Permissions struct {
+	policy PermPolicy
+	dirs   []permFinding // Filled by ObserveDir on the walker goroutine
+	files  []permFinding
+}
+
+type permFinding struct {
+	path     string
+	mode     fs.FileMode
+	uid, gid any
+	issues   []string
+}
+
+// NewPermissions checks entries against policy.
+func NewPermissions(policy PermPolicy) *Permissions {
+	return &Permissions{policy: policy}
+}
+
+func (p *Permissions) Name() string { return "permissions" }
+
+// Requires turns on mode and owner collection.
+func (p *Permissions) Requires() []string { return []string{"mode", "uid", "gid"} }
+
+func (p *Permissions) ObserveDir(d *scan.DirRecord) {
+	info, err := d.Info()
+	if err != nil {
+		return
+	}
+	uid, gid, ok := scan.Owner(info)
+	if issues := p.policy.check(d.Path, info.Mode(), uid, gid, ok); issues != nil {
+		p.dirs = append(p.dirs, newPermFinding(d.Path, info.Mode(), uid, gid, ok, issues))
+	}
+}
+
+func (p *Permissions) Observe(r *scan.Record) {
+	if r.Mode&fs.ModeSymlink != 0 {
+		return
+	}
+	ok := r.UID >= 0
+	if issues := p.policy.check(r.Path, r.Mode, r.UID, r.GID, ok); issues != nil {
+		p.files = append(p.files, newPermFinding(r.Path, r.Mode, r.UID, r.GID, ok, issues))
+	}
+}
+
+func newPermFinding(path string, mode fs.FileMode, uid, gid int, hasOwner bool, issues []string) permFinding {
+	f := permFinding{path: path, mode: mode, issues: issues}
+	if hasOwner {
+		f.uid, f.gid = uid, gid
+	}
+	return f
+}
+
+func (p *Permissions) Tables() []Table {
+	all := append(append([]permFinding(nil), p.dirs...), p.files...)
+	sort.Slice(all, func(i, j int) bool { return all[i].path < all[j].path })
+	t := Table{
+		Name:    "permissions",
+		Title:   "Permission anomalies",
+		Columns: []string{"path", "mode", "uid", "gid", "issues"},
+	}
+	for _, f := range all {
+		t.Rows = append(t.Rows, []any{f.path, f.mode, f.uid, f.gid, f.issues})
+	}
+	return []Table{t}
+}
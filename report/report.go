@@ -0,0 +1,242 @@
+// Package report builds secondary reports (histograms, top-N lists,
+// aggregations) from the records of a scan. Reports are scan.Observers:
+// register them with scan.WithObserver and render them after Run returns.
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// DefaultTop is the number of entries kept by top-N reports.
+const DefaultTop = 20
+
+// Report is a scan observer that renders one or more tables once the scan
+// is done.
+type Report interface {
+	scan.Observer
+	Name() string
+	Tables() []Table
+}
+
+// Finisher is implemented by reports with work left once the scan is done,
+// such as hashing duplicate candidates.
+type Finisher interface {
+	Finish(ctx context.Context) error
+}
+
+// Finish runs r's Finish method if it has one. Call it after the scan and
+// before rendering.
+func Finish(ctx context.Context, r Report) error {
+	if f, ok := r.(Finisher); ok {
+		return f.Finish(ctx)
+	}
+	return nil
+}
+
+// Table is one section of a report. Values are typed; renderers format
+// them.
+type Table struct {
+	Name    string
+	Title   string
+	Columns []string
+	Rows    [][]any
+}
+
+// Options tunes the built-in reports.
+type Options struct {
+	Top   int    // Entries kept by top-N reports
+	Root  string // Scan root, for reports relative to it
+	Depth int    // Directory levels for rollups
+
+	Hash    scan.HashAlgorithm // Content hash for duplicate detection
+	Workers int                // Hashing goroutines (default: number of CPUs)
+	Warn    scan.WarnFunc      // Receives files a report failed to read
+
+	AgeBuckets []int       // Bucket edges in days for the age report
+	PermPolicy *PermPolicy // Rules for the permissions report
+
+	LargeDirEntries int // Entry count flagged by the large-dirs report
+}
+
+var factories = map[string]func(Options) Report{
+	"length-histogram": func(o Options) Report { return NewLengthHistogram(o.Top) },
+	"largest":          func(o Options) Report { return NewLargest(o.Top) },
+	"extensions":       func(Options) Report { return NewExtensions() },
+	"rollup":           func(o Options) Report { return NewRollup(o.Root, o.Depth) },
+	"dupes":            func(o Options) Report { return NewDupes(o.Hash, o.Workers, 1, o.Warn) },
+	"empty":            func(Options) Report { return NewEmpty() },
+	"broken-links":     func(Options) Report { return NewBrokenLinks() },
+	"age":              func(o Options) Report { return NewAge(time.Now(), o.AgeBuckets) },
+	"permissions":      func(o Options) Report { return NewPermissions(*o.PermPolicy) },
+	"large-dirs":       func(o Options) Report { return NewLargeDirs(o.LargeDirEntries) },
+	"sensitive":        func(Options) Report { return NewSensitive() },
+	"sparse":           func(Options) Report { return NewSparse() },
+}
+
+// New returns the built-in report registered under name.
+func New(name string, opts Options) (Report, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown report %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	if opts.Top <= 0 {
+		opts.Top = DefaultTop
+	}
+	if opts.Depth <= 0 {
+		opts.Depth = DefaultRollupDepth
+	}
+	if opts.AgeBuckets == nil {
+		opts.AgeBuckets = DefaultAgeBuckets
+	}
+	if opts.LargeDirEntries <= 0 {
+		opts.LargeDirEntries = DefaultLargeDirEntries
+	}
+	if opts.PermPolicy == nil {
+		opts.PermPolicy = &DefaultPermPolicy
+	}
+	return factory(opts), nil
+}
+
+// Names lists the built-in reports.
+func Names() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteText renders every table of r as aligned console text.
+func WriteText(w io.Writer, r Report) error {
+	for _, t := range r.Tables() {
+		fmt.Fprintf(w, "\n%s\n", t.Title)
+		if err := WriteTableText(w, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTableText renders table t as aligned console text under a header
+// row.
+func WriteTableText(w io.Writer, t Table) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.Columns, "\t"))
+	for _, row := range t.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = scan.FormatValue(v)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// WriteCSV writes table t as CSV with a header row.
+func WriteCSV(w io.Writer, t Table) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.Columns); err != nil {
+		return err
+	}
+	cells := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i, v := range row {
+			cells[i] = scan.FormatValue(v)
+		}
+		if err := cw.Write(cells); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes every table of r as one JSON object keyed by table
+// name, each holding an array of row objects.
+func WriteJSON(w io.Writer, r Report) error {
+	out := make(map[string][]map[string]any)
+	for _, t := range r.Tables() {
+		out[t.Name] = rowObjects(t)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// WriteTableJSON writes table t as a JSON array of row objects.
+func WriteTableJSON(w io.Writer, t Table) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rowObjects(t))
+}
+
+func rowObjects(t Table) []map[string]any {
+	rows := make([]map[string]any, len(t.Rows))
+	for i, row := range t.Rows {
+		obj := make(map[string]any, len(row))
+		for j, v := range row {
+			obj[t.Columns[j]] = v
+		}
+		rows[i] = obj
+	}
+	return rows
+}
+
+// WriteFiles writes r into dir in the given format ("csv" or "json") and
+// returns the paths created. CSV gets one file per table, named
+// report_<report>_<table>.csv; JSON gets report_<report>.json.
+func WriteFiles(dir, format string, r Report) ([]string, error) {
+	write := func(name string, fn func(io.Writer) error) (string, error) {
+		path := filepath.Join(dir, name)
+		f, err := scan.CreateAtomic(path)
+		if err != nil {
+			return "", err
+		}
+		if err := fn(f); err != nil {
+			f.Abort()
+			return "", err
+		}
+		return path, f.Commit()
+	}
+
+	switch format {
+	case "json":
+		path, err := write("report_"+r.Name()+".json", func(w io.Writer) error { return WriteJSON(w, r) })
+		if err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	case "csv":
+		var paths []string
+		for _, t := range r.Tables() {
+			path, err := write(fmt.Sprintf("report_%s_%s.csv", r.Name(), t.Name), func(w io.Writer) error { return WriteCSV(w, t) })
+			if err != nil {
+				return paths, err
+			}
+			paths = append(paths, path)
+		}
+		return paths, nil
+	default:
+		return nil, fmt.Errorf("unsupported report format %q (want text, csv or json)", format)
+	}
+}
+
+// percent returns part as a percentage of total, rounded to one decimal.
+func percent(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(int64(float64(part)*1000/float64(total)+0.5)) / 10
+}
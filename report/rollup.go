@@ -0,0 +1,85 @@
+package report
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// DefaultRollupDepth is how many directory levels below the root a rollup
+// reports when no depth is given.
+const DefaultRollupDepth = 2
+
+// Rollup aggregates cumulative file counts and sizes per directory, down to
+// a fixed depth below the scan root, like du --max-depth.
+type Rollup struct {
+	root  string
+	depth int
+	dirs  map[string]*dirTotals
+}
+
+type dirTotals struct {
+	depth        int
+	files, bytes int64
+}
+
+// NewRollup reports directories up to depth levels below root.
+func NewRollup(root string, depth int) *Rollup {
+	return &Rollup{root: filepath.Clean(root), depth: depth, dirs: make(map[string]*dirTotals)}
+}
+
+func (r *Rollup) Name() string { return "rollup" }
+
+// Requires turns on size collection.
+func (r *Rollup) Requires() []string { return []string{"size"} }
+
+func (r *Rollup) Observe(rec *scan.Record) {
+	rel, err := filepath.Rel(r.root, rec.Path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		// Transforms moved the path outside the root; roll it up under
+		// its own directory instead
+		r.add(filepath.Dir(rec.Path), 0, rec.DiskSize())
+		return
+	}
+	parts := strings.Split(filepath.Dir(rel), string(filepath.Separator))
+	if parts[0] == "." {
+		parts = nil
+	}
+	dir := r.root
+	r.add(dir, 0, rec.DiskSize())
+	for i := 0; i < len(parts) && i < r.depth; i++ {
+		dir = filepath.Join(dir, parts[i])
+		r.add(dir, i+1, rec.DiskSize())
+	}
+}
+
+func (r *Rollup) add(dir string, depth int, size int64) {
+	t, ok := r.dirs[dir]
+	if !ok {
+		t = &dirTotals{depth: depth}
+		r.dirs[dir] = t
+	}
+	t.files++
+	t.bytes += size
+}
+
+func (r *Rollup) Tables() []Table {
+	dirs := make([]string, 0, len(r.dirs))
+	for dir := range r.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	t := Table{
+		Name:    "rollup",
+		Title:   "Directory usage (cumulative)",
+		Columns: []string{"directory", "depth", "files", "total_bytes", "size_human"},
+	}
+	for _, dir := range dirs {
+		d := r.dirs[dir]
+		t.Rows = append(t.Rows, []any{dir, d.depth, d.files, d.bytes, scan.FormatBytes(d.bytes)})
+	}
+	return []Table{t}
+}
@@ -0,0 +1,64 @@
+package report
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Sensitive lists the files content detectors found something in, with
+// totals per detector.
+type Sensitive struct {
+	files   [][2]string // path, findings
+	matches map[string]int
+	counts  map[string]int // Files per detector
+}
+
+// NewSensitive returns a sensitive content report.
+func NewSensitive() *Sensitive {
+	return &Sensitive{matches: make(map[string]int), counts: make(map[string]int)}
+}
+
+func (s *Sensitive) Name() string { return "sensitive" }
+
+// Requires turns on content detection.
+func (s *Sensitive) Requires() []string { return []string{"findings"} }
+
+func (s *Sensitive) Observe(r *scan.Record) {
+	if len(r.Findings) == 0 {
+		return
+	}
+	s.files = append(s.files, [2]string{r.Path, strings.Join(r.Findings, "; ")})
+	for name, n := range scan.ParseFindings(r.Findings) {
+		s.matches[name] += n
+		s.counts[name]++
+	}
+}
+
+func (s *Sensitive) Tables() []Table {
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i][0] < s.files[j][0] })
+	files := Table{
+		Name:    "sensitive_files",
+		Title:   "Files with sensitive content",
+		Columns: []string{"file_path", "findings"},
+	}
+	for _, f := range s.files {
+		files.Rows = append(files.Rows, []any{f[0], f[1]})
+	}
+
+	names := make([]string, 0, len(s.counts))
+	for name := range s.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	totals := Table{
+		Name:    "sensitive_totals",
+		Title:   "Sensitive content by detector",
+		Columns: []string{"detector", "files", "matches"},
+	}
+	for _, name := range names {
+		totals.Rows = append(totals.Rows, []any{name, s.counts[name], s.matches[name]})
+	}
+	return []Table{files, totals}
+}
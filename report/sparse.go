@@ -0,0 +1,63 @@
+package report
+
+import (
+	"sort"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Sparse lists sparse files, those with less space allocated on disk
+// than their size, biggest holes first. Copying them with a tool that
+// doesn't preserve holes writes out the full size.
+type Sparse struct {
+	files []scan.Record
+}
+
+// NewSparse returns a sparse file report.
+func NewSparse() *Sparse { return &Sparse{} }
+
+func (s *Sparse) Name() string { return "sparse" }
+
+// Requires turns on size and allocation collection.
+func (s *Sparse) Requires() []string { return []string{"size", "sparse"} }
+
+func (s *Sparse) Observe(r *scan.Record) {
+	if r.Sparse {
+		s.files = append(s.files, scan.Record{Path: r.Path, Size: r.Size, Allocated: r.Allocated})
+	}
+}
+
+func (s *Sparse) Tables() []Table {
+	// Without allocations (on Windows) the holes are unknown; sort by size
+	hole := func(r scan.Record) int64 {
+		if r.Allocated < 0 {
+			return r.Size
+		}
+		return r.Size - r.Allocated
+	}
+	sort.Slice(s.files, func(i, j int) bool {
+		a, b := hole(s.files[i]), hole(s.files[j])
+		if a != b {
+			return a > b
+		}
+		return s.files[i].Path < s.files[j].Path
+	})
+	t := Table{
+		Name:    "sparse",
+		Title:   "Sparse files",
+		Columns: []string{"file_path", "size", "allocated", "unallocated", "unallocated_human"},
+	}
+	var total int64
+	for _, r := range s.files {
+		if r.Allocated < 0 {
+			t.Rows = append(t.Rows, []any{r.Path, r.Size, nil, nil, ""})
+			continue
+		}
+		total += hole(r)
+		t.Rows = append(t.Rows, []any{r.Path, r.Size, r.Allocated, hole(r), scan.FormatBytes(hole(r))})
+	}
+	if total > 0 {
+		t.Title += " (" + scan.FormatBytes(total) + " unallocated)"
+	}
+	return []Table{t}
+}
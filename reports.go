@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/csv"
+	"strings"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// collisionReport writes case-collision groups as CSV, one row per group.
+type collisionReport struct {
+	file   *scan.AtomicFile
+	w      *csv.Writer
+	groups int
+}
+
+func newCollisionReport(path string) (*collisionReport, error) {
+	f, err := scan.CreateAtomic(path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"directory", "folded_name", "names"}); err != nil {
+		f.Abort()
+		return nil, err
+	}
+	return &collisionReport{file: f, w: w}, nil
+}
+
+// Add is a scan.CollisionFunc; the walker calls it from a single goroutine.
+func (c *collisionReport) Add(dir string, names []string) {
+	c.groups++
+	c.w.Write([]string{dir, strings.ToLower(names[0]), strings.Join(names, "|")})
+}
+
+// Close commits the report, or discards it when the scan failed.
+func (c *collisionReport) Close(ok bool) error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil || !ok {
+		c.file.Abort()
+		return err
+	}
+	return c.file.Commit()
+}
+
+// nameReport writes one row per record with Windows or SharePoint name
+// issues.
+type nameReport struct {
+	file  *scan.AtomicFile
+	w     *csv.Writer
+	count int
+}
+
+func newNameReport(path string) (*nameReport, error) {
+	f, err := scan.CreateAtomic(path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"file_path", "issues"}); err != nil {
+		f.Abort()
+		return nil, err
+	}
+	return &nameReport{file: f, w: w}, nil
+}
+
+func (n *nameReport) Requires() []string { return []string{"windows_issues"} }
+
+func (n *nameReport) Observe(r *scan.Record) {
+	if len(r.WindowsIssues) == 0 {
+		return
+	}
+	n.count++
+	n.w.Write([]string{r.Path, scan.FormatValue(r.WindowsIssues)})
+}
+
+// Close commits the report, or discards it when the scan failed.
+func (n *nameReport) Close(ok bool) error {
+	n.w.Flush()
+	if err := n.w.Error(); err != nil || !ok {
+		n.file.Abort()
+		return err
+	}
+	return n.file.Commit()
+}
@@ -0,0 +1,164 @@
+// Package rpc serves scans over gRPC. The service is defined in
+// scanner.proto; framing and protobuf encoding are implemented here on top
+// of net/http's HTTP/2 support, so no gRPC runtime is needed.
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// ScanMethod is the HTTP/2 path of Scanner.Scan.
+const ScanMethod = "/readfilepaths.v1.Scanner/Scan"
+
+// maxRequestSize bounds the ScanRequest a client may send.
+const maxRequestSize = 1 << 20
+
+// Code is a gRPC status code.
+type Code uint32
+
+const (
+	OK               Code = 0
+	Canceled         Code = 1
+	Unknown          Code = 2
+	InvalidArgument  Code = 3
+	PermissionDenied Code = 7
+	Unimplemented    Code = 12
+	Internal         Code = 13
+)
+
+// Status is an error carrying a gRPC status code.
+type Status struct {
+	Code    Code
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("rpc error: code = %d desc = %s", s.Code, s.Message)
+}
+
+// Errorf returns a Status error.
+func Errorf(code Code, format string, args ...any) error {
+	return &Status{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ScanFunc runs the scan described by req, writing records to sink. A
+// returned Status sets the response code; other errors are Unknown.
+type ScanFunc func(ctx context.Context, req *ScanRequest, sink scan.Sink) error
+
+// Handler serves the Scanner service. Mount it on a server speaking
+// HTTP/2, with TLS or as h2c.
+func Handler(fn ScanFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC requires HTTP/2 and an application/grpc content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+
+		if r.URL.Path != ScanMethod {
+			finish(w, Errorf(Unimplemented, "unknown method %s", r.URL.Path))
+			return
+		}
+		msg, err := readMessage(r.Body)
+		if err != nil {
+			finish(w, err)
+			return
+		}
+		req, err := UnmarshalScanRequest(msg)
+		if err != nil {
+			finish(w, Errorf(InvalidArgument, "%v", err))
+			return
+		}
+		err = fn(r.Context(), req, &stream{w: w})
+		if r.Context().Err() != nil {
+			err = Errorf(Canceled, "client cancelled")
+		}
+		finish(w, err)
+	})
+}
+
+// readMessage reads one length-prefixed message.
+func readMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, Errorf(InvalidArgument, "reading request: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, Errorf(Unimplemented, "compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > maxRequestSize {
+		return nil, Errorf(InvalidArgument, "request of %d bytes is too large", n)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, Errorf(InvalidArgument, "reading request: %v", err)
+	}
+	return msg, nil
+}
+
+// finish sets the status trailers.
+func finish(w http.ResponseWriter, err error) {
+	code, msg := OK, ""
+	if err != nil {
+		var st *Status
+		if errors.As(err, &st) {
+			code, msg = st.Code, st.Message
+		} else {
+			code, msg = Unknown, err.Error()
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(code)))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", encodeMessage(msg))
+	}
+}
+
+// encodeMessage percent-encodes a status message as gRPC requires.
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// stream is a Sink sending each record as one response message.
+type stream struct {
+	w   http.ResponseWriter
+	buf []byte
+}
+
+func (s *stream) WriteHeader([]scan.Column) error { return nil }
+
+func (s *stream) WriteBatch(records []scan.Record) error {
+	for i := range records {
+		s.buf = append(s.buf[:0], 0, 0, 0, 0, 0)
+		s.buf = AppendRecord(s.buf, &records[i])
+		binary.BigEndian.PutUint32(s.buf[1:5], uint32(len(s.buf)-5))
+		if _, err := s.w.Write(s.buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *stream) Flush() error {
+	return http.NewResponseController(s.w).Flush()
+}
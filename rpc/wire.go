@@ -0,0 +1,167 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Protocol buffer wire types.
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+// ScanRequest mirrors the ScanRequest message in scanner.proto.
+type ScanRequest struct {
+	Root         string
+	ExcludePaths []string
+	ExcludeNames []string
+	Hash         string
+	Stat         bool
+}
+
+var errTruncated = errors.New("rpc: truncated message")
+
+// UnmarshalScanRequest decodes a ScanRequest. Unknown fields are skipped.
+func UnmarshalScanRequest(b []byte) (*ScanRequest, error) {
+	req := &ScanRequest{}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		b = b[n:]
+		field, wire := tag>>3, tag&7
+		switch wire {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errTruncated
+			}
+			b = b[n:]
+			if field == 5 {
+				req.Stat = v != 0
+			}
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, errTruncated
+			}
+			s := string(b[n : n+int(l)])
+			b = b[n+int(l):]
+			switch field {
+			case 1:
+				req.Root = s
+			case 2:
+				req.ExcludePaths = append(req.ExcludePaths, s)
+			case 3:
+				req.ExcludeNames = append(req.ExcludeNames, s)
+			case 4:
+				req.Hash = s
+			}
+		case wire64:
+			if len(b) < 8 {
+				return nil, errTruncated
+			}
+			b = b[8:]
+		case wire32:
+			if len(b) < 4 {
+				return nil, errTruncated
+			}
+			b = b[4:]
+		default:
+			return nil, fmt.Errorf("rpc: unsupported wire type %d", wire)
+		}
+	}
+	return req, nil
+}
+
+// MarshalScanRequest encodes a ScanRequest, for clients.
+func MarshalScanRequest(req *ScanRequest) []byte {
+	var b []byte
+	b = appendString(b, 1, req.Root)
+	for _, p := range req.ExcludePaths {
+		b = appendBytes(b, 2, []byte(p))
+	}
+	for _, p := range req.ExcludeNames {
+		b = appendBytes(b, 3, []byte(p))
+	}
+	b = appendString(b, 4, req.Hash)
+	if req.Stat {
+		b = appendVarint(b, 5, 1)
+	}
+	return b
+}
+
+// AppendRecord appends r encoded as a Record message. Zero values are
+// omitted, as proto3 does.
+func AppendRecord(b []byte, r *scan.Record) []byte {
+	b = appendString(b, 1, r.Path)
+	b = appendVarint(b, 2, uint64(r.Size))
+	b = appendVarint(b, 3, uint64(r.Mode))
+	if !r.MTime.IsZero() {
+		b = appendVarint(b, 4, uint64(r.MTime.UnixNano()))
+	}
+	b = appendString(b, 5, r.Hash)
+	b = appendBool(b, 6, r.LongPath)
+	b = appendBool(b, 7, r.InvalidUTF8)
+	b = appendBool(b, 8, r.CaseCollision)
+	for _, issue := range r.WindowsIssues {
+		b = appendBytes(b, 9, []byte(issue))
+	}
+	b = appendString(b, 10, r.LinkTarget)
+	b = appendString(b, 11, r.LinkStatus)
+	// int32 negatives are sign-extended to 64 bits on the wire
+	b = appendVarint(b, 12, uint64(int64(r.UID)))
+	b = appendVarint(b, 13, uint64(int64(r.GID)))
+	keys := make([]string, 0, len(r.Extra))
+	for k := range r.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendString(entry, 2, scan.FormatValue(r.Extra[k]))
+		b = appendBytes(b, 14, entry)
+	}
+	return b
+}
+
+func appendTag(b []byte, field, wire uint64) []byte {
+	return binary.AppendUvarint(b, field<<3|wire)
+}
+
+func appendVarint(b []byte, field, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendBool(b []byte, field uint64, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarint(b, field, 1)
+}
+
+func appendString(b []byte, field uint64, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return appendBytes(b, field, []byte(s))
+}
+
+// appendBytes always writes the field, so repeated and map entries keep
+// empty values.
+func appendBytes(b []byte, field uint64, v []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,283 @@
+package scan
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Actions an ActionHook can take on files.
+const (
+	ActionMove   = "move"
+	ActionCopy   = "copy"
+	ActionDelete = "delete"
+)
+
+// Columns added by an ActionHook.
+const (
+	ActionColumn       = "action"
+	ActionDestColumn   = "action_dest"
+	ActionStatusColumn = "action_status"
+)
+
+// Values of the action_status column besides "error: ..." for failures.
+const (
+	ActionDone   = "done"
+	ActionDryRun = "dry-run"
+
+	// ActionStarted is only written to the journal, ahead of each action
+	ActionStarted = "started"
+)
+
+// ActionHook is a ColumnTransform moving, copying or deleting every file
+// that reaches it, and recording what it did in the action, action_dest
+// and action_status columns for auditing. Moves and copies keep each
+// file's path below the scan root under the destination. Failures are
+// recorded, not returned, so one locked file doesn't hide the others.
+//
+// The hook acts on the path the file was walked at, never on Record.Path,
+// which earlier transforms and plugins may have rewritten or escaped.
+// Archive members are left alone; any other record without a walked path,
+// such as one from a remote source, fails.
+type ActionHook struct {
+	action string
+	dest   string
+	dryRun bool
+
+	done, failed atomic.Int64
+
+	journalMu sync.Mutex
+	journal   *os.File // Set by Journal
+	journalW  *csv.Writer
+}
+
+// NewActionHook returns a hook taking action on the files of a scan of
+// root. Move and copy need a dest outside root; dryRun records what would
+// be done without doing it.
+func NewActionHook(action, root, dest string, dryRun bool) (*ActionHook, error) {
+	switch action {
+	case ActionMove, ActionCopy:
+		if dest == "" {
+			return nil, fmt.Errorf("action %s needs a destination", action)
+		}
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		absDest, err := filepath.Abs(dest)
+		if err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(absRoot, absDest); err == nil && (rel == "." || filepath.IsLocal(rel)) {
+			return nil, fmt.Errorf("destination %s is inside the scanned directory", dest)
+		}
+	case ActionDelete:
+		if dest != "" {
+			return nil, errors.New("action delete takes no destination")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q (want move, copy or delete)", action)
+	}
+	return &ActionHook{action: action, dest: dest, dryRun: dryRun}, nil
+}
+
+// Journal makes h record every action in a CSV file at path, apart from
+// the scan output: a started row, synced to disk before the file is
+// touched, and a row with the outcome after. Records can be lost when a
+// scan fails or is interrupted and its output discarded; the journal
+// still says what was done. An action whose started row can't be written
+// is not taken. Call Close once the scan is over.
+func (h *ActionHook) Journal(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"time", ActionColumn, "path", ActionDestColumn, ActionStatusColumn})
+	if w.Flush(); w.Error() != nil {
+		f.Close()
+		return w.Error()
+	}
+	h.journal, h.journalW = f, w
+	return nil
+}
+
+// logAction writes a journal row, syncing it when sync is set.
+func (h *ActionHook) logAction(when, path, dest, status string, sync bool) error {
+	h.journalMu.Lock()
+	defer h.journalMu.Unlock()
+	if err := h.journalW.Write([]string{when, h.action, path, dest, status}); err != nil {
+		return err
+	}
+	if h.journalW.Flush(); h.journalW.Error() != nil {
+		return h.journalW.Error()
+	}
+	if sync {
+		return h.journal.Sync()
+	}
+	return nil
+}
+
+// Close syncs and closes the journal, if any.
+func (h *ActionHook) Close() error {
+	if h.journal == nil {
+		return nil
+	}
+	err := h.journal.Sync()
+	if cerr := h.journal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (h *ActionHook) Columns() []Column {
+	return []Column{ExtraColumn(ActionColumn), ExtraColumn(ActionDestColumn), ExtraColumn(ActionStatusColumn)}
+}
+
+// Counts returns how many actions succeeded (or would have, in a dry
+// run) and failed so far.
+func (h *ActionHook) Counts() (done, failed int64) {
+	return h.done.Load(), h.failed.Load()
+}
+
+func (h *ActionHook) Apply(r *Record) (bool, error) {
+	if !r.IsFile() || r.inArchive {
+		// Moving or deleting a directory would take its whole subtree
+		return true, nil
+	}
+	r.SetExtra(ActionColumn, h.action)
+	src := r.osPath
+	info, err := h.resolve(r)
+	var dest string
+	if err == nil && h.action != ActionDelete {
+		dest = filepath.Join(h.dest, r.relPath)
+		r.SetExtra(ActionDestColumn, dest)
+	}
+
+	status := ActionDryRun
+	if err == nil && !h.dryRun {
+		if h.journal != nil {
+			if err := h.logAction(time.Now().UTC().Format(time.RFC3339), src, dest, ActionStarted, true); err != nil {
+				return false, fmt.Errorf("not %s: writing action journal: %w", actionVerb(h.action), err)
+			}
+		}
+		switch h.action {
+		case ActionMove:
+			err = moveFile(src, dest, info)
+		case ActionCopy:
+			err = copyFile(src, dest, info)
+		case ActionDelete:
+			err = os.Remove(src)
+		}
+		status = ActionDone
+	}
+	if err != nil {
+		status = "error: " + err.Error()
+		h.failed.Add(1)
+	} else {
+		h.done.Add(1)
+	}
+	r.SetExtra(ActionStatusColumn, status)
+	if h.journal != nil && !h.dryRun {
+		// The outcome is worth keeping even when writing it fails
+		h.logAction(time.Now().UTC().Format(time.RFC3339), src, dest, status, false)
+	}
+	return true, nil
+}
+
+// resolve returns the info of the file r was walked at, failing for
+// records without a local path below the root.
+func (h *ActionHook) resolve(r *Record) (fs.FileInfo, error) {
+	if r.osPath == "" {
+		return nil, errors.New("not a local file")
+	}
+	if h.action != ActionDelete && !filepath.IsLocal(r.relPath) {
+		return nil, fmt.Errorf("%s is not below the scan root", r.relPath)
+	}
+	return os.Lstat(r.osPath)
+}
+
+// actionVerb is the participle of action, for messages.
+func actionVerb(action string) string {
+	switch action {
+	case ActionMove:
+		return "moved"
+	case ActionCopy:
+		return "copied"
+	}
+	return "deleted"
+}
+
+// moveFile renames src to dest, copying across filesystems. An existing
+// dest is never replaced.
+func moveFile(src, dest string, info fs.FileInfo) error {
+	if err := prepareDest(dest); err != nil {
+		return err
+	}
+	err := os.Rename(src, dest)
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) || !errors.Is(linkErr.Err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyFile(src, dest, info); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies a regular file or symlink to dest, keeping its
+// permissions and modification time. An existing dest is never replaced.
+func copyFile(src, dest string, info fs.FileInfo) error {
+	if err := prepareDest(dest); err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dest)
+	case !info.Mode().IsRegular():
+		return errors.New("not a regular file")
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := CreateAtomic(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Abort()
+		return err
+	}
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
+		out.Abort()
+		return err
+	}
+	if err := out.Commit(); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, info.ModTime(), info.ModTime())
+}
+
+// prepareDest creates dest's directory and fails if dest exists.
+func prepareDest(dest string) error {
+	if _, err := os.Lstat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.MkdirAll(filepath.Dir(dest), 0o755)
+}
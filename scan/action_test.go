@@ -0,0 +1,101 @@
+package scan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestActionMoveKeepsTree(t *testing.T) {
+	root, dest := t.TempDir(), t.TempDir()
+	writeFiles(t, root, "a.txt", "sub/b.txt")
+	hook, err := NewActionHook(ActionMove, root, dest, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordSink{}
+	if _, err := New(root, WithSink(sink), WithTransform(hook)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if _, err := os.Lstat(filepath.Join(dest, name)); err != nil {
+			t.Errorf("%s not moved: %v", name, err)
+		}
+		if _, err := os.Lstat(filepath.Join(root, name)); err == nil {
+			t.Errorf("%s still in the root", name)
+		}
+	}
+	if done, failed := hook.Counts(); done != 2 || failed != 0 {
+		t.Errorf("Counts() = %d, %d, want 2, 0", done, failed)
+	}
+}
+
+func TestActionIgnoresRewrittenPath(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	writeFiles(t, root, "walked.txt")
+	writeFiles(t, outside, "victim.txt")
+	victim := filepath.Join(outside, "victim.txt")
+	hook, err := NewActionHook(ActionDelete, root, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As a plugin reply replacing file_path would
+	rewrite := TransformFunc(func(r *Record) (bool, error) {
+		r.Path = victim
+		return true, nil
+	})
+	if _, err := New(root, WithSink(&recordSink{}), WithTransform(rewrite, hook)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(victim); err != nil {
+		t.Errorf("rewritten path was acted on: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "walked.txt")); err == nil {
+		t.Error("walked file was not deleted")
+	}
+}
+
+func TestActionInvalidUTF8Name(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs a filesystem taking arbitrary bytes in names")
+	}
+	root := t.TempDir()
+	name := filepath.Join(root, "bad\xff.txt")
+	if err := os.WriteFile(name, nil, 0o644); err != nil {
+		t.Skip("filesystem refuses invalid UTF-8:", err)
+	}
+	hook, err := NewActionHook(ActionDelete, root, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(root, WithSink(&recordSink{}), WithEscapeMode(EscapeHex), WithTransform(hook)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(name); err == nil {
+		t.Error("file with an escaped name was not deleted")
+	}
+	if done, failed := hook.Counts(); done != 1 || failed != 0 {
+		t.Errorf("Counts() = %d, %d, want 1, 0", done, failed)
+	}
+}
+
+func TestActionUnresolvableFails(t *testing.T) {
+	hook, err := NewActionHook(ActionDelete, t.TempDir(), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Record{Path: "/etc/passwd", Type: EntryFile}
+	keep, err := hook.Apply(&r)
+	if !keep || err != nil {
+		t.Fatalf("Apply = %v, %v", keep, err)
+	}
+	if status, _ := r.Extra[ActionStatusColumn].(string); !strings.HasPrefix(status, "error: ") {
+		t.Errorf("status = %q, want an error", status)
+	}
+	if done, failed := hook.Counts(); done != 0 || failed != 1 {
+		t.Errorf("Counts() = %d, %d, want 0, 1", done, failed)
+	}
+}
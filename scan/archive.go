@@ -0,0 +1,348 @@
+package scan
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ArchiveSeparator joins an archive's path to the path of a member inside
+// it, as in backups/home.tar.gz!/etc/passwd.
+const ArchiveSeparator = "!/"
+
+// splitMember splits the path of an archive member into the archive's
+// path and the member's name inside it. ok is false for paths outside
+// archives, including those merely containing the separator.
+func splitMember(p string) (archive, member string, ok bool) {
+	for i := 0; ; {
+		j := strings.Index(p[i:], ArchiveSeparator)
+		if j < 0 {
+			return p, "", false
+		}
+		i += j
+		if archiveKind(p[:i]) != "" {
+			return p[:i], p[i+len(ArchiveSeparator):], true
+		}
+		i += len(ArchiveSeparator)
+	}
+}
+
+// archiveKind returns the format of an archive judging by its name, or ""
+// when it isn't one the scanner can read.
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tgz"
+	case strings.HasSuffix(lower, ".tar.bz2"), strings.HasSuffix(lower, ".tbz2"), strings.HasSuffix(lower, ".tbz"):
+		return "tbz2"
+	case strings.HasSuffix(lower, ".7z"):
+		return "7z"
+	}
+	return ""
+}
+
+// archiveMember is a file inside an archive. open is only valid during the
+// callback that receives the member.
+type archiveMember struct {
+	name     string
+	info     fs.FileInfo
+	uid, gid int
+	link     string
+	open     func() (io.ReadCloser, error)
+}
+
+// members records the files inside the archive of e. base is the
+// archive's recorded path before transforms. Members read before an
+// error are returned along with it.
+func (s *Scanner) members(ctx context.Context, e entry, base string) ([]Record, error) {
+	var recs []Record
+	err := listArchive(e.osPath, archiveKind(e.path), func(m archiveMember) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rec, keep, err := s.memberRecord(e.root.root, base, m)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.name, err)
+		}
+		if keep {
+			recs = append(recs, rec)
+		}
+		return nil
+	})
+	return recs, err
+}
+
+// memberRecord builds the record of an archive member. Directories and
+// members rejected by the filters are dropped.
+func (s *Scanner) memberRecord(root, base string, m archiveMember) (Record, bool, error) {
+	p := base + ArchiveSeparator + m.name
+	if m.info.IsDir() || !s.accept(p, fs.FileInfoToDirEntry(m.info)) {
+		return Record{}, false, nil
+	}
+	rec := Record{
+		Path:  p,
+		Root:  root,
+		Size:  m.info.Size(),
+		Mode:  m.info.Mode(),
+		MTime: m.info.ModTime(),
+		UID:   m.uid,
+		GID:   m.gid,
+
+		Allocated:    -1,
+		ResourceFork: -1,
+		ChildFiles:   -1,
+		ChildDirs:    -1,
+		Type:         entryType(m.info.Mode()),
+		ScanID:       s.runID,
+		inArchive:    true,
+	}
+	if s.auditNames {
+		rec.WindowsIssues = WindowsNameIssues(m.name)
+	}
+	if !utf8.ValidString(p) {
+		rec.InvalidUTF8 = true
+		rec.Path = escapeInvalidUTF8(p, s.escape)
+	}
+	if s.needHash && m.info.Mode().IsRegular() {
+		rc, err := m.open()
+		if err != nil {
+			return rec, false, err
+		}
+		h := s.hash.New()
+		n, err := io.Copy(h, rc)
+		rc.Close()
+		s.metrics.hashed(n)
+		if err != nil {
+			return rec, false, err
+		}
+		rec.Hash = hex.EncodeToString(h.Sum(nil))
+	}
+	if s.needDetect && m.info.Mode().IsRegular() {
+		rc, err := m.open()
+		if err != nil {
+			return rec, false, err
+		}
+		rec.Findings, err = detect(rc, s.detectors, s.detectLimit)
+		rc.Close()
+		if err != nil {
+			return rec, false, err
+		}
+	}
+	if s.needLinks && m.info.Mode()&fs.ModeSymlink != 0 {
+		// Link status needs the target on disk, which members don't have
+		rec.LinkTarget = m.link
+	}
+	s.provide(&rec, m.info)
+	keep, err := s.transform(&rec)
+	return rec, keep, err
+}
+
+// listArchive calls fn for each member of the archive at osPath, in the
+// order they are stored.
+func listArchive(osPath, kind string, fn func(archiveMember) error) error {
+	switch kind {
+	case "zip":
+		return listZip(osPath, fn)
+	case "7z":
+		return list7z(osPath, fn)
+	}
+
+	f, err := os.Open(osPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	switch kind {
+	case "tgz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case "tbz2":
+		r = bzip2.NewReader(r)
+	}
+	return listTar(r, fn)
+}
+
+func listTar(r io.Reader, fn func(archiveMember) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name, ok := memberName(hdr.Name)
+		if !ok {
+			continue
+		}
+		m := archiveMember{
+			name: name,
+			info: hdr.FileInfo(),
+			uid:  hdr.Uid,
+			gid:  hdr.Gid,
+			link: hdr.Linkname,
+			open: func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+}
+
+func listZip(osPath string, fn func(archiveMember) error) error {
+	zr, err := zip.OpenReader(osPath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		name, ok := memberName(f.Name)
+		if !ok {
+			continue
+		}
+		m := archiveMember{name: name, info: f.FileInfo(), uid: -1, gid: -1, open: f.Open}
+		if m.info.Mode()&fs.ModeSymlink != 0 {
+			// Zip stores a link's target as its content
+			if rc, err := f.Open(); err == nil {
+				target, _ := io.ReadAll(io.LimitReader(rc, 4096))
+				rc.Close()
+				m.link = string(target)
+			}
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errNo7z is returned for 7z archives when no 7z command is installed.
+var errNo7z = errors.New("reading 7z archives needs the 7z command (p7zip or 7-Zip)")
+
+// list7z lists a 7z archive with the 7z command, which must be on PATH.
+// Hashing runs one extraction per member.
+func list7z(osPath string, fn func(archiveMember) error) error {
+	var bin string
+	for _, name := range []string{"7z", "7zz", "7za"} {
+		if p, err := exec.LookPath(name); err == nil {
+			bin = p
+			break
+		}
+	}
+	if bin == "" {
+		return errNo7z
+	}
+	out, err := exec.Command(bin, "l", "-slt", "--", osPath).Output()
+	if err != nil {
+		return fmt.Errorf("7z l: %w", err)
+	}
+	// Entries follow the dashed line, one "Key = Value" block each
+	_, listing, ok := bytes.Cut(out, []byte("\n----------\n"))
+	if !ok {
+		return nil
+	}
+	for _, block := range strings.Split(string(listing), "\n\n") {
+		fields := make(map[string]string)
+		for _, line := range strings.Split(block, "\n") {
+			if k, v, ok := strings.Cut(strings.TrimRight(line, "\r"), " = "); ok {
+				fields[k] = v
+			}
+		}
+		raw, ok := fields["Path"]
+		if !ok {
+			continue
+		}
+		name, ok := memberName(strings.ReplaceAll(raw, `\`, "/"))
+		if !ok {
+			continue
+		}
+		info := memberInfo{name: path.Base(name), mode: 0o644}
+		if fields["Folder"] == "+" || strings.HasPrefix(fields["Attributes"], "D") {
+			info.mode = fs.ModeDir | 0o755
+		}
+		info.size, _ = strconv.ParseInt(fields["Size"], 10, 64)
+		if mod := fields["Modified"]; mod != "" {
+			info.mtime, _ = time.ParseInLocation("2006-01-02 15:04:05", mod[:min(len(mod), 19)], time.Local)
+		}
+		m := archiveMember{name: name, info: info, uid: -1, gid: -1, open: func() (io.ReadCloser, error) {
+			return extract7z(bin, osPath, raw)
+		}}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extract7z streams one member of a 7z archive.
+func extract7z(bin, osPath, member string) (io.ReadCloser, error) {
+	cmd := exec.Command(bin, "x", "-so", "--", osPath, member)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdReader{ReadCloser: out, cmd: cmd}, nil
+}
+
+// cmdReader reads a command's output and waits for it on Close.
+type cmdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *cmdReader) Close() error {
+	io.Copy(io.Discard, r.ReadCloser)
+	return r.cmd.Wait()
+}
+
+// memberName cleans an archive member's name to a relative slash path.
+// It reports false for names that refer to the archive root.
+func memberName(name string) (string, bool) {
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	return name, name != ""
+}
+
+// memberInfo is the fs.FileInfo of a member listed by an external tool.
+type memberInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+func (i memberInfo) Name() string       { return i.name }
+func (i memberInfo) Size() int64        { return i.size }
+func (i memberInfo) Mode() fs.FileMode  { return i.mode }
+func (i memberInfo) ModTime() time.Time { return i.mtime }
+func (i memberInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memberInfo) Sys() any           { return nil }
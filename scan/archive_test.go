@@ -0,0 +1,74 @@
+package scan
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveMembersRealPaths(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "arc.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"inner.txt", "sub/deeper.txt"} {
+		if _, err := zw.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &recordSink{}
+	var warnings []string
+	_, err = New(dir, WithSink(sink), WithArchives(true), WithTransform(RealPaths()), WithDeterministic(true),
+		WithWarnFunc(func(path string, err error) { warnings = append(warnings, path+": "+err.Error()) })).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) > 0 {
+		t.Errorf("warnings: %v", warnings)
+	}
+	var members []string
+	for _, r := range sink.records {
+		if strings.Contains(r.Path, ArchiveSeparator) {
+			members = append(members, r.Path)
+		}
+	}
+	want := []string{
+		filepath.Join(real, "arc.zip") + "!/inner.txt",
+		filepath.Join(real, "arc.zip") + "!/sub/deeper.txt",
+	}
+	if strings.Join(members, "\n") != strings.Join(want, "\n") {
+		t.Errorf("members = %q, want %q", members, want)
+	}
+}
+
+func TestSplitMember(t *testing.T) {
+	tests := []struct {
+		path, archive, member string
+		ok                    bool
+	}{
+		{"a/b.zip!/c/d", "a/b.zip", "c/d", true},
+		{"odd!/b.tar!/c", "odd!/b.tar", "c", true},
+		{"odd!/plain", "odd!/plain", "", false},
+		{"a/b.zip", "a/b.zip", "", false},
+	}
+	for _, tt := range tests {
+		archive, member, ok := splitMember(tt.path)
+		if archive != tt.archive || member != tt.member || ok != tt.ok {
+			t.Errorf("splitMember(%q) = %q, %q, %v, want %q, %q, %v", tt.path, archive, member, ok, tt.archive, tt.member, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package scan
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheMagic starts a hash cache file and versions its layout. Version 1
+// files, which hold only file hashes, are still read.
+const (
+	cacheMagic   = "rfp-hash-cache 2\n"
+	cacheMagicV1 = "rfp-hash-cache 1\n"
+)
+
+// Kinds of record in a version 2 cache file.
+const (
+	cacheFileRecord = 'f'
+	cacheDirRecord  = 'd'
+)
+
+// racyWindow is how close to the cache being opened a file's mtime may
+// be and still be cached. A file written within it could change again
+// without its mtime moving, as timestamps are coarser than writes.
+const racyWindow = 2 * time.Second
+
+// cacheKey identifies a file: its absolute path, and the device and inode
+// behind it, so a file replaced under the same name misses.
+type cacheKey struct {
+	path     string
+	dev, ino uint64
+}
+
+// cacheEntry is what a file looked like when it was hashed.
+type cacheEntry struct {
+	size  int64
+	mtime int64 // UnixNano
+	algo  HashAlgorithm
+	hash  string
+}
+
+// dirListing is what a directory held when it was listed, and the mtime
+// and link count that show it unchanged since.
+type dirListing struct {
+	mtime    int64 // UnixNano
+	links    uint64
+	children []dirChild
+}
+
+type dirChild struct {
+	name string
+	typ  fs.FileMode
+}
+
+// HashCache remembers file hashes between scans, so files whose device,
+// inode, size and mtime are unchanged aren't read again, and optionally
+// directory listings, so unchanged directories aren't listed again. It is
+// held in memory and persisted to a single file, rewritten whole by Save;
+// entries under a scanned root that the scan didn't see are dropped then.
+// A HashCache is safe for concurrent use.
+type HashCache struct {
+	path   string
+	opened time.Time
+
+	mu       sync.Mutex
+	entries  map[cacheKey]cacheEntry
+	seen     map[cacheKey]bool
+	roots    []string // Absolute roots of the scans hashing through the cache
+	dirs     map[cacheKey]dirListing
+	dirsSeen map[cacheKey]bool
+	dirRoots []string // Absolute roots of the scans listing through the cache
+
+	hits, misses       atomic.Int64
+	dirHits, dirMisses atomic.Int64
+}
+
+// OpenHashCache loads the cache at path, or starts an empty one if the
+// file doesn't exist yet.
+func OpenHashCache(path string) (*HashCache, error) {
+	c := &HashCache{
+		path:     path,
+		opened:   time.Now(),
+		entries:  make(map[cacheKey]cacheEntry),
+		seen:     make(map[cacheKey]bool),
+		dirs:     make(map[cacheKey]dirListing),
+		dirsSeen: make(map[cacheKey]bool),
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := c.load(bufio.NewReader(f)); err != nil {
+		return nil, fmt.Errorf("reading hash cache %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *HashCache) load(r *bufio.Reader) error {
+	magic := make([]byte, len(cacheMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || (string(magic) != cacheMagic && string(magic) != cacheMagicV1) {
+		return errors.New("not a hash cache")
+	}
+	v1 := string(magic) == cacheMagicV1
+	for {
+		kind := byte(cacheFileRecord)
+		if !v1 {
+			var err error
+			if kind, err = r.ReadByte(); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		path, err := readCacheString(r)
+		if err == io.EOF && v1 {
+			return nil
+		}
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		k := cacheKey{path: path}
+		if k.dev, err = binary.ReadUvarint(r); err != nil {
+			return unexpectedEOF(err)
+		}
+		if k.ino, err = binary.ReadUvarint(r); err != nil {
+			return unexpectedEOF(err)
+		}
+		switch kind {
+		case cacheFileRecord:
+			err = c.loadFile(r, k)
+		case cacheDirRecord:
+			err = c.loadDir(r, k)
+		default:
+			err = errors.New("corrupt entry")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *HashCache) loadFile(r *bufio.Reader, k cacheKey) error {
+	var e cacheEntry
+	var err error
+	if e.size, err = binary.ReadVarint(r); err != nil {
+		return unexpectedEOF(err)
+	}
+	if e.mtime, err = binary.ReadVarint(r); err != nil {
+		return unexpectedEOF(err)
+	}
+	algo, err := readCacheString(r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	e.algo = HashAlgorithm(algo)
+	if e.hash, err = readCacheString(r); err != nil {
+		return unexpectedEOF(err)
+	}
+	c.entries[k] = e
+	return nil
+}
+
+func (c *HashCache) loadDir(r *bufio.Reader, k cacheKey) error {
+	var l dirListing
+	var err error
+	if l.mtime, err = binary.ReadVarint(r); err != nil {
+		return unexpectedEOF(err)
+	}
+	if l.links, err = binary.ReadUvarint(r); err != nil {
+		return unexpectedEOF(err)
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if n > 1<<24 {
+		return errors.New("corrupt entry")
+	}
+	l.children = make([]dirChild, n)
+	for i := range l.children {
+		if l.children[i].name, err = readCacheString(r); err != nil {
+			return unexpectedEOF(err)
+		}
+		typ, err := binary.ReadUvarint(r)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		l.children[i].typ = fs.FileMode(typ) & fs.ModeType
+	}
+	c.dirs[k] = l
+	return nil
+}
+
+func readCacheString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > 1<<20 {
+		return "", errors.New("corrupt entry")
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	return string(b), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// addRoot notes a scanned root, under which unseen file hashes and,
+// when the scan lists through the cache, unseen listings are dropped on
+// Save.
+func (c *HashCache) addRoot(root string, hashes, listings bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hashes {
+		c.roots = append(c.roots, root)
+	}
+	if listings {
+		c.dirRoots = append(c.dirRoots, root)
+	}
+}
+
+// lookup returns the cached hash of a file, if it is still valid.
+func (c *HashCache) lookup(k cacheKey, info fs.FileInfo, algo HashAlgorithm) (string, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[k]
+	if ok {
+		c.seen[k] = true
+	}
+	c.mu.Unlock()
+	if ok && e.algo == algo && e.size == info.Size() && e.mtime == info.ModTime().UnixNano() {
+		c.hits.Add(1)
+		return e.hash, true
+	}
+	c.misses.Add(1)
+	return "", false
+}
+
+// store caches a file's hash, unless it was modified too recently to
+// trust its mtime.
+func (c *HashCache) store(k cacheKey, info fs.FileInfo, algo HashAlgorithm, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen[k] = true
+	if info.ModTime().After(c.opened.Add(-racyWindow)) {
+		delete(c.entries, k)
+		return
+	}
+	c.entries[k] = cacheEntry{size: info.Size(), mtime: info.ModTime().UnixNano(), algo: algo, hash: hash}
+}
+
+// lookupDir returns the cached listing of the directory at osPath, if
+// its mtime and link count are unchanged.
+func (c *HashCache) lookupDir(k cacheKey, info fs.FileInfo, osPath string) ([]fs.DirEntry, bool) {
+	c.mu.Lock()
+	l, ok := c.dirs[k]
+	if ok {
+		c.dirsSeen[k] = true
+	}
+	c.mu.Unlock()
+	if !ok || l.mtime != info.ModTime().UnixNano() || l.links != linkCount(info) {
+		c.dirMisses.Add(1)
+		return nil, false
+	}
+	c.dirHits.Add(1)
+	entries := make([]fs.DirEntry, len(l.children))
+	for i, child := range l.children {
+		entries[i] = cachedDirEntry{dir: osPath, dirChild: child}
+	}
+	return entries, true
+}
+
+// storeDir caches a directory's listing, unless it was modified too
+// recently to trust its mtime. info must be from before the listing, so a
+// change made while listing moves the mtime past the cached one.
+func (c *HashCache) storeDir(k cacheKey, info fs.FileInfo, entries []fs.DirEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirsSeen[k] = true
+	if info.ModTime().After(c.opened.Add(-racyWindow)) {
+		delete(c.dirs, k)
+		return
+	}
+	l := dirListing{mtime: info.ModTime().UnixNano(), links: linkCount(info), children: make([]dirChild, len(entries))}
+	for i, e := range entries {
+		l.children[i] = dirChild{name: e.Name(), typ: e.Type()}
+	}
+	c.dirs[k] = l
+}
+
+// cachedDirEntry replays an entry of a cached listing. Like the entries
+// os.ReadDir returns, it stats the file only when asked for its Info.
+type cachedDirEntry struct {
+	dir string
+	dirChild
+}
+
+func (e cachedDirEntry) Name() string               { return e.name }
+func (e cachedDirEntry) IsDir() bool                { return e.typ.IsDir() }
+func (e cachedDirEntry) Type() fs.FileMode          { return e.typ }
+func (e cachedDirEntry) Info() (fs.FileInfo, error) { return os.Lstat(filepath.Join(e.dir, e.name)) }
+func (e cachedDirEntry) String() string             { return fs.FormatDirEntry(e) }
+
+// Counts returns how many lookups found a valid hash and how many
+// didn't.
+func (c *HashCache) Counts() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// DirCounts returns how many directories were replayed from the cache
+// and how many had to be listed.
+func (c *HashCache) DirCounts() (hits, misses int64) {
+	return c.dirHits.Load(), c.dirMisses.Load()
+}
+
+// Save writes the cache back to its file, atomically. Call it only after
+// a complete scan, since entries it didn't see are dropped.
+func (c *HashCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := CreateAtomic(c.path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(cacheMagic)
+	var buf []byte
+	for k, e := range c.entries {
+		if !c.seen[k] && underRoot(c.roots, k.path) {
+			continue
+		}
+		buf = appendCacheKey(append(buf[:0], cacheFileRecord), k)
+		buf = binary.AppendVarint(buf, e.size)
+		buf = binary.AppendVarint(buf, e.mtime)
+		buf = appendCacheString(buf, string(e.algo))
+		buf = appendCacheString(buf, e.hash)
+		w.Write(buf)
+	}
+	for k, l := range c.dirs {
+		if !c.dirsSeen[k] && underRoot(c.dirRoots, k.path) {
+			continue
+		}
+		buf = appendCacheKey(append(buf[:0], cacheDirRecord), k)
+		buf = binary.AppendVarint(buf, l.mtime)
+		buf = binary.AppendUvarint(buf, l.links)
+		buf = binary.AppendUvarint(buf, uint64(len(l.children)))
+		for _, child := range l.children {
+			buf = appendCacheString(buf, child.name)
+			buf = binary.AppendUvarint(buf, uint64(child.typ))
+		}
+		w.Write(buf)
+	}
+	if err := w.Flush(); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
+
+func underRoot(roots []string, path string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendCacheKey(b []byte, k cacheKey) []byte {
+	b = appendCacheString(b, k.path)
+	b = binary.AppendUvarint(b, k.dev)
+	return binary.AppendUvarint(b, k.ino)
+}
+
+func appendCacheString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,83 @@
+package scan
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ChecksumSink writes records in the format of GNU coreutils' sha256sum
+// (and md5sum, sha1sum): a hex digest, two spaces and the path, so
+// `sha256sum -c` can check the output directly. Records without a hash,
+// such as symlinks, are left out. Paths containing a backslash or line
+// break are escaped the way coreutils does, with a leading backslash on
+// the line.
+type ChecksumSink struct {
+	out  io.Writer
+	w    *bufio.Writer
+	path Column
+	hash Column
+}
+
+// NewChecksumSink returns a Sink writing checksum lines to w.
+func NewChecksumSink(w io.Writer) *ChecksumSink {
+	return &ChecksumSink{out: w, w: bufio.NewWriter(w)}
+}
+
+// Location returns the path of the file the sink writes, if any.
+func (c *ChecksumSink) Location() string { return writerLocation(c.out) }
+
+func (c *ChecksumSink) WriteHeader(columns []Column) error {
+	var havePath, haveHash bool
+	for _, col := range columns {
+		switch col.Name {
+		case "file_path":
+			c.path, havePath = col, true
+		case "hash":
+			c.hash, haveHash = col, true
+		}
+	}
+	if !havePath || !haveHash {
+		return errors.New("checksum output needs the file_path and hash columns")
+	}
+	return nil
+}
+
+// checksumEscaper escapes paths as coreutils does.
+var checksumEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+func (c *ChecksumSink) WriteBatch(records []Record) error {
+	for i := range records {
+		sum := FormatValue(c.hash.Value(&records[i]))
+		if sum == "" {
+			continue
+		}
+		path := FormatValue(c.path.Value(&records[i]))
+		if strings.ContainsAny(path, "\\\n\r") {
+			c.w.WriteByte('\\')
+			path = checksumEscaper.Replace(path)
+		}
+		c.w.WriteString(sum)
+		c.w.WriteString("  ")
+		c.w.WriteString(path)
+		if err := c.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ChecksumSink) Flush() error { return c.w.Flush() }
+
+// Sync flushes buffered lines and fsyncs the underlying writer when it
+// supports it.
+func (c *ChecksumSink) Sync() error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	if syncer, ok := c.out.(Syncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
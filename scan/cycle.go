@@ -0,0 +1,66 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+var (
+	// ErrCycle reports a directory reached a second time through a bind
+	// mount or similar aliasing. The second visit is skipped.
+	ErrCycle = errors.New("directory already visited")
+
+	// ErrVanished reports an entry that was listed in its directory but no
+	// longer existed when it was stat'ed or read, as happens in active build
+	// directories. It wraps the underlying not-exist error.
+	ErrVanished = errors.New("vanished during scan")
+
+	// ErrSymlinkLoop reports a symlink whose target chain loops back on
+	// itself. The link is not recorded.
+	ErrSymlinkLoop = errors.New("symlink loop")
+)
+
+// WarnFunc receives entries skipped because of non-fatal problems: errors
+// reading a directory, stat or hash failures, and cycles. It may be called
+// from several goroutines at once.
+type WarnFunc func(path string, err error)
+
+// cycleGuard remembers every directory visited by (device, inode) so that
+// revisits can be pruned. It is only used from the walker goroutine.
+type cycleGuard struct {
+	visited map[fileID]string
+}
+
+func newCycleGuard() *cycleGuard {
+	return &cycleGuard{visited: make(map[fileID]string)}
+}
+
+// enter records a visit of the directory at osPath, displayed as path,
+// and returns ErrCycle, with the displayed path of the first visit, if
+// the same directory was already entered through another path.
+func (g *cycleGuard) enter(osPath, path string, d fs.DirEntry) (string, error) {
+	info, err := d.Info()
+	if err != nil {
+		return "", nil // Let the walker report unreadable directories
+	}
+	id, ok := statFileID(osPath, info)
+	if !ok {
+		return "", nil
+	}
+	if first, seen := g.visited[id]; seen {
+		return first, fmt.Errorf("%w as %s", ErrCycle, first)
+	}
+	g.visited[id] = path
+	return "", nil
+}
+
+// checkSymlink resolves a symlink and returns ErrSymlinkLoop if its target
+// chain never terminates.
+func checkSymlink(path string) error {
+	if _, err := os.Stat(path); err != nil && isSymlinkLoop(err) {
+		return ErrSymlinkLoop
+	}
+	return nil
+}
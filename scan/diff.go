@@ -0,0 +1,251 @@
+package scan
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+)
+
+// Change kinds reported by Diff.
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "changed"
+	// Kinds only Diff3 reports
+	ChangeRestored  = "restored"
+	ChangeRecreated = "recreated"
+)
+
+// Change is one difference between two scan outputs.
+type Change struct {
+	Kind   string
+	Path   string
+	Fields []string // Compared columns that differ, for ChangeModified
+	Old    []string // Compared values, aligned with DiffResult.Columns; nil when added
+	New    []string // nil when removed
+	Base   []string // Baseline values, for Diff3; nil when not in the baseline
+}
+
+// DiffResult holds the changes between two outputs, sorted by path.
+type DiffResult struct {
+	Columns  []string // Columns that were compared
+	Changes  []Change
+	Baseline bool // Made by Diff3, so changes carry baseline values
+}
+
+// Count returns the number of changes of kind.
+func (d *DiffResult) Count(kind string) int {
+	n := 0
+	for _, c := range d.Changes {
+		if c.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Diff compares two outputs keyed by file_path. compare lists the columns
+// to compare; when it is empty every column present in both outputs
+// (except file_path and the derived path_length) is. The old output is
+// held in memory; the new one is streamed.
+func Diff(old, new OutputReader, compare []string) (*DiffResult, error) {
+	oldIdx, newIdx, columns, err := diffColumns(old.Columns(), new.Columns(), compare)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := readValues(old, oldIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &DiffResult{Columns: columns}
+	for {
+		row, err := new.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		path := row[newIdx[0]]
+		values := pick(row, newIdx[1:])
+		prev, ok := before[path]
+		if !ok {
+			res.Changes = append(res.Changes, Change{Kind: ChangeAdded, Path: path, New: values})
+			continue
+		}
+		delete(before, path)
+		if fields := changedFields(columns, prev, values); fields != nil {
+			res.Changes = append(res.Changes, Change{Kind: ChangeModified, Path: path, Fields: fields, Old: prev, New: values})
+		}
+	}
+	for path, prev := range before {
+		res.Changes = append(res.Changes, Change{Kind: ChangeRemoved, Path: path, Old: prev})
+	}
+	sort.Slice(res.Changes, func(i, j int) bool { return res.Changes[i].Path < res.Changes[j].Path })
+	return res, nil
+}
+
+// diffColumns resolves the compared columns. The returned index slices
+// start with the position of file_path.
+func diffColumns(oldCols, newCols, compare []string) (oldIdx, newIdx []int, columns []string, err error) {
+	if !slices.Contains(oldCols, "file_path") || !slices.Contains(newCols, "file_path") {
+		return nil, nil, nil, fmt.Errorf("both outputs need a file_path column")
+	}
+	if len(compare) == 0 {
+		columns = commonColumns(oldCols, newCols)
+	} else {
+		for _, col := range compare {
+			if !slices.Contains(oldCols, col) || !slices.Contains(newCols, col) {
+				return nil, nil, nil, fmt.Errorf("column %q is not in both outputs", col)
+			}
+		}
+		columns = compare
+	}
+	return columnIndexes(oldCols, columns), columnIndexes(newCols, columns), columns, nil
+}
+
+// commonColumns lists the columns of the first output found in all the
+// others, except file_path and the derived path_length.
+func commonColumns(first []string, others ...[]string) []string {
+	var columns []string
+	for _, col := range first {
+		if col == "file_path" || col == "path_length" {
+			continue
+		}
+		common := true
+		for _, o := range others {
+			common = common && slices.Contains(o, col)
+		}
+		if common {
+			columns = append(columns, col)
+		}
+	}
+	return columns
+}
+
+// columnIndexes returns the positions of file_path and then of columns
+// in cols.
+func columnIndexes(cols, columns []string) []int {
+	idx := []int{slices.Index(cols, "file_path")}
+	for _, col := range columns {
+		idx = append(idx, slices.Index(cols, col))
+	}
+	return idx
+}
+
+// Diff3 compares a current output with a previous one, like Diff, and
+// uses a baseline, such as a known-good scan, to tell apart changes that
+// look alike between two scans: a file back to its baseline values is
+// ChangeRestored, and one missing from the previous scan but in the
+// baseline with other values is ChangeRecreated, the deleted-and-written
+// pattern of ransomware. Other kinds are as for Diff. Files unchanged
+// since the previous scan aren't reported, even when they differ from
+// the baseline. The baseline and previous outputs are held in memory.
+func Diff3(base, old, new OutputReader, compare []string) (*DiffResult, error) {
+	outputs := [][]string{base.Columns(), old.Columns(), new.Columns()}
+	for _, cols := range outputs {
+		if !slices.Contains(cols, "file_path") {
+			return nil, fmt.Errorf("all three outputs need a file_path column")
+		}
+	}
+	columns := compare
+	if len(columns) == 0 {
+		columns = commonColumns(outputs[1], outputs[2], outputs[0])
+	}
+	for _, col := range columns {
+		for _, cols := range outputs {
+			if !slices.Contains(cols, col) {
+				return nil, fmt.Errorf("column %q is not in all three outputs", col)
+			}
+		}
+	}
+	baseline, err := readValues(base, columnIndexes(outputs[0], columns))
+	if err != nil {
+		return nil, err
+	}
+	before, err := readValues(old, columnIndexes(outputs[1], columns))
+	if err != nil {
+		return nil, err
+	}
+	newIdx := columnIndexes(outputs[2], columns)
+
+	res := &DiffResult{Columns: columns, Baseline: true}
+	for {
+		row, err := new.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		path := row[newIdx[0]]
+		values := pick(row, newIdx[1:])
+		prev, inPrev := before[path]
+		delete(before, path)
+		b, inBase := baseline[path]
+		c := Change{Path: path, Old: prev, New: values, Base: b}
+		switch {
+		case inPrev && slices.Equal(prev, values):
+			continue
+		case inBase && slices.Equal(b, values):
+			c.Kind = ChangeRestored
+			c.Fields = changedFields(columns, prev, values)
+		case inPrev:
+			c.Kind = ChangeModified
+			c.Fields = changedFields(columns, prev, values)
+		case inBase:
+			c.Kind = ChangeRecreated
+			c.Fields = changedFields(columns, b, values)
+		default:
+			c.Kind = ChangeAdded
+		}
+		res.Changes = append(res.Changes, c)
+	}
+	for path, prev := range before {
+		res.Changes = append(res.Changes, Change{Kind: ChangeRemoved, Path: path, Old: prev, Base: baseline[path]})
+	}
+	sort.Slice(res.Changes, func(i, j int) bool { return res.Changes[i].Path < res.Changes[j].Path })
+	return res, nil
+}
+
+// readValues reads an output into a map from file_path to the values at
+// idx[1:], idx[0] being the position of file_path.
+func readValues(r OutputReader, idx []int) (map[string][]string, error) {
+	values := make(map[string][]string)
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[row[idx[0]]] = pick(row, idx[1:])
+	}
+}
+
+// changedFields lists the columns whose values differ between a and b,
+// or nil when a is.
+func changedFields(columns, a, b []string) []string {
+	if a == nil {
+		return nil
+	}
+	var fields []string
+	for i, col := range columns {
+		if a[i] != b[i] {
+			fields = append(fields, col)
+		}
+	}
+	return fields
+}
+
+func pick(row []string, idx []int) []string {
+	values := make([]string, len(idx))
+	for i, j := range idx {
+		values[i] = row[j]
+	}
+	return values
+}
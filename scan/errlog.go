@@ -0,0 +1,188 @@
+package scan
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"sync"
+)
+
+// ErrorLog records skipped entries to a companion file. The file is only
+// created once the first entry is added; a clean scan removes any stale
+// manifest left by a previous run. ErrorLog is safe for concurrent use and
+// its Add method can be passed to WithWarnFunc.
+type ErrorLog struct {
+	path   string
+	format string
+
+	// wrap, when set, filters what is written, e.g. to encrypt it
+	wrap func(io.Writer) (io.WriteCloser, error)
+
+	mu      sync.Mutex
+	file    *AtomicFile
+	out     io.Writer // file, or wrapped
+	wrapped io.WriteCloser
+	csv     *csv.Writer
+	count   int
+	classes map[string]int
+	err     error
+}
+
+type errorEntry struct {
+	Path  string `json:"file_path"`
+	Class string `json:"class"`
+	Error string `json:"error"`
+}
+
+// ErrorClass returns the manifest class for a skip reason: "vanished" for
+// entries deleted mid-scan, "permission" for access denied, "not_found"
+// for other missing paths, "io" for device and media errors, "cycle" and
+// "symlink_loop" for aliased directories and looping links, and "error"
+// for everything else.
+func ErrorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrVanished):
+		return "vanished"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission"
+	case errors.Is(err, fs.ErrNotExist):
+		return "not_found"
+	case isIOError(err):
+		return "io"
+	case errors.Is(err, ErrCycle):
+		return "cycle"
+	case errors.Is(err, ErrSymlinkLoop):
+		return "symlink_loop"
+	}
+	return "error"
+}
+
+// NewErrorLog returns an ErrorLog writing to path in format "csv" or "json".
+func NewErrorLog(path, format string) (*ErrorLog, error) {
+	if format != "csv" && format != "json" {
+		return nil, fmt.Errorf("unsupported errors format %q (want csv or json)", format)
+	}
+	return &ErrorLog{path: path, format: format}, nil
+}
+
+// WrapWith makes the manifest pass through the writer wrap returns for
+// its file, which is closed before the file is committed.
+func (l *ErrorLog) WrapWith(wrap func(io.Writer) (io.WriteCloser, error)) {
+	l.wrap = wrap
+}
+
+// Path returns the manifest destination.
+func (l *ErrorLog) Path() string {
+	return l.path
+}
+
+// Add records a skipped path and the reason.
+func (l *ErrorLog) Add(path string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return
+	}
+	if l.file == nil {
+		if l.err = l.open(); l.err != nil {
+			return
+		}
+	}
+	entry := errorEntry{Path: path, Class: ErrorClass(err), Error: err.Error()}
+	if l.format == "json" {
+		l.err = l.writeJSON(entry)
+	} else {
+		l.err = l.csv.Write([]string{entry.Path, entry.Class, entry.Error})
+	}
+	l.count++
+	if l.classes == nil {
+		l.classes = make(map[string]int)
+	}
+	l.classes[entry.Class]++
+}
+
+func (l *ErrorLog) open() error {
+	f, err := CreateAtomic(l.path)
+	if err != nil {
+		return err
+	}
+	l.file, l.out = f, f
+	if l.wrap != nil {
+		if l.wrapped, err = l.wrap(f); err != nil {
+			f.Abort()
+			l.file = nil
+			return err
+		}
+		l.out = l.wrapped
+	}
+	if l.format == "json" {
+		_, err = io.WriteString(l.out, "[")
+		return err
+	}
+	l.csv = csv.NewWriter(l.out)
+	return l.csv.Write([]string{"file_path", "class", "error"})
+}
+
+func (l *ErrorLog) writeJSON(entry errorEntry) error {
+	if l.count > 0 {
+		if _, err := io.WriteString(l.out, ","); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = l.out.Write(append([]byte("\n  "), data...))
+	return err
+}
+
+// Count returns the number of entries recorded.
+func (l *ErrorLog) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// ClassCounts returns the number of entries recorded in each class.
+func (l *ErrorLog) ClassCounts() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return maps.Clone(l.classes)
+}
+
+// Close finalizes the manifest. With no entries it removes any manifest
+// from an earlier scan so it can't be mistaken for this one.
+func (l *ErrorLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return l.err
+	}
+	if l.err == nil {
+		if l.format == "json" {
+			_, l.err = io.WriteString(l.out, "\n]\n")
+		} else {
+			l.csv.Flush()
+			l.err = l.csv.Error()
+		}
+	}
+	if l.wrapped != nil {
+		if err := l.wrapped.Close(); l.err == nil {
+			l.err = err
+		}
+	}
+	if l.err != nil {
+		l.file.Abort()
+		return l.err
+	}
+	return l.file.Commit()
+}
@@ -0,0 +1,110 @@
+package scan
+
+import (
+	"context"
+	"io/fs"
+	"os"
+)
+
+// Estimate counts the entries a Run would record, so progress can be
+// shown against a total. It lists directories and applies the filters as
+// Run does, but builds no records and tells no observers, which makes it
+// a fraction of the cost of a scan. Archive members aren't counted and
+// unreadable directories are skipped, so the count is only an estimate.
+// It must not be called while the scanner is running.
+func (s *Scanner) Estimate(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.estimate(ctx, func(string) { n++ })
+	return n, err
+}
+
+// EstimateTop is Estimate broken down by the entries directly below the
+// root: a directory's count covers everything below it, and files in the
+// root itself count under ".". With several roots, directories are keyed
+// by their path and files in a root by the root. Filtered-out directories
+// are left out.
+func (s *Scanner) EstimateTop(ctx context.Context) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	err := s.estimate(ctx, func(top string) { counts[top]++ })
+	return counts, err
+}
+
+// estimate walks the roots as Run would, calling count with what each
+// would-be record falls under: the name of its root's child, or with
+// several roots, that child's path.
+func (s *Scanner) estimate(ctx context.Context, count func(top string)) error {
+	roots := []pathMapper{newPathMapper(s.root)}
+	if s.source != nil {
+		roots[0] = sourcePathMapper(s.root, s.sourceDir)
+	}
+	for _, root := range s.moreRoots {
+		roots = append(roots, newPathMapper(root))
+	}
+	for _, paths := range roots {
+		if err := s.estimateRoot(ctx, paths, len(roots) > 1, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) estimateRoot(ctx context.Context, paths pathMapper, qualify bool, count func(top string)) error {
+	var walk func(dir, top string) error
+	walk = func(dir, top string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var entries []fs.DirEntry
+		err := s.retry(ctx, func() (err error) {
+			if s.source != nil {
+				entries, err = s.source.ReadDir(dir)
+			} else {
+				entries, err = os.ReadDir(dir)
+			}
+			return err
+		})
+		// Only an unreadable root fails the estimate, as it fails a scan
+		if err != nil && dir == paths.walkRoot && len(entries) == 0 {
+			return err
+		}
+		for _, e := range entries {
+			osPath := s.join(dir, e.Name())
+			path := paths.display(osPath)
+			if !s.acceptQuietly(path, e) || e.IsDir() && !s.snapshotDirs && isSnapshotDir(path) {
+				continue
+			}
+			switch {
+			case !e.IsDir():
+				count(top)
+			case dir == paths.walkRoot && qualify:
+				if err := walk(osPath, path); err != nil {
+					return err
+				}
+			case dir == paths.walkRoot:
+				if err := walk(osPath, e.Name()); err != nil {
+					return err
+				}
+			default:
+				if err := walk(osPath, top); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	top := "."
+	if qualify {
+		top = paths.root
+	}
+	return walk(paths.walkRoot, top)
+}
+
+// acceptQuietly applies the filters without tracing the decision.
+func (s *Scanner) acceptQuietly(path string, d fs.DirEntry) bool {
+	for _, f := range s.filters {
+		if !f(path, d) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package scan
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ExcludePaths returns a Filter rejecting the given files, such as the
+// scan's own output. Only entries whose base name matches one of them pay
+// for resolving an absolute path.
+func ExcludePaths(paths ...string) Filter {
+	byName := make(map[string][]string)
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(abs)
+		byName[name] = append(byName[name], abs)
+	}
+	return func(path string, d fs.DirEntry) bool {
+		candidates, ok := byName[d.Name()]
+		if !ok {
+			return true
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return true
+		}
+		for _, c := range candidates {
+			if abs == c {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// ExcludeNames returns a filter skipping entries whose base name matches
+// any of the glob patterns (as in filepath.Match), along with everything
+// below matching directories.
+func ExcludeNames(patterns ...string) (Filter, error) {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad exclude pattern %q: %w", p, err)
+		}
+	}
+	return func(path string, d fs.DirEntry) bool {
+		for _, p := range patterns {
+			if ok, _ := filepath.Match(p, d.Name()); ok {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// MatchNames returns a filter keeping only files whose base name matches
+// one of the glob patterns. Directories always pass, so matching files
+// are found at any depth.
+func MatchNames(patterns ...string) (Filter, error) {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad name pattern %q: %w", p, err)
+		}
+	}
+	return func(path string, d fs.DirEntry) bool {
+		if d.IsDir() {
+			return true
+		}
+		for _, p := range patterns {
+			if ok, _ := filepath.Match(p, d.Name()); ok {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// SnapshotDirs name the read-only snapshot directories storage systems
+// expose inside shares: NetApp's .snapshot (~snapshot over SMB) and ZFS's
+// .zfs/snapshot. Each holds a full copy of the tree per snapshot.
+var SnapshotDirs = []string{".snapshot", "~snapshot", ".zfs/snapshot"}
+
+// isSnapshotDir reports whether the directory at path is one of the
+// SnapshotDirs.
+func isSnapshotDir(path string) bool {
+	parts := strings.FieldsFunc(path, isPathSeparator)
+	for _, dir := range SnapshotDirs {
+		want := strings.Split(dir, "/")
+		if len(parts) >= len(want) && slices.Equal(parts[len(parts)-len(want):], want) {
+			return true
+		}
+	}
+	return false
+}
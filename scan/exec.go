@@ -0,0 +1,183 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Columns added by an ExecHook.
+const (
+	ExecStatusColumn = "exec_status"
+	ExecOutputColumn = "exec_output"
+)
+
+// maxExecOutput caps the output kept per file, so a chatty command can't
+// bloat the output.
+const maxExecOutput = 4096
+
+// ExecHook is a ColumnTransform running a command on every file, such as
+// a virus scanner or a validator, and recording its exit status (and
+// optionally its output). Commands run without a shell; the path the file
+// was walked at replaces {} in the arguments, or is appended when there is
+// none. Relative paths start with ./, so a file named -rf can't pass for an
+// option. Record.Path, which transforms and plugins may rewrite, is never
+// used. Records without a local path, such as archive members, get no
+// values.
+type ExecHook struct {
+	args    []string
+	output  bool
+	timeout time.Duration
+	slots   chan struct{}
+}
+
+// NewExecHook parses command, a command line split as a shell would but
+// without expansions, and returns a hook running at most workers copies
+// at a time. A timeout above zero kills commands that run longer;
+// output records their combined stdout and stderr.
+func NewExecHook(command string, workers int, timeout time.Duration, output bool) (*ExecHook, error) {
+	args, err := SplitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("exec: empty command")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, err
+	}
+	placeholder := false
+	for _, a := range args[1:] {
+		placeholder = placeholder || strings.Contains(a, "{}")
+	}
+	if !placeholder {
+		args = append(args, "{}")
+	}
+	return &ExecHook{args: args, output: output, timeout: timeout, slots: make(chan struct{}, max(workers, 1))}, nil
+}
+
+func (h *ExecHook) Columns() []Column {
+	status := ExtraColumn(ExecStatusColumn)
+	status.Type = TypeInt
+	cols := []Column{status}
+	if h.output {
+		cols = append(cols, ExtraColumn(ExecOutputColumn))
+	}
+	return cols
+}
+
+func (h *ExecHook) Apply(r *Record) (bool, error) {
+	if !r.IsFile() || r.inArchive || r.osPath == "" {
+		return true, nil
+	}
+	if _, err := os.Lstat(r.osPath); err != nil {
+		return true, nil
+	}
+	path := r.osPath
+	if !filepath.IsAbs(path) && !strings.HasPrefix(path, "."+string(filepath.Separator)) {
+		path = "." + string(filepath.Separator) + path
+	}
+	args := make([]string, len(h.args))
+	for i, a := range h.args {
+		args[i] = strings.ReplaceAll(a, "{}", path)
+	}
+
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+	ctx := context.Background()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	// Children of a killed command may hold its output open
+	cmd.WaitDelay = time.Second
+	var out bytes.Buffer
+	if h.output {
+		cmd.Stdout, cmd.Stderr = &out, &out
+	}
+	err := cmd.Run()
+	status := 0
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		// Killed, so the exit status means nothing
+		status = -1
+		out.WriteString("\n(timed out after " + h.timeout.String() + ")")
+	case errors.As(err, &exitErr):
+		status = exitErr.ExitCode()
+	case err != nil:
+		return false, fmt.Errorf("exec %s: %w", args[0], err)
+	}
+	r.SetExtra(ExecStatusColumn, status)
+	if h.output {
+		r.SetExtra(ExecOutputColumn, execOutput(out.Bytes()))
+	}
+	return true, nil
+}
+
+// execOutput trims output to maxExecOutput bytes of valid UTF-8.
+func execOutput(b []byte) string {
+	b = bytes.TrimSpace(b)
+	if len(b) > maxExecOutput {
+		b = b[:maxExecOutput]
+		for len(b) > 0 && !utf8.Valid(b) {
+			b = b[:len(b)-1]
+		}
+	}
+	return strings.ToValidUTF8(string(b), "�")
+}
+
+// SplitCommand splits a command line into words much as a POSIX shell
+// would, honouring single and double quotes and backslash escapes, but
+// without variable, glob or any other expansion.
+func SplitCommand(s string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, c := range s {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
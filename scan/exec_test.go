@@ -0,0 +1,38 @@
+package scan
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestExecPassesWalkedPaths(t *testing.T) {
+	if _, err := exec.LookPath("echo"); err != nil {
+		t.Skip("no echo command")
+	}
+	t.Chdir(t.TempDir())
+	writeFiles(t, ".", "-n", "sub/x.txt")
+	hook, err := NewExecHook("echo {}", 1, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As a plugin reply replacing file_path would
+	rewrite := TransformFunc(func(r *Record) (bool, error) {
+		r.Path = "/etc/passwd"
+		return true, nil
+	})
+	sink := &recordSink{}
+	if _, err := New(".", WithSink(sink), WithDeterministic(true), WithTransform(rewrite, hook)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"./-n", "./" + filepath.Join("sub", "x.txt")}
+	if len(sink.records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(sink.records), len(want))
+	}
+	for i, r := range sink.records {
+		if got := r.Extra[ExecOutputColumn]; got != filepath.FromSlash(want[i]) {
+			t.Errorf("exec_output = %q, want %q", got, filepath.FromSlash(want[i]))
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package scan
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PathMatch reports whether a path, relative to the root it was found
+// under and with forward slashes, matches.
+type PathMatch func(rel string) bool
+
+// MatchPaths returns a PathMatch for glob patterns over relative paths.
+// Segments match as in path.Match, and a "**" segment matches any number
+// of directories, so "**/*.db" matches .db files at any depth and
+// "db/**" everything below db. A pattern without a slash matches the base
+// name alone, at any depth.
+func MatchPaths(patterns ...string) (PathMatch, error) {
+	split := make([][]string, len(patterns))
+	for i, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad path pattern %q: %w", p, err)
+		}
+		if !strings.Contains(p, "/") {
+			p = "**/" + p
+		}
+		split[i] = strings.Split(strings.Trim(p, "/"), "/")
+	}
+	return func(rel string) bool {
+		segments := strings.Split(filepath.ToSlash(rel), "/")
+		for _, p := range split {
+			if matchSegments(p, segments) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// matchSegments matches path segments against pattern segments.
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try every number of directories for the rest to match
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// walkPass is one walk of the roots. Scans with priority patterns walk
+// twice: first for the files matching them, then for the rest.
+type walkPass int
+
+const (
+	passAll walkPass = iota
+	passFirst
+	passRest
+)
+
+// takes reports whether a file belongs to the pass.
+func (s *Scanner) takes(pass walkPass, path string) bool {
+	switch pass {
+	case passFirst:
+		return s.first(s.paths.rel(path))
+	case passRest:
+		return !s.first(s.paths.rel(path))
+	}
+	return true
+}
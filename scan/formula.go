@@ -0,0 +1,44 @@
+package scan
+
+import "strings"
+
+// safeCSVMarker is the preamble line of CSV outputs written in safe mode,
+// whose values OpenOutput unescapes.
+const safeCSVMarker = "# safe_csv: true"
+
+// formulaTriggers start a cell that spreadsheets evaluate as a formula.
+const formulaTriggers = "=+-@\t\r"
+
+// needsFormulaEscape reports whether a spreadsheet would evaluate v, or v
+// starts with the quote that escapes such a value.
+func needsFormulaEscape(v string) bool {
+	switch {
+	case v == "":
+		return false
+	case strings.IndexByte(formulaTriggers, v[0]) >= 0:
+		return true
+	case v[0] == '\'':
+		return needsFormulaEscape(v[1:])
+	}
+	return false
+}
+
+// EscapeFormula prefixes v with a single quote when a spreadsheet would
+// otherwise evaluate it as a formula, as it would "=HYPERLINK(...)", so
+// that attacker-chosen file names stay text. Values already starting
+// with a quote before such a character get another, so UnescapeFormula
+// restores every value exactly.
+func EscapeFormula(v string) string {
+	if needsFormulaEscape(v) {
+		return "'" + v
+	}
+	return v
+}
+
+// UnescapeFormula undoes EscapeFormula.
+func UnescapeFormula(v string) string {
+	if strings.HasPrefix(v, "'") && needsFormulaEscape(v[1:]) {
+		return v[1:]
+	}
+	return v
+}
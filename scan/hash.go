@@ -0,0 +1,79 @@
+package scan
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// HashAlgorithm selects the content hash recorded for each file.
+type HashAlgorithm string
+
+const (
+	NoHash HashAlgorithm = ""
+	MD5    HashAlgorithm = "md5"
+	SHA1   HashAlgorithm = "sha1"
+	SHA256 HashAlgorithm = "sha256"
+)
+
+// ParseHashAlgorithm converts a name such as "sha256" to a HashAlgorithm.
+// "none" and the empty string both disable hashing.
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	switch algo := HashAlgorithm(strings.ToLower(name)); algo {
+	case MD5, SHA1, SHA256:
+		return algo, nil
+	case NoHash, "none":
+		return NoHash, nil
+	default:
+		return NoHash, fmt.Errorf("unsupported hash algorithm %q", name)
+	}
+}
+
+// New returns a fresh hash.Hash for the algorithm. NoHash yields SHA-256.
+func (a HashAlgorithm) New() hash.Hash {
+	switch a {
+	case MD5:
+		return md5.New()
+	case SHA1:
+		return sha1.New()
+	default:
+		return sha256.New()
+	}
+}
+
+// HashFile returns the hex-encoded digest of the file at path and the
+// number of bytes read.
+func HashFile(path string, algo HashAlgorithm) (string, int64, error) {
+	return hashFile(path, algo, -1)
+}
+
+// HashFilePrefix is HashFile over at most the first limit bytes.
+func HashFilePrefix(path string, algo HashAlgorithm, limit int64) (string, int64, error) {
+	return hashFile(path, algo, limit)
+}
+
+// hashFile hashes the whole file when limit is negative.
+func hashFile(path string, algo HashAlgorithm, limit int64) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if limit >= 0 {
+		r = io.LimitReader(f, limit)
+	}
+	h := algo.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,97 @@
+package scan
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// JSONSink writes records as newline-delimited JSON objects with keys in
+// column order. Values keep their types; times are RFC 3339 strings and
+// modes use their ls-style form. Invalid UTF-8 in paths is replaced with
+// U+FFFD, so choose an escape mode if those must round-trip.
+type JSONSink struct {
+	out     io.Writer
+	w       *bufio.Writer
+	columns []Column
+	keys    [][]byte
+}
+
+// NewJSONSink returns a Sink writing JSON lines to w.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{out: w, w: bufio.NewWriter(w)}
+}
+
+// Location returns the path of the file the sink writes, if any.
+func (j *JSONSink) Location() string { return writerLocation(j.out) }
+
+func (j *JSONSink) WriteHeader(columns []Column) error {
+	j.columns = columns
+	j.keys = make([][]byte, len(columns))
+	for i, col := range columns {
+		key, err := json.Marshal(col.Name)
+		if err != nil {
+			return err
+		}
+		j.keys[i] = key
+	}
+	return nil
+}
+
+func (j *JSONSink) WriteBatch(records []Record) error {
+	for i := range records {
+		j.w.WriteByte('{')
+		for k, col := range j.columns {
+			if k > 0 {
+				j.w.WriteByte(',')
+			}
+			j.w.Write(j.keys[k])
+			j.w.WriteByte(':')
+			v, err := json.Marshal(JSONValue(col.Value(&records[i])))
+			if err != nil {
+				return err
+			}
+			j.w.Write(v)
+		}
+		if _, err := j.w.WriteString("}\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *JSONSink) Flush() error { return j.w.Flush() }
+
+// Sync flushes buffered records and fsyncs the underlying writer when it
+// supports it.
+func (j *JSONSink) Sync() error {
+	if err := j.Flush(); err != nil {
+		return err
+	}
+	if syncer, ok := j.out.(Syncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// JSONValue converts a column value to the form JSON sinks encode.
+func JSONValue(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+		return v.UTC().Format(time.RFC3339)
+	case fs.FileMode:
+		return v.String()
+	case []string:
+		if v == nil {
+			return []string{}
+		}
+		return v
+	default:
+		return v
+	}
+}
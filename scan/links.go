@@ -0,0 +1,45 @@
+package scan
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Symlink states recorded in Record.LinkStatus.
+const (
+	LinkOK      = "ok"
+	LinkBroken  = "broken"  // The target doesn't exist
+	LinkOutside = "outside" // The target exists but resolves outside the root
+)
+
+// readLink fills in the target and status of a symlink record. realRoot is
+// the scan root with its own symlinks resolved.
+func readLink(rec *Record, osPath, realRoot string) error {
+	target, err := os.Readlink(osPath)
+	if err != nil {
+		return err
+	}
+	rec.LinkTarget = target
+
+	resolved, err := filepath.EvalSymlinks(osPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		rec.LinkStatus = LinkBroken
+		return nil
+	case err != nil:
+		return err
+	}
+	if resolved, err = filepath.Abs(resolved); err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(realRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rec.LinkStatus = LinkOutside
+	} else {
+		rec.LinkStatus = LinkOK
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// LockSuffix is appended to an output path to name the lock file held
+// while a scan writes that output.
+const LockSuffix = ".rfp.lock"
+
+// lockPoll is how often a waiting LockOutput retries.
+const lockPoll = 250 * time.Millisecond
+
+// ErrLocked reports an output another process is writing.
+var ErrLocked = errors.New("output is locked")
+
+// lockOwner is what a lock file says about its holder.
+type lockOwner struct {
+	PID   int       `json:"pid"`
+	Host  string    `json:"host"`
+	Since time.Time `json:"since"`
+}
+
+// OutputLock keeps other processes from writing the same output. Two
+// scans writing one output would otherwise share its temp file.
+type OutputLock struct {
+	path string
+}
+
+// LockOutput takes the lock on output, waiting up to wait for another
+// holder to release it. A lock left behind by a process on this host that
+// has since exited is taken over; one from another host has to be removed
+// by hand if its scan is gone.
+func LockOutput(output string, wait time.Duration) (*OutputLock, error) {
+	path := output + LockSuffix
+	host, _ := os.Hostname()
+	me, err := json.Marshal(lockOwner{PID: os.Getpid(), Host: host, Since: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = f.Write(me)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return &OutputLock{path: path}, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		// A lock file still being written has no owner yet, and counts as
+		// held
+		var owner lockOwner
+		if b, err := os.ReadFile(path); err == nil && json.Unmarshal(b, &owner) == nil {
+			if owner.Host == host && owner.PID > 0 && !processAlive(owner.PID) {
+				os.Remove(path)
+				continue
+			}
+		}
+		if time.Now().After(deadline) {
+			if owner.PID == 0 {
+				return nil, fmt.Errorf("%w: %s exists", ErrLocked, path)
+			}
+			return nil, fmt.Errorf("%w by process %d on %s since %s; remove %s if that scan is no longer running",
+				ErrLocked, owner.PID, owner.Host, owner.Since.Local().Format(time.DateTime), path)
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// Path returns the lock file's path.
+func (l *OutputLock) Path() string {
+	return l.path
+}
+
+// Unlock releases the lock.
+func (l *OutputLock) Unlock() error {
+	return os.Remove(l.path)
+}
@@ -0,0 +1,43 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockOutputExcludesSecondScan(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "file_paths.csv")
+	lock, err := LockOutput(output, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockOutput(output, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second lock: got %v, want ErrLocked", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lock.Path()); !os.IsNotExist(err) {
+		t.Fatalf("lock file still there after Unlock: %v", err)
+	}
+	again, err := LockOutput(output, 0)
+	if err != nil {
+		t.Fatalf("lock after Unlock: %v", err)
+	}
+	again.Unlock()
+}
+
+func TestLockOutputKeepsOtherHostsLock(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "file_paths.csv")
+	b, _ := json.Marshal(lockOwner{PID: 1, Host: "elsewhere.invalid", Since: time.Now()})
+	if err := os.WriteFile(output+LockSuffix, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockOutput(output, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v, want ErrLocked for another host's lock", err)
+	}
+}
@@ -0,0 +1,71 @@
+package scan
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// MaxPath is the legacy Windows path limit (MAX_PATH), including the
+// terminating NUL. Paths at or above it need the extended-length form.
+const MaxPath = 260
+
+// pathMapper translates between the paths the walker opens and the paths
+// recorded in the output. On Windows the walk runs under an extended-length
+// (\\?\) root so deep trees stay accessible, while records keep the root as
+// the user typed it.
+type pathMapper struct {
+	root     string // As given by the caller
+	walkRoot string // As passed to the OS
+	absRoot  string
+	slash    bool // Walking a Source, whose paths aren't local
+}
+
+func newPathMapper(root string) pathMapper {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		abs = root
+	}
+	return pathMapper{root: root, walkRoot: longPathRoot(root), absRoot: abs}
+}
+
+// display converts a walked path back to its recorded form.
+func (m pathMapper) display(osPath string) string {
+	if m.slash {
+		return m.displaySlash(osPath)
+	}
+	if m.walkRoot == m.root {
+		return osPath
+	}
+	if osPath == m.walkRoot {
+		return m.root
+	}
+	return filepath.Join(m.root, strings.TrimPrefix(osPath, m.walkRoot))
+}
+
+// absLen returns the length of the absolute form of a recorded path, which
+// is what the MAX_PATH limit applies to.
+func (m pathMapper) absLen(path string) int {
+	if m.slash {
+		return len(path)
+	}
+	if root := filepath.Clean(m.root); root != "." {
+		return len(m.absRoot) + len(path) - len(root)
+	}
+	// WalkDir drops the "./" prefix for paths below "."
+	if path == "." {
+		return len(m.absRoot)
+	}
+	return len(m.absRoot) + 1 + len(path)
+}
+
+// rel returns a recorded path relative to the root.
+func (m pathMapper) rel(path string) string {
+	if m.slash {
+		return strings.TrimPrefix(path, strings.TrimSuffix(m.root, "/")+"/")
+	}
+	rel, err := filepath.Rel(m.root, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
@@ -0,0 +1,40 @@
+package scan
+
+import "strconv"
+
+// AppleMetadataNames match the Finder's .DS_Store files and the ._*
+// AppleDouble files macOS writes beside files on filesystems without
+// extended attributes.
+var AppleMetadataNames = []string{".DS_Store", "._*"}
+
+// Finder flags from the FinderInfo attribute, by name.
+var finderFlagNames = []struct {
+	bit  uint16
+	name string
+}{
+	{0x8000, "alias"},
+	{0x4000, "invisible"},
+	{0x2000, "bundle"},
+	{0x1000, "name_locked"},
+	{0x0800, "stationery"},
+	{0x0400, "custom_icon"},
+}
+
+// finderFlags names the flags set in a FinderInfo attribute, with the
+// color label as "label:N".
+func finderFlags(info []byte) []string {
+	if len(info) < 10 {
+		return nil
+	}
+	bits := uint16(info[8])<<8 | uint16(info[9])
+	var flags []string
+	for _, f := range finderFlagNames {
+		if bits&f.bit != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	if label := bits & 0x000E >> 1; label != 0 {
+		flags = append(flags, "label:"+strconv.Itoa(int(label)))
+	}
+	return flags
+}
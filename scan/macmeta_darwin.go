@@ -0,0 +1,72 @@
+//go:build darwin
+
+package scan
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+const xattrNoFollow = 0x1
+
+// macMetadata reads the Finder flags, the quarantine attribute and the
+// resource fork size of a file, without following symlinks.
+func macMetadata(path string) (flags []string, quarantine string, rsrc int64, err error) {
+	info, err := getxattr(path, "com.apple.FinderInfo")
+	if err != nil {
+		return nil, "", -1, err
+	}
+	q, err := getxattr(path, "com.apple.quarantine")
+	if err != nil {
+		return nil, "", -1, err
+	}
+	n, err := xattrSize(path, "com.apple.ResourceFork")
+	if err != nil {
+		return nil, "", -1, err
+	}
+	return finderFlags(info), string(q), n, nil
+}
+
+// getxattr returns an extended attribute, or nil when the file doesn't
+// have it.
+func getxattr(path, name string) ([]byte, error) {
+	n, err := xattr(path, name, nil)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	n, err = xattr(path, name, buf)
+	return buf[:n], err
+}
+
+// xattrSize returns the size of an extended attribute, 0 when missing.
+func xattrSize(path, name string) (int64, error) {
+	n, err := xattr(path, name, nil)
+	return int64(n), err
+}
+
+func xattr(path, name string, buf []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	a, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	var b unsafe.Pointer
+	if len(buf) > 0 {
+		b = unsafe.Pointer(&buf[0])
+	}
+	n, _, errno := syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)),
+		uintptr(b), uintptr(len(buf)), 0, xattrNoFollow)
+	switch {
+	case errno == 0:
+		return int(n), nil
+	case errors.Is(errno, syscall.ENOATTR), errors.Is(errno, syscall.ENOTSUP):
+		// A missing attribute, or a filesystem without attributes
+		return 0, nil
+	}
+	return 0, errno
+}
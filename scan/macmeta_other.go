@@ -0,0 +1,8 @@
+//go:build !darwin
+
+package scan
+
+// macMetadata returns nothing: Finder metadata only exists on macOS.
+func macMetadata(string) (flags []string, quarantine string, rsrc int64, err error) {
+	return nil, "", -1, nil
+}
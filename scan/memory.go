@@ -0,0 +1,39 @@
+package scan
+
+// Rough sizes of what the buffers hold, used to fit them in a memory
+// limit. Queued entries may carry archive members, so they are sized
+// generously.
+const (
+	queuedEntryBytes = 4 << 10
+	parquetRowBytes  = 1 << 10
+
+	// Minimum Parquet row group, below which the file gets needlessly
+	// large and slow to read
+	minParquetRowGroupRows = 1000
+)
+
+// bufferSize returns how many entries the queues between the walker, the
+// workers and the sink hold: pathBuffer, unless a sixteenth of the memory
+// limit fits fewer. Each worker keeps at least two queued.
+func (s *Scanner) bufferSize() int {
+	if s.memLimit == 0 {
+		return pathBuffer
+	}
+	return min(pathBuffer, max(2*s.workers, int(s.memLimit/16/queuedEntryBytes)))
+}
+
+// fitSinks shrinks the row groups of Parquet sinks to fit a quarter of the
+// memory limit.
+func (s *Scanner) fitSinks(sink Sink) {
+	if s.memLimit == 0 {
+		return
+	}
+	switch sink := sink.(type) {
+	case multiSink:
+		for _, sub := range sink {
+			s.fitSinks(sub)
+		}
+	case *ParquetSink:
+		sink.rowGroupRows = min(ParquetRowGroupRows, max(minParquetRowGroupRows, int(s.memLimit/4/parquetRowBytes)))
+	}
+}
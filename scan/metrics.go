@@ -0,0 +1,161 @@
+package scan
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics collects scan counters and exposes them in the Prometheus text
+// exposition format. A single Metrics may be shared by several scans; the
+// counters accumulate across them.
+type Metrics struct {
+	entries atomic.Int64 // Directory entries visited by the walker
+	files   atomic.Int64 // Records written to the sink
+	errors  atomic.Int64 // Scans that ended in an error
+	skipped atomic.Int64 // Entries skipped because of errors or cycles
+	bytes   atomic.Int64 // Bytes read while hashing
+	retries atomic.Int64 // Filesystem calls retried after a transient error
+	running atomic.Int64
+	started atomic.Int64 // Unix time of the most recent scan start
+
+	batchLatency *histogram
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		batchLatency: newHistogram([]float64{.0005, .001, .005, .01, .05, .1, .5, 1, 5}),
+	}
+}
+
+// Handler serves the metrics for scraping.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+}
+
+// WriteTo writes every metric in text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	writeMetric(cw, "rfp_entries_walked_total", "counter", "Directory entries visited by the walker.", m.entries.Load())
+	writeMetric(cw, "rfp_files_scanned_total", "counter", "Files recorded to the output.", m.files.Load())
+	writeMetric(cw, "rfp_errors_total", "counter", "Scans that ended in an error.", m.errors.Load())
+	writeMetric(cw, "rfp_skipped_total", "counter", "Entries skipped because of errors or directory cycles.", m.skipped.Load())
+	writeMetric(cw, "rfp_retries_total", "counter", "Filesystem calls retried after a transient error.", m.retries.Load())
+	writeMetric(cw, "rfp_hashed_bytes_total", "counter", "Bytes read while hashing file contents.", m.bytes.Load())
+	writeMetric(cw, "rfp_scan_running", "gauge", "Number of scans currently running.", m.running.Load())
+	writeMetric(cw, "rfp_scan_start_time_seconds", "gauge", "Unix time the most recent scan started.", m.started.Load())
+	m.batchLatency.writeTo(cw, "rfp_batch_write_seconds", "Time spent writing one batch to the sink.")
+	return cw.n, cw.err
+}
+
+func writeMetric(w io.Writer, name, kind, help string, v int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, v)
+}
+
+func (m *Metrics) scanStarted() {
+	if m == nil {
+		return
+	}
+	m.running.Add(1)
+	m.started.Store(time.Now().Unix())
+}
+
+func (m *Metrics) scanFinished(err error) {
+	if m == nil {
+		return
+	}
+	m.running.Add(-1)
+	if err != nil {
+		m.errors.Add(1)
+	}
+}
+
+func (m *Metrics) entryWalked() {
+	if m != nil {
+		m.entries.Add(1)
+	}
+}
+
+func (m *Metrics) entrySkipped() {
+	if m != nil {
+		m.skipped.Add(1)
+	}
+}
+
+func (m *Metrics) retried() {
+	if m != nil {
+		m.retries.Add(1)
+	}
+}
+
+func (m *Metrics) batchWritten(n int, d time.Duration) {
+	if m != nil {
+		m.files.Add(int64(n))
+		m.batchLatency.observe(d.Seconds())
+	}
+}
+
+func (m *Metrics) hashed(n int64) {
+	if m != nil {
+		m.bytes.Add(n)
+	}
+}
+
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) writeTo(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,47 @@
+package scan
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+)
+
+// mount is an entry of the system's mount table.
+type mount struct {
+	dir    string // Mount point
+	fsType string
+}
+
+// skippedMounts returns the mount points, with their type, whose
+// filesystem type matches one of patterns, as in path.Match.
+func skippedMounts(patterns []string) (map[string]string, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad filesystem type pattern %q: %w", p, err)
+		}
+	}
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, fmt.Errorf("reading mount table: %w", err)
+	}
+	skipped := make(map[string]string)
+	for _, m := range mounts {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, m.fsType); ok {
+				skipped[filepath.Clean(m.dir)] = m.fsType
+				break
+			}
+		}
+	}
+	return skipped, nil
+}
+
+// skipMount reports whether the directory at path, below root, is the
+// mount point of a filesystem type skipped WithSkipFSTypes.
+func (s *Scanner) skipMount(root *scanRoot, path string) bool {
+	if len(s.mounts) == 0 {
+		return false
+	}
+	_, ok := s.mounts[filepath.Join(root.realRoot, root.paths.rel(path))]
+	return ok
+}
@@ -0,0 +1,56 @@
+package scan
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readMounts reads the mount table of the process's mount namespace.
+func readMounts() ([]mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mounts []mount
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// ID, parent ID, device, root, mount point, options, optional
+		// fields, then "-", type, source and super options
+		fields := strings.Fields(sc.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 5 || sep < 0 || sep+1 >= len(fields) {
+			continue
+		}
+		mounts = append(mounts, mount{dir: unescapeMount(fields[4]), fsType: fields[sep+1]})
+	}
+	return mounts, sc.Err()
+}
+
+// unescapeMount undoes the octal escapes (\040 for a space) of the
+// kernel's mount tables.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package scan
+
+import "errors"
+
+// readMounts fails: mount types are only read on Linux and macOS.
+func readMounts() ([]mount, error) {
+	return nil, errors.New("filesystem types are only known on Linux and macOS")
+}
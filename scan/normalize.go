@@ -0,0 +1,73 @@
+package scan
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CleanPaths returns a transform applying filepath.Clean to every path.
+func CleanPaths() Transform {
+	return TransformFunc(func(r *Record) (bool, error) {
+		r.Path = filepath.Clean(r.Path)
+		return true, nil
+	})
+}
+
+// RelativePaths returns a transform rewriting every path relative to the
+// root it was found under, e.g. docs/a.txt for /srv/share/docs/a.txt
+// scanned from /srv/share. Paths are left as they are when the root is
+// already relative, as in a scan of ".". Pair it with the root column
+// when scanning several roots.
+func RelativePaths() Transform {
+	return TransformFunc(func(r *Record) (bool, error) {
+		// Remote sources use slashes on every platform
+		for _, sep := range []string{string(filepath.Separator), "/"} {
+			if rel, ok := strings.CutPrefix(r.Path, strings.TrimSuffix(r.Root, sep)+sep); ok && r.Root != "" {
+				r.Path = rel
+				break
+			}
+		}
+		return true, nil
+	})
+}
+
+// RealPaths returns a transform rewriting every path to its canonical
+// absolute form, with symlinks in the directory components resolved. A
+// symlink entry keeps its own name so it isn't conflated with its target.
+// Archive members keep their path inside the archive, which is resolved
+// itself. Resolved directories are cached, so each is only evaluated once.
+func RealPaths() Transform {
+	return &realPaths{}
+}
+
+// realPaths is the transform RealPaths returns. It resolves symlinks,
+// which takes a stat call per directory.
+type realPaths struct {
+	cache sync.Map // Directory as walked -> resolved directory
+}
+
+func (t *realPaths) Apply(r *Record) (bool, error) {
+	p, member, inArchive := splitMember(r.Path)
+	dir, name := filepath.Split(p)
+	if dir == "" {
+		dir = "."
+	}
+	resolved, ok := t.cache.Load(dir)
+	if !ok {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return false, err
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return false, err
+		}
+		resolved, _ = t.cache.LoadOrStore(dir, real)
+	}
+	r.Path = filepath.Join(resolved.(string), name)
+	if inArchive {
+		r.Path += ArchiveSeparator + member
+	}
+	return true, nil
+}
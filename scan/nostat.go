@@ -0,0 +1,70 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoStat is returned by DirRecord.Info in a scan configured WithNoStat.
+var ErrNoStat = errors.New("no stat calls in a no-stat scan")
+
+// checkStatFree returns an error naming the first of cols, of the columns
+// observers require, or of the options that would make the scan call
+// more than ReadDir per entry.
+func (s *Scanner) checkStatFree(cols []Column) error {
+	for _, col := range cols {
+		if needsMoreThanListing(col) {
+			return fmt.Errorf("no-stat scan can't write column %q, which needs a stat call or the file's content", col.Name)
+		}
+	}
+	for _, o := range s.observers {
+		if r, ok := o.(Requirer); ok {
+			for _, name := range r.Requires() {
+				if col, ok := LookupColumn(name); ok && needsMoreThanListing(col) {
+					return fmt.Errorf("no-stat scan can't collect %q, which a report needs", name)
+				}
+			}
+		}
+	}
+	var reason string
+	switch {
+	case s.archives:
+		reason = "list archive members"
+	case s.cache != nil:
+		reason = "use a hash cache"
+	case s.cacheDirs:
+		reason = "replay directory listings, which are checked with a stat call"
+	case len(s.providers) > 0:
+		reason = "compute provider columns, which are handed the file's info"
+	case s.noHydrate:
+		reason = "tell cloud placeholders apart, which takes their attributes"
+	case s.aliases:
+		reason = "tell aliased directories apart, which takes a stat call"
+	case len(s.skipFSTypes) > 0:
+		reason = "skip filesystem types, which takes resolving each root's mount point"
+	}
+	for _, t := range s.transforms {
+		switch t.(type) {
+		case *ExecHook:
+			reason = "run a command per file"
+		case *ActionHook:
+			reason = "act on files"
+		case *realPaths:
+			reason = "resolve real paths, which takes a stat call per directory"
+		case trashTagger:
+			reason = "tag trash directories"
+		}
+	}
+	if reason != "" {
+		return fmt.Errorf("no-stat scan can't %s", reason)
+	}
+	return nil
+}
+
+// needsMoreThanListing reports whether col's values take more than a
+// directory listing to collect.
+func needsMoreThanListing(col Column) bool {
+	var probe Scanner
+	probe.require(col)
+	return probe.needStat || probe.needHash || probe.needDetect || probe.needMac || probe.needLinks
+}
@@ -0,0 +1,35 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNoStatRefusals(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+		want string
+	}{
+		{"realpath", WithTransform(RealPaths()), "resolve real paths"},
+		{"trash tag", WithTransform(TagTrash()), "tag trash"},
+		{"skip fstype", WithSkipFSTypes("nfs"), "skip filesystem types"},
+		{"exec", WithTransform(&ExecHook{}), "run a command"},
+		{"archives", WithArchives(true), "list archive members"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(t.TempDir(), WithNoStat(true), tt.opt).Columns()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Columns() error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoStatAllowsPathTransforms(t *testing.T) {
+	_, err := New(t.TempDir(), WithNoStat(true), WithTransform(CleanPaths(), RelativePaths())).Columns()
+	if err != nil {
+		t.Errorf("Columns() error = %v", err)
+	}
+}
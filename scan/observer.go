@@ -0,0 +1,67 @@
+package scan
+
+import "io/fs"
+
+// Observer sees every record after its batch has been written, from the
+// single consumer goroutine, so implementations need no locking. Reports
+// are built as observers.
+type Observer interface {
+	Observe(r *Record)
+}
+
+// Requirer is implemented by observers that need fields only collected
+// for certain columns (such as "size"). Listing those column names turns
+// collection on even when the columns aren't written.
+type Requirer interface {
+	Requires() []string
+}
+
+// DirRecord describes a directory the walker has listed.
+type DirRecord struct {
+	Path    string // Display path, in the same form as Record.Path
+	Entries int    // Entries in the listing, before filters
+
+	d      fs.DirEntry
+	noStat bool
+}
+
+// Info returns the lstat of the directory. It costs a syscall, so the
+// walker only makes it on request, and fails with ErrNoStat in a scan
+// configured WithNoStat.
+func (d *DirRecord) Info() (fs.FileInfo, error) {
+	if d.noStat {
+		return nil, ErrNoStat
+	}
+	return d.d.Info()
+}
+
+// DirObserver is implemented by observers that also want to see
+// directories. ObserveDir runs on the walker goroutine, concurrently with
+// Observe, once per successfully listed directory; unreadable, filtered
+// and cyclic directories are never passed.
+type DirObserver interface {
+	ObserveDir(d *DirRecord)
+}
+
+// BatchFunc receives each batch of records once the sink has written it,
+// on the goroutine that writes the output, so the next batch waits for it
+// to return. The slice is reused afterwards and must not be kept.
+type BatchFunc func(records []Record) error
+
+// BatchPolicy decides what an error from a BatchFunc does to the scan.
+type BatchPolicy int
+
+const (
+	BatchAbort BatchPolicy = iota // The error fails the scan
+	BatchSkip                     // The batch is counted in Stats.FailedBatches and the scan goes on
+)
+
+// ObserverFunc adapts a plain function to Observer.
+type ObserverFunc func(r *Record)
+
+func (f ObserverFunc) Observe(r *Record) { f(r) }
+
+type batchHook struct {
+	fn     BatchFunc
+	policy BatchPolicy
+}
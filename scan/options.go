@@ -0,0 +1,376 @@
+package scan
+
+import (
+	"io/fs"
+	"time"
+)
+
+// Option configures a Scanner. Options are applied in order by New.
+type Option func(*Scanner)
+
+// Filter reports whether an entry should be recorded. Returning false for a
+// directory prunes it from the walk entirely.
+type Filter func(path string, d fs.DirEntry) bool
+
+// WithWorkers sets the number of goroutines building records from walked
+// paths. Values below 1 are ignored.
+func WithWorkers(n int) Option {
+	return func(s *Scanner) {
+		if n > 0 {
+			s.workers = n
+		}
+	}
+}
+
+// WithOrder sets the order the walker visits entries in, which is also
+// the order records are written in under WithDeterministic. The default
+// is DepthFirst.
+func WithOrder(order Order) Option {
+	return func(s *Scanner) {
+		s.order = order
+	}
+}
+
+// WithIncludeDirs records directories as rows of their own, once listed
+// and ahead of their entries, with ChildFiles and ChildDirs counting what
+// they hold. Unreadable directories are reported as skipped instead.
+// Observers still only see files in Observe; directories go to
+// DirObservers as before. Type is always among the columns, since the
+// rows can't be told apart without it.
+func WithIncludeDirs(on bool) Option {
+	return func(s *Scanner) {
+		s.includeDirs = on
+	}
+}
+
+// WithMaxPerDir records at most n files, counted after filters, from
+// each directory. The first file over the cap is replaced by a row of
+// type EntryTruncated with the directory's path, and the rest are only
+// counted, in Stats.Truncated. Subdirectories are still walked. It is
+// meant for exploring trees with pathological directories; every
+// directory is still listed in full. Zero or less records every file.
+func WithMaxPerDir(n int) Option {
+	return func(s *Scanner) {
+		s.maxPerDir = max(n, 0)
+	}
+}
+
+// WithSnapshotDirs walks the SnapshotDirs below the roots, which scans
+// skip by default: each multiplies the results by the number of
+// snapshots it holds. A root is walked even when it is one.
+func WithSnapshotDirs(on bool) Option {
+	return func(s *Scanner) {
+		s.snapshotDirs = on
+	}
+}
+
+// WithSkipFSTypes leaves out the filesystems whose type, as the mount
+// table gives it, matches one of patterns (path.Match globs such as
+// "fuse.*"): the walk doesn't descend into their mount points below the
+// roots, as with an excluded directory. A root is walked whatever its
+// type. The mount table is read once per Run, on Linux and macOS only;
+// elsewhere, or for remote sources, Run fails.
+func WithSkipFSTypes(patterns ...string) Option {
+	return func(s *Scanner) {
+		s.skipFSTypes = patterns
+	}
+}
+
+// WithAliases records each directory reached again, by device and inode,
+// through another path, such as a bind mount or an overlapping root, as
+// one row of type EntryAlias whose AliasOf names the path it was first
+// walked under. Without it the revisit is only reported to the WarnFunc
+// as ErrCycle. Either way it is walked once. Type and alias_of are always
+// among the columns.
+func WithAliases(on bool) Option {
+	return func(s *Scanner) {
+		s.aliases = on
+	}
+}
+
+// WithScanID sets the ID recorded in the scan_id column and the Result,
+// so rows from many runs loaded into one table can be traced to the run
+// that wrote them. Without it every Run gets a new ID from NewScanID;
+// setting it lets a rerun of an interrupted scan keep the ID of the
+// original.
+func WithScanID(id string) Option {
+	return func(s *Scanner) {
+		s.scanID = id
+	}
+}
+
+// WithFirst records the files matching first ahead of all others, so a
+// consumer of the output can start on them while the scan goes on. It
+// costs a second walk: the first lists the whole tree for the matching
+// files, the second records the rest. A nil PathMatch turns it off.
+func WithFirst(first PathMatch) Option {
+	return func(s *Scanner) {
+		s.first = first
+	}
+}
+
+// WithMaxOpenDirs sets how many directories may be open for listing at
+// once. Above 1, the walker lists the subdirectories it is about to enter
+// ahead, in parallel, which hides the latency of network filesystems;
+// records still come out in walk order. The default of 1 lists one
+// directory at a time. Values below 1 are ignored.
+func WithMaxOpenDirs(n int) Option {
+	return func(s *Scanner) {
+		if n > 0 {
+			s.openDirs = n
+		}
+	}
+}
+
+// WithMemoryLimit sizes the scan's buffers to fit in about limit bytes:
+// the queues between the walker, the workers and the sink, and the row
+// groups of a ParquetSink. It does not limit the heap; pair it with
+// debug.SetMemoryLimit for that. Values below 1 are ignored.
+func WithMemoryLimit(limit int64) Option {
+	return func(s *Scanner) {
+		if limit > 0 {
+			s.memLimit = limit
+		}
+	}
+}
+
+// WithBatchSize sets how many records are grouped before being handed to
+// the sink. Values below 1 are ignored.
+func WithBatchSize(n int) Option {
+	return func(s *Scanner) {
+		if n > 0 {
+			s.batchSize = n
+		}
+	}
+}
+
+// WithHash enables content hashing with the given algorithm.
+func WithHash(algo HashAlgorithm) Option {
+	return func(s *Scanner) {
+		s.hash = algo
+	}
+}
+
+// WithFilter adds a filter. Entries must pass every filter to be recorded.
+func WithFilter(f Filter) Option {
+	return func(s *Scanner) {
+		if f != nil {
+			s.filters = append(s.filters, f)
+		}
+	}
+}
+
+// WithSink sets the destination for recorded batches.
+func WithSink(sink Sink) Option {
+	return func(s *Scanner) {
+		s.sink = sink
+	}
+}
+
+// WithRoots adds directories to walk after the root passed to New, in
+// order, into the same output. Records carry the root they were found
+// under, and the root column is then always written, first unless
+// selected elsewhere. Remote sources take a single root.
+func WithRoots(roots ...string) Option {
+	return func(s *Scanner) {
+		s.moreRoots = append(s.moreRoots, roots...)
+	}
+}
+
+// WithColumns selects the output columns by name, in order. See
+// ColumnNames for the available names.
+func WithColumns(names ...string) Option {
+	return func(s *Scanner) {
+		s.columns = names
+	}
+}
+
+// WithTransform appends transforms to the record pipeline. They run in the
+// workers after a record is built and before it is batched.
+func WithTransform(t ...Transform) Option {
+	return func(s *Scanner) {
+		s.transforms = append(s.transforms, t...)
+	}
+}
+
+// WithMetrics records scan progress into m.
+func WithMetrics(m *Metrics) Option {
+	return func(s *Scanner) {
+		s.metrics = m
+	}
+}
+
+// WithTracer records a trace of each Run in t.
+func WithTracer(t *Tracer) Option {
+	return func(s *Scanner) {
+		s.tracer = t
+	}
+}
+
+// WithWarnFunc sets the callback for skipped entries, such as unreadable
+// directories or cycles. Without one, skips are only counted in metrics.
+func WithWarnFunc(fn WarnFunc) Option {
+	return func(s *Scanner) {
+		s.warn = fn
+	}
+}
+
+// WithEscapeMode sets how paths that are not valid UTF-8 are recorded. The
+// default, EscapeRaw, keeps the original bytes.
+func WithEscapeMode(mode EscapeMode) Option {
+	return func(s *Scanner) {
+		s.escape = mode
+	}
+}
+
+// WithDeterministic makes the output order match the walk order, which is
+// sorted by name within each directory, so scans of an unchanged tree are
+// byte-identical. Workers still run in parallel, but records that finish
+// early are held back until their predecessors are written.
+func WithDeterministic(on bool) Option {
+	return func(s *Scanner) {
+		s.deterministic = on
+	}
+}
+
+// WithFlush controls durability. The sink is flushed every `every` batches
+// (0 disables count-based flushing) and at least once per interval (0
+// disables it); pending records are written first on each interval. With
+// sync set, sinks implementing Syncer are also fsynced on every flush. The
+// default flushes after every batch without syncing.
+func WithFlush(every int, interval time.Duration, sync bool) Option {
+	return func(s *Scanner) {
+		s.flushEvery = every
+		s.flushInterval = interval
+		s.fsync = sync
+	}
+}
+
+// WithRetry sets the retry policy for transient filesystem errors. The
+// default makes no retries.
+func WithRetry(p RetryPolicy) Option {
+	return func(s *Scanner) {
+		s.retryPolicy = p
+	}
+}
+
+// CollisionFunc receives a directory and a group of its entries whose names
+// differ only by case. It is called from the walker goroutine.
+type CollisionFunc func(dir string, names []string)
+
+// WithCaseCollisions enables case-collision detection and reports each
+// colliding group to fn (which may be nil). Selecting the case_collision
+// column enables detection too.
+func WithCaseCollisions(fn CollisionFunc) Option {
+	return func(s *Scanner) {
+		s.caseCollisions = true
+		s.onCollision = fn
+	}
+}
+
+// WithObserver adds observers that see every record after it is written.
+func WithObserver(o ...Observer) Option {
+	return func(s *Scanner) {
+		s.observers = append(s.observers, o...)
+	}
+}
+
+// WithOnBatch adds a callback that sees every batch of records after it
+// is written, for embedders indexing records into their own store. With
+// BatchAbort an error from fn stops the scan and is returned by Run; with
+// BatchSkip the scan carries on, the records staying in the output.
+func WithOnBatch(fn BatchFunc, policy BatchPolicy) Option {
+	return func(s *Scanner) {
+		s.onBatch = append(s.onBatch, batchHook{fn, policy})
+	}
+}
+
+// WithColumnProvider adds custom computed columns. Providers need file
+// info, so they turn on stat collection.
+func WithColumnProvider(p ...ColumnProvider) Option {
+	return func(s *Scanner) {
+		s.providers = append(s.providers, p...)
+	}
+}
+
+// WithDetectors turns on a content scanning stage: the first limit bytes
+// of every regular file (DefaultDetectLimit when limit is 0) are searched
+// with detectors, and what they find goes in the findings column, which
+// is added to the default columns. Selecting the findings column without
+// this option uses the built-in detectors. Files with a NUL byte near the
+// start are taken to be binary and skipped.
+func WithDetectors(limit int64, detectors ...Detector) Option {
+	return func(s *Scanner) {
+		s.detectors = append(s.detectors, detectors...)
+		if limit > 0 {
+			s.detectLimit = limit
+		}
+	}
+}
+
+// WithHashCache reuses the hashes c remembers for files whose device,
+// inode, size and mtime haven't changed, and records new ones in it.
+// Only local scans use it. Call c.Save after a complete scan to keep
+// them.
+func WithHashCache(c *HashCache) Option {
+	return func(s *Scanner) {
+		s.cache = c
+	}
+}
+
+// WithDirCache replays the listings c remembers for directories whose
+// mtime and link count haven't changed instead of listing them again, and
+// records new ones in it. Adding, removing or renaming an entry moves its
+// directory's mtime; changes to the files themselves don't, so they are
+// still stat'ed. Only local scans use it. Call c.Save after a complete
+// scan to keep them.
+func WithDirCache(c *HashCache) Option {
+	return func(s *Scanner) {
+		s.cache = c
+		s.cacheDirs = true
+	}
+}
+
+// WithArchives makes the scanner look inside zip, tar, tar.gz, tar.bz2 and
+// 7z files and record their members after the archive itself, with paths
+// of the form archive!/inner/path. Filters and transforms apply to members
+// too. Archives nested in archives are recorded but not opened, and 7z
+// needs the 7z command installed.
+func WithArchives(on bool) Option {
+	return func(s *Scanner) {
+		s.archives = on
+	}
+}
+
+// WithNoStat guarantees the scan makes no system calls per entry beyond
+// listing directories: records carry only what a listing tells, the path
+// and the type. Directories reached twice through bind mounts aren't
+// detected, and symlink loops aren't checked. Columns and options that
+// need more, such as size or hashing, make Columns and Run fail instead of
+// quietly adding stat calls. Each root is still stat'ed once.
+func WithNoStat(on bool) Option {
+	return func(s *Scanner) {
+		s.noStat = on
+	}
+}
+
+// WithNoHydrate leaves the content of cloud placeholders and offline files
+// unread, so that hashing, content detection and archive listing don't
+// download them. Their records carry no hash or findings. Only Windows
+// marks such files; see Record.Reparse.
+func WithNoHydrate(on bool) Option {
+	return func(s *Scanner) {
+		s.noHydrate = on
+	}
+}
+
+// WithSource walks dir within src instead of the local filesystem.
+// Records keep the scanner's root in place of dir, so a source opened from
+// a URL can be scanned with the URL as root and recorded under it. Link
+// status and archive contents are not available for sources.
+func WithSource(src Source, dir string) Option {
+	return func(s *Scanner) {
+		s.source = src
+		s.sourceDir = dir
+	}
+}
@@ -0,0 +1,149 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// OTLPExporter sends spans to an OpenTelemetry collector with OTLP over
+// HTTP, JSON encoded, which collectors accept alongside protobuf.
+type OTLPExporter struct {
+	Endpoint string            // Full URL, e.g. http://localhost:4318/v1/traces
+	Headers  map[string]string // Such as an API key for a hosted backend
+	Service  string            // service.name resource attribute
+	Client   *http.Client
+}
+
+// OTLP JSON shapes, as defined by the opentelemetry-proto JSON mapping:
+// IDs are hex and 64-bit integers are decimal strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID      string         `json:"traceId"`
+		SpanID       string         `json:"spanId"`
+		ParentSpanID string         `json:"parentSpanId,omitempty"`
+		Name         string         `json:"name"`
+		Kind         int            `json:"kind"`
+		Start        string         `json:"startTimeUnixNano"`
+		End          string         `json:"endTimeUnixNano"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+		Status       otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+const (
+	otlpKindInternal = 1
+	otlpStatusError  = 2
+)
+
+// ExportSpans posts spans as one request.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []Span) error {
+	service := e.Service
+	if service == "" {
+		service = "read_file_paths"
+	}
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "github.com/pcoelho00/read_file_paths/scan"}}
+	for _, sp := range spans {
+		out := otlpSpan{
+			TraceID:    hex.EncodeToString(sp.TraceID[:]),
+			SpanID:     hex.EncodeToString(sp.SpanID[:]),
+			Name:       sp.Name,
+			Kind:       otlpKindInternal,
+			Start:      strconv.FormatInt(sp.Start.UnixNano(), 10),
+			End:        strconv.FormatInt(sp.End.UnixNano(), 10),
+			Attributes: otlpAttributes(sp.Attributes),
+		}
+		if sp.ParentID != ([8]byte{}) {
+			out.ParentSpanID = hex.EncodeToString(sp.ParentID[:])
+		}
+		if sp.Err != nil {
+			out.Status = otlpStatus{Code: otlpStatusError, Message: sp.Err.Error()}
+		}
+		scope.Spans = append(scope.Spans, out)
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(map[string]any{"service.name": service})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("otlp: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("otlp: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// otlpAttributes converts attributes, sorted by key for stable output.
+func otlpAttributes(attrs map[string]any) []otlpKeyValue {
+	out := make([]otlpKeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]any
+		switch v := v.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, otlpKeyValue{Key: k, Value: value})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
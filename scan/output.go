@@ -0,0 +1,43 @@
+package scan
+
+import "os"
+
+// TempSuffix is appended to the final output path while a scan is writing.
+const TempSuffix = ".tmp"
+
+// AtomicFile writes to a temporary file next to its destination and only
+// renames it into place on Commit, so readers never see a partial output.
+type AtomicFile struct {
+	*os.File
+	path string
+}
+
+// CreateAtomic creates path+TempSuffix for writing.
+func CreateAtomic(path string) (*AtomicFile, error) {
+	f, err := os.Create(path + TempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicFile{File: f, path: path}, nil
+}
+
+// Path returns the final destination path.
+func (a *AtomicFile) Path() string {
+	return a.path
+}
+
+// Commit closes the temp file and renames it over the destination.
+func (a *AtomicFile) Commit() error {
+	if err := a.File.Close(); err != nil {
+		os.Remove(a.Name())
+		return err
+	}
+	return os.Rename(a.Name(), a.path)
+}
+
+// Abort closes and deletes the temp file, leaving any previous output at
+// the destination untouched.
+func (a *AtomicFile) Abort() {
+	a.File.Close()
+	os.Remove(a.Name())
+}
@@ -0,0 +1,669 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Parquet physical types, converted types and other enums from the
+// format's Thrift definition.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+	parquetTimestampMicros = 10
+
+	parquetOptional = 1
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetUncompressed = 0
+	parquetGzip         = 2
+
+	parquetDataPage = 0
+)
+
+var parquetMagic = []byte("PAR1")
+
+// ParquetRowGroupRows is how many records ParquetSink buffers per row
+// group.
+const ParquetRowGroupRows = 100_000
+
+// ParquetSink writes records as an Apache Parquet file: one optional,
+// flat column per output column, plain encoded and gzip compressed, in
+// row groups of ParquetRowGroupRows (fewer under WithMemoryLimit). Each column's type comes from its
+// first non-nil value: integers become INT64, times INT64 timestamps in
+// milliseconds (UTC), bools BOOLEAN, and everything else UTF-8 text as
+// FormatValue renders it.
+//
+// The file is only readable once Close writes the footer; Flush and Sync
+// do not make a partial file readable.
+type ParquetSink struct {
+	w       *bufio.Writer
+	out     io.Writer
+	offset  int64
+	columns []*parquetColumn
+	rows    int
+	groups  []parquetRowGroup
+
+	rowGroupRows int // ParquetRowGroupRows unless a memory limit asks for fewer
+	total        int64
+	started      bool
+	closed       bool
+
+	keys   []string
+	values []func() string
+}
+
+type parquetColumn struct {
+	col    Column
+	typ    int32 // -1 until the first non-nil value
+	conv   int32 // -1 for none
+	defs   []bool
+	values bytes.Buffer
+	bools  []bool
+}
+
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int
+	size   int64
+}
+
+type parquetChunk struct {
+	typ                      int32
+	offset, size, compressed int64
+}
+
+// NewParquetSink returns a Sink writing Parquet to w. Close must be
+// called to finish the file; Scanner.Run does so.
+func NewParquetSink(w io.Writer) *ParquetSink {
+	return &ParquetSink{out: w, w: bufio.NewWriter(w), rowGroupRows: ParquetRowGroupRows}
+}
+
+// SetKeyValue adds an entry to the file's key/value metadata. value is
+// called when Close writes the footer, so it can describe the finished
+// scan.
+func (p *ParquetSink) SetKeyValue(key string, value func() string) {
+	p.keys = append(p.keys, key)
+	p.values = append(p.values, value)
+}
+
+// Location returns the path of the file the sink writes, if any.
+func (p *ParquetSink) Location() string { return writerLocation(p.out) }
+
+func (p *ParquetSink) WriteHeader(columns []Column) error {
+	p.columns = make([]*parquetColumn, len(columns))
+	for i, col := range columns {
+		p.columns[i] = &parquetColumn{col: col, typ: -1, conv: -1}
+	}
+	p.started = true
+	return p.write(parquetMagic)
+}
+
+func (p *ParquetSink) WriteBatch(records []Record) error {
+	for i := range records {
+		for _, c := range p.columns {
+			if err := c.add(c.col.Value(&records[i])); err != nil {
+				return err
+			}
+		}
+		p.rows++
+		if p.rows >= p.rowGroupRows {
+			if err := p.writeRowGroup(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush writes out buffered bytes; records stay buffered until their row
+// group is full.
+func (p *ParquetSink) Flush() error { return p.w.Flush() }
+
+// Sync flushes and fsyncs the underlying writer when it supports it.
+func (p *ParquetSink) Sync() error {
+	if err := p.Flush(); err != nil {
+		return err
+	}
+	if syncer, ok := p.out.(Syncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// Close writes the last row group and the footer. It does not close the
+// underlying writer.
+func (p *ParquetSink) Close() error {
+	if !p.started || p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.rows > 0 {
+		if err := p.writeRowGroup(); err != nil {
+			return err
+		}
+	}
+	meta := p.footer()
+	var trailer [4]byte
+	binary.LittleEndian.PutUint32(trailer[:], uint32(len(meta)))
+	if err := p.write(meta); err != nil {
+		return err
+	}
+	if err := p.write(trailer[:]); err != nil {
+		return err
+	}
+	if err := p.write(parquetMagic); err != nil {
+		return err
+	}
+	return p.w.Flush()
+}
+
+func (p *ParquetSink) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+func (c *parquetColumn) add(v any) error {
+	if t, ok := v.(time.Time); ok && t.IsZero() {
+		v = nil
+	}
+	if v == nil {
+		c.defs = append(c.defs, false)
+		return nil
+	}
+	if c.typ < 0 {
+		c.resolve(v)
+	}
+	switch c.typ {
+	case parquetInt64:
+		var n int64
+		switch v := v.(type) {
+		case int:
+			n = int64(v)
+		case int64:
+			n = v
+		case int32:
+			n = int64(v)
+		case time.Time:
+			n = v.UnixMilli()
+		default:
+			return fmt.Errorf("parquet: column %s: cannot store %T in an INT64 column", c.col.Name, v)
+		}
+		c.values.Write(binary.LittleEndian.AppendUint64(nil, uint64(n)))
+	case parquetBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("parquet: column %s: cannot store %T in a BOOLEAN column", c.col.Name, v)
+		}
+		c.bools = append(c.bools, b)
+	default:
+		s := FormatValue(v)
+		c.values.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(s))))
+		c.values.WriteString(s)
+	}
+	c.defs = append(c.defs, true)
+	return nil
+}
+
+func (c *parquetColumn) resolve(v any) {
+	switch v.(type) {
+	case int, int64, int32:
+		c.typ = parquetInt64
+	case time.Time:
+		c.typ, c.conv = parquetInt64, parquetTimestampMillis
+	case bool:
+		c.typ = parquetBoolean
+	default:
+		c.typ, c.conv = parquetByteArray, parquetUTF8
+	}
+}
+
+// writeRowGroup writes every column's buffered values as one gzip
+// compressed data page.
+func (p *ParquetSink) writeRowGroup() error {
+	group := parquetRowGroup{rows: p.rows}
+	for _, c := range p.columns {
+		if c.typ < 0 {
+			c.typ, c.conv = parquetByteArray, parquetUTF8
+		}
+		var page bytes.Buffer
+		levels := encodeLevels(c.defs)
+		page.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(levels))))
+		page.Write(levels)
+		if c.typ == parquetBoolean {
+			page.Write(packBools(c.bools))
+		} else {
+			page.Write(c.values.Bytes())
+		}
+
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write(page.Bytes())
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		var h thriftWriter
+		h.i32(1, parquetDataPage)
+		h.i32(2, int32(page.Len()))
+		h.i32(3, int32(compressed.Len()))
+		h.beginStruct(5)
+		h.i32(1, int32(len(c.defs)))
+		h.i32(2, parquetPlain)
+		h.i32(3, parquetRLE)
+		h.i32(4, parquetRLE)
+		h.endStruct()
+		h.buf = append(h.buf, 0)
+
+		chunk := parquetChunk{
+			typ:        c.typ,
+			offset:     p.offset,
+			size:       int64(len(h.buf) + page.Len()),
+			compressed: int64(len(h.buf) + compressed.Len()),
+		}
+		if err := p.write(h.buf); err != nil {
+			return err
+		}
+		if err := p.write(compressed.Bytes()); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.size
+
+		c.defs, c.bools = c.defs[:0], c.bools[:0]
+		c.values.Reset()
+	}
+	p.groups = append(p.groups, group)
+	p.total += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+// footer encodes the FileMetaData struct.
+func (p *ParquetSink) footer() []byte {
+	var t thriftWriter
+	t.i32(1, 1)
+	t.list(2, thriftStruct, len(p.columns)+1)
+	t.beginStruct(0)
+	t.binary(4, "schema")
+	t.i32(5, int32(len(p.columns)))
+	t.endStruct()
+	for _, c := range p.columns {
+		if c.typ < 0 {
+			c.typ, c.conv = parquetByteArray, parquetUTF8
+		}
+		t.beginStruct(0)
+		t.i32(1, c.typ)
+		t.i32(3, parquetOptional)
+		t.binary(4, c.col.Name)
+		if c.conv >= 0 {
+			t.i32(6, c.conv)
+		}
+		t.endStruct()
+	}
+	t.i64(3, p.total)
+	t.list(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		t.beginStruct(0)
+		t.list(1, thriftStruct, len(g.chunks))
+		for i, ch := range g.chunks {
+			t.beginStruct(0)
+			t.i64(2, ch.offset)
+			t.beginStruct(3)
+			t.i32(1, ch.typ)
+			t.list(2, thriftI32, 2)
+			t.rawI32(parquetPlain)
+			t.rawI32(parquetRLE)
+			t.list(3, thriftBinary, 1)
+			t.rawBinary(p.columns[i].col.Name)
+			t.i32(4, parquetGzip)
+			t.i64(5, int64(g.rows))
+			t.i64(6, ch.size)
+			t.i64(7, ch.compressed)
+			t.i64(9, ch.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, g.size)
+		t.i64(3, int64(g.rows))
+		t.endStruct()
+	}
+	if len(p.keys) > 0 {
+		t.list(5, thriftStruct, len(p.keys))
+		for i, key := range p.keys {
+			t.beginStruct(0)
+			t.binary(1, key)
+			t.binary(2, p.values[i]())
+			t.endStruct()
+		}
+	}
+	t.binary(6, "file_paths")
+	t.buf = append(t.buf, 0)
+	return t.buf
+}
+
+// encodeLevels encodes definition levels (bit width 1) as RLE runs of the
+// RLE/bit-packing hybrid encoding.
+func encodeLevels(defs []bool) []byte {
+	var out []byte
+	for i := 0; i < len(defs); {
+		j := i
+		for j < len(defs) && defs[j] == defs[i] {
+			j++
+		}
+		out = binary.AppendUvarint(out, uint64(j-i)<<1)
+		if defs[i] {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+		i = j
+	}
+	return out
+}
+
+func packBools(bs []bool) []byte {
+	out := make([]byte, (len(bs)+7)/8)
+	for i, b := range bs {
+		if b {
+			out[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return out
+}
+
+// parquetReader reads flat Parquet files with plain encoded, uncompressed
+// or gzip compressed v1 data pages, as ParquetSink writes them.
+// Dictionary encoding and other codecs are reported as unsupported.
+type parquetReader struct {
+	f       *os.File
+	columns []string
+	schema  []parquetField
+	groups  []thriftStructValue
+	rows    [][]string // Current row group, column-major
+	n, next int
+}
+
+type parquetField struct {
+	name     string
+	typ      int64
+	conv     int64
+	optional bool
+}
+
+var errParquetUnsupported = errors.New("parquet: unsupported feature")
+
+func newParquetReader(f *os.File) (OutputReader, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	var tail [8]byte
+	if size < 12 {
+		return nil, errors.New("parquet: file too short")
+	}
+	if _, err := f.ReadAt(tail[:], size-8); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tail[4:], parquetMagic) {
+		return nil, errors.New("parquet: missing footer (was the file finished?)")
+	}
+	metaLen := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if metaLen > size-12 {
+		return nil, errors.New("parquet: corrupt footer")
+	}
+	tr := thriftReader{bufio.NewReader(io.NewSectionReader(f, size-8-metaLen, metaLen))}
+	meta, err := tr.readStruct()
+	if err != nil {
+		return nil, fmt.Errorf("parquet: reading footer: %w", err)
+	}
+
+	p := &parquetReader{f: f}
+	schema := meta.list(2)
+	if len(schema) == 0 {
+		return nil, errors.New("parquet: empty schema")
+	}
+	for _, e := range schema[1:] {
+		el, _ := e.(thriftStructValue)
+		if el.int(5) > 0 {
+			return nil, fmt.Errorf("%w: nested column %s", errParquetUnsupported, el.str(4))
+		}
+		field := parquetField{name: el.str(4), typ: el.int(1), conv: -1, optional: el.int(3) == parquetOptional}
+		if el.has(6) {
+			field.conv = el.int(6)
+		}
+		p.schema = append(p.schema, field)
+		p.columns = append(p.columns, field.name)
+	}
+	for _, g := range meta.list(4) {
+		group, _ := g.(thriftStructValue)
+		p.groups = append(p.groups, group)
+	}
+	return p, nil
+}
+
+func (p *parquetReader) Columns() []string { return p.columns }
+
+func (p *parquetReader) Next() ([]string, error) {
+	for p.next >= p.n {
+		if len(p.groups) == 0 {
+			return nil, io.EOF
+		}
+		if err := p.readRowGroup(p.groups[0]); err != nil {
+			return nil, err
+		}
+		p.groups = p.groups[1:]
+	}
+	row := make([]string, len(p.rows))
+	for i, col := range p.rows {
+		row[i] = col[p.next]
+	}
+	p.next++
+	return row, nil
+}
+
+func (p *parquetReader) Close() error { return p.f.Close() }
+
+func (p *parquetReader) readRowGroup(g thriftStructValue) error {
+	chunks := g.list(1)
+	if len(chunks) != len(p.schema) {
+		return errors.New("parquet: row group does not match the schema")
+	}
+	p.n, p.next = int(g.int(3)), 0
+	p.rows = make([][]string, len(chunks))
+	for i, c := range chunks {
+		chunk, _ := c.(thriftStructValue)
+		values, err := p.readChunk(p.schema[i], chunk.strct(3))
+		if err != nil {
+			return fmt.Errorf("parquet: column %s: %w", p.schema[i].name, err)
+		}
+		if len(values) != p.n {
+			return fmt.Errorf("parquet: column %s has %d values, want %d", p.schema[i].name, len(values), p.n)
+		}
+		p.rows[i] = values
+	}
+	return nil
+}
+
+func (p *parquetReader) readChunk(field parquetField, meta thriftStructValue) ([]string, error) {
+	if meta == nil {
+		return nil, errors.New("missing column metadata")
+	}
+	if meta.has(11) {
+		return nil, fmt.Errorf("%w: dictionary pages", errParquetUnsupported)
+	}
+	codec := meta.int(4)
+	if codec != parquetUncompressed && codec != parquetGzip {
+		return nil, fmt.Errorf("%w: compression codec %d", errParquetUnsupported, codec)
+	}
+	want := int(meta.int(5))
+	tr := thriftReader{bufio.NewReader(io.NewSectionReader(p.f, meta.int(9), meta.int(7)))}
+	values := make([]string, 0, want)
+	for len(values) < want {
+		h, err := tr.readStruct()
+		if err != nil {
+			return nil, err
+		}
+		if h.int(1) != parquetDataPage {
+			return nil, fmt.Errorf("%w: page type %d", errParquetUnsupported, h.int(1))
+		}
+		page := make([]byte, h.int(3))
+		if _, err := io.ReadFull(tr.r, page); err != nil {
+			return nil, err
+		}
+		if codec == parquetGzip {
+			zr, err := gzip.NewReader(bytes.NewReader(page))
+			if err != nil {
+				return nil, err
+			}
+			if page, err = io.ReadAll(zr); err != nil {
+				return nil, err
+			}
+		}
+		dp := h.strct(5)
+		if dp.int(2) != parquetPlain {
+			return nil, fmt.Errorf("%w: encoding %d", errParquetUnsupported, dp.int(2))
+		}
+		if values, err = decodePage(values, field, page, int(dp.int(1))); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// decodePage appends the n values of a plain encoded data page as text.
+func decodePage(values []string, field parquetField, page []byte, n int) ([]string, error) {
+	defs := make([]bool, n)
+	for i := range defs {
+		defs[i] = true
+	}
+	if field.optional {
+		if len(page) < 4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		l := int(binary.LittleEndian.Uint32(page))
+		if l > len(page)-4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err := decodeLevels(page[4:4+l], defs); err != nil {
+			return nil, err
+		}
+		page = page[4+l:]
+	}
+	bit := 0
+	for _, defined := range defs {
+		if !defined {
+			values = append(values, "")
+			continue
+		}
+		var v string
+		switch field.typ {
+		case parquetBoolean:
+			if bit/8 >= len(page) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			v = strconv.FormatBool(page[bit/8]&(1<<uint(bit%8)) != 0)
+			bit++
+		case parquetInt32:
+			if len(page) < 4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			v = strconv.Itoa(int(int32(binary.LittleEndian.Uint32(page))))
+			page = page[4:]
+		case parquetInt64:
+			if len(page) < 8 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			n := int64(binary.LittleEndian.Uint64(page))
+			page = page[8:]
+			switch field.conv {
+			case parquetTimestampMillis:
+				v = FormatValue(time.UnixMilli(n))
+			case parquetTimestampMicros:
+				v = FormatValue(time.UnixMicro(n))
+			default:
+				v = strconv.FormatInt(n, 10)
+			}
+		case parquetFloat:
+			if len(page) < 4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			v = strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(page))), 'g', -1, 32)
+			page = page[4:]
+		case parquetDouble:
+			if len(page) < 8 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			v = strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(page)), 'g', -1, 64)
+			page = page[8:]
+		case parquetByteArray:
+			if len(page) < 4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			l := int(binary.LittleEndian.Uint32(page))
+			if l > len(page)-4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			v = string(page[4 : 4+l])
+			page = page[4+l:]
+		default:
+			return nil, fmt.Errorf("%w: physical type %d", errParquetUnsupported, field.typ)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// decodeLevels decodes bit width 1 definition levels in the RLE/bit-packing
+// hybrid encoding.
+func decodeLevels(b []byte, defs []bool) error {
+	r := bytes.NewReader(b)
+	for i := 0; i < len(defs); {
+		h, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if h&1 == 0 {
+			v, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			for n := h >> 1; n > 0 && i < len(defs); n-- {
+				defs[i] = v != 0
+				i++
+			}
+			continue
+		}
+		for groups := h >> 1; groups > 0; groups-- {
+			v, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			for bit := 0; bit < 8 && i < len(defs); bit++ {
+				defs[i] = v&(1<<uint(bit)) != 0
+				i++
+			}
+		}
+	}
+	return nil
+}
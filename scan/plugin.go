@@ -0,0 +1,216 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// pluginHandshakeTimeout bounds the wait for a plugin to name its columns.
+const pluginHandshakeTimeout = 10 * time.Second
+
+// PluginHook is a ColumnTransform handing every record to a long-running
+// external program, so the pipeline can be extended in any language
+// without recompiling. See NewPluginHook for the protocol.
+type PluginHook struct {
+	name    string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+	columns []string
+
+	mu  sync.Mutex // One record in flight at a time
+	err error      // Set once the plugin has failed
+}
+
+// NewPluginHook starts command, split as by SplitCommand, and reads its
+// handshake: a line of JSON such as {"columns": ["team", "asset_id"]}
+// naming the columns it adds. Every record is then written to the
+// plugin's stdin as a JSON object on one line, with the values of all
+// built-in and transform columns, and the plugin answers each with one
+// line, in order: the record back with its columns set (strings, numbers,
+// booleans or null), or null to drop the record. A changed file_path is
+// kept too, for the output only: ActionHook and ExecHook act on the path
+// the file was walked at, whatever a plugin replied. The plugin's stderr
+// is passed through. Close ends it.
+func NewPluginHook(command string) (*PluginHook, error) {
+	args, err := SplitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("plugin: empty command")
+	}
+	return startPlugin(args[0], args)
+}
+
+// WasmRuntimes are the WASI runtimes NewWasmPluginHook looks for, in
+// order, with the arguments running a module under them.
+var WasmRuntimes = []struct {
+	Command string
+	Args    []string // The module path is appended
+}{
+	{"wasmtime", []string{"run", "--"}},
+	{"wasmer", []string{"run"}},
+}
+
+// NewWasmPluginHook runs a WebAssembly module built for WASI as a plugin,
+// speaking the protocol of NewPluginHook over its stdin and stdout. The
+// module runs under the first runtime of WasmRuntimes found on the PATH,
+// which gives it no files, network or environment. Its replies still
+// decide the records, file_path included, so the output is only as
+// trustworthy as the module.
+func NewWasmPluginHook(module string) (*PluginHook, error) {
+	magic := make([]byte, 4)
+	f, err := os.Open(module)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(f, magic)
+	f.Close()
+	if err != nil || string(magic) != "\x00asm" {
+		return nil, fmt.Errorf("%s: not a WebAssembly module", module)
+	}
+	for _, rt := range WasmRuntimes {
+		path, err := exec.LookPath(rt.Command)
+		if err != nil {
+			continue
+		}
+		args := append(append([]string{path}, rt.Args...), module)
+		return startPlugin(filepath.Base(module), args)
+	}
+	return nil, fmt.Errorf("%s: no WebAssembly runtime found (install wasmtime or wasmer)", module)
+}
+
+// startPlugin starts args and reads its handshake, naming it name in
+// errors.
+func startPlugin(name string, args []string) (*PluginHook, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = time.Second
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", name, err)
+	}
+	h := &PluginHook{name: name, cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}
+
+	var handshake struct {
+		Columns []string `json:"columns"`
+	}
+	done := make(chan error, 1)
+	go func() {
+		line, err := h.stdout.ReadBytes('\n')
+		if err == nil || err == io.EOF && len(line) > 0 {
+			err = json.Unmarshal(line, &handshake)
+		}
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(pluginHandshakeTimeout):
+		err = fmt.Errorf("no handshake within %s", pluginHandshakeTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		h.Close()
+		return nil, fmt.Errorf("plugin %s: reading handshake: %w", h.name, err)
+	}
+	for _, name := range handshake.Columns {
+		if _, ok := LookupColumn(name); ok {
+			h.Close()
+			return nil, fmt.Errorf("plugin %s: column %q is built in", h.name, name)
+		}
+	}
+	h.columns = handshake.Columns
+	return h, nil
+}
+
+func (h *PluginHook) Columns() []Column {
+	cols := make([]Column, len(h.columns))
+	for i, name := range h.columns {
+		cols[i] = ExtraColumn(name)
+	}
+	return cols
+}
+
+func (h *PluginHook) Apply(r *Record) (bool, error) {
+	obj := make(map[string]any, len(builtinColumns)+len(r.Extra))
+	for _, col := range builtinColumns {
+		obj[col.Name] = JSONValue(col.Value(r))
+	}
+	for name, v := range r.Extra {
+		obj[name] = JSONValue(v)
+	}
+	line, err := json.Marshal(obj)
+	if err != nil {
+		return false, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err != nil {
+		return false, h.err
+	}
+	reply, err := h.exchange(append(line, '\n'))
+	if err != nil {
+		// The stream is out of step or gone; fail every later record
+		h.err = fmt.Errorf("plugin %s: %w", h.name, err)
+		return false, h.err
+	}
+	if reply == nil {
+		return false, nil
+	}
+	for _, name := range h.columns {
+		r.SetExtra(name, reply[name])
+	}
+	if p, ok := reply["file_path"].(string); ok {
+		r.Path = p
+	}
+	return true, nil
+}
+
+// exchange sends one record and reads the plugin's answer, nil for a
+// dropped record.
+func (h *PluginHook) exchange(line []byte) (map[string]any, error) {
+	if _, err := h.stdin.Write(line); err != nil {
+		return nil, err
+	}
+	answer, err := h.stdout.ReadBytes('\n')
+	if err == io.EOF && len(answer) == 0 {
+		return nil, errors.New("exited before answering")
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	var reply map[string]any
+	dec := json.NewDecoder(bytes.NewReader(answer))
+	dec.UseNumber()
+	if err := dec.Decode(&reply); err != nil {
+		return nil, fmt.Errorf("bad answer %q: %w", bytes.TrimSpace(answer), err)
+	}
+	return reply, nil
+}
+
+// Close closes the plugin's stdin and waits for it to exit.
+func (h *PluginHook) Close() error {
+	h.stdin.Close()
+	if err := h.cmd.Wait(); err != nil {
+		return fmt.Errorf("plugin %s: %w", h.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package scan
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestPluginPathDoesNotSteerActions(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	root, outside := t.TempDir(), t.TempDir()
+	writeFiles(t, root, "walked.txt")
+	writeFiles(t, outside, "victim.txt")
+	victim := filepath.Join(outside, "victim.txt")
+	plugin, err := NewPluginHook(`sh -c 'echo "{\"columns\":[]}"; while read -r l; do echo "{\"file_path\":\"` + victim + `\"}"; done'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plugin.Close()
+	hook, err := NewActionHook(ActionDelete, root, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordSink{}
+	if _, err := New(root, WithSink(sink), WithTransform(plugin, hook)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.records) != 1 || sink.records[0].Path != victim {
+		t.Fatalf("records = %+v, want the plugin's path kept in the output", sink.records)
+	}
+	if _, err := os.Lstat(victim); err != nil {
+		t.Errorf("plugin's path was acted on: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "walked.txt")); err == nil {
+		t.Error("walked file was not deleted")
+	}
+}
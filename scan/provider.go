@@ -0,0 +1,21 @@
+package scan
+
+import "io/fs"
+
+// ColumnProvider computes a custom column, such as an internal asset ID,
+// from each record and the file's info. Its column is selectable by name
+// and appended to the default columns, so every sink writes it. Compute
+// is called from several goroutines at once, after hashing and before the
+// transforms, with the info of the entry itself (not of a symlink's
+// target). Register providers with WithColumnProvider.
+type ColumnProvider interface {
+	Name() string
+	Compute(r Record, info fs.FileInfo) string
+}
+
+// provide stores the value of every provider's column in rec.
+func (s *Scanner) provide(rec *Record, info fs.FileInfo) {
+	for _, p := range s.providers {
+		rec.SetExtra(p.Name(), p.Compute(*rec, info))
+	}
+}
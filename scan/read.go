@@ -0,0 +1,213 @@
+package scan
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OutputReader reads rows back from a scan output. Values come back as
+// text in FormatValue form, whatever the file format.
+type OutputReader interface {
+	// Columns returns the column names in file order.
+	Columns() []string
+	// Next returns the next row, aligned with Columns, or io.EOF.
+	Next() ([]string, error)
+	Close() error
+}
+
+// outputReaders opens each readable format, keyed by file extension.
+var outputReaders = map[string]func(f *os.File) (OutputReader, error){
+	".csv":     newCSVReader,
+	".json":    newJSONReader,
+	".jsonl":   newJSONReader,
+	".ndjson":  newJSONReader,
+	".parquet": newParquetReader,
+}
+
+// OpenOutput opens a scan output, choosing the format from the file
+// extension.
+func OpenOutput(path string) (OutputReader, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	open, ok := outputReaders[ext]
+	if !ok {
+		return nil, fmt.Errorf("%s: unrecognized output format %q", path, ext)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := open(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+type csvReader struct {
+	f       *os.File
+	r       *csv.Reader
+	columns []string
+	safe    bool // Values were escaped with EscapeFormula
+}
+
+func newCSVReader(f *os.File) (OutputReader, error) {
+	br := bufio.NewReader(f)
+	// Excel mode starts with a byte order mark
+	if b, err := br.Peek(len(utf8BOM)); err == nil && string(b) == utf8BOM {
+		br.Discard(len(utf8BOM))
+	}
+	// Skip a metadata preamble of # lines; no column name starts with #
+	safe := false
+	for {
+		b, err := br.Peek(1)
+		if err != nil || b[0] != '#' {
+			break
+		}
+		line, err := br.ReadString('\n')
+		if strings.TrimRight(line, "\r\n") == safeCSVMarker {
+			safe = true
+		}
+		if err != nil {
+			break
+		}
+	}
+	r := csv.NewReader(br)
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty file, no header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &csvReader{f: f, r: r, columns: append([]string(nil), header...), safe: safe}, nil
+}
+
+func (c *csvReader) Columns() []string { return c.columns }
+
+// SafeCSV reports whether r reads a CSV output written in safe mode,
+// whose values it unescapes. Outputs rewritten from it should be written
+// in safe mode too.
+func SafeCSV(r OutputReader) bool {
+	c, ok := r.(*csvReader)
+	return ok && c.safe
+}
+
+func (c *csvReader) Next() ([]string, error) {
+	row, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	values := append([]string(nil), row...)
+	if c.safe {
+		// Only escaped values start with a quote before a formula trigger
+		for i, v := range values {
+			values[i] = UnescapeFormula(v)
+		}
+	}
+	return values, nil
+}
+
+func (c *csvReader) Close() error { return c.f.Close() }
+
+// jsonReader reads JSON lines. Columns come from the keys of the first
+// object; later objects are matched by key.
+type jsonReader struct {
+	f       *os.File
+	dec     *json.Decoder
+	columns []string
+	index   map[string]int
+	first   []string
+}
+
+func newJSONReader(f *os.File) (OutputReader, error) {
+	dec := json.NewDecoder(bufio.NewReader(f))
+	dec.UseNumber()
+	j := &jsonReader{f: f, dec: dec, index: make(map[string]int)}
+	keys, values, err := j.object()
+	if err == io.EOF {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		j.index[k] = i
+	}
+	j.columns, j.first = keys, values
+	return j, nil
+}
+
+func (j *jsonReader) Columns() []string { return j.columns }
+
+func (j *jsonReader) Next() ([]string, error) {
+	if j.first != nil {
+		row := j.first
+		j.first = nil
+		return row, nil
+	}
+	keys, values, err := j.object()
+	if err != nil {
+		return nil, err
+	}
+	row := make([]string, len(j.columns))
+	for i, k := range keys {
+		if idx, ok := j.index[k]; ok {
+			row[idx] = values[i]
+		}
+	}
+	return row, nil
+}
+
+func (j *jsonReader) Close() error { return j.f.Close() }
+
+// object decodes one JSON object, keeping its key order.
+func (j *jsonReader) object() (keys, values []string, err error) {
+	tok, err := j.dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("expected a JSON object per line, got %v", tok)
+	}
+	for j.dec.More() {
+		tok, err := j.dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var v any
+		if err := j.dec.Decode(&v); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, tok.(string))
+		values = append(values, jsonText(v))
+	}
+	if _, err := j.dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	return keys, values, nil
+}
+
+// jsonText renders a decoded JSON value as FormatValue would have.
+func jsonText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []any:
+		parts := make([]string, len(v))
+		for i, p := range v {
+			parts[i] = jsonText(p)
+		}
+		return strings.Join(parts, "; ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,51 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeCSVRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewCSVSink(f)
+	sink.Safe()
+	col, _ := LookupColumn("file_path")
+	paths := []string{"=evil.csv", "'=quoted", "plain", "-rw"}
+	records := make([]Record, len(paths))
+	for i, p := range paths {
+		records[i] = Record{Path: p}
+	}
+	if err := sink.WriteHeader([]Column{col}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteBatch(records); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r, err := OpenOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if !SafeCSV(r) {
+		t.Error("SafeCSV = false for a safe output")
+	}
+	for _, want := range paths {
+		row, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row[0] != want {
+			t.Errorf("read %q, want %q", row[0], want)
+		}
+	}
+}
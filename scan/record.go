@@ -0,0 +1,277 @@
+package scan
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record describes one scanned file. Fields that require a stat call are
+// only populated when a selected column needs them.
+type Record struct {
+	Path  string
+	Root  string // The root Path was found under, as given to the scanner
+	Size  int64
+	Mode  fs.FileMode
+	MTime time.Time
+	Hash  string
+
+	// UID and GID are the numeric owner, or -1 where the platform has none
+	UID, GID int
+
+	// Allocated is the space the file takes on disk, or -1 where unknown.
+	// Sparse is set when that falls short of Size; see Allocation.
+	Allocated int64
+	Sparse    bool
+
+	// LongPath is set when the absolute path reaches MaxPath
+	LongPath bool
+
+	// InvalidUTF8 is set when the original path was not valid UTF-8. Path
+	// then holds the escaped form chosen with WithEscapeMode.
+	InvalidUTF8 bool
+
+	// CaseCollision is set when a sibling's name differs only by case,
+	// which breaks on case-insensitive filesystems
+	CaseCollision bool
+
+	// WindowsIssues lists name problems that block transfer to Windows or
+	// SharePoint; see WindowsNameIssues
+	WindowsIssues []string
+
+	// LinkTarget and LinkStatus describe symlinks: the raw target as
+	// stored in the link, and one of LinkOK, LinkBroken or LinkOutside
+	LinkTarget string
+	LinkStatus string
+
+	// Findings lists what content detectors found, as "name:count"
+	// entries; see WithDetectors
+	Findings []string
+
+	// FinderFlags, Quarantine and ResourceFork are macOS metadata: the
+	// Finder flags set, the raw com.apple.quarantine attribute of files
+	// downloaded from the internet, and the resource fork size, or -1
+	// where unknown
+	FinderFlags  []string
+	Quarantine   string
+	ResourceFork int64
+
+	// Reparse is the reparse point state of the file on Windows: one of
+	// ReparsePlaceholder, ReparseOffline or ReparsePoint, or empty
+	Reparse string
+
+	// HardLink is set on the second and later links to a file already
+	// recorded in this scan, whose content is then counted only once
+	HardLink bool
+
+	// Type is what the entry is: EntryFile, EntryDir, EntrySymlink or
+	// EntryOther. Directories are only recorded WithIncludeDirs.
+	Type string
+
+	// ChildFiles and ChildDirs count the entries a directory listed that
+	// pass the filters, so excluded entries and the scan's own files are
+	// left out: directories in ChildDirs, anything else in ChildFiles. They
+	// are -1 for everything but directories.
+	ChildFiles, ChildDirs int
+
+	// AliasOf is, for rows of type EntryAlias, the path the same directory
+	// was first walked under
+	AliasOf string
+
+	// ScanID identifies the run that recorded the file; see WithScanID
+	ScanID string
+
+	// Extra holds values added by transforms, keyed by column name
+	Extra map[string]any
+
+	fileID fileID // Device and inode, set for files with several links
+	linked bool   // fileID is set
+
+	// osPath is the path the file was walked at and relPath the same below
+	// its root, unescaped and out of reach of transforms, for the hooks
+	// acting on the file. Both are empty for entries not on local disk.
+	osPath, relPath string
+	inArchive       bool // The record is of an archive member
+}
+
+// Entry types recorded in Record.Type.
+const (
+	EntryFile    = "file"
+	EntryDir     = "dir"
+	EntrySymlink = "symlink"
+	EntryOther   = "other" // Devices, pipes and sockets
+
+	// EntryTruncated marks the row standing in for the files a directory
+	// held beyond the WithMaxPerDir cap. Its path is the directory's.
+	EntryTruncated = "truncated"
+	// EntryAlias marks a directory that is the same, by device and inode,
+	// as one already walked, as with bind mounts and overlapping roots. It
+	// is not walked again; see WithAliases.
+	EntryAlias = "alias"
+)
+
+// entryType names the type of a directory entry.
+func entryType(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return EntryFile
+	case mode.IsDir():
+		return EntryDir
+	case mode&fs.ModeSymlink != 0:
+		return EntrySymlink
+	}
+	return EntryOther
+}
+
+// IsDir reports whether r records a directory.
+func (r *Record) IsDir() bool {
+	return r.Type == EntryDir
+}
+
+// IsFile reports whether r records a file, as opposed to a directory, an
+// alias or a truncation marker.
+func (r *Record) IsFile() bool {
+	return r.Type != EntryDir && r.Type != EntryTruncated && r.Type != EntryAlias
+}
+
+// DiskSize returns the bytes r adds to disk usage: its size, or 0 for a
+// later link to content already counted.
+func (r *Record) DiskSize() int64 {
+	if r.HardLink {
+		return 0
+	}
+	return r.Size
+}
+
+// SetExtra stores a computed value under name.
+func (r *Record) SetExtra(name string, v any) {
+	if r.Extra == nil {
+		r.Extra = make(map[string]any)
+	}
+	r.Extra[name] = v
+}
+
+// Column is a named output field. Value returns a typed value; sinks decide
+// how to render it.
+type Column struct {
+	Name      string
+	NeedsStat bool
+	Value     func(r *Record) any
+
+	// Type and Nullable describe the values for schemas; see WriteSchema
+	Type     ColumnType
+	Nullable bool
+}
+
+var builtinColumns = []Column{
+	{Name: "file_path", Type: TypeString, Value: func(r *Record) any { return r.Path }},
+	{Name: "root", Type: TypeString, Value: func(r *Record) any { return r.Root }},
+	{Name: "scan_id", Type: TypeString, Value: func(r *Record) any { return r.ScanID }},
+	{Name: "type", Type: TypeString, Value: func(r *Record) any { return r.Type }},
+	{Name: "child_files", Type: TypeInt, Nullable: true, Value: func(r *Record) any { return owner(r.ChildFiles) }},
+	{Name: "child_dirs", Type: TypeInt, Nullable: true, Value: func(r *Record) any { return owner(r.ChildDirs) }},
+	{Name: "alias_of", Type: TypeString, Value: func(r *Record) any { return r.AliasOf }},
+	{Name: "path_length", Type: TypeInt, Value: func(r *Record) any { return len(r.Path) }},
+	{Name: "size", NeedsStat: true, Type: TypeInt, Value: func(r *Record) any { return r.Size }},
+	{Name: "mode", NeedsStat: true, Type: TypeString, Value: func(r *Record) any { return r.Mode }},
+	{Name: "mtime", NeedsStat: true, Type: TypeTime, Nullable: true, Value: func(r *Record) any { return r.MTime }},
+	{Name: "uid", NeedsStat: true, Type: TypeInt, Nullable: true, Value: func(r *Record) any { return owner(r.UID) }},
+	{Name: "gid", NeedsStat: true, Type: TypeInt, Nullable: true, Value: func(r *Record) any { return owner(r.GID) }},
+	{Name: "allocated", NeedsStat: true, Type: TypeInt, Nullable: true, Value: func(r *Record) any { return knownSize(r.Allocated) }},
+	{Name: "sparse", NeedsStat: true, Type: TypeBool, Value: func(r *Record) any { return r.Sparse }},
+	{Name: "hash", Type: TypeString, Value: func(r *Record) any { return r.Hash }},
+	{Name: "long_path", Type: TypeBool, Value: func(r *Record) any { return r.LongPath }},
+	{Name: "invalid_utf8", Type: TypeBool, Value: func(r *Record) any { return r.InvalidUTF8 }},
+	{Name: "case_collision", Type: TypeBool, Value: func(r *Record) any { return r.CaseCollision }},
+	{Name: "reparse", NeedsStat: true, Type: TypeString, Value: func(r *Record) any { return r.Reparse }},
+	{Name: "hard_link", NeedsStat: true, Type: TypeBool, Value: func(r *Record) any { return r.HardLink }},
+	{Name: "windows_issues", Type: TypeStrings, Value: func(r *Record) any { return r.WindowsIssues }},
+	{Name: "link_target", Type: TypeString, Value: func(r *Record) any { return r.LinkTarget }},
+	{Name: "link_status", Type: TypeString, Value: func(r *Record) any { return r.LinkStatus }},
+	{Name: "finder_flags", Type: TypeStrings, Value: func(r *Record) any { return r.FinderFlags }},
+	{Name: "quarantine", Type: TypeString, Value: func(r *Record) any { return r.Quarantine }},
+	{Name: "resource_fork", Type: TypeInt, Nullable: true, Value: func(r *Record) any { return knownSize(r.ResourceFork) }},
+	{Name: "findings", Type: TypeStrings, Value: func(r *Record) any { return r.Findings }},
+}
+
+// owner hides the -1 used for unknown owners, and for the child counts of
+// entries that aren't directories.
+func owner(id int) any {
+	if id < 0 {
+		return nil
+	}
+	return id
+}
+
+// knownSize hides the -1 used for unknown sizes.
+func knownSize(n int64) any {
+	if n < 0 {
+		return nil
+	}
+	return n
+}
+
+// DefaultColumns are written when no columns are selected explicitly.
+var DefaultColumns = []string{"file_path", "path_length"}
+
+// LookupColumn returns the column registered under name.
+func LookupColumn(name string) (Column, bool) {
+	for _, c := range builtinColumns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Column{}, false
+}
+
+// ColumnNames lists every available column name.
+func ColumnNames() []string {
+	names := make([]string, len(builtinColumns))
+	for i, c := range builtinColumns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// ParseColumns splits a comma-separated list of column names. Names are
+// resolved by Scanner.Columns, since transforms may add columns.
+func ParseColumns(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// FormatValue renders a column value as text for line-oriented sinks.
+func FormatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	case fs.FileMode:
+		return v.String()
+	case []string:
+		return strings.Join(v, "; ")
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
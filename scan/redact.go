@@ -0,0 +1,214 @@
+package scan
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UserPlaceholder replaces user names stripped from home directory paths.
+const UserPlaceholder = "USER"
+
+// homeParents are the directories holding home directories, compared
+// case-insensitively and with either separator: /home, /Users on macOS and
+// \Users on Windows, after a drive letter there.
+var homeParents = []string{"/home/", "/users/"}
+
+// redaction is a transform hiding parts of paths. The scanner applies it
+// to the paths it reports outside records too; see Scanner.RedactPath.
+type redaction TransformFunc
+
+func (f redaction) Apply(r *Record) (bool, error) { return f(r) }
+
+// HashComponents returns a transform replacing every path component below
+// the root with the first 12 hex digits of its HMAC-SHA256 under key, or
+// of its plain SHA-256 when key is empty. File extensions are kept, so
+// inventories still break down by type, and equal names hash alike, so
+// the tree's shape survives. Without a key, common names can be guessed
+// by hashing candidates.
+func HashComponents(key string) Transform {
+	hash := func(s string) string {
+		var sum []byte
+		if key == "" {
+			h := sha256.Sum256([]byte(s))
+			sum = h[:]
+		} else {
+			mac := hmac.New(sha256.New, []byte(key))
+			mac.Write([]byte(s))
+			sum = mac.Sum(nil)
+		}
+		return hex.EncodeToString(sum)[:12]
+	}
+	return redaction(func(r *Record) (bool, error) {
+		r.Path = mapComponents(r, hash)
+		return true, nil
+	})
+}
+
+// TruncateComponents returns a transform cutting every path component
+// below the root to n characters, keeping file extensions.
+func TruncateComponents(n int) Transform {
+	return redaction(func(r *Record) (bool, error) {
+		r.Path = mapComponents(r, func(s string) string {
+			if runes := []rune(s); len(runes) > n {
+				return string(runes[:n])
+			}
+			return s
+		})
+		return true, nil
+	})
+}
+
+// mapComponents applies fn to each component of r's path below its root,
+// leaving the file extension of the last one out.
+func mapComponents(r *Record, fn func(string) string) string {
+	prefix, rel := splitRoot(r.Path, r.Root)
+	parts := strings.FieldsFunc(rel, isPathSeparator)
+	if len(parts) == 0 || r.Path == r.Root {
+		return r.Path
+	}
+	var b strings.Builder
+	b.WriteString(prefix)
+	start := 0
+	for i, part := range parts {
+		// Keep the separators as they were
+		at := start + strings.Index(rel[start:], part)
+		b.WriteString(rel[start:at])
+		start = at + len(part)
+		if i == len(parts)-1 {
+			ext := filepath.Ext(part)
+			if ext == part {
+				ext = "" // A dotfile such as .bashrc
+			}
+			b.WriteString(fn(strings.TrimSuffix(part, ext)) + ext)
+			continue
+		}
+		b.WriteString(fn(part))
+	}
+	b.WriteString(rel[start:])
+	return b.String()
+}
+
+// splitRoot splits path into its root, with the separator after it, and
+// the rest. Paths outside the root are all rest.
+func splitRoot(path, root string) (prefix, rest string) {
+	if root == "" {
+		return "", path
+	}
+	for _, sep := range []string{string(filepath.Separator), "/"} {
+		base := strings.TrimSuffix(root, sep) + sep
+		if rel, ok := strings.CutPrefix(path, base); ok {
+			return base, rel
+		}
+	}
+	return "", path
+}
+
+func isPathSeparator(c rune) bool {
+	return c == '/' || c == filepath.Separator
+}
+
+// StripUsers returns a transform replacing the user name in home
+// directory paths, such as /home/alice/notes.txt, C:\Users\alice\notes.txt
+// or the current user's home wherever it is, with UserPlaceholder, in the
+// path and the root. Only paths starting with a home directory are
+// changed; /srv/home/x is kept.
+func StripUsers() Transform {
+	home, err := os.UserHomeDir()
+	if err != nil || filepath.Dir(home) == home {
+		home = ""
+	}
+	return redaction(func(r *Record) (bool, error) {
+		r.Path = stripUser(r.Path, home)
+		r.Root = stripUser(r.Root, home)
+		return true, nil
+	})
+}
+
+// stripUser replaces the user name in path when it starts with a home
+// directory: one in a homeParents directory, or home.
+func stripUser(path, home string) string {
+	slashed := strings.ReplaceAll(path, `\`, "/") // Same length, so indexes carry over
+	user, ok := userStart(slashed, strings.TrimSuffix(strings.ReplaceAll(home, `\`, "/"), "/"))
+	if !ok {
+		return path
+	}
+	next := strings.IndexByte(slashed[user:], '/')
+	if next < 0 {
+		next = len(path) - user
+	}
+	if next == 0 {
+		return path
+	}
+	return path[:user] + UserPlaceholder + path[user+next:]
+}
+
+// userStart returns where the user name starts in a slash-separated path
+// starting with a home directory.
+func userStart(path, home string) (int, bool) {
+	if home != "" && (path == home || strings.HasPrefix(path, home+"/")) {
+		return strings.LastIndex(home, "/") + 1, true
+	}
+	rest, parents := path, homeParents
+	if len(rest) >= 2 && rest[1] == ':' {
+		rest, parents = rest[2:], []string{"/users/"} // C:\Users
+	}
+	for _, parent := range parents {
+		if len(rest) > len(parent) && strings.EqualFold(rest[:len(parent)], parent) {
+			return len(path) - len(rest) + len(parent), true
+		}
+	}
+	return 0, false
+}
+
+// AliasRoots returns a transform replacing each record's root, at the
+// start of its path and in the root column, with alias. An empty alias
+// gives every root its own, "root-" and 8 hex digits of its SHA-256, so
+// records of different roots stay apart.
+func AliasRoots(alias string) Transform {
+	return redaction(func(r *Record) (bool, error) {
+		if r.Root == "" {
+			return true, nil
+		}
+		a := alias
+		if a == "" {
+			sum := sha256.Sum256([]byte(r.Root))
+			a = "root-" + hex.EncodeToString(sum[:4])
+		}
+		if prefix, rest := splitRoot(r.Path, r.Root); prefix != "" {
+			r.Path = a + prefix[len(prefix)-1:] + rest
+		} else if r.Path == r.Root {
+			r.Path = a
+		}
+		r.Root = a
+		return true, nil
+	})
+}
+
+// RedactPath applies the transforms hiding parts of paths (hash-components,
+// truncate-components, strip-users and alias-root) to path, a root or a
+// path below one. The scan does so for the paths of skipped entries it
+// passes to the WarnFunc; callers writing roots or paths elsewhere, such as
+// in a summary, should too.
+func (s *Scanner) RedactPath(path string) string {
+	// A path below no root is taken for one
+	rec := Record{Path: path, Root: path}
+	var under string
+	for _, root := range append([]string{s.root}, s.moreRoots...) {
+		if prefix, _ := splitRoot(path, root); prefix != "" && len(root) > len(under) {
+			under = root
+		}
+	}
+	if under != "" {
+		rec.Root = under
+	}
+	for _, t := range s.transforms {
+		if r, ok := t.(redaction); ok {
+			r.Apply(&rec)
+		}
+	}
+	return rec.Path
+}
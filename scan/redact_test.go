@@ -0,0 +1,61 @@
+package scan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStripUser(t *testing.T) {
+	tests := []struct{ path, home, want string }{
+		{"/home/alice/notes.txt", "", "/home/USER/notes.txt"},
+		{"/home/alice", "", "/home/USER"},
+		{"/Users/Alice/Documents", "", "/Users/USER/Documents"},
+		{`C:\Users\bob\notes.txt`, "", `C:\Users\USER\notes.txt`},
+		{"c:/users/bob", "", "c:/users/USER"},
+		{"/root/notes.txt", "/root", "/USER/notes.txt"},
+		{"/srv/staff/carol/x", "/srv/staff/carol", "/srv/staff/USER/x"},
+		{"/srv/home/bob/x", "", "/srv/home/bob/x"},
+		{"/data/users/list.csv", "", "/data/users/list.csv"},
+		{"home/alice/x", "", "home/alice/x"},
+		{"/home/", "", "/home/"},
+		{"/rootless/x", "/root", "/rootless/x"},
+	}
+	for _, tt := range tests {
+		if got := stripUser(tt.path, tt.home); got != tt.want {
+			t.Errorf("stripUser(%q, %q) = %q, want %q", tt.path, tt.home, got, tt.want)
+		}
+	}
+}
+
+func TestRedactSkippedPaths(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Symlink("loop", filepath.Join(dir, "loop")); err != nil {
+		t.Skip("no symlinks:", err)
+	}
+	var warned []string
+	_, err := New(dir, WithSink(&recordSink{}), WithTransform(AliasRoots("share")),
+		WithWarnFunc(func(path string, err error) { warned = append(warned, path+": "+err.Error()) })).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warned) != 1 {
+		t.Fatalf("warnings = %q, want one for the loop", warned)
+	}
+	if !strings.HasPrefix(warned[0], "share") || strings.Contains(warned[0], dir) {
+		t.Errorf("warning %q isn't redacted", warned[0])
+	}
+}
+
+func TestRedactPathRoots(t *testing.T) {
+	s := New("/srv/data", WithRoots("/srv/data/archive", "/mnt/other"), WithTransform(HashComponents("k")))
+	if got := s.RedactPath("/srv/data"); got != "/srv/data" {
+		t.Errorf("root redacted to %q", got)
+	}
+	got := s.RedactPath("/srv/data/archive/x.txt")
+	if !strings.HasPrefix(got, "/srv/data/archive/") || strings.Contains(got, "x.txt") {
+		t.Errorf("RedactPath = %q, want the name below the innermost root hashed", got)
+	}
+}
@@ -0,0 +1,14 @@
+package scan
+
+// Reparse point states recorded in Record.Reparse.
+const (
+	ReparsePlaceholder = "placeholder"   // Cloud file whose content is downloaded when read
+	ReparseOffline     = "offline"       // Content moved to offline storage
+	ReparsePoint       = "reparse_point" // Any other reparse point: a hydrated cloud file, symlink, junction or deduplicated file
+)
+
+// Offline reports whether reading r's content would fetch it from
+// elsewhere, as for cloud placeholders and offline files.
+func (r *Record) Offline() bool {
+	return r.Reparse == ReparsePlaceholder || r.Reparse == ReparseOffline
+}
@@ -0,0 +1,75 @@
+package scan
+
+import (
+	"io"
+	"maps"
+	"time"
+)
+
+// Result describes a finished Run, failed or not, so that embedders and
+// the command line summary read the same figures.
+type Result struct {
+	ScanID  string         `json:"scan_id,omitempty"`
+	Stats   Stats          `json:"stats"`
+	Skipped map[string]int `json:"skipped_by_class,omitempty"` // Skipped entries by ErrorClass
+	Outputs []string       `json:"outputs,omitempty"`          // Where the sinks that implement Locator wrote
+
+	// Stages holds the seconds spent walking, filtering, hashing and
+	// writing, keyed by stage. Stages run concurrently, on several
+	// workers, so they add up to more than the elapsed time.
+	Stages map[string]float64 `json:"stage_seconds,omitempty"`
+
+	Error string `json:"error,omitempty"` // Why the scan failed
+}
+
+// Locator is implemented by sinks that know where their output goes, such
+// as the path of the file they write.
+type Locator interface {
+	Location() string
+}
+
+// result builds the Result of the Run that ended with err.
+func (s *Scanner) result(err error) Result {
+	s.skipMu.Lock()
+	skipped := maps.Clone(s.skippedBy)
+	s.skipMu.Unlock()
+	res := Result{ScanID: s.runID, Stats: s.Stats(), Skipped: skipped, Outputs: sinkLocations(s.sink), Stages: make(map[string]float64)}
+	for i, name := range stageNames {
+		if i != stageHash || s.needHash {
+			res.Stages[name] = time.Duration(s.busy[i].Load()).Seconds()
+		}
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// sinkLocations lists where sink writes, looking inside a MultiSink.
+func sinkLocations(sink Sink) []string {
+	switch sink := sink.(type) {
+	case multiSink:
+		var locations []string
+		for _, s := range sink {
+			locations = append(locations, sinkLocations(s)...)
+		}
+		return locations
+	case Locator:
+		if l := sink.Location(); l != "" {
+			return []string{l}
+		}
+	}
+	return nil
+}
+
+// writerLocation names what w writes to: the destination of an
+// AtomicFile, or the name of a file. Other writers have none.
+func writerLocation(w io.Writer) string {
+	switch w := w.(type) {
+	case interface{ Path() string }:
+		return w.Path()
+	case interface{ Name() string }:
+		return w.Name()
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package scan
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy controls how filesystem calls failing with a transient error
+// (EINTR, EAGAIN, ESTALE, dropped SMB/NFS connections and the like) are
+// retried before the entry is reported as skipped.
+type RetryPolicy struct {
+	Attempts   int           // Retries after the first failure
+	Backoff    time.Duration // Delay before the first retry
+	MaxBackoff time.Duration // Cap for the doubling delay; 0 means no cap
+}
+
+// retry runs fn until it succeeds, fails with a permanent error, or the
+// policy's attempts are used up.
+func (s *Scanner) retry(ctx context.Context, fn func() error) error {
+	err := fn()
+	delay := s.retryPolicy.Backoff
+	for attempt := 0; err != nil && attempt < s.retryPolicy.Attempts && isTransient(err); attempt++ {
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		s.metrics.retried()
+		err = fn()
+		delay *= 2
+		if max := s.retryPolicy.MaxBackoff; max > 0 && delay > max {
+			delay = max
+		}
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package scan
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PrefixRewrite maps paths under From to the same paths under To.
+type PrefixRewrite struct {
+	From, To string
+}
+
+// ParsePrefixRewrite parses a mapping of the form FROM=>TO, such as
+// "/mnt/nfs/projects=>P:/projects".
+func ParsePrefixRewrite(spec string) (PrefixRewrite, error) {
+	from, to, ok := strings.Cut(spec, "=>")
+	if !ok || from == "" {
+		return PrefixRewrite{}, fmt.Errorf("bad rewrite %q (want FROM=>TO)", spec)
+	}
+	return PrefixRewrite{From: from, To: to}, nil
+}
+
+// RewritePrefixes returns a transform moving every path under a From
+// prefix to its To prefix, as when an inventory made on Linux is read on
+// Windows. Prefixes only match whole components, so /mnt/a doesn't
+// match /mnt/ab, and the longest matching From wins. When To uses
+// backslashes, the rest of the path is switched to backslashes too.
+func RewritePrefixes(rules ...PrefixRewrite) Transform {
+	rules = append([]PrefixRewrite(nil), rules...)
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].From) > len(rules[j].From) })
+	return TransformFunc(func(r *Record) (bool, error) {
+		for _, rule := range rules {
+			if p, ok := rewritePrefix(r.Path, rule); ok {
+				r.Path = p
+				break
+			}
+		}
+		return true, nil
+	})
+}
+
+func rewritePrefix(path string, rule PrefixRewrite) (string, bool) {
+	from := strings.TrimRight(rule.From, `/\`)
+	rest, ok := strings.CutPrefix(path, from)
+	if !ok || rest != "" && rest[0] != '/' && rest[0] != '\\' && from != "" {
+		return "", false
+	}
+	to := strings.TrimRight(rule.To, `/\`)
+	if strings.Contains(rule.To, `\`) {
+		rest = strings.ReplaceAll(rest, "/", `\`)
+	}
+	if to == "" && rest == "" {
+		// The prefix itself, mapped to a root such as "/"
+		return rule.To, true
+	}
+	return to + rest, true
+}
@@ -0,0 +1,178 @@
+package scan
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OutputColumns resolves column names read from an output: built-in names
+// map to their columns and anything else (transform columns) to Extra.
+func OutputColumns(names []string) []Column {
+	cols := make([]Column, len(names))
+	for i, name := range names {
+		col, ok := LookupColumn(name)
+		if !ok {
+			col = ExtraColumn(name)
+		}
+		cols[i] = col
+	}
+	return cols
+}
+
+// RecordFromRow rebuilds a Record from text values, the inverse of
+// FormatValue. Built-in columns are parsed into their typed fields;
+// others are stored in Extra as strings.
+func RecordFromRow(columns, row []string) (Record, error) {
+	rec := Record{UID: -1, GID: -1, Allocated: -1, ResourceFork: -1, ChildFiles: -1, ChildDirs: -1}
+	for i, name := range columns {
+		v := row[i]
+		var err error
+		switch name {
+		case "file_path":
+			rec.Path = v
+		case "root":
+			rec.Root = v
+		case "scan_id":
+			rec.ScanID = v
+		case "path_length":
+			// Derived from the path
+		case "type":
+			rec.Type = v
+		case "alias_of":
+			rec.AliasOf = v
+		case "child_files", "child_dirs":
+			n := -1
+			if v != "" {
+				n, err = strconv.Atoi(v)
+			}
+			if name == "child_files" {
+				rec.ChildFiles = n
+			} else {
+				rec.ChildDirs = n
+			}
+		case "size":
+			rec.Size, err = parseInt(v)
+		case "mode":
+			rec.Mode, err = ParseMode(v)
+		case "mtime":
+			if v != "" {
+				rec.MTime, err = time.Parse(time.RFC3339, v)
+				if err != nil {
+					// As written by Excel mode
+					if t, excelErr := time.Parse(ExcelTimeLayout, v); excelErr == nil {
+						rec.MTime, err = t, nil
+					}
+				}
+			}
+		case "hash":
+			rec.Hash = v
+		case "long_path":
+			rec.LongPath, err = parseBool(v)
+		case "invalid_utf8":
+			rec.InvalidUTF8, err = parseBool(v)
+		case "allocated":
+			if v != "" {
+				rec.Allocated, err = parseInt(v)
+			}
+		case "sparse":
+			rec.Sparse, err = parseBool(v)
+		case "case_collision":
+			rec.CaseCollision, err = parseBool(v)
+		case "hard_link":
+			rec.HardLink, err = parseBool(v)
+		case "windows_issues":
+			if v != "" {
+				rec.WindowsIssues = strings.Split(v, "; ")
+			}
+		case "findings":
+			if v != "" {
+				rec.Findings = strings.Split(v, "; ")
+			}
+		case "finder_flags":
+			if v != "" {
+				rec.FinderFlags = strings.Split(v, "; ")
+			}
+		case "quarantine":
+			rec.Quarantine = v
+		case "resource_fork":
+			if v != "" {
+				rec.ResourceFork, err = parseInt(v)
+			}
+		case "reparse":
+			rec.Reparse = v
+		case "link_target":
+			rec.LinkTarget = v
+		case "link_status":
+			rec.LinkStatus = v
+		case "uid", "gid":
+			id := -1
+			if v != "" {
+				id, err = strconv.Atoi(v)
+			}
+			if name == "uid" {
+				rec.UID = id
+			} else {
+				rec.GID = id
+			}
+		default:
+			rec.SetExtra(name, v)
+		}
+		if err != nil {
+			return rec, fmt.Errorf("column %s: %w", name, err)
+		}
+	}
+	return rec, nil
+}
+
+func parseInt(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+func parseBool(v string) (bool, error) {
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// modeTypeChars are the type letters fs.FileMode.String prints, from the
+// highest bit down.
+const modeTypeChars = "dalTLDpSugct?"
+
+// ParseMode parses the output of fs.FileMode.String, e.g. "drwxr-xr-x".
+func ParseMode(s string) (fs.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if len(s) < 10 {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	var m fs.FileMode
+	types, perm := s[:len(s)-9], s[len(s)-9:]
+	if types != "-" {
+		for _, c := range types {
+			i := strings.IndexRune(modeTypeChars, c)
+			if i < 0 {
+				return 0, fmt.Errorf("invalid mode %q", s)
+			}
+			m |= 1 << uint(31-i)
+		}
+	}
+	const rwx = "rwxrwxrwx"
+	for i := range perm {
+		switch perm[i] {
+		case rwx[i]:
+			m |= 1 << uint(8-i)
+		case '-':
+		default:
+			return 0, fmt.Errorf("invalid mode %q", s)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,984 @@
+// Package scan walks a directory tree and streams one Record per file to a
+// Sink in batches.
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DefaultBatchSize = 100
+
+	// Buffer allows the walker to keep scanning while workers and the sink
+	// are busy
+	pathBuffer = 1000
+)
+
+// Scanner walks a root directory, or several, and writes the files it
+// finds to a Sink. Construct one with New.
+type Scanner struct {
+	root       string
+	moreRoots  []string // Walked after root; see WithRoots
+	workers    int
+	batchSize  int
+	order      Order
+	first      PathMatch     // Files walked in a first pass, ahead of the rest
+	scanID     string        // Set with WithScanID, or empty for one per run
+	runID      string        // The ID of the current run
+	openDirs   int           // Directories listed at once; 1 walks one at a time
+	dirSlots   chan struct{} // Limits open directories when openDirs > 1
+	memLimit   int64         // Bytes the buffers are sized for; 0 for the defaults
+	hash       HashAlgorithm
+	filters    []Filter
+	columns    []string
+	transforms []Transform
+	sink       Sink
+	metrics    *Metrics
+	tracer     *Tracer
+	warn       WarnFunc
+	observers  []Observer
+	onBatch    []batchHook
+	providers  []ColumnProvider
+	escape     EscapeMode
+
+	deterministic bool
+
+	retryPolicy RetryPolicy
+
+	caseCollisions bool
+	onCollision    CollisionFunc
+
+	archives  bool
+	noHydrate bool // Don't read the content of cloud placeholders
+	noStat    bool // Make no calls beyond listing directories; see WithNoStat
+
+	includeDirs bool // Record directories as well as files
+	aliases     bool // Record directories reached again as alias rows
+
+	skipFSTypes  []string          // Patterns of filesystem types not descended into
+	snapshotDirs bool              // Walk SnapshotDirs, skipped by default
+	mounts       map[string]string // Mount points skipped, with their type
+	maxPerDir    int               // Files recorded per directory; 0 for all
+	// perDir counts the files recorded in each directory, over all passes,
+	// when maxPerDir is set
+	perDir map[string]int
+	// dirListed emits the row of each listed directory, for the root
+	// being walked, when includeDirs
+	dirListed func(path string, d fs.DirEntry, flags entryFlags, entries []fs.DirEntry)
+
+	detectors   []Detector
+	detectLimit int64
+
+	cache     *HashCache
+	cacheDirs bool // Replay unchanged directory listings from the cache
+
+	source    Source
+	sourceDir string
+
+	flushEvery    int
+	flushInterval time.Duration
+	fsync         bool
+
+	// Resolved from the selected columns at the start of Run
+	needStat   bool
+	needHash   bool
+	auditNames bool
+	needLinks  bool
+	needDetect bool
+	needMac    bool
+	roots      []*scanRoot
+	paths      pathMapper // Of the root being walked; records use their entry's
+	trace      *scanTrace
+
+	count   int64                         // Atomic counter of records written
+	dirs    int64                         // Atomic counter of directories entered
+	skipped int64                         // Atomic counter of skipped entries
+	omitted int64                         // Atomic counter of files over maxPerDir
+	busy    [len(stageNames)]atomic.Int64 // Time spent in each stage, over all workers
+
+	skipMu    sync.Mutex
+	skippedBy map[string]int // Skipped entries by ErrorClass
+	stats     Stats
+}
+
+// New returns a Scanner for root configured by opts.
+func New(root string, opts ...Option) *Scanner {
+	s := &Scanner{
+		root:       root,
+		workers:    runtime.GOMAXPROCS(0),
+		openDirs:   1,
+		batchSize:  DefaultBatchSize,
+		flushEvery: 1,
+
+		detectLimit: DefaultDetectLimit,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Stats returns the summary of the last Run. Call it after Run returns.
+func (s *Scanner) Stats() Stats {
+	st := s.stats
+	st.Dirs = atomic.LoadInt64(&s.dirs)
+	st.Skipped = atomic.LoadInt64(&s.skipped)
+	st.Truncated = atomic.LoadInt64(&s.omitted)
+	return st
+}
+
+// Count returns the number of records written so far. It is safe to call while
+// Run is in progress.
+func (s *Scanner) Count() int64 {
+	return atomic.LoadInt64(&s.count)
+}
+
+// Progress returns the records written, directories entered and entries
+// skipped so far. It is safe to call while Run is in progress.
+func (s *Scanner) Progress() (files, dirs, skipped int64) {
+	return atomic.LoadInt64(&s.count), atomic.LoadInt64(&s.dirs), atomic.LoadInt64(&s.skipped)
+}
+
+// Columns returns the columns written for each record, in order. It
+// fails for columns the configuration rules out, such as those needing a
+// stat call under WithNoStat.
+func (s *Scanner) Columns() ([]Column, error) {
+	cols, err := s.selectColumns()
+	if err == nil && s.noStat {
+		err = s.checkStatFree(cols)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cols, nil
+}
+
+func (s *Scanner) selectColumns() ([]Column, error) {
+	var computed []Column
+	for _, t := range s.transforms {
+		if ct, ok := t.(ColumnTransform); ok {
+			computed = append(computed, ct.Columns()...)
+		}
+	}
+	for _, p := range s.providers {
+		computed = append(computed, ExtraColumn(p.Name()))
+	}
+
+	// Paths from several roots only stay unambiguous with their root
+	multiRoot := len(s.moreRoots) > 0
+	if len(s.columns) == 0 {
+		cols := make([]Column, 0, len(DefaultColumns)+len(computed)+2)
+		if multiRoot {
+			col, _ := LookupColumn("root")
+			cols = append(cols, col)
+		}
+		for _, name := range DefaultColumns {
+			col, _ := LookupColumn(name)
+			cols = append(cols, col)
+		}
+		if s.hash != NoHash {
+			col, _ := LookupColumn("hash")
+			cols = append(cols, col)
+		}
+		if len(s.detectors) > 0 {
+			col, _ := LookupColumn("findings")
+			cols = append(cols, col)
+		}
+		if s.includeDirs {
+			for _, name := range []string{"type", "child_files", "child_dirs"} {
+				col, _ := LookupColumn(name)
+				cols = append(cols, col)
+			}
+		} else if s.maxPerDir > 0 || s.aliases {
+			col, _ := LookupColumn("type")
+			cols = append(cols, col)
+		}
+		if s.aliases {
+			col, _ := LookupColumn("alias_of")
+			cols = append(cols, col)
+		}
+		return append(cols, computed...), nil
+	}
+
+	// Source columns are only written when selected
+	if cs, ok := s.source.(ColumnSource); ok {
+		computed = append(computed, cs.Columns()...)
+	}
+	names := s.columns
+	if multiRoot && !slices.Contains(names, "root") {
+		names = append([]string{"root"}, names...)
+	}
+	// Directory and marker rows only stay apart from files with their type
+	if (s.includeDirs || s.maxPerDir > 0 || s.aliases) && !slices.Contains(names, "type") {
+		names = append(slices.Clip(names), "type")
+	}
+	if s.aliases && !slices.Contains(names, "alias_of") {
+		names = append(slices.Clip(names), "alias_of")
+	}
+	cols := make([]Column, 0, len(names))
+	for _, name := range names {
+		col, ok := LookupColumn(name)
+		if !ok {
+			col, ok = findColumn(computed, name)
+		}
+		if !ok {
+			available := ColumnNames()
+			for _, c := range computed {
+				available = append(available, c.Name)
+			}
+			return nil, fmt.Errorf("unknown column %q (available: %s)", name, strings.Join(available, ", "))
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+func findColumn(cols []Column, name string) (Column, bool) {
+	for _, c := range cols {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Column{}, false
+}
+
+// require turns on whatever collection col depends on.
+func (s *Scanner) require(col Column) {
+	s.needStat = s.needStat || col.NeedsStat
+	switch col.Name {
+	case "hash":
+		s.needHash = true
+	case "case_collision":
+		s.caseCollisions = true
+	case "windows_issues":
+		s.auditNames = true
+	case "link_target", "link_status":
+		s.needLinks = true
+	case "findings":
+		s.needDetect = true
+	case "finder_flags", "quarantine", "resource_fork":
+		s.needMac = true
+	}
+}
+
+// scanRoot is one of the directories a scan covers.
+type scanRoot struct {
+	root     string // As given
+	paths    pathMapper
+	realRoot string // Root with symlinks resolved, when needLinks or skipping mounts
+}
+
+// entry is a walked file waiting to be turned into a Record.
+type entry struct {
+	seq    uint64 // Walk order, used to restore it in deterministic mode
+	root   *scanRoot
+	path   string // Recorded form
+	osPath string // Form used for stat and open calls
+	d      fs.DirEntry
+	flags  entryFlags
+
+	// childFiles and childDirs count the entries of a directory's listing
+	// that pass the filters
+	childFiles, childDirs int
+	aliasOf               string // First path of an aliased directory
+}
+
+// result is a worker's outcome for one entry. Dropped and failed entries
+// are still reported so deterministic mode can advance past them.
+type result struct {
+	seq     uint64
+	rec     Record
+	keep    bool
+	members []Record // Files inside the entry when it is an archive
+}
+
+// Run performs the scan and describes how it went. Records written before
+// an error remain in the sink, and the Result covers them.
+func (s *Scanner) Run(ctx context.Context) (Result, error) {
+	err := s.run(ctx)
+	return s.result(err), err
+}
+
+func (s *Scanner) run(ctx context.Context) (err error) {
+	s.metrics.scanStarted()
+	s.stats = Stats{Start: time.Now()}
+	if s.runID = s.scanID; s.runID == "" {
+		s.runID = NewScanID()
+	}
+	s.skipMu.Lock()
+	s.skippedBy = nil
+	s.skipMu.Unlock()
+	for i := range s.busy {
+		s.busy[i].Store(0)
+	}
+	defer func() {
+		s.stats.Elapsed = time.Since(s.stats.Start)
+		s.metrics.scanFinished(err)
+		s.trace.finish(s.Stats(), err)
+		s.trace = nil
+	}()
+
+	if s.sink == nil {
+		return errors.New("scan: no sink configured")
+	}
+	columns, err := s.Columns()
+	if err != nil {
+		return err
+	}
+	for _, col := range columns {
+		s.require(col)
+	}
+	for _, o := range s.observers {
+		if r, ok := o.(Requirer); ok {
+			for _, name := range r.Requires() {
+				if col, ok := LookupColumn(name); ok {
+					s.require(col)
+				}
+			}
+		}
+	}
+	if s.needHash && s.hash == NoHash {
+		s.hash = SHA256
+	}
+	if s.needDetect && len(s.detectors) == 0 {
+		s.detectors = BuiltinDetectors()
+	}
+	s.mounts = nil
+	if len(s.skipFSTypes) > 0 {
+		if s.source != nil {
+			return errors.New("scan: filesystem types can only be skipped in local directories")
+		}
+		if s.mounts, err = skippedMounts(s.skipFSTypes); err != nil {
+			return err
+		}
+	}
+	if s.roots, err = s.scanRoots(); err != nil {
+		return err
+	}
+	if s.cache != nil && s.source == nil {
+		if s.needHash {
+			// Cached hashes are only trusted for an unchanged size and mtime
+			s.needStat = true
+		}
+		for _, r := range s.roots {
+			s.cache.addRoot(r.paths.absRoot, s.needHash, s.cacheDirs)
+		}
+	}
+	if s.noHydrate || len(s.providers) > 0 {
+		// Placeholders are told apart by their attributes, and providers
+		// are handed the info
+		s.needStat = true
+	}
+	s.stats.BytesKnown = s.needStat
+	s.fitSinks(s.sink)
+	s.dirSlots = nil
+	if s.openDirs > 1 {
+		s.dirSlots = make(chan struct{}, s.openDirs)
+	}
+	s.trace = s.tracer.startScan(s.root, s.workers, s.needHash)
+	if err := s.sink.WriteHeader(columns); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	buffer := s.bufferSize()
+	pathChan := make(chan entry, buffer)
+	resultChan := make(chan result, buffer)
+
+	// 1. Producer (walker)
+	var walkErr error
+	go func() {
+		defer close(pathChan)
+		walkErr = s.walk(ctx, pathChan)
+		s.trace.endStage(stageWalk, walkErr)
+		s.trace.endStage(stageFilter, nil)
+	}()
+
+	// 2. Workers build records, calling stat and hashing only when needed
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range pathChan {
+				rec, keep, err := s.record(ctx, e)
+				if err != nil {
+					s.warning(e.path, err)
+				}
+				res := result{seq: e.seq, rec: rec, keep: keep && err == nil}
+				if err == nil && s.archives && s.source == nil && e.d != nil && e.d.Type().IsRegular() && archiveKind(e.path) != "" &&
+					!(s.noHydrate && rec.Offline()) {
+					base := e.path
+					if !utf8.ValidString(base) {
+						base = escapeInvalidUTF8(base, s.escape)
+					}
+					// A damaged archive still keeps the members read before the damage
+					if res.members, err = s.members(ctx, e, base); err != nil && ctx.Err() == nil {
+						s.warning(e.path, err)
+					}
+				}
+				select {
+				case resultChan <- res:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		s.trace.endStage(stageHash, nil)
+		close(resultChan)
+	}()
+
+	// 3. Consumer writes batches to the sink
+	batch := make([]Record, 0, s.batchSize)
+	batches := 0 // Written since the last sink flush
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		start := time.Now()
+		err := s.sink.WriteBatch(batch)
+		s.timed(stageWrite, "write batch", start, err, "rfp.records", int64(len(batch)))
+		if err != nil {
+			return err
+		}
+		s.metrics.batchWritten(len(batch), time.Since(start))
+		for _, h := range s.onBatch {
+			if err := h.fn(batch); err != nil {
+				if h.policy == BatchAbort {
+					return fmt.Errorf("batch callback: %w", err)
+				}
+				s.stats.FailedBatches++
+			}
+		}
+		files := 0
+		for i := range batch {
+			// Directories are counted by the walker and seen by DirObservers
+			if !batch[i].IsFile() {
+				continue
+			}
+			files++
+			s.stats.add(&batch[i], s.needStat)
+			for _, o := range s.observers {
+				o.Observe(&batch[i])
+			}
+		}
+		atomic.AddInt64(&s.count, int64(files))
+		batch = batch[:0]
+		batches++
+		if s.flushEvery > 0 && batches >= s.flushEvery {
+			return s.flushSink(&batches)
+		}
+		return nil
+	}
+	// Links seen so far to files with several; later ones are flagged
+	links := make(map[fileID]struct{})
+	push := func(rec Record) {
+		// Once the scan has failed, what the workers still deliver is dropped
+		if context.Cause(ctx) != nil {
+			return
+		}
+		if rec.linked {
+			if _, seen := links[rec.fileID]; seen {
+				rec.HardLink = true
+			} else {
+				links[rec.fileID] = struct{}{}
+			}
+		}
+		batch = append(batch, rec)
+		if len(batch) >= s.batchSize {
+			if err := flush(); err != nil {
+				cancel(err)
+			}
+		}
+	}
+	add := func(res result) {
+		if res.keep {
+			push(res.rec)
+		}
+		for _, m := range res.members {
+			push(m)
+		}
+	}
+
+	// The ticker bounds how long records can sit in memory or OS buffers
+	// when the walk is slow, not just how many batches
+	var tick <-chan time.Time
+	if s.flushInterval > 0 {
+		ticker := time.NewTicker(s.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	// Deterministic mode holds early results until every earlier entry
+	// has arrived, so output follows walk order regardless of workers
+	pending := make(map[uint64]result)
+	var next uint64
+	for done := false; !done; {
+		select {
+		case res, ok := <-resultChan:
+			if !ok {
+				done = true
+				break
+			}
+			if !s.deterministic {
+				add(res)
+				continue
+			}
+			pending[res.seq] = res
+			for {
+				r, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				add(r)
+			}
+		case <-tick:
+			if context.Cause(ctx) != nil {
+				continue
+			}
+			if err := flush(); err != nil {
+				cancel(err)
+			} else if err := s.flushSink(&batches); err != nil {
+				cancel(err)
+			}
+		}
+	}
+	if context.Cause(ctx) == nil {
+		if err := flush(); err != nil {
+			cancel(err)
+		}
+	}
+	if err := s.flushSink(&batches); err != nil {
+		cancel(err)
+	}
+	// Closed even after an error so the records written stay readable
+	if err := CloseSink(s.sink); err != nil {
+		cancel(err)
+	}
+	s.trace.endStage(stageWrite, context.Cause(ctx))
+
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+	return walkErr
+}
+
+// Syncer is implemented by sinks that can commit written data to stable
+// storage, such as a file sink calling fsync.
+type Syncer interface {
+	Sync() error
+}
+
+// flushSink flushes the sink and, when fsync is enabled, syncs it.
+func (s *Scanner) flushSink(batches *int) error {
+	*batches = 0
+	if err := s.sink.Flush(); err != nil {
+		return err
+	}
+	if syncer, ok := s.sink.(Syncer); ok && s.fsync {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// scanRoots resolves the roots to walk, in order.
+func (s *Scanner) scanRoots() ([]*scanRoot, error) {
+	if s.source != nil {
+		if len(s.moreRoots) > 0 {
+			return nil, errors.New("scan: several roots need local directories")
+		}
+		return []*scanRoot{{root: s.root, paths: sourcePathMapper(s.root, s.sourceDir)}}, nil
+	}
+	var roots []*scanRoot
+	for _, root := range append([]string{s.root}, s.moreRoots...) {
+		r := &scanRoot{root: root, paths: newPathMapper(root)}
+		if s.needLinks || len(s.mounts) > 0 {
+			// Resolved from the walk root so it has the same form as the
+			// walked paths (\\?\ on Windows)
+			abs, err := filepath.Abs(r.paths.walkRoot)
+			if err != nil {
+				return nil, err
+			}
+			if r.realRoot, err = filepath.EvalSymlinks(abs); err != nil {
+				return nil, err
+			}
+		}
+		roots = append(roots, r)
+	}
+	return roots, nil
+}
+
+// walk walks the roots in turn. A directory reached again through a
+// later, overlapping root is skipped as a cycle.
+func (s *Scanner) walk(ctx context.Context, out chan<- entry) error {
+	passes := []walkPass{passAll}
+	if s.first != nil {
+		passes = []walkPass{passFirst, passRest}
+	}
+	var seq uint64
+	s.perDir = nil
+	if s.maxPerDir > 0 {
+		s.perDir = make(map[string]int)
+	}
+	for _, pass := range passes {
+		guard := newCycleGuard()
+		for _, root := range s.roots {
+			s.paths = root.paths
+			if err := s.walkRoot(ctx, root, guard, &seq, out, pass); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkRoot walks one root for a pass. The priority pass leaves problems
+// with directories to the pass after it, so they are reported once.
+func (s *Scanner) walkRoot(ctx context.Context, root *scanRoot, guard *cycleGuard, seq *uint64, out chan<- entry, pass walkPass) error {
+	s.dirListed = nil
+	if s.includeDirs && pass != passFirst {
+		// Recorded once listed, ahead of their entries, with their counts
+		s.dirListed = func(osPath string, d fs.DirEntry, flags entryFlags, entries []fs.DirEntry) {
+			e := entry{seq: *seq, root: root, path: s.paths.display(osPath), osPath: osPath, d: d, flags: flags}
+			for _, child := range entries {
+				// Only the children the scan would take count, which leaves
+				// out excluded entries and the scan's own files
+				childPath := s.paths.display(s.join(osPath, child.Name()))
+				if !s.acceptQuietly(childPath, child) || child.IsDir() && !s.snapshotDirs && isSnapshotDir(childPath) {
+					continue
+				}
+				if child.IsDir() {
+					e.childDirs++
+				} else {
+					e.childFiles++
+				}
+			}
+			out <- e
+			(*seq)++
+		}
+	}
+	// Directory entries carry their type, so no extra stat call per entry
+	return s.walkTree(ctx, s.paths.walkRoot, func(osPath string, d fs.DirEntry, flags entryFlags, err error) error {
+		path := s.paths.display(osPath)
+		if err != nil {
+			// Only an unreadable root aborts the scan; anything below it is
+			// skipped and reported
+			if d == nil || osPath == s.paths.walkRoot {
+				return err
+			}
+			if pass != passFirst {
+				s.warning(path, err)
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if pass != passFirst {
+			s.metrics.entryWalked()
+		}
+		if !s.accept(path, d) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// d.IsDir() checks the directory entry directly, no extra syscall needed
+		if d.IsDir() {
+			if osPath != s.paths.walkRoot && (s.skipMount(root, path) || !s.snapshotDirs && isSnapshotDir(path)) {
+				return filepath.SkipDir
+			}
+			// Telling directories apart takes a stat call
+			if !s.noStat {
+				if first, err := guard.enter(osPath, path, d); err != nil {
+					switch {
+					case pass == passFirst:
+					case s.aliases:
+						out <- entry{seq: *seq, root: root, path: path, osPath: osPath, d: d, flags: flagAlias, aliasOf: first}
+						(*seq)++
+					default:
+						s.warning(path, err)
+					}
+					return filepath.SkipDir
+				}
+			}
+			if pass != passFirst {
+				atomic.AddInt64(&s.dirs, 1)
+			}
+			return nil
+		}
+		if !s.takes(pass, path) {
+			return nil
+		}
+		if s.perDir != nil {
+			// The first file over the cap is replaced by the marker row
+			dir := s.dir(osPath)
+			n := s.perDir[dir]
+			s.perDir[dir] = n + 1
+			if n >= s.maxPerDir {
+				atomic.AddInt64(&s.omitted, 1)
+				if n > s.maxPerDir {
+					return nil
+				}
+				out <- entry{seq: *seq, root: root, path: s.paths.display(dir), osPath: dir, flags: flagTruncated}
+				(*seq)++
+				return nil
+			}
+		}
+		if d.Type()&fs.ModeSymlink != 0 && s.source == nil && !s.noStat {
+			if err := checkSymlink(osPath); err != nil {
+				s.warning(path, err)
+				return nil
+			}
+		}
+		out <- entry{seq: *seq, root: root, path: path, osPath: osPath, d: d, flags: flags}
+		(*seq)++
+		return nil
+	})
+}
+
+// transformError is an error returned by a transform. It isn't taken for
+// the entry vanishing even when it wraps fs.ErrNotExist.
+type transformError struct{ err error }
+
+func (e transformError) Error() string { return e.err.Error() }
+func (e transformError) Unwrap() error { return e.err }
+
+// redactedError is an error whose message has the skipped entry's path
+// redacted, as RedactPath does.
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e redactedError) Error() string { return e.msg }
+func (e redactedError) Unwrap() error { return e.err }
+
+func (s *Scanner) warning(path string, err error) {
+	var te transformError
+	if errors.Is(err, fs.ErrNotExist) && !errors.Is(err, ErrVanished) && !errors.As(err, &te) {
+		err = fmt.Errorf("%w: %w", ErrVanished, err)
+	}
+	if redacted := s.RedactPath(path); redacted != path {
+		err = redactedError{err: err, msg: strings.ReplaceAll(err.Error(), path, redacted)}
+		path = redacted
+	}
+	s.metrics.entrySkipped()
+	atomic.AddInt64(&s.skipped, 1)
+	s.skipMu.Lock()
+	if s.skippedBy == nil {
+		s.skippedBy = make(map[string]int)
+	}
+	s.skippedBy[ErrorClass(err)]++
+	s.skipMu.Unlock()
+	if s.warn != nil {
+		s.warn(path, err)
+	}
+}
+
+func (s *Scanner) accept(path string, d fs.DirEntry) bool {
+	start := time.Now()
+	for _, f := range s.filters {
+		if !f(path, d) {
+			s.timed(stageFilter, "filter entry", start, nil, "rfp.path", path, "rfp.accepted", false)
+			return false
+		}
+	}
+	s.timed(stageFilter, "filter entry", start, nil, "rfp.path", path, "rfp.accepted", true)
+	return true
+}
+
+func (s *Scanner) record(ctx context.Context, e entry) (Record, bool, error) {
+	if e.flags&(flagTruncated|flagAlias) != 0 {
+		return s.marker(e)
+	}
+	rec := Record{
+		Path:          e.path,
+		Root:          e.root.root,
+		ScanID:        s.runID,
+		LongPath:      e.root.paths.absLen(e.path) >= MaxPath,
+		CaseCollision: e.flags&flagCaseCollision != 0,
+		ResourceFork:  -1,
+		Type:          entryType(e.d.Type()),
+		ChildFiles:    -1,
+		ChildDirs:     -1,
+	}
+	if e.d.IsDir() {
+		rec.ChildFiles, rec.ChildDirs = e.childFiles, e.childDirs
+	}
+	if s.auditNames {
+		rec.WindowsIssues = WindowsNameIssues(e.root.paths.rel(e.path))
+	}
+	if !utf8.ValidString(e.path) {
+		rec.InvalidUTF8 = true
+		rec.Path = escapeInvalidUTF8(e.path, s.escape)
+	}
+	if s.source == nil {
+		rec.osPath, rec.relPath = e.osPath, e.root.paths.rel(e.path)
+	}
+	var info fs.FileInfo
+	if s.needStat {
+		// For directory entries Info is an lstat of the entry itself
+		err := s.retry(ctx, func() (err error) {
+			info, err = e.d.Info()
+			return err
+		})
+		if err != nil {
+			return rec, false, err
+		}
+		rec.Size = info.Size()
+		rec.Mode = info.Mode()
+		rec.MTime = info.ModTime()
+		rec.UID, rec.GID = -1, -1
+		if uid, gid, ok := fileOwner(info); ok {
+			rec.UID, rec.GID = uid, gid
+		}
+		rec.Allocated, rec.Sparse = Allocation(info)
+		rec.Reparse = reparseStatus(info)
+		for name, v := range sourceValues(info) {
+			rec.SetExtra(name, v)
+		}
+		if !info.IsDir() && linkCount(info) > 1 {
+			rec.fileID, rec.linked = statFileID(e.osPath, info)
+		}
+	}
+	// Only regular files have content to hash; symlinks are not followed.
+	// Placeholders aren't read when that would download them.
+	hollow := s.noHydrate && rec.Offline()
+	var cached bool
+	var key cacheKey
+	if s.needHash && e.d.Type().IsRegular() && s.cache != nil && s.source == nil {
+		key = s.cacheKey(e, info)
+		rec.Hash, cached = s.cache.lookup(key, info, s.hash)
+	}
+	if s.needHash && e.d.Type().IsRegular() && !cached && !hollow {
+		var sum string
+		var bytes int64
+		start := time.Now()
+		err := s.retry(ctx, func() (err error) {
+			var n int64
+			if s.source != nil {
+				sum, n, err = hashSource(s.source, e.osPath, s.hash)
+			} else {
+				sum, n, err = HashFile(e.osPath, s.hash)
+			}
+			s.metrics.hashed(n)
+			bytes += n
+			return err
+		})
+		s.timed(stageHash, "hash file", start, err, "rfp.path", e.path, "rfp.bytes", bytes)
+		if err != nil {
+			return rec, false, err
+		}
+		rec.Hash = sum
+		if key.path != "" {
+			s.cache.store(key, info, s.hash, sum)
+		}
+	}
+	if s.needDetect && e.d.Type().IsRegular() && !hollow {
+		err := s.retry(ctx, func() (err error) {
+			rec.Findings, err = s.detectFile(e.osPath)
+			return err
+		})
+		if err != nil {
+			return rec, false, err
+		}
+	}
+	if s.needMac && s.source == nil {
+		err := s.retry(ctx, func() (err error) {
+			rec.FinderFlags, rec.Quarantine, rec.ResourceFork, err = macMetadata(e.osPath)
+			return err
+		})
+		if err != nil {
+			return rec, false, err
+		}
+	}
+	if s.needLinks && e.d.Type()&fs.ModeSymlink != 0 {
+		err := s.retry(ctx, func() (err error) {
+			if s.source != nil {
+				// Resolving the target would cost round trips per link
+				rec.LinkTarget, err = s.source.Readlink(e.osPath)
+				return err
+			}
+			return readLink(&rec, e.osPath, e.root.realRoot)
+		})
+		if err != nil {
+			return rec, false, err
+		}
+	}
+	s.provide(&rec, info)
+	keep, err := s.transform(&rec)
+	return rec, keep, err
+}
+
+// marker builds the row standing in for the files of a directory over
+// the per-directory cap, or for a directory aliasing one already walked.
+// Transforms see it, so its path lines up with those of files.
+func (s *Scanner) marker(e entry) (Record, bool, error) {
+	rec := Record{
+		Path:         e.path,
+		Root:         e.root.root,
+		ScanID:       s.runID,
+		Type:         EntryTruncated,
+		AliasOf:      e.aliasOf,
+		UID:          -1,
+		GID:          -1,
+		Allocated:    -1,
+		ResourceFork: -1,
+		ChildFiles:   -1,
+		ChildDirs:    -1,
+	}
+	if e.flags&flagAlias != 0 {
+		rec.Type = EntryAlias
+	}
+	if !utf8.ValidString(e.path) {
+		rec.InvalidUTF8 = true
+		rec.Path = escapeInvalidUTF8(e.path, s.escape)
+	}
+	keep, err := s.transform(&rec)
+	return rec, keep, err
+}
+
+// cacheKey identifies a walked file in the hash cache.
+func (s *Scanner) cacheKey(e entry, info fs.FileInfo) cacheKey {
+	k := cacheKey{path: filepath.Join(e.root.paths.absRoot, e.root.paths.rel(e.path))}
+	if id, ok := statFileID(e.osPath, info); ok {
+		k.dev, k.ino = id.dev, id.ino
+	}
+	return k
+}
+
+// transform applies the transforms in order, stopping at the first that
+// drops the record or fails.
+func (s *Scanner) transform(rec *Record) (bool, error) {
+	for _, t := range s.transforms {
+		keep, err := t.Apply(rec)
+		if err != nil {
+			return false, transformError{err}
+		}
+		if !keep {
+			return false, nil
+		}
+	}
+	return true, nil
+}
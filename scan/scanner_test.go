@@ -0,0 +1,91 @@
+package scan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// recordSink keeps the records written to it.
+type recordSink struct {
+	records []Record
+}
+
+func (s *recordSink) WriteHeader([]Column) error { return nil }
+func (s *recordSink) Flush() error               { return nil }
+
+func (s *recordSink) WriteBatch(records []Record) error {
+	s.records = append(s.records, records...)
+	return nil
+}
+
+// writeFiles creates empty files under dir.
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMaxPerDirDotRoot(t *testing.T) {
+	for _, root := range []string{".", "./"} {
+		t.Run(root, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			writeFiles(t, ".", "a", "b", "c", "d", "sub/e")
+			sink := &recordSink{}
+			res, err := New(root, WithSink(sink), WithMaxPerDir(2), WithDeterministic(true)).Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files, markers int
+			for _, r := range sink.records {
+				switch r.Type {
+				case EntryTruncated:
+					markers++
+					if r.Path != "." && r.Path != "./" {
+						t.Errorf("marker path = %q, want the root", r.Path)
+					}
+				default:
+					files++
+				}
+			}
+			if files != 3 || markers != 1 {
+				t.Errorf("got %d files and %d markers, want 3 and 1", files, markers)
+			}
+			if res.Stats.Truncated != 2 {
+				t.Errorf("Truncated = %d, want 2", res.Stats.Truncated)
+			}
+		})
+	}
+}
+
+func TestChildCountsSkipFiltered(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.txt", "b.log", "out.csv.tmp", "sub/c.txt", "node_modules/d.js")
+	exclude, err := ExcludeNames("*.log", "node_modules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordSink{}
+	_, err = New(dir, WithSink(sink), WithIncludeDirs(true), WithDeterministic(true),
+		WithFilter(exclude), WithFilter(ExcludePaths(filepath.Join(dir, "out.csv.tmp")))).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range sink.records {
+		if r.Path == dir {
+			if r.ChildFiles != 1 || r.ChildDirs != 1 {
+				t.Errorf("root counts = %d files, %d dirs, want 1 and 1", r.ChildFiles, r.ChildDirs)
+			}
+			return
+		}
+	}
+	t.Fatal("no row for the root")
+}
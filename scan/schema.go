@@ -0,0 +1,220 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// ColumnType is the type of a column's values as the JSON sink writes
+// them. Schemas describing the output are derived from it.
+type ColumnType string
+
+const (
+	// TypeAny is for values whose type isn't known up front, such as
+	// those computed by plugins
+	TypeAny     ColumnType = ""
+	TypeString  ColumnType = "string"
+	TypeInt     ColumnType = "int"
+	TypeBool    ColumnType = "bool"
+	TypeTime    ColumnType = "time"
+	TypeStrings ColumnType = "strings"
+)
+
+// Schema formats accepted by WriteSchema.
+const (
+	SchemaJSON  = "json-schema"
+	SchemaAvro  = "avro"
+	SchemaArrow = "arrow"
+)
+
+// SchemaFormats lists the formats WriteSchema can write.
+var SchemaFormats = []string{SchemaJSON, SchemaAvro, SchemaArrow}
+
+// schemaTitle names the record type in every schema format.
+const schemaTitle = "file_paths_record"
+
+// WriteSchema writes a schema of records with the given columns, in
+// column order, to w. JSON Schema describes the JSON lines output, where
+// times are RFC 3339 strings; Avro and Arrow use their timestamp types,
+// as a Parquet conversion would. Columns of TypeAny are strings there. A
+// version above zero is recorded in the schema so consumers can tell
+// incompatible outputs apart.
+func WriteSchema(w io.Writer, format string, columns []Column, version int) error {
+	var schema any
+	switch format {
+	case SchemaJSON:
+		schema = jsonSchema(columns, version)
+	case SchemaAvro:
+		var err error
+		if schema, err = avroSchema(columns, version); err != nil {
+			return err
+		}
+	case SchemaArrow:
+		schema = arrowSchema(columns, version)
+	default:
+		return fmt.Errorf("unknown schema format %q (want one of %v)", format, SchemaFormats)
+	}
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func jsonSchema(columns []Column, version int) orderedObject {
+	properties := make(orderedObject, len(columns))
+	required := make([]string, len(columns))
+	for i, col := range columns {
+		var prop map[string]any
+		switch col.Type {
+		case TypeString:
+			prop = map[string]any{"type": "string"}
+		case TypeInt:
+			prop = map[string]any{"type": "integer"}
+		case TypeBool:
+			prop = map[string]any{"type": "boolean"}
+		case TypeTime:
+			prop = map[string]any{"type": "string", "format": "date-time"}
+		case TypeStrings:
+			prop = map[string]any{"type": "array", "items": map[string]any{"type": "string"}}
+		default:
+			prop = map[string]any{}
+		}
+		if col.Nullable && col.Type != TypeAny {
+			prop["type"] = []any{prop["type"], "null"}
+		}
+		properties[i] = keyValue{col.Name, prop}
+		required[i] = col.Name
+	}
+	schema := orderedObject{
+		{"$schema", "https://json-schema.org/draft/2020-12/schema"},
+		{"title", schemaTitle},
+	}
+	if version > 0 {
+		schema = append(schema, keyValue{"schema_version", version})
+	}
+	return append(schema,
+		keyValue{"type", "object"},
+		keyValue{"properties", properties},
+		keyValue{"required", required},
+		keyValue{"additionalProperties", false},
+	)
+}
+
+// avroName matches the names Avro allows for record fields.
+var avroName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func avroSchema(columns []Column, version int) (orderedObject, error) {
+	fields := make([]orderedObject, len(columns))
+	for i, col := range columns {
+		if !avroName.MatchString(col.Name) {
+			return nil, fmt.Errorf("column %q is not a valid Avro field name", col.Name)
+		}
+		var typ any
+		switch col.Type {
+		case TypeInt:
+			typ = "long"
+		case TypeBool:
+			typ = "boolean"
+		case TypeTime:
+			typ = map[string]any{"type": "long", "logicalType": "timestamp-millis"}
+		case TypeStrings:
+			typ = map[string]any{"type": "array", "items": "string"}
+		default:
+			typ = "string"
+		}
+		field := orderedObject{{"name", col.Name}}
+		if col.Nullable || col.Type == TypeAny {
+			field = append(field, keyValue{"type", []any{"null", typ}}, keyValue{"default", nil})
+		} else {
+			field = append(field, keyValue{"type", typ})
+		}
+		fields[i] = field
+	}
+	schema := orderedObject{
+		{"type", "record"},
+		{"name", schemaTitle},
+		{"namespace", "read_file_paths"},
+	}
+	if version > 0 {
+		schema = append(schema, keyValue{"schema_version", version})
+	}
+	return append(schema, keyValue{"fields", fields}), nil
+}
+
+func arrowSchema(columns []Column, version int) orderedObject {
+	fields := make([]orderedObject, len(columns))
+	for i, col := range columns {
+		var typ any
+		var children []orderedObject
+		switch col.Type {
+		case TypeInt:
+			typ = orderedObject{{"name", "int"}, {"bitWidth", 64}, {"isSigned", true}}
+		case TypeBool:
+			typ = orderedObject{{"name", "bool"}}
+		case TypeTime:
+			typ = orderedObject{{"name", "timestamp"}, {"unit", "MILLISECOND"}, {"timezone", "UTC"}}
+		case TypeStrings:
+			typ = orderedObject{{"name", "list"}}
+			children = append(children, orderedObject{
+				{"name", "item"},
+				{"nullable", false},
+				{"type", orderedObject{{"name", "utf8"}}},
+				{"children", []orderedObject{}},
+			})
+		default:
+			typ = orderedObject{{"name", "utf8"}}
+		}
+		if children == nil {
+			children = []orderedObject{}
+		}
+		fields[i] = orderedObject{
+			{"name", col.Name},
+			{"nullable", col.Nullable || col.Type == TypeAny},
+			{"type", typ},
+			{"children", children},
+		}
+	}
+	schema := orderedObject{{"fields", fields}}
+	if version > 0 {
+		metadata := []orderedObject{{{"key", "schema_version"}, {"value", fmt.Sprint(version)}}}
+		schema = append(schema, keyValue{"metadata", metadata})
+	}
+	return schema
+}
+
+type keyValue struct {
+	key   string
+	value any
+}
+
+// orderedObject marshals as a JSON object with its keys in order, so
+// schemas list columns as the output does.
+type orderedObject []keyValue
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, kv := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(kv.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(kv.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,166 @@
+package scan
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultDetectLimit is how much of each file detectors read by default.
+const DefaultDetectLimit = 16 << 20
+
+// binarySniff is how much of a file is checked for a NUL byte, which
+// marks it as binary and not worth searching.
+const binarySniff = 8 << 10
+
+// Detector finds one kind of sensitive content, such as credit card
+// numbers, in file contents.
+type Detector struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Valid, when set, weeds out matches of Pattern that aren't the real
+	// thing, such as numbers failing a checksum
+	Valid func(match []byte) bool
+}
+
+var builtinDetectors = []Detector{
+	{
+		Name:    "ssn",
+		Pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+		Valid:   validSSN,
+	},
+	{
+		Name:    "credit-card",
+		Pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		Valid:   validCardNumber,
+	},
+	{
+		Name:    "private-key",
+		Pattern: regexp.MustCompile(`-----BEGIN (?:RSA |DSA |EC |OPENSSH |ENCRYPTED |PGP )?PRIVATE KEY(?: BLOCK)?-----`),
+	},
+}
+
+// BuiltinDetectors returns the detectors ParseDetector knows by name.
+func BuiltinDetectors() []Detector {
+	return append([]Detector(nil), builtinDetectors...)
+}
+
+// DetectorNames lists the built-in detectors.
+func DetectorNames() []string {
+	names := make([]string, len(builtinDetectors))
+	for i, d := range builtinDetectors {
+		names[i] = d.Name
+	}
+	return names
+}
+
+// ParseDetector builds a detector from a CLI spec: a built-in name such
+// as "ssn", or NAME=REGEX for a custom rule.
+func ParseDetector(spec string) (Detector, error) {
+	name, expr, custom := strings.Cut(spec, "=")
+	if !custom {
+		for _, d := range builtinDetectors {
+			if d.Name == name {
+				return d, nil
+			}
+		}
+		return Detector{}, fmt.Errorf("unknown detector %q (available: %s, or NAME=REGEX)", name, strings.Join(DetectorNames(), ", "))
+	}
+	if name == "" || strings.ContainsAny(name, ":; ") {
+		return Detector{}, fmt.Errorf("detector %q needs a name without ':', ';' or spaces", spec)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return Detector{}, fmt.Errorf("detector %s: %w", name, err)
+	}
+	return Detector{Name: name, Pattern: re}, nil
+}
+
+// validSSN rejects numbers the SSA never issues: area 000, 666 or 9xx,
+// group 00 and serial 0000.
+func validSSN(m []byte) bool {
+	area, group, serial := string(m[0:3]), string(m[4:6]), string(m[7:11])
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// validCardNumber checks the length and Luhn checksum of a card number
+// written with or without space or dash separators.
+func validCardNumber(m []byte) bool {
+	digits := make([]byte, 0, 19)
+	for _, c := range m {
+		if c >= '0' && c <= '9' {
+			digits = append(digits, c-'0')
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i])
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// detect reads up to limit bytes from r and returns what the detectors
+// found as "name:count" entries, in detector order. Binary content finds
+// nothing.
+func detect(r io.Reader, detectors []Detector, limit int64) ([]string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(data[:min(len(data), binarySniff)], 0) >= 0 {
+		return nil, nil
+	}
+	var findings []string
+	for _, d := range detectors {
+		count := 0
+		for _, m := range d.Pattern.FindAll(data, -1) {
+			if d.Valid == nil || d.Valid(m) {
+				count++
+			}
+		}
+		if count > 0 {
+			findings = append(findings, d.Name+":"+strconv.Itoa(count))
+		}
+	}
+	return findings, nil
+}
+
+// detectFile runs the detectors over a walked file.
+func (s *Scanner) detectFile(osPath string) ([]string, error) {
+	var f io.ReadCloser
+	var err error
+	if s.source != nil {
+		f, err = s.source.Open(osPath)
+	} else {
+		f, err = os.Open(osPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return detect(f, s.detectors, s.detectLimit)
+}
+
+// ParseFindings splits a findings value back into detector names and
+// counts.
+func ParseFindings(findings []string) map[string]int {
+	counts := make(map[string]int, len(findings))
+	for _, f := range findings {
+		name, n, _ := strings.Cut(f, ":")
+		counts[name], _ = strconv.Atoi(n)
+	}
+	return counts
+}
@@ -0,0 +1,204 @@
+package scan
+
+import (
+	"encoding/csv"
+	"io"
+	"time"
+)
+
+// Sink receives the selected columns once and then batches of records as
+// the scan progresses. Rendering records is the sink's job. Sinks whose
+// format needs finishing, such as Parquet's footer, implement io.Closer;
+// Scanner.Run closes them when the scan ends.
+type Sink interface {
+	WriteHeader(columns []Column) error
+	WriteBatch(records []Record) error
+	Flush() error
+}
+
+// CSVSink writes records as CSV.
+type CSVSink struct {
+	out     io.Writer
+	w       *csv.Writer
+	columns []Column
+	row     []string
+
+	skipHeader bool
+	excel      bool
+	safe       bool
+}
+
+// ExcelTimeLayout is how Excel mode writes timestamps, in UTC: a form
+// Excel reads as a date and time, unlike RFC 3339.
+const ExcelTimeLayout = "2006-01-02 15:04:05"
+
+// utf8BOM marks a file as UTF-8 for Excel, which otherwise assumes the
+// system code page.
+const utf8BOM = "\ufeff"
+
+// NewCSVSink returns a Sink writing CSV to w.
+func NewCSVSink(w io.Writer) *CSVSink {
+	return &CSVSink{out: w, w: csv.NewWriter(w)}
+}
+
+// SkipHeader stops WriteHeader from writing the header row, for appending
+// to a file that already has one.
+func (c *CSVSink) SkipHeader() { c.skipHeader = true }
+
+// Excel makes the sink write for Microsoft Excel: a UTF-8 byte order mark
+// before the header, so non-ASCII names aren't garbled, CRLF line
+// endings, and timestamps as ExcelTimeLayout.
+func (c *CSVSink) Excel() {
+	c.excel = true
+	c.w.UseCRLF = true
+}
+
+// Safe hardens the output for spreadsheets: text values a spreadsheet
+// would run as a formula are escaped with EscapeFormula, and lines end in
+// CRLF, as RFC 4180 has it. Values the scanner generates, such as modes
+// and numbers, are left alone. A safeCSVMarker line ahead of the header
+// tells OpenOutput to undo the escaping.
+func (c *CSVSink) Safe() {
+	c.safe = true
+	c.w.UseCRLF = true
+}
+
+// Location returns the path of the file the sink writes, if any.
+func (c *CSVSink) Location() string { return writerLocation(c.out) }
+
+func (c *CSVSink) WriteHeader(columns []Column) error {
+	c.columns = columns
+	c.row = make([]string, len(columns))
+	if c.skipHeader {
+		return nil
+	}
+	if c.excel {
+		if _, err := io.WriteString(c.out, utf8BOM); err != nil {
+			return err
+		}
+	}
+	if c.safe {
+		if _, err := io.WriteString(c.out, safeCSVMarker+"\r\n"); err != nil {
+			return err
+		}
+	}
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.Name
+	}
+	return c.w.Write(header)
+}
+
+func (c *CSVSink) WriteBatch(records []Record) error {
+	for i := range records {
+		for j, col := range c.columns {
+			v := col.Value(&records[i])
+			if t, ok := v.(time.Time); ok && c.excel && !t.IsZero() {
+				c.row[j] = t.UTC().Format(ExcelTimeLayout)
+				continue
+			}
+			c.row[j] = FormatValue(v)
+			switch v.(type) {
+			case string, []string:
+				if c.safe {
+					c.row[j] = EscapeFormula(c.row[j])
+				}
+			}
+		}
+		if err := c.w.Write(c.row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CSVSink) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Sync flushes buffered rows and fsyncs the underlying writer when it
+// supports it (as *os.File does).
+func (c *CSVSink) Sync() error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	if syncer, ok := c.out.(Syncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// MultiSink writes every record to all of sinks, in order.
+func MultiSink(sinks ...Sink) Sink { return multiSink(sinks) }
+
+type multiSink []Sink
+
+func (m multiSink) WriteHeader(columns []Column) error {
+	for _, s := range m {
+		if err := s.WriteHeader(columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiSink) WriteBatch(records []Record) error {
+	for _, s := range m {
+		if err := s.WriteBatch(records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiSink) Flush() error {
+	for _, s := range m {
+		if err := s.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiSink) Sync() error {
+	for _, s := range m {
+		if syncer, ok := s.(Syncer); ok {
+			if err := syncer.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes every sink that implements io.Closer, returning the first
+// error.
+func (m multiSink) Close() error {
+	var first error
+	for _, s := range m {
+		if err := CloseSink(s); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// CloseSink closes s if it implements io.Closer, for callers writing to a
+// sink without Scanner.Run.
+func CloseSink(s Sink) error {
+	if c, ok := s.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Discard is a Sink that drops every record, for scans run only for their
+// observers.
+var Discard Sink = discard{}
+
+type discard struct{}
+
+func (discard) WriteHeader([]Column) error { return nil }
+func (discard) WriteBatch([]Record) error  { return nil }
+func (discard) Flush() error               { return nil }
@@ -0,0 +1,131 @@
+package scan
+
+import (
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Source is a tree walked in place of the local filesystem, such as a
+// directory on an SFTP server. Paths are slash-separated and absolute
+// within the source.
+type Source interface {
+	// Lstat describes path without following a final symlink
+	Lstat(path string) (fs.FileInfo, error)
+	// ReadDir lists a directory sorted by name. Info on the entries must
+	// not need another round trip where the protocol allows it.
+	ReadDir(path string) ([]fs.DirEntry, error)
+	// Open reads a file's content, for hashing and content detectors
+	Open(path string) (io.ReadCloser, error)
+	// Readlink returns the target stored in a symlink
+	Readlink(path string) (string, error)
+}
+
+// FileOwner may be returned by the Sys method of a Source's FileInfo to
+// report the file's numeric owner.
+type FileOwner struct {
+	UID, GID int
+}
+
+// ColumnSource is a Source with columns of its own, such as an object
+// store's ETag, available to select by name. The values come from
+// FileInfo.Sys returning SourceValues or SourceSys.
+type ColumnSource interface {
+	Source
+	Columns() []Column
+}
+
+// SourceValues may be returned by the Sys method of a Source's FileInfo
+// to supply the values of its columns, keyed by name.
+type SourceValues map[string]any
+
+// SourceSys may be returned by the Sys method of a Source's FileInfo that
+// reports both an owner and column values.
+type SourceSys struct {
+	Owner  *FileOwner
+	Values SourceValues
+}
+
+// SourceColumn returns a column reading name from Record.Extra, for use by
+// a ColumnSource.
+func SourceColumn(name string) Column {
+	return Column{Name: name, NeedsStat: true, Value: func(r *Record) any { return r.Extra[name] }}
+}
+
+// fileOwner returns the owner of a local or Source file. A nil *FileOwner
+// means the source did not report one.
+func fileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	var o *FileOwner
+	switch sys := info.Sys().(type) {
+	case *FileOwner:
+		o = sys
+	case *SourceSys:
+		o = sys.Owner
+	default:
+		return Owner(info)
+	}
+	if o == nil {
+		return 0, 0, false
+	}
+	return o.UID, o.GID, true
+}
+
+// sourceValues returns the column values of a Source file.
+func sourceValues(info fs.FileInfo) SourceValues {
+	switch sys := info.Sys().(type) {
+	case SourceValues:
+		return sys
+	case *SourceSys:
+		return sys.Values
+	}
+	return nil
+}
+
+// hashSource hashes a file read from a Source.
+func hashSource(src Source, p string, algo HashAlgorithm) (string, int64, error) {
+	f, err := src.Open(p)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := algo.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// sourcePathMapper records paths of a Source walked from dir under root,
+// which is usually the URL the source was opened from.
+func sourcePathMapper(root, dir string) pathMapper {
+	return pathMapper{root: root, walkRoot: dir, absRoot: root, slash: true}
+}
+
+// join appends a directory entry's name to a walked path.
+func (s *Scanner) join(dir, name string) string {
+	if s.source != nil {
+		return path.Join(dir, name)
+	}
+	return filepath.Join(dir, name)
+}
+
+// dir returns the directory of a walked path, the inverse of join.
+func (s *Scanner) dir(p string) string {
+	if s.source != nil {
+		return path.Dir(p)
+	}
+	return filepath.Dir(p)
+}
+
+// displaySlash is pathMapper.display for Source paths.
+func (m pathMapper) displaySlash(p string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(p, m.walkRoot), "/")
+	if rel == "" {
+		return m.root
+	}
+	return strings.TrimSuffix(m.root, "/") + "/" + rel
+}
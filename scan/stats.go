@@ -0,0 +1,156 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats summarizes a finished (or failed) scan.
+type Stats struct {
+	Files   int64 `json:"files"`
+	Dirs    int64 `json:"directories"`
+	Skipped int64 `json:"skipped"`
+
+	// Truncated counts files left out by a per-directory cap; see
+	// WithMaxPerDir
+	Truncated int64 `json:"truncated,omitempty"`
+
+	// FailedBatches counts batches a BatchSkip callback failed on
+	FailedBatches int64 `json:"failed_batches,omitempty"`
+
+	// Bytes is the total size of recorded files. It is only known when
+	// sizes were collected; see BytesKnown.
+	Bytes      int64 `json:"total_bytes"`
+	BytesKnown bool  `json:"total_bytes_known"`
+
+	// UniqueBytes is Bytes with hard-linked content counted once, and
+	// HardLinks the number of later links left out of it
+	UniqueBytes int64 `json:"unique_bytes"`
+	HardLinks   int64 `json:"hard_links"`
+
+	MinPathLen   int   `json:"min_path_length"`
+	MaxPathLen   int   `json:"max_path_length"`
+	TotalPathLen int64 `json:"-"`
+
+	Start   time.Time     `json:"start"`
+	Elapsed time.Duration `json:"-"`
+}
+
+// AvgPathLen returns the mean recorded path length.
+func (s Stats) AvgPathLen() float64 {
+	if s.Files == 0 {
+		return 0
+	}
+	return float64(s.TotalPathLen) / float64(s.Files)
+}
+
+// FilesPerSecond returns the recording throughput.
+func (s Stats) FilesPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Files) / s.Elapsed.Seconds()
+}
+
+func (s *Stats) add(r *Record, sized bool) {
+	n := len(r.Path)
+	if s.Files == 0 || n < s.MinPathLen {
+		s.MinPathLen = n
+	}
+	if n > s.MaxPathLen {
+		s.MaxPathLen = n
+	}
+	s.Files++
+	s.TotalPathLen += int64(n)
+	if sized {
+		s.Bytes += r.Size
+		s.UniqueBytes += r.DiskSize()
+		if r.HardLink {
+			s.HardLinks++
+		}
+	}
+}
+
+// MarshalJSON adds the derived values and renders durations in seconds.
+func (s Stats) MarshalJSON() ([]byte, error) {
+	type plain Stats
+	return json.Marshal(struct {
+		plain
+		AvgPathLen     float64 `json:"avg_path_length"`
+		ElapsedSeconds float64 `json:"elapsed_seconds"`
+		FilesPerSecond float64 `json:"files_per_second"`
+	}{plain(s), s.AvgPathLen(), s.Elapsed.Seconds(), s.FilesPerSecond()})
+}
+
+// UnmarshalJSON reads what MarshalJSON writes, recovering the elapsed
+// time and total path length from the derived values.
+func (s *Stats) UnmarshalJSON(data []byte) error {
+	type plain Stats
+	var v struct {
+		plain
+		AvgPathLen     float64 `json:"avg_path_length"`
+		ElapsedSeconds float64 `json:"elapsed_seconds"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = Stats(v.plain)
+	s.Elapsed = time.Duration(v.ElapsedSeconds * float64(time.Second))
+	s.TotalPathLen = int64(math.Round(v.AvgPathLen * float64(s.Files)))
+	return nil
+}
+
+// ParseBytes parses a byte count such as "2GiB", "512MiB", "1.5G" or
+// "750MB". Binary units (KiB, MiB, ...) and bare initials (K, M, ...) are
+// powers of 1024, decimal ones (KB, MB, ...) powers of 1000, and a plain
+// number is bytes.
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit := strings.ToUpper(strings.TrimSpace(s[i:]))
+	mult := 1.0
+	if unit != "" && unit != "B" {
+		exp := strings.IndexByte("KMGTPE", unit[0]) + 1
+		base := 1024.0
+		switch unit[1:] {
+		case "", "IB":
+		case "B":
+			base = 1000
+		default:
+			exp = 0
+		}
+		if exp == 0 {
+			return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, s[i:])
+		}
+		mult = math.Pow(base, float64(exp))
+	}
+	if n*mult >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return int64(n * mult), nil
+}
+
+// FormatBytes renders a byte count with a binary unit, e.g. "1.5 GiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
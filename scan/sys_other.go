@@ -0,0 +1,57 @@
+//go:build !unix && !windows
+
+package scan
+
+import "io/fs"
+
+// fileID identifies a file independently of the path used to reach it.
+type fileID struct {
+	dev, ino uint64
+}
+
+func statFileID(string, fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// linkCount returns 0: link counts aren't known on this platform.
+func linkCount(fs.FileInfo) uint64 {
+	return 0
+}
+
+// Allocation returns -1: allocations aren't known on this platform.
+func Allocation(fs.FileInfo) (allocated int64, sparse bool) {
+	return -1, false
+}
+
+// reparseStatus returns "": reparse points are a Windows feature.
+func reparseStatus(fs.FileInfo) string {
+	return ""
+}
+
+// Owner returns false: files have no numeric owner on this platform.
+func Owner(fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// processAlive returns true: processes can't be checked on this
+// platform, so locks are never assumed abandoned.
+func processAlive(int) bool {
+	return true
+}
+
+func isIOError(error) bool {
+	return false
+}
+
+func isSymlinkLoop(error) bool {
+	return false
+}
+
+// longPathRoot returns root unchanged; only Windows needs extended paths.
+func longPathRoot(root string) string {
+	return root
+}
+
+func isTransient(error) bool {
+	return false
+}
@@ -0,0 +1,94 @@
+//go:build unix
+
+package scan
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+)
+
+// fileID identifies a file independently of the path used to reach it.
+type fileID struct {
+	dev, ino uint64
+}
+
+func statFileID(_ string, info fs.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// linkCount returns a file's hard link count; for a directory, two more
+// than its subdirectories.
+func linkCount(info fs.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 0
+}
+
+// Allocation returns the bytes allocated to a file on disk, or -1 when
+// unknown, and whether it is sparse: a regular file whose allocation falls
+// short of its size by at least a filesystem block. Files compressed by
+// the filesystem look sparse too.
+func Allocation(info fs.FileInfo) (allocated int64, sparse bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, false
+	}
+	// Blocks counts 512-byte units whatever the filesystem block size
+	allocated = int64(st.Blocks) * 512
+	return allocated, info.Mode().IsRegular() && allocated+int64(st.Blksize) <= info.Size()
+}
+
+// reparseStatus returns "": reparse points are a Windows feature.
+func reparseStatus(fs.FileInfo) string {
+	return ""
+}
+
+// Owner returns the numeric owner and group of a file.
+func Owner(info fs.FileInfo) (uid, gid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+// processAlive reports whether a process with this ID exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// isIOError reports a device or media error.
+func isIOError(err error) bool {
+	return errors.Is(err, syscall.EIO)
+}
+
+func isSymlinkLoop(err error) bool {
+	return errors.Is(err, syscall.ELOOP)
+}
+
+// longPathRoot returns root unchanged; only Windows needs extended paths.
+func longPathRoot(root string) string {
+	return root
+}
+
+// isTransient reports errors worth retrying: interrupted or would-block
+// calls, stale NFS handles, and dropped network filesystem connections.
+func isTransient(err error) bool {
+	for _, errno := range []syscall.Errno{
+		syscall.EINTR, syscall.EAGAIN, syscall.ESTALE, syscall.ETIMEDOUT,
+		syscall.EHOSTDOWN, syscall.EHOSTUNREACH, syscall.ECONNRESET,
+		syscall.ECONNABORTED, syscall.ENOTCONN,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+//go:build windows
+
+package scan
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// fileID identifies a file independently of the path used to reach it.
+type fileID struct {
+	dev, ino uint64
+}
+
+func statFileID(path string, _ fs.FileInfo) (fileID, bool) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return fileID{}, false
+	}
+	// Backup semantics are required to open a directory handle
+	h, err := syscall.CreateFile(p, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS|syscall.FILE_FLAG_OPEN_REPARSE_POINT, 0)
+	if err != nil {
+		return fileID{}, false
+	}
+	defer syscall.CloseHandle(h)
+
+	var fi syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(h, &fi); err != nil {
+		return fileID{}, false
+	}
+	return fileID{
+		dev: uint64(fi.VolumeSerialNumber),
+		ino: uint64(fi.FileIndexHigh)<<32 | uint64(fi.FileIndexLow),
+	}, true
+}
+
+// linkCount returns 0: the link count needs a handle to the file.
+func linkCount(fs.FileInfo) uint64 {
+	return 0
+}
+
+// File attributes missing from package syscall.
+const (
+	fileAttributeSparseFile         = 0x200
+	fileAttributeOffline            = 0x1000
+	fileAttributeRecallOnOpen       = 0x40000
+	fileAttributeRecallOnDataAccess = 0x400000
+)
+
+// reparseStatus tells cloud placeholders (OneDrive, Dropbox and other
+// cloud filter drivers), whose content is fetched on first read, from
+// offline files and other reparse points.
+func reparseStatus(info fs.FileInfo) string {
+	d, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	switch {
+	case !ok:
+		return ""
+	case d.FileAttributes&(fileAttributeRecallOnOpen|fileAttributeRecallOnDataAccess) != 0:
+		return ReparsePlaceholder
+	case d.FileAttributes&fileAttributeOffline != 0:
+		return ReparseOffline
+	case d.FileAttributes&syscall.FILE_ATTRIBUTE_REPARSE_POINT != 0:
+		return ReparsePoint
+	}
+	return ""
+}
+
+// Allocation returns -1 for the allocation, which needs a handle to the
+// file, and whether the file has the sparse attribute.
+func Allocation(info fs.FileInfo) (allocated int64, sparse bool) {
+	d, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return -1, ok && d.FileAttributes&fileAttributeSparseFile != 0
+}
+
+// Owner returns false: files have no numeric owner on this platform.
+func Owner(fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// processAlive reports whether a process with this ID exists.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// Denied access still means there is a process to deny it
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	const stillActive = 259
+	return syscall.GetExitCodeProcess(h, &code) != nil || code == stillActive
+}
+
+// Windows device and media errors.
+const (
+	errorCRC            syscall.Errno = 23
+	errorSectorNotFound syscall.Errno = 27
+	errorReadFault      syscall.Errno = 30
+	errorIODevice       syscall.Errno = 1117
+)
+
+// isIOError reports a device or media error.
+func isIOError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case errorCRC, errorSectorNotFound, errorReadFault, errorIODevice:
+		return true
+	}
+	return false
+}
+
+func isSymlinkLoop(err error) bool {
+	return errors.Is(err, syscall.ELOOP)
+}
+
+// longPathRoot returns root in extended-length form (\\?\C:\... or
+// \\?\UNC\server\share\...) so that every path below it may exceed
+// MAX_PATH.
+func longPathRoot(root string) string {
+	if strings.HasPrefix(root, `\\?\`) {
+		return root
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return root
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
+
+// Sharing violations and the network errors the SMB redirector returns for
+// dropped or busy shares.
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorNetworkBusy      syscall.Errno = 54
+	errorUnexpNetErr      syscall.Errno = 59
+	errorNetnameDeleted   syscall.Errno = 64
+	errorSemTimeout       syscall.Errno = 121
+)
+
+// isTransient reports errors worth retrying: sharing violations and
+// dropped or busy network shares.
+func isTransient(err error) bool {
+	for _, errno := range []syscall.Errno{
+		errorSharingViolation, errorNetworkBusy, errorUnexpNetErr,
+		errorNetnameDeleted, errorSemTimeout,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package scan
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Thrift compact protocol types, enough for Parquet metadata.
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol. Fields are
+// written in id order; structs nest with beginStruct/endStruct.
+type thriftWriter struct {
+	buf  []byte
+	last int16
+	// Enclosing structs' last field ids
+	stack []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendUvarint(t.buf, zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.buf = binary.AppendUvarint(t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = binary.AppendUvarint(t.buf, zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.rawBinary(s)
+}
+
+func (t *thriftWriter) rawBinary(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// beginStruct starts a struct field; a zero id starts a list element.
+func (t *thriftWriter) beginStruct(id int16) {
+	if id != 0 {
+		t.field(id, thriftStruct)
+	}
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+// list starts a list field of n elements, which the caller then writes.
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xf0|elem)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+func (t *thriftWriter) rawI32(v int32) {
+	t.buf = binary.AppendUvarint(t.buf, zigzag(int64(v)))
+}
+
+func zigzag(v int64) uint64 { return uint64(v<<1) ^ uint64(v>>63) }
+
+func unzigzag(u uint64) int64 { return int64(u>>1) ^ -int64(u&1) }
+
+// thriftStructValue is a decoded struct: field id to value. Integers
+// decode as int64, binaries as []byte, lists as []any and structs as
+// thriftStructValue.
+type thriftStructValue map[int16]any
+
+func (s thriftStructValue) int(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func (s thriftStructValue) has(id int16) bool {
+	_, ok := s[id]
+	return ok
+}
+
+func (s thriftStructValue) str(id int16) string {
+	v, _ := s[id].([]byte)
+	return string(v)
+}
+
+func (s thriftStructValue) list(id int16) []any {
+	v, _ := s[id].([]any)
+	return v
+}
+
+func (s thriftStructValue) strct(id int16) thriftStructValue {
+	v, _ := s[id].(thriftStructValue)
+	return v
+}
+
+// thriftReader decodes compact protocol structs without a schema.
+type thriftReader struct {
+	r *bufio.Reader
+}
+
+// maxThriftSize bounds lengths read from a file so corrupt input can't
+// trigger huge allocations.
+const maxThriftSize = 1 << 28
+
+var errThriftCorrupt = errors.New("corrupt thrift metadata")
+
+func (t *thriftReader) readStruct() (thriftStructValue, error) {
+	s := make(thriftStructValue)
+	var last int16
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return s, nil
+		}
+		typ := b & 0x0f
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			u, err := binary.ReadUvarint(t.r)
+			if err != nil {
+				return nil, err
+			}
+			id = int16(unzigzag(u))
+		}
+		last = id
+		var v any
+		switch typ {
+		case thriftTrue:
+			v = true
+		case thriftFalse:
+			v = false
+		default:
+			if v, err = t.readValue(typ); err != nil {
+				return nil, err
+			}
+		}
+		s[id] = v
+	}
+}
+
+func (t *thriftReader) readValue(typ byte) (any, error) {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		// Inside lists a bool is a whole byte
+		b, err := t.r.ReadByte()
+		return b == thriftTrue, err
+	case thriftByte:
+		b, err := t.r.ReadByte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		u, err := binary.ReadUvarint(t.r)
+		return unzigzag(u), err
+	case thriftDouble:
+		var b [8]byte
+		if _, err := io.ReadFull(t.r, b[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), nil
+	case thriftBinary:
+		n, err := t.size()
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(t.r, b)
+		return b, err
+	case thriftList, thriftSet:
+		h, err := t.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		n := int(h >> 4)
+		if n == 15 {
+			if n, err = t.size(); err != nil {
+				return nil, err
+			}
+		}
+		items := make([]any, 0, min(n, 1024))
+		for range n {
+			v, err := t.readValue(h & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case thriftMap:
+		n, err := t.size()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		kv, err := t.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		for range 2 * n {
+			typ := kv >> 4
+			if _, err := t.readValue(typ); err != nil {
+				return nil, err
+			}
+			kv = kv<<4 | kv>>4
+		}
+		return nil, nil
+	case thriftStruct:
+		return t.readStruct()
+	default:
+		return nil, fmt.Errorf("%w: unknown type %d", errThriftCorrupt, typ)
+	}
+}
+
+func (t *thriftReader) size() (int, error) {
+	u, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return 0, err
+	}
+	if u > maxThriftSize {
+		return 0, errThriftCorrupt
+	}
+	return int(u), nil
+}
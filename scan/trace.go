@@ -0,0 +1,232 @@
+package scan
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSlowSpan is the duration from which a single directory listing,
+// filter call, hash or batch write gets a span of its own.
+const DefaultSlowSpan = 100 * time.Millisecond
+
+// maxSlowSpans bounds the per-operation spans kept from one scan, so a
+// uniformly slow disk doesn't produce a span per file.
+const maxSlowSpans = 1000
+
+// Span is a finished OpenTelemetry span.
+type Span struct {
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte // Zero for a root span
+	Name     string
+	Start    time.Time
+	End      time.Time
+	// Attributes hold string, int64, float64 or bool values
+	Attributes map[string]any
+	Err        error
+}
+
+// SpanExporter sends finished spans to a tracing backend.
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []Span) error
+}
+
+// Tracer records a trace per scan. The root "scan" span has one child per
+// stage: "walk" (listing directories), "filter", "hash" and "write" (sink
+// batches). Stages run interleaved, so each stage span covers the stage's
+// lifetime and rfp.busy_seconds gives the time actually spent in it.
+// Single operations slower than the slow threshold get child spans of
+// their stage naming the path, which is where slow storage shows up.
+//
+// Spans are buffered until Export; a Tracer may be shared by several
+// scans, each getting its own trace.
+type Tracer struct {
+	slow   time.Duration
+	parent [24]byte // Trace and span ID of a remote parent, if any
+
+	mu    sync.Mutex
+	spans []Span
+}
+
+// NewTracer returns a Tracer that gives operations taking at least slow
+// their own span. A slow of 0 uses DefaultSlowSpan.
+func NewTracer(slow time.Duration) *Tracer {
+	if slow <= 0 {
+		slow = DefaultSlowSpan
+	}
+	return &Tracer{slow: slow}
+}
+
+// SetParent makes scans children of a W3C traceparent, such as the
+// TRACEPARENT a CI pipeline passes to its steps.
+func (t *Tracer) SetParent(traceparent string) error {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	var p [24]byte
+	if _, err := hex.Decode(p[:16], []byte(parts[1])); err != nil {
+		return fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	if _, err := hex.Decode(p[16:], []byte(parts[2])); err != nil {
+		return fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	t.parent = p
+	return nil
+}
+
+// Export sends the spans recorded so far and forgets them, even when the
+// export fails.
+func (t *Tracer) Export(ctx context.Context, exp SpanExporter) error {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	return exp.ExportSpans(ctx, spans)
+}
+
+func (t *Tracer) record(sp Span) {
+	t.mu.Lock()
+	t.spans = append(t.spans, sp)
+	t.mu.Unlock()
+}
+
+// Stages of a scan.
+const (
+	stageWalk = iota
+	stageFilter
+	stageHash
+	stageWrite
+)
+
+var stageNames = [...]string{"walk", "filter", "hash", "write"}
+
+// scanTrace is the trace of one Run. Its methods do nothing on a nil
+// receiver, so the scanner calls them unconditionally.
+type scanTrace struct {
+	t      *Tracer
+	root   *activeSpan
+	stages [len(stageNames)]*stage // nil for a stage that doesn't run
+
+	slowSpans atomic.Int64
+}
+
+// stage is a stage span with the time spent in the stage so far.
+type stage struct {
+	span *activeSpan
+	busy atomic.Int64
+}
+
+// activeSpan is a span that hasn't ended.
+type activeSpan struct {
+	Span
+	mu sync.Mutex
+}
+
+// startScan starts the scan span and its stage spans. The hash stage only
+// runs when hashing.
+func (t *Tracer) startScan(root string, workers int, hashing bool) *scanTrace {
+	if t == nil {
+		return nil
+	}
+	st := &scanTrace{t: t}
+	st.root = &activeSpan{Span: Span{Name: "scan", Start: time.Now()}}
+	copy(st.root.TraceID[:], t.parent[:16])
+	copy(st.root.ParentID[:], t.parent[16:])
+	if st.root.TraceID == ([16]byte{}) {
+		rand.Read(st.root.TraceID[:])
+	}
+	rand.Read(st.root.SpanID[:])
+	st.root.set("rfp.root", root)
+	st.root.set("rfp.workers", int64(workers))
+	for i, name := range stageNames {
+		if i != stageHash || hashing {
+			st.stages[i] = &stage{span: childSpan(st.root, name, st.root.Start)}
+		}
+	}
+	return st
+}
+
+// child starts a span under parent.
+func childSpan(parent *activeSpan, name string, start time.Time) *activeSpan {
+	sp := &activeSpan{Span: Span{TraceID: parent.TraceID, ParentID: parent.SpanID, Name: name, Start: start}}
+	rand.Read(sp.SpanID[:])
+	return sp
+}
+
+// endStage ends a stage span.
+func (st *scanTrace) endStage(i int, err error) {
+	if st == nil || st.stages[i] == nil {
+		return
+	}
+	sg := st.stages[i]
+	sg.span.set("rfp.busy_seconds", time.Duration(sg.busy.Load()).Seconds())
+	st.end(sg.span, err)
+}
+
+// timed adds the time since start to a stage and, when it is slow, records
+// an operation span with attrs given as key, value pairs.
+func (st *scanTrace) timed(i int, name string, start time.Time, err error, attrs ...any) {
+	if st == nil || st.stages[i] == nil {
+		return
+	}
+	sg := st.stages[i]
+	d := time.Since(start)
+	sg.busy.Add(int64(d))
+	if d < st.t.slow || st.slowSpans.Add(1) > maxSlowSpans {
+		return
+	}
+	sp := childSpan(sg.span, name, start)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		sp.set(attrs[i].(string), attrs[i+1])
+	}
+	st.end(sp, err)
+}
+
+// timed adds the time since start to stage i of the scan, and hands the
+// operation on to the trace.
+func (s *Scanner) timed(i int, name string, start time.Time, err error, attrs ...any) {
+	s.busy[i].Add(int64(time.Since(start)))
+	s.trace.timed(i, name, start, err, attrs...)
+}
+
+// finish ends the scan span with the scan's totals.
+func (st *scanTrace) finish(stats Stats, err error) {
+	if st == nil {
+		return
+	}
+	st.root.set("rfp.files", stats.Files)
+	st.root.set("rfp.directories", stats.Dirs)
+	st.root.set("rfp.skipped", stats.Skipped)
+	if n := st.slowSpans.Load() - maxSlowSpans; n > 0 {
+		st.root.set("rfp.slow_spans_dropped", n)
+	}
+	st.end(st.root, err)
+}
+
+func (st *scanTrace) end(sp *activeSpan, err error) {
+	sp.mu.Lock()
+	sp.End = time.Now()
+	sp.Err = err
+	done := sp.Span
+	sp.mu.Unlock()
+	st.t.record(done)
+}
+
+func (sp *activeSpan) set(key string, v any) {
+	sp.mu.Lock()
+	if sp.Attributes == nil {
+		sp.Attributes = make(map[string]any)
+	}
+	sp.Attributes[key] = v
+	sp.mu.Unlock()
+}
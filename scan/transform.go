@@ -0,0 +1,122 @@
+package scan
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Transform modifies a record between the walker and the sink. Returning
+// false drops the record. Transforms run in the order they were added.
+type Transform interface {
+	Apply(r *Record) (keep bool, err error)
+}
+
+// TransformFunc adapts a plain function to Transform.
+type TransformFunc func(r *Record) (bool, error)
+
+func (f TransformFunc) Apply(r *Record) (bool, error) { return f(r) }
+
+// ColumnTransform is a Transform that adds computed values to Record.Extra.
+// Its columns become selectable by name and are appended to the default
+// column set.
+type ColumnTransform interface {
+	Transform
+	Columns() []Column
+}
+
+// ExtraColumn returns a column reading r.Extra[name].
+func ExtraColumn(name string) Column {
+	return Column{Name: name, Value: func(r *Record) any { return r.Extra[name] }}
+}
+
+type computed struct {
+	name string
+	fn   func(r *Record) any
+}
+
+func (c computed) Apply(r *Record) (bool, error) {
+	r.SetExtra(c.name, c.fn(r))
+	return true, nil
+}
+
+func (c computed) Columns() []Column { return []Column{ExtraColumn(c.name)} }
+
+// ComputedColumn returns a transform storing fn(r) under name in every
+// record.
+func ComputedColumn(name string, fn func(r *Record) any) ColumnTransform {
+	return computed{name: name, fn: fn}
+}
+
+// StripPrefix removes prefix from the start of every path.
+func StripPrefix(prefix string) Transform {
+	return TransformFunc(func(r *Record) (bool, error) {
+		r.Path = strings.TrimPrefix(r.Path, prefix)
+		return true, nil
+	})
+}
+
+// transformFactories builds named transforms from CLI specs of the form
+// NAME or NAME=ARG.
+var transformFactories = map[string]func(arg string) (Transform, error){
+	"strip-prefix": func(arg string) (Transform, error) {
+		if arg == "" {
+			return nil, fmt.Errorf("strip-prefix requires a prefix")
+		}
+		return StripPrefix(arg), nil
+	},
+	"ext": func(string) (Transform, error) {
+		return ComputedColumn("ext", func(r *Record) any {
+			return strings.ToLower(filepath.Ext(r.Path))
+		}), nil
+	},
+	"clean":           func(string) (Transform, error) { return CleanPaths(), nil },
+	"realpath":        func(string) (Transform, error) { return RealPaths(), nil },
+	"relative":        func(string) (Transform, error) { return RelativePaths(), nil },
+	"hash-components": func(key string) (Transform, error) { return HashComponents(key), nil },
+	"truncate-components": func(arg string) (Transform, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("truncate-components requires a length of at least 1")
+		}
+		return TruncateComponents(n), nil
+	},
+	"rewrite": func(arg string) (Transform, error) {
+		rule, err := ParsePrefixRewrite(arg)
+		if err != nil {
+			return nil, err
+		}
+		return RewritePrefixes(rule), nil
+	},
+	"strip-users": func(string) (Transform, error) { return StripUsers(), nil },
+	"alias-root":  func(alias string) (Transform, error) { return AliasRoots(alias), nil },
+	"trash":       func(string) (Transform, error) { return TagTrash(), nil },
+	"depth": func(string) (Transform, error) {
+		return ComputedColumn("depth", func(r *Record) any {
+			return strings.Count(filepath.ToSlash(r.Path), "/")
+		}), nil
+	},
+}
+
+// ParseTransform builds a named transform from a spec such as
+// "strip-prefix=/mnt/data".
+func ParseTransform(spec string) (Transform, error) {
+	name, arg, _ := strings.Cut(spec, "=")
+	factory, ok := transformFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown transform %q (available: %s)", name, strings.Join(TransformNames(), ", "))
+	}
+	return factory(arg)
+}
+
+// TransformNames lists the transforms available to ParseTransform.
+func TransformNames() []string {
+	names := make([]string, 0, len(transformFactories))
+	for name := range transformFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
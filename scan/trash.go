@@ -0,0 +1,47 @@
+package scan
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// TrashNames match the directories operating systems keep deleted files
+// and recovered fragments in: the macOS .Trash and per-volume .Trashes,
+// the freedesktop.org .Trash-UID of removable media, the Windows recycle
+// bins, and lost+found.
+var TrashNames = []string{".Trash", ".Trashes", ".Trash-*", "$RECYCLE.BIN", "$Recycle.Bin", "RECYCLER", "lost+found"}
+
+// TrashColumn is the column TagTrash adds.
+const TrashColumn = "trash"
+
+// InTrash reports whether path is a trash directory or lies inside one.
+func InTrash(path string) bool {
+	for _, name := range strings.FieldsFunc(path, isPathSeparator) {
+		for _, p := range TrashNames {
+			if ok, _ := filepath.Match(p, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TagTrash returns a transform setting the trash column of every record,
+// true for those InTrash. Apply it before transforms that hide path
+// components.
+func TagTrash() ColumnTransform {
+	return trashTagger{}
+}
+
+type trashTagger struct{}
+
+func (trashTagger) Apply(r *Record) (bool, error) {
+	r.SetExtra(TrashColumn, InTrash(r.Path))
+	return true, nil
+}
+
+func (trashTagger) Columns() []Column {
+	col := ExtraColumn(TrashColumn)
+	col.Type = TypeBool
+	return []Column{col}
+}
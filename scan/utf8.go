@@ -0,0 +1,59 @@
+package scan
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// EscapeMode controls how paths that are not valid UTF-8 are recorded.
+type EscapeMode string
+
+const (
+	// EscapeRaw records the original bytes unchanged.
+	EscapeRaw EscapeMode = "raw"
+	// EscapePercent replaces each invalid byte with %XX. Literal '%' is
+	// escaped too so the original bytes can be recovered.
+	EscapePercent EscapeMode = "percent"
+	// EscapeHex replaces each invalid byte with \xXX.
+	EscapeHex EscapeMode = "hex"
+)
+
+// ParseEscapeMode converts a name such as "percent" to an EscapeMode.
+func ParseEscapeMode(name string) (EscapeMode, error) {
+	switch mode := EscapeMode(strings.ToLower(name)); mode {
+	case EscapeRaw, EscapePercent, EscapeHex:
+		return mode, nil
+	case "":
+		return EscapeRaw, nil
+	default:
+		return EscapeRaw, fmt.Errorf("unsupported escape mode %q (want raw, percent or hex)", name)
+	}
+}
+
+// escapeInvalidUTF8 rewrites the invalid bytes of path according to mode.
+// Valid input is returned unchanged.
+func escapeInvalidUTF8(path string, mode EscapeMode) string {
+	if mode == EscapeRaw || mode == "" {
+		return path
+	}
+	var b strings.Builder
+	b.Grow(len(path) + 8)
+	for i := 0; i < len(path); {
+		r, size := utf8.DecodeRuneInString(path[i:])
+		switch {
+		case r == utf8.RuneError && size <= 1:
+			if mode == EscapePercent {
+				fmt.Fprintf(&b, "%%%02X", path[i])
+			} else {
+				fmt.Fprintf(&b, `\x%02X`, path[i])
+			}
+		case r == '%' && mode == EscapePercent:
+			b.WriteString("%25")
+		default:
+			b.WriteString(path[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
@@ -0,0 +1,379 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// entryFlags carries facts about an entry that are only visible while its
+// parent directory is being listed.
+type entryFlags uint8
+
+const (
+	// flagCaseCollision marks an entry with a sibling whose name differs
+	// only by case.
+	flagCaseCollision entryFlags = 1 << iota
+	// flagTruncated marks the entry standing in for the files of its
+	// directory over the per-directory cap; its path is the directory's.
+	flagTruncated
+	// flagAlias marks a directory already walked through another path,
+	// recorded as an alias row instead of being walked again
+	flagAlias
+)
+
+// walkFunc is fs.WalkDirFunc plus the entry's flags.
+type walkFunc func(path string, d fs.DirEntry, flags entryFlags, err error) error
+
+// Order is the order the walker visits entries in. Either way, the entries
+// of a directory are visited in lexical order.
+type Order string
+
+const (
+	// DepthFirst walks each directory's subtree before its next sibling,
+	// as filepath.WalkDir does
+	DepthFirst Order = "dfs"
+	// BreadthFirst walks every entry at one depth before going deeper,
+	// which surfaces the top of a tree first. The directories waiting to
+	// be listed are held in memory.
+	BreadthFirst Order = "bfs"
+)
+
+// ParseOrder converts a name such as "bfs" to an Order.
+func ParseOrder(name string) (Order, error) {
+	switch order := Order(strings.ToLower(name)); order {
+	case DepthFirst, BreadthFirst:
+		return order, nil
+	case "":
+		return DepthFirst, nil
+	default:
+		return DepthFirst, fmt.Errorf("unsupported order %q (want dfs or bfs)", name)
+	}
+}
+
+// walkTree walks root like filepath.WalkDir, calling fn for every entry in
+// lexical order, but reads directories through readDir so transient errors
+// can be retried. BreadthFirst scanners walk it level by level instead.
+func (s *Scanner) walkTree(ctx context.Context, root string, fn walkFunc) error {
+	var info fs.FileInfo
+	err := s.retry(ctx, func() (err error) {
+		if s.source != nil {
+			info, err = s.source.Lstat(root)
+		} else {
+			info, err = os.Lstat(root)
+		}
+		return err
+	})
+	switch {
+	case err != nil:
+		err = fn(root, nil, 0, err)
+	case s.order == BreadthFirst:
+		err = s.walkLevels(ctx, root, fs.FileInfoToDirEntry(info), fn)
+	default:
+		err = s.walkDir(ctx, root, fs.FileInfoToDirEntry(info), 0, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (s *Scanner) walkDir(ctx context.Context, path string, d fs.DirEntry, flags entryFlags, fn walkFunc) error {
+	if err := fn(path, d, flags, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	return s.descend(ctx, path, d, flags, fn, nil)
+}
+
+// listing is a directory listing read ahead of the walker.
+type listing struct {
+	entries []fs.DirEntry
+	err     error
+	done    chan struct{}
+}
+
+// open lists a directory fn has accepted, unless ahead holds its listing
+// already, and returns its entries with a function giving their flags. A
+// read error goes to fn, and whatever fn returns for it to the caller.
+func (s *Scanner) open(ctx context.Context, path string, d fs.DirEntry, flags entryFlags, fn walkFunc, ahead *listing) ([]fs.DirEntry, func(i int) entryFlags, error) {
+	var entries []fs.DirEntry
+	var err error
+	if ahead != nil {
+		<-ahead.done
+		entries, err = ahead.entries, ahead.err
+	} else {
+		entries, err = s.listDir(ctx, path, d)
+	}
+	if err != nil && ctx.Err() != nil {
+		// Cancelled, not unreadable
+		return nil, nil, context.Cause(ctx)
+	}
+	if err == nil {
+		s.observeDir(path, d, entries)
+		if s.dirListed != nil {
+			s.dirListed(path, d, flags, entries)
+		}
+	} else {
+		// Second call reports the read error; entries read before it are
+		// still walked
+		if err = fn(path, d, flags, err); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var childFlags []entryFlags
+	if s.caseCollisions {
+		childFlags = s.findCaseCollisions(path, entries)
+	}
+	flagsOf := func(i int) entryFlags {
+		if childFlags == nil {
+			return 0
+		}
+		return childFlags[i]
+	}
+	return entries, flagsOf, nil
+}
+
+// descend walks the entries of a directory fn has accepted, depth first.
+func (s *Scanner) descend(ctx context.Context, path string, d fs.DirEntry, flags entryFlags, fn walkFunc, ahead *listing) error {
+	entries, flagsOf, err := s.open(ctx, path, d, flags, fn, ahead)
+	if err != nil {
+		if err == filepath.SkipDir {
+			err = nil
+		}
+		return err
+	}
+
+	// Subdirectories fn has accepted ahead of the walker, with their
+	// listings; nil ones were skipped. Listings still being read are
+	// waited for, so none outlives the walk of its root.
+	var aheads map[int]*listing
+	next := 0
+	defer func() {
+		for _, l := range aheads {
+			if l != nil {
+				<-l.done
+			}
+		}
+	}()
+
+	for i, child := range entries {
+		if s.dirSlots != nil {
+			if next <= i {
+				next = i + 1
+			}
+			for ; next < len(entries) && len(aheads) < s.openDirs; next++ {
+				sibling := entries[next]
+				if !sibling.IsDir() {
+					continue
+				}
+				if aheads == nil {
+					aheads = make(map[int]*listing)
+				}
+				siblingPath := s.join(path, sibling.Name())
+				if err := fn(siblingPath, sibling, flagsOf(next), nil); err != nil {
+					if err != filepath.SkipDir {
+						return err
+					}
+					aheads[next] = nil
+					continue
+				}
+				aheads[next] = s.readAhead(ctx, siblingPath, sibling)
+			}
+		}
+
+		childPath := s.join(path, child.Name())
+		var err error
+		if l, ok := aheads[i]; ok {
+			delete(aheads, i)
+			if l != nil {
+				err = s.descend(ctx, childPath, child, flagsOf(i), fn, l)
+			}
+		} else {
+			err = s.walkDir(ctx, childPath, child, flagsOf(i), fn)
+		}
+		if err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// walkLevels walks root breadth first: fn sees every entry of a
+// directory, and accepts its subdirectories, before any of them is
+// listed.
+func (s *Scanner) walkLevels(ctx context.Context, root string, d fs.DirEntry, fn walkFunc) error {
+	if err := fn(root, d, 0, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	type queued struct {
+		path  string
+		d     fs.DirEntry
+		flags entryFlags
+		ahead *listing
+	}
+	queue := []*queued{{path: root, d: d}}
+	defer func() {
+		// No listing outlives the walk of its root
+		for _, q := range queue {
+			if q.ahead != nil {
+				<-q.ahead.done
+			}
+		}
+	}()
+	for len(queue) > 0 {
+		if s.dirSlots != nil {
+			for _, q := range queue[:min(len(queue), s.openDirs)] {
+				if q.ahead == nil {
+					q.ahead = s.readAhead(ctx, q.path, q.d)
+				}
+			}
+		}
+		dir := queue[0]
+		queue = queue[1:]
+		entries, flagsOf, err := s.open(ctx, dir.path, dir.d, dir.flags, fn, dir.ahead)
+		if err == filepath.SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for i, child := range entries {
+			childPath := s.join(dir.path, child.Name())
+			if err := fn(childPath, child, flagsOf(i), nil); err != nil {
+				if err != filepath.SkipDir {
+					return err
+				}
+				if !child.IsDir() {
+					// Skips the rest of the directory, as in WalkDir
+					break
+				}
+				continue
+			}
+			if child.IsDir() {
+				queue = append(queue, &queued{path: childPath, d: child, flags: flagsOf(i)})
+			}
+		}
+	}
+	return nil
+}
+
+// readAhead lists a directory in the background.
+func (s *Scanner) readAhead(ctx context.Context, path string, d fs.DirEntry) *listing {
+	l := &listing{done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		l.entries, l.err = s.listDir(ctx, path, d)
+	}()
+	return l
+}
+
+// observeDir passes a listed directory to the DirObservers.
+func (s *Scanner) observeDir(osPath string, d fs.DirEntry, entries []fs.DirEntry) {
+	var rec *DirRecord
+	for _, o := range s.observers {
+		if do, ok := o.(DirObserver); ok {
+			if rec == nil {
+				rec = &DirRecord{Path: s.paths.display(osPath), Entries: len(entries), d: d, noStat: s.noStat}
+			}
+			do.ObserveDir(rec)
+		}
+	}
+}
+
+// listDir lists a directory, replaying its previous listing from the
+// cache when its mtime and link count show it unchanged.
+func (s *Scanner) listDir(ctx context.Context, path string, d fs.DirEntry) ([]fs.DirEntry, error) {
+	if !s.cacheDirs || s.source != nil {
+		return s.readDir(ctx, path)
+	}
+	// Stat'ed before listing, so a change made meanwhile misses next time
+	info, err := d.Info()
+	if err != nil {
+		return s.readDir(ctx, path)
+	}
+	k := cacheKey{path: filepath.Join(s.paths.absRoot, s.paths.rel(s.paths.display(path)))}
+	if id, ok := statFileID(path, info); ok {
+		k.dev, k.ino = id.dev, id.ino
+	}
+	if entries, ok := s.cache.lookupDir(k, info, path); ok {
+		return entries, nil
+	}
+	entries, err := s.readDir(ctx, path)
+	if err == nil {
+		s.cache.storeDir(k, info, entries)
+	}
+	return entries, err
+}
+
+// readDir lists a directory sorted by name, retrying transient failures.
+func (s *Scanner) readDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	if s.dirSlots != nil {
+		select {
+		case s.dirSlots <- struct{}{}:
+			defer func() { <-s.dirSlots }()
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+	var entries []fs.DirEntry
+	start := time.Now()
+	err := s.retry(ctx, func() (err error) {
+		if s.source != nil {
+			entries, err = s.source.ReadDir(path)
+		} else {
+			entries, err = os.ReadDir(path)
+		}
+		return err
+	})
+	// The displayed path is only worth building for the trace
+	s.busy[stageWalk].Add(int64(time.Since(start)))
+	if s.trace != nil {
+		s.trace.timed(stageWalk, "readdir", start, err, "rfp.path", s.paths.display(path), "rfp.entries", int64(len(entries)))
+	}
+	return entries, err
+}
+
+// findCaseCollisions flags entries whose names differ only by case and
+// reports each colliding group.
+func (s *Scanner) findCaseCollisions(dir string, entries []fs.DirEntry) []entryFlags {
+	groups := make(map[string][]int, len(entries))
+	for i, e := range entries {
+		key := strings.ToLower(e.Name())
+		groups[key] = append(groups[key], i)
+	}
+	if len(groups) == len(entries) {
+		return nil
+	}
+
+	flags := make([]entryFlags, len(entries))
+	for i, e := range entries {
+		group := groups[strings.ToLower(e.Name())]
+		if len(group) < 2 {
+			continue
+		}
+		flags[i] |= flagCaseCollision
+		// Report each group once, from its first member
+		if group[0] == i && s.onCollision != nil {
+			names := make([]string, len(group))
+			for j, idx := range group {
+				names[j] = entries[idx].Name()
+			}
+			s.onCollision(s.paths.display(dir), names)
+		}
+	}
+	return flags
+}
@@ -0,0 +1,200 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Change events written by Watch in the "event" column.
+const (
+	EventCreate = "create"
+	EventModify = "modify"
+	EventDelete = "delete"
+)
+
+// EventColumn is the column Watch appends to the selected columns.
+var EventColumn = ExtraColumn("event")
+
+// snapshot is an observer holding every record of one scan by path.
+type snapshot map[string]Record
+
+func (s snapshot) Observe(r *Record) { s[r.Path] = *r }
+
+// Requires turns on the stat fields used to detect modifications.
+func (s snapshot) Requires() []string { return []string{"size", "mode", "mtime"} }
+
+// watchSettle is how long Watch lets a burst of notified changes, such as
+// a large copy, go on before rescanning.
+const watchSettle = time.Second
+
+// notifier is told by the operating system of changes in the directories
+// added to it, and signals events after any of them. Signals coalesce.
+type notifier interface {
+	add(dir string) error
+	events() <-chan struct{}
+	close() error
+}
+
+// watchedDirs is an observer adding every directory a scan lists to a
+// notifier, keeping the first error. A directory gone since it was listed
+// isn't one: its parent's watch sees it go.
+type watchedDirs struct {
+	n   notifier
+	mu  sync.Mutex
+	err error
+}
+
+func (w *watchedDirs) Observe(*Record) {}
+
+func (w *watchedDirs) ObserveDir(d *DirRecord) {
+	if err := w.n.add(d.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		w.mu.Lock()
+		if w.err == nil {
+			w.err = err
+		}
+		w.mu.Unlock()
+	}
+}
+
+// Watch turns the scanner into a live inventory feed. It rescans the tree
+// when it changes and writes the records that were created, modified or
+// deleted since the previous scan to the sink, with an "event" column
+// after the selected ones. Size, mode and mtime (and the hash, when
+// hashing) are compared between scans. The first scan only sets the
+// baseline. Watch runs until ctx is cancelled, which is not an error.
+//
+// Where NotifyChanges holds, every directory scanned is watched for
+// changes, and a rescan follows watchSettle after the first one notified.
+// Elsewhere, for remote sources, or once the system runs out of watches
+// (reported to the WarnFunc), the tree is polled with a rescan every
+// interval instead.
+func (s *Scanner) Watch(ctx context.Context, interval time.Duration) error {
+	out := s.sink
+	if out == nil {
+		return errors.New("scan: no sink configured")
+	}
+	columns, err := s.Columns()
+	if err != nil {
+		return err
+	}
+	if err := out.WriteHeader(append(columns, EventColumn)); err != nil {
+		return err
+	}
+
+	observers := s.observers
+	s.sink = Discard
+	defer func() {
+		s.sink = out
+		s.observers = observers
+	}()
+
+	var n notifier
+	if s.source == nil {
+		// Without notifications, polling is all there is
+		n, _ = newNotifier()
+	}
+	stopNotify := func(err error) {
+		n.close()
+		n = nil
+		if s.warn != nil {
+			s.warn(s.root, fmt.Errorf("watching for changes: %w; polling every %s instead", err, interval))
+		}
+	}
+	defer func() {
+		if n != nil {
+			n.close()
+		}
+	}()
+
+	var prev snapshot
+	for {
+		cur := make(snapshot)
+		s.observers = append(observers[:len(observers):len(observers)], cur)
+		var dirs *watchedDirs
+		if n != nil {
+			dirs = &watchedDirs{n: n}
+			s.observers = append(s.observers, dirs)
+		}
+		if _, err := s.Run(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if dirs != nil && dirs.err != nil {
+			stopNotify(dirs.err)
+		}
+		if prev != nil {
+			if events := diffSnapshots(prev, cur); len(events) > 0 {
+				if err := out.WriteBatch(events); err != nil {
+					return err
+				}
+				if err := out.Flush(); err != nil {
+					return err
+				}
+				if syncer, ok := out.(Syncer); ok && s.fsync {
+					if err := syncer.Sync(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		prev = cur
+
+		if n == nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-n.events():
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(watchSettle):
+		}
+		// Changes in the meantime are seen by the rescan
+		select {
+		case <-n.events():
+		default:
+		}
+	}
+}
+
+// diffSnapshots returns the changes from prev to cur, sorted by path.
+func diffSnapshots(prev, cur snapshot) []Record {
+	var events []Record
+	event := func(r Record, kind string) {
+		r.Extra = maps.Clone(r.Extra)
+		r.SetExtra("event", kind)
+		events = append(events, r)
+	}
+	for path, r := range cur {
+		old, ok := prev[path]
+		switch {
+		case !ok:
+			event(r, EventCreate)
+		case r.Size != old.Size || r.Mode != old.Mode || !r.MTime.Equal(old.MTime) || r.Hash != old.Hash:
+			event(r, EventModify)
+		}
+	}
+	for path, r := range prev {
+		if _, ok := cur[path]; !ok {
+			event(r, EventDelete)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+	return events
+}
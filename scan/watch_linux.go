@@ -0,0 +1,61 @@
+package scan
+
+import (
+	"os"
+	"syscall"
+)
+
+// NotifyChanges reports whether Watch is told of changes by the operating
+// system, through inotify here, rather than polling.
+const NotifyChanges = true
+
+// inotifyMask is what a directory is watched for: anything that changes
+// its listing or the size, mode or mtime of an entry.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+// inotify is the notifier on Linux. Watches are per directory, and go
+// away by themselves with the directory.
+type inotify struct {
+	fd      int
+	f       *os.File // fd, registered with the runtime poller so Close ends a pending Read
+	changed chan struct{}
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	n := &inotify{fd: fd, f: os.NewFile(uintptr(fd), "inotify"), changed: make(chan struct{}, 1)}
+	go n.read()
+	return n, nil
+}
+
+// read signals changed for every batch of events until the notifier is
+// closed. Which entries changed doesn't matter, since the next scan finds
+// out, and a queue overflow is as good as any event.
+func (n *inotify) read() {
+	buf := make([]byte, 64<<10)
+	for {
+		if _, err := n.f.Read(buf); err != nil {
+			return
+		}
+		select {
+		case n.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *inotify) add(dir string) error {
+	_, err := syscall.InotifyAddWatch(n.fd, dir, inotifyMask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	return nil
+}
+
+func (n *inotify) events() <-chan struct{} { return n.changed }
+
+func (n *inotify) close() error { return n.f.Close() }
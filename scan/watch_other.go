@@ -0,0 +1,14 @@
+//go:build !linux
+
+package scan
+
+import "errors"
+
+// NotifyChanges reports whether Watch is told of changes by the operating
+// system rather than polling. Only Linux, through inotify, is supported.
+const NotifyChanges = false
+
+// newNotifier fails: Watch polls on this platform.
+func newNotifier() (notifier, error) {
+	return nil, errors.New("change notifications are only supported on Linux")
+}
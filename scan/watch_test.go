@@ -0,0 +1,77 @@
+package scan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventSink passes the records written to it to a channel.
+type eventSink chan Record
+
+func (s eventSink) WriteHeader([]Column) error { return nil }
+func (s eventSink) Flush() error               { return nil }
+
+func (s eventSink) WriteBatch(records []Record) error {
+	for _, r := range records {
+		s <- r
+	}
+	return nil
+}
+
+// listed is an observer closing its channel once a directory was listed.
+type listed struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func (l *listed) Observe(*Record)       {}
+func (l *listed) ObserveDir(*DirRecord) { l.once.Do(func() { close(l.ch) }) }
+
+func TestWatchEvents(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "keep.txt", "gone.txt")
+	sink := make(eventSink, 16)
+	baseline := &listed{ch: make(chan struct{})}
+	scanner := New(dir, WithSink(sink), WithObserver(baseline))
+	// Where notifications are supported only they can trigger the rescan
+	interval := time.Hour
+	if !NotifyChanges {
+		interval = 50 * time.Millisecond
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- scanner.Watch(ctx, interval) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// The baseline of two files is done soon after the root is listed
+	<-baseline.ch
+	time.Sleep(200 * time.Millisecond)
+	writeFiles(t, dir, "sub/new.txt")
+	if err := os.Remove(filepath.Join(dir, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		filepath.Join(dir, "sub", "new.txt"): EventCreate,
+		filepath.Join(dir, "gone.txt"):       EventDelete,
+	}
+	for len(want) > 0 {
+		select {
+		case r := <-sink:
+			if event, ok := want[r.Path]; !ok || r.Extra["event"] != event {
+				t.Errorf("unexpected event %v for %s", r.Extra["event"], r.Path)
+			}
+			delete(want, r.Path)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("no events for %v", want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package scan
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// MaxNameLength is the longest name NTFS allows, in UTF-16 code units.
+const MaxNameLength = 255
+
+// Device names Windows reserves in every directory, with or without an
+// extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Names SharePoint and OneDrive refuse to sync.
+var sharePointBlocked = map[string]bool{
+	".lock": true, "desktop.ini": true,
+}
+
+// WindowsNameIssues audits each component of a path relative to the scan
+// root and describes anything NTFS or SharePoint would reject: reserved
+// device names, trailing dots or spaces, illegal characters, and names
+// SharePoint blocks. It returns nil for a clean path.
+func WindowsNameIssues(rel string) []string {
+	var issues []string
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if name == "" || name == "." || name == ".." {
+			continue
+		}
+		base, _, _ := strings.Cut(name, ".")
+		if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+			issues = append(issues, fmt.Sprintf("reserved name %q", name))
+		}
+		if last := name[len(name)-1]; last == '.' || last == ' ' {
+			issues = append(issues, fmt.Sprintf("trailing dot or space in %q", name))
+		}
+		if c := illegalChar(name); c != "" {
+			issues = append(issues, fmt.Sprintf("illegal character %s in %q", c, name))
+		}
+		if sharePointBlocked[strings.ToLower(name)] || strings.HasPrefix(name, "~$") || strings.Contains(name, "_vti_") {
+			issues = append(issues, fmt.Sprintf("blocked by SharePoint: %q", name))
+		}
+	}
+	return issues
+}
+
+// illegalChar returns the first character NTFS forbids in a name, quoted
+// for display, or "" if there is none.
+func illegalChar(name string) string {
+	for _, r := range name {
+		switch {
+		case r < 0x20:
+			return fmt.Sprintf("0x%02X", r)
+		case strings.ContainsRune(`<>:"\|?*`, r):
+			return fmt.Sprintf("%q", r)
+		}
+	}
+	return ""
+}
+
+// SuggestName returns a name Windows and SharePoint accept in place of
+// name, or name itself when it is already fine: illegal characters and
+// invalid UTF-8 become underscores, trailing dots and spaces go, reserved
+// device names and SharePoint's ~$ and _vti_ markers get an underscore,
+// and names longer than maxLen UTF-16 code units are shortened, keeping
+// the extension. The names SharePoint blocks outright, such as
+// desktop.ini, are left alone.
+func SuggestName(name string, maxLen int) string {
+	s := strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, strings.ToValidUTF8(name, "_"))
+	s = strings.TrimRight(s, ". ")
+	if s == "" {
+		s = "_"
+	}
+	base, _, _ := strings.Cut(s, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		s = "_" + s
+	}
+	if rest, ok := strings.CutPrefix(s, "~$"); ok {
+		s = "_$" + rest
+	}
+	s = strings.ReplaceAll(s, "_vti_", "_vti-")
+	return ShortenName(s, maxLen)
+}
+
+// ShortenName cuts name to at most maxLen UTF-16 code units by trimming
+// the end of its stem, keeping the extension when it is short enough to.
+func ShortenName(name string, maxLen int) string {
+	if maxLen <= 0 || UTF16Len(name) <= maxLen {
+		return name
+	}
+	stem, ext := name, filepath.Ext(name)
+	if ext == name || UTF16Len(ext) > maxLen/2 {
+		ext = ""
+	}
+	stem = strings.TrimSuffix(stem, ext)
+	for stem != "" && UTF16Len(stem)+UTF16Len(ext) > maxLen {
+		_, size := utf8.DecodeLastRuneInString(stem)
+		stem = stem[:len(stem)-size]
+	}
+	if stem = strings.TrimRight(stem, ". "); stem == "" {
+		stem = "_"
+	}
+	return stem + ext
+}
+
+// UTF16Len is the length of s in UTF-16 code units, the unit of Windows
+// name and path limits.
+func UTF16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/rpc"
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Job states reported by the API.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// scanRequest is the body of POST /scans. Every field is optional.
+type scanRequest struct {
+	Root    string   `json:"root"`
+	Hash    string   `json:"hash"`
+	Columns []string `json:"columns"`
+}
+
+// options returns the scan options the request asks for.
+func (req scanRequest) options() ([]scan.Option, error) {
+	var opts []scan.Option
+	if req.Hash != "" {
+		algo, err := scan.ParseHashAlgorithm(req.Hash)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, scan.WithHash(algo))
+	}
+	if len(req.Columns) > 0 {
+		opts = append(opts, scan.WithColumns(req.Columns...))
+	}
+	return opts, nil
+}
+
+// job is one scan run by the server. Its outputs live in dir, one file per
+// format in sinkFormats.
+type job struct {
+	id      string
+	root    string
+	dir     string
+	created time.Time
+	scanner *scan.Scanner
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu      sync.Mutex
+	status  string
+	err     error
+	beat    *heartbeat // Set once the scan runs
+	skipped atomic.Int64
+}
+
+func (j *job) setStatus(status string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status, j.err = status, err
+}
+
+func (j *job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// MarshalJSON renders the job's current state.
+func (j *job) MarshalJSON() ([]byte, error) {
+	j.mu.Lock()
+	status, err := j.status, j.err
+	j.mu.Unlock()
+	out := struct {
+		ID      string      `json:"id"`
+		Root    string      `json:"root"`
+		Status  string      `json:"status"`
+		Error   string      `json:"error,omitempty"`
+		Created time.Time   `json:"created"`
+		Files   int64       `json:"files"`
+		Skipped int64       `json:"skipped"`
+		Stats   *scan.Stats `json:"stats,omitempty"`
+	}{ID: j.id, Root: j.root, Status: status, Created: j.created, Files: j.scanner.Count(), Skipped: j.skipped.Load()}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	if j.finished() {
+		st := j.scanner.Stats()
+		out.Stats = &st
+	}
+	return json.Marshal(out)
+}
+
+func (j *job) output(format string) string {
+	return filepath.Join(j.dir, "file_paths."+format)
+}
+
+// server runs scans on request or on a schedule and serves their results.
+type server struct {
+	roots   []string // Absolute; scans are confined to these trees
+	dataDir string
+	keep    int
+	opts    []scan.Option // Applied to every scan before per-request options
+	metrics *scan.Metrics
+	slots   chan struct{} // Limits concurrent scans
+
+	// stallAfter is how long a running scan may go without progress
+	// before GET /healthz fails; 0 never fails it
+	stallAfter time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	seq  int
+}
+
+func newServer(roots []string, dataDir string, maxScans, keep int, opts []scan.Option) (*server, error) {
+	s := &server{
+		dataDir: dataDir,
+		keep:    keep,
+		opts:    opts,
+		metrics: scan.NewMetrics(),
+		slots:   make(chan struct{}, maxScans),
+		jobs:    make(map[string]*job),
+	}
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		s.roots = append(s.roots, abs)
+	}
+	return s, os.MkdirAll(dataDir, 0o755)
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /scans", s.handleStart)
+	mux.HandleFunc("GET /scans", s.handleList)
+	mux.HandleFunc("GET /scans/{id}", s.handleJob(s.handleStatus))
+	mux.HandleFunc("DELETE /scans/{id}", s.handleJob(s.handleCancel))
+	mux.HandleFunc("GET /scans/{id}/summary", s.handleJob(s.handleSummary))
+	mux.HandleFunc("GET /scans/{id}/output", s.handleJob(s.handleOutput))
+	mux.HandleFunc("GET /scans/{id}/stream", s.handleJob(s.handleStream))
+	mux.Handle("GET /metrics", s.metrics.Handler())
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	return mux
+}
+
+// allowed resolves root against the configured roots. An empty root means
+// the first one.
+func (s *server) allowed(root string) (string, error) {
+	return confine(s.roots, root)
+}
+
+// confine resolves root against roots, which must be absolute, and fails
+// unless it is one of them or lies below one. Symlinks are resolved on
+// both sides first, so a link inside a root can't lead out of it; the
+// resolved path is returned, so the link can't be swapped after the
+// check either. An empty root means the first one.
+func confine(roots []string, root string) (string, error) {
+	if root == "" {
+		return roots[0], nil
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range roots {
+		realRoot, err := filepath.EvalSymlinks(r)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(realRoot, real)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return real, nil
+		}
+	}
+	return "", fmt.Errorf("%s is outside the served roots", root)
+}
+
+// start queues a scan and returns its job.
+func (s *server) start(req scanRequest) (*job, error) {
+	root, err := s.allowed(req.Root)
+	if err != nil {
+		return nil, err
+	}
+	reqOpts, err := req.options()
+	if err != nil {
+		return nil, err
+	}
+	opts := append(append([]scan.Option(nil), s.opts...), reqOpts...)
+	if _, err := scan.New(root, opts...).Columns(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.seq++
+	id := fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405"), s.seq)
+	s.mu.Unlock()
+
+	j := &job{id: id, root: root, dir: filepath.Join(s.dataDir, id), created: time.Now(), status: jobQueued, done: make(chan struct{})}
+	if err := os.MkdirAll(j.dir, 0o755); err != nil {
+		return nil, err
+	}
+	var sinks []scan.Sink
+	var files []*os.File
+	for _, format := range formatNames() {
+		f, err := os.Create(j.output(format))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		sink, _ := newSink(format, f)
+		sinks = append(sinks, sink)
+	}
+	opts = append(opts,
+		scan.WithSink(scan.MultiSink(sinks...)),
+		scan.WithMetrics(s.metrics),
+		scan.WithWarnFunc(func(string, error) { j.skipped.Add(1) }),
+	)
+	j.scanner = scan.New(root, opts...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	s.mu.Lock()
+	s.jobs[id] = j
+	s.mu.Unlock()
+
+	go func() {
+		defer close(j.done)
+		defer func() {
+			for _, f := range files {
+				f.Close()
+			}
+		}()
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			j.setStatus(jobCancelled, nil)
+			return
+		}
+		defer func() { <-s.slots }()
+		j.mu.Lock()
+		j.status, j.beat = jobRunning, newHeartbeat(j.scanner)
+		j.mu.Unlock()
+		_, err := j.scanner.Run(ctx)
+		switch {
+		case errors.Is(err, context.Canceled):
+			j.setStatus(jobCancelled, nil)
+		case err != nil:
+			j.setStatus(jobFailed, err)
+		default:
+			j.setStatus(jobDone, nil)
+		}
+		s.prune()
+	}()
+	return j, nil
+}
+
+// grpcScan serves Scanner.Scan: it streams a scan straight to the client,
+// without a job or output files.
+func (s *server) grpcScan(ctx context.Context, req *rpc.ScanRequest, sink scan.Sink) error {
+	root, err := s.allowed(req.Root)
+	if err != nil {
+		return rpc.Errorf(rpc.PermissionDenied, "%v", err)
+	}
+	opts := append([]scan.Option(nil), s.opts...)
+	algo, err := scan.ParseHashAlgorithm(req.Hash)
+	if err != nil {
+		return rpc.Errorf(rpc.InvalidArgument, "%v", err)
+	}
+	if algo != scan.NoHash {
+		opts = append(opts, scan.WithHash(algo))
+	}
+	if len(req.ExcludePaths) > 0 {
+		opts = append(opts, scan.WithFilter(scan.ExcludePaths(req.ExcludePaths...)))
+	}
+	if len(req.ExcludeNames) > 0 {
+		f, err := scan.ExcludeNames(req.ExcludeNames...)
+		if err != nil {
+			return rpc.Errorf(rpc.InvalidArgument, "%v", err)
+		}
+		opts = append(opts, scan.WithFilter(f))
+	}
+	columns := []string{"file_path"}
+	if req.Stat {
+		columns = append(columns, "size", "mode", "mtime", "uid", "gid")
+	}
+	if algo != scan.NoHash {
+		columns = append(columns, "hash")
+	}
+	opts = append(opts,
+		// Columns only decide what is collected; every Record field is sent
+		scan.WithColumns(columns...),
+		// Protobuf strings must be valid UTF-8
+		scan.WithEscapeMode(scan.EscapePercent),
+		scan.WithSink(sink),
+		scan.WithMetrics(s.metrics),
+	)
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.slots }()
+	if _, err := scan.New(root, opts...).Run(ctx); err != nil {
+		return rpc.Errorf(rpc.Internal, "%v", err)
+	}
+	return nil
+}
+
+// prune removes the oldest finished jobs beyond the retention limit.
+func (s *server) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var finished []*job
+	for _, j := range s.jobs {
+		if j.finished() {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= s.keep {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].created.Before(finished[b].created) })
+	for _, j := range finished[:len(finished)-s.keep] {
+		delete(s.jobs, j.id)
+		os.RemoveAll(j.dir)
+	}
+}
+
+// schedule scans every root each interval until ctx is done.
+func (s *server) schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, root := range s.roots {
+				if _, err := s.start(scanRequest{Root: root}); err != nil {
+					fmt.Fprintf(os.Stderr, "Error starting scheduled scan of %s: %v\n", root, err)
+				}
+			}
+		}
+	}
+}
+
+func (s *server) handleStart(w http.ResponseWriter, r *http.Request) {
+	var req scanRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	j, err := s.start(req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Location", "/scans/"+j.id)
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].created.After(jobs[b].created) })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// handleJob resolves the {id} path value before calling fn.
+func (s *server) handleJob(fn func(http.ResponseWriter, *http.Request, *job)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		j, ok := s.jobs[r.PathValue("id")]
+		s.mu.Unlock()
+		if !ok {
+			httpError(w, http.StatusNotFound, errors.New("no such scan"))
+			return
+		}
+		fn(w, r, j)
+	}
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request, j *job) {
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (s *server) handleCancel(w http.ResponseWriter, r *http.Request, j *job) {
+	j.cancel()
+	<-j.done
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (s *server) handleSummary(w http.ResponseWriter, r *http.Request, j *job) {
+	if !j.finished() {
+		httpError(w, http.StatusConflict, errors.New("scan has not finished"))
+		return
+	}
+	writeJSON(w, http.StatusOK, j.scanner.Stats())
+}
+
+// handleHealth reports the progress of running scans, failing with 503
+// when one has made none for stallAfter, as a scan hung on a stuck mount
+// would.
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	type scanHealth struct {
+		ID string `json:"id"`
+		heartbeatStatus
+	}
+	health := struct {
+		Status string       `json:"status"` // "ok" or "stalled"
+		Scans  []scanHealth `json:"scans"`
+	}{Status: "ok", Scans: []scanHealth{}}
+	s.mu.Lock()
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].created.Before(jobs[b].created) })
+	for _, j := range jobs {
+		j.mu.Lock()
+		status, beat := j.status, j.beat
+		j.mu.Unlock()
+		if status != jobRunning || beat == nil {
+			continue
+		}
+		health.Scans = append(health.Scans, scanHealth{ID: j.id, heartbeatStatus: beat.status()})
+		if s.stallAfter > 0 && beat.stalled(s.stallAfter) {
+			health.Status = "stalled"
+		}
+	}
+	code := http.StatusOK
+	if health.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, health)
+}
+
+func (s *server) handleOutput(w http.ResponseWriter, r *http.Request, j *job) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if _, ok := sinkFormats[format]; !ok {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("unsupported format %q (available: %s)", format, strings.Join(formatNames(), ", ")))
+		return
+	}
+	j.mu.Lock()
+	status := j.status
+	j.mu.Unlock()
+	if status != jobDone {
+		httpError(w, http.StatusConflict, fmt.Errorf("scan is %s", status))
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "file_paths_"+j.id+"."+format))
+	http.ServeFile(w, r, j.output(format))
+}
+
+// handleStream sends records as JSON lines while the scan runs, following
+// the job's output until it finishes.
+func (s *server) handleStream(w http.ResponseWriter, r *http.Request, j *job) {
+	f, err := os.Open(j.output("json"))
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	for {
+		// Check before copying so the final read sees everything written
+		finished := j.finished()
+		if _, err := io.Copy(w, f); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if finished {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-j.done:
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func httpError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
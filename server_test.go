@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfine(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skip("no symlinks:", err)
+	}
+	if err := os.Symlink(root, filepath.Join(base, "alias")); err != nil {
+		t.Fatal(err)
+	}
+	roots := []string{root}
+	for _, ok := range []string{root, filepath.Join(root, "sub"), filepath.Join(base, "alias", "sub")} {
+		if _, err := confine(roots, ok); err != nil {
+			t.Errorf("confine(%s) = %v, want it allowed", ok, err)
+		}
+	}
+	for _, bad := range []string{outside, filepath.Join(root, ".."), filepath.Join(root, "link"), filepath.Join(root, "link", "x")} {
+		if got, err := confine(roots, bad); err == nil {
+			t.Errorf("confine(%s) = %s, want it refused", bad, got)
+		}
+	}
+	if got, err := confine([]string{filepath.Join(base, "alias")}, filepath.Join(root, "sub")); err != nil {
+		t.Errorf("confine below a root given through a symlink = %s, %v", got, err)
+	}
+}
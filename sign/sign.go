@@ -0,0 +1,185 @@
+// Package sign makes and checks detached signatures over scan outputs, so
+// consumers can tell an inventory hasn't been altered since it was
+// written.
+//
+// Signatures are raw, as openssl writes them: RSA keys sign a SHA-256
+// digest with PKCS #1 v1.5 and ECDSA keys a SHA-256 digest as ASN.1, so
+// `openssl dgst -sha256 -verify pub.pem -signature file.sig file` checks
+// them. Ed25519 keys use Ed25519ph (RFC 8032) over a SHA-512 digest, so
+// large outputs needn't be held in memory.
+package sign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Ext is appended to a file's name for its signature.
+const Ext = ".sig"
+
+// ErrBadSignature is returned for a signature that doesn't match.
+var ErrBadSignature = errors.New("signature does not match")
+
+// Signer signs files with a private key.
+type Signer struct {
+	key crypto.Signer
+}
+
+// LoadSigner reads an unencrypted PEM private key: PKCS #8, or PKCS #1
+// RSA or SEC 1 EC.
+func LoadSigner(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		key, err := parsePrivateKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if key != nil {
+			return &Signer{key: key}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no PEM private key", path)
+}
+
+// parsePrivateKey parses a PEM private key block, returning nil for
+// blocks of other types.
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	var key any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "ENCRYPTED PRIVATE KEY":
+		return nil, errors.New("encrypted keys aren't supported; decrypt it with openssl pkey")
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || hashFor(signer.Public()) == 0 {
+		return nil, fmt.Errorf("unsupported key type %T (want RSA, ECDSA or Ed25519)", key)
+	}
+	return signer, nil
+}
+
+// SignFile returns the signature of the file at path.
+func (s *Signer) SignFile(path string) ([]byte, error) {
+	h := hashFor(s.key.Public())
+	digest, err := digestFile(path, h)
+	if err != nil {
+		return nil, err
+	}
+	var opts crypto.SignerOpts = h
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		opts = &ed25519.Options{Hash: h}
+	}
+	return s.key.Sign(rand.Reader, digest, opts)
+}
+
+// Verifier checks signatures with a public key.
+type Verifier struct {
+	key crypto.PublicKey
+}
+
+// LoadVerifier reads a PEM public key, a certificate, whose key is used,
+// or a private key.
+func LoadVerifier(path string) (*Verifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		var key any
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			var signer crypto.Signer
+			if signer, err = parsePrivateKey(block); signer != nil {
+				key = signer.Public()
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if key != nil {
+			if hashFor(key) == 0 {
+				return nil, fmt.Errorf("%s: unsupported key type %T (want RSA, ECDSA or Ed25519)", path, key)
+			}
+			return &Verifier{key: key}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no PEM public key", path)
+}
+
+// VerifyFile checks sig against the file at path, returning
+// ErrBadSignature if it doesn't match.
+func (v *Verifier) VerifyFile(path string, sig []byte) error {
+	h := hashFor(v.key)
+	digest, err := digestFile(path, h)
+	if err != nil {
+		return err
+	}
+	ok := false
+	switch key := v.key.(type) {
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, h, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, digest, sig)
+	case ed25519.PublicKey:
+		ok = ed25519.VerifyWithOptions(key, digest, sig, &ed25519.Options{Hash: h}) == nil
+	}
+	if !ok {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// hashFor is the digest signed with a key, or 0 for unsupported keys.
+func hashFor(key crypto.PublicKey) crypto.Hash {
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return crypto.SHA256
+	case ed25519.PublicKey:
+		return crypto.SHA512
+	}
+	return 0
+}
+
+func digestFile(path string, h crypto.Hash) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := h.New()
+	if _, err := io.Copy(d, f); err != nil {
+		return nil, err
+	}
+	return d.Sum(nil), nil
+}
@@ -0,0 +1,210 @@
+package source
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// azureVersion is the Blob service API version requested.
+const azureVersion = "2021-08-06"
+
+// azureStore lists an Azure Blob Storage container.
+type azureStore struct {
+	client    *http.Client
+	endpoint  string // Blob service root, without a trailing slash
+	account   string
+	container string
+	key       []byte     // Shared key, when signing requests
+	sas       url.Values // SAS token, when used instead
+}
+
+// openAzure opens an azblob://account/container/prefix URL. It signs
+// requests with AZURE_STORAGE_KEY, appends AZURE_STORAGE_SAS_TOKEN, or
+// takes either, and the endpoint, from AZURE_STORAGE_CONNECTION_STRING;
+// without any, requests are anonymous, which public containers allow.
+func openAzure(u *url.URL) (scan.Source, string, error) {
+	container, prefix, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if u.Host == "" || container == "" {
+		return nil, "", errors.New("azblob: URL needs an account and a container: azblob://account/container/prefix")
+	}
+	a := &azureStore{
+		client:    &http.Client{},
+		endpoint:  "https://" + u.Host + ".blob.core.windows.net",
+		account:   u.Host,
+		container: container,
+	}
+	key, sas := os.Getenv("AZURE_STORAGE_KEY"), os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+	if conn := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); conn != "" {
+		fields := make(map[string]string)
+		for _, part := range strings.Split(conn, ";") {
+			if k, v, ok := strings.Cut(part, "="); ok {
+				fields[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+			}
+		}
+		if name := fields["accountname"]; name != "" && name != a.account {
+			return nil, "", fmt.Errorf("azblob: the connection string is for account %s, not %s", name, a.account)
+		}
+		if ep := fields["blobendpoint"]; ep != "" {
+			a.endpoint = strings.TrimSuffix(ep, "/")
+		}
+		key, sas = fields["accountkey"], fields["sharedaccesssignature"]
+	}
+	if key != "" {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, "", fmt.Errorf("azblob: account key is not base64: %w", err)
+		}
+		a.key = decoded
+	} else if sas != "" {
+		values, err := url.ParseQuery(strings.TrimPrefix(sas, "?"))
+		if err != nil {
+			return nil, "", fmt.Errorf("azblob: SAS token: %w", err)
+		}
+		a.sas = values
+	}
+	return newBucket(a), path.Clean("/" + prefix), nil
+}
+
+// do sends a request for the container, or for a blob when key is set.
+func (a *azureStore) do(method, key string, query url.Values) (*http.Response, error) {
+	target := a.endpoint + "/" + url.PathEscape(a.container)
+	if key != "" {
+		segments := strings.Split(key, "/")
+		for i, s := range segments {
+			segments[i] = url.PathEscape(s)
+		}
+		target += "/" + strings.Join(segments, "/")
+	}
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	for k, v := range a.sas {
+		q[k] = v
+	}
+	if len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+	return doHTTP(a.client, func() (*http.Request, error) {
+		req, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Ms-Version", azureVersion)
+		req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+		if a.key != nil {
+			a.sign(req)
+		}
+		return req, nil
+	})
+}
+
+// sign adds a Shared Key authorization header. Requests carry no body,
+// so the content headers of the string to sign are empty.
+func (a *azureStore) sign(req *http.Request) {
+	var headers []string
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			headers = append(headers, lower+":"+strings.TrimSpace(req.Header.Get(name)))
+		}
+	}
+	sort.Strings(headers)
+
+	resource := "/" + a.account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	toSign := req.Method + strings.Repeat("\n", 12) + strings.Join(headers, "\n") + "\n" + resource
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(toSign))
+	req.Header.Set("Authorization", "SharedKey "+a.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+type azureListResult struct {
+	Blobs struct {
+		Blob []struct {
+			Name       string
+			Properties struct {
+				LastModified  string `xml:"Last-Modified"`
+				Etag          string
+				ContentLength int64 `xml:"Content-Length"`
+				AccessTier    string
+			}
+		}
+		BlobPrefix []struct {
+			Name string
+		}
+	}
+	NextMarker string
+}
+
+func (a *azureStore) list(prefix, token string, max int) (*objectPage, error) {
+	q := url.Values{"restype": {"container"}, "comp": {"list"}, "delimiter": {"/"}, "prefix": {prefix}}
+	if token != "" {
+		q.Set("marker", token)
+	}
+	if max > 0 {
+		q.Set("maxresults", strconv.Itoa(max))
+	}
+	resp, err := a.do(http.MethodGet, "", q)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res azureListResult
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("azblob: listing %s: %w", prefix, err)
+	}
+	page := &objectPage{next: res.NextMarker}
+	for _, b := range res.Blobs.Blob {
+		mtime, _ := http.ParseTime(b.Properties.LastModified)
+		page.objects = append(page.objects,
+			newObjectInfo(b.Name, b.Properties.ContentLength, mtime, b.Properties.Etag, b.Properties.AccessTier))
+	}
+	for _, p := range res.Blobs.BlobPrefix {
+		page.prefixes = append(page.prefixes, p.Name)
+	}
+	return page, nil
+}
+
+func (a *azureStore) stat(key string) (*objectInfo, error) {
+	resp, err := a.do(http.MethodHead, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	mtime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return newObjectInfo(key, resp.ContentLength, mtime, resp.Header.Get("ETag"), resp.Header.Get("X-Ms-Access-Tier")), nil
+}
+
+func (a *azureStore) get(key string) (io.ReadCloser, error) {
+	resp, err := a.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
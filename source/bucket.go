@@ -0,0 +1,159 @@
+package source
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// bucketPrefetch bounds the prefix listings fetched ahead of the walker.
+const bucketPrefetch = 16
+
+// objectStore is the part of a cloud storage API a bucket source needs.
+// Keys are full object names without a leading slash.
+type objectStore interface {
+	// list returns a page of the objects and sub-prefixes directly under
+	// prefix, which is empty or ends in a slash. token continues an
+	// earlier page; max limits the page when positive.
+	list(prefix, token string, max int) (*objectPage, error)
+	// stat describes one object
+	stat(key string) (*objectInfo, error)
+	// get reads one object
+	get(key string) (io.ReadCloser, error)
+}
+
+// objectPage is one page of a listing. Object names are full keys and
+// prefixes end in a slash.
+type objectPage struct {
+	objects  []*objectInfo
+	prefixes []string
+	next     string // Token of the next page, empty on the last
+}
+
+// bucket is a Source over an object store. Key prefixes ending in / are
+// walked as directories, one paginated listing per prefix, with sibling
+// prefixes listed in parallel. Every store offers the same columns, so
+// inventories of different clouds share one schema.
+type bucket struct {
+	store    objectStore
+	prefetch *prefetcher
+}
+
+func newBucket(store objectStore) *bucket {
+	b := &bucket{store: store}
+	b.prefetch = newPrefetcher(bucketPrefetch, b.readDir)
+	return b
+}
+
+// Columns implements scan.ColumnSource.
+func (b *bucket) Columns() []scan.Column {
+	return []scan.Column{scan.SourceColumn("etag"), scan.SourceColumn("storage_class")}
+}
+
+// objectKey converts a source path to an object key.
+func objectKey(p string) string {
+	return strings.TrimPrefix(p, "/")
+}
+
+// dirPrefix returns the key prefix listing a source directory.
+func dirPrefix(p string) string {
+	if key := objectKey(p); key != "" {
+		return key + "/"
+	}
+	return ""
+}
+
+// ReadDir implements scan.Source.
+func (b *bucket) ReadDir(p string) ([]fs.DirEntry, error) {
+	return b.prefetch.ReadDir(p)
+}
+
+func (b *bucket) readDir(p string) ([]fs.DirEntry, error) {
+	prefix := dirPrefix(p)
+	var entries []fs.DirEntry
+	token := ""
+	for {
+		page, err := b.store.list(prefix, token, 0)
+		if err != nil {
+			return entries, &fs.PathError{Op: "list", Path: p, Err: err}
+		}
+		for _, obj := range page.objects {
+			// Skips the zero-byte marker some tools create for folders
+			if obj.name = strings.TrimPrefix(obj.name, prefix); obj.name != "" {
+				entries = append(entries, fs.FileInfoToDirEntry(obj))
+			}
+		}
+		for _, sub := range page.prefixes {
+			if name := strings.TrimSuffix(strings.TrimPrefix(sub, prefix), "/"); name != "" {
+				entries = append(entries, fs.FileInfoToDirEntry(&objectInfo{name: name, mode: fs.ModeDir | 0o755}))
+			}
+		}
+		if page.next == "" {
+			break
+		}
+		token = page.next
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// Lstat implements scan.Source. A path is a directory when some key starts
+// with it and a slash; the bucket root always is.
+func (b *bucket) Lstat(p string) (fs.FileInfo, error) {
+	prefix := dirPrefix(p)
+	page, err := b.store.list(prefix, "", 1)
+	if err != nil {
+		return nil, &fs.PathError{Op: "list", Path: p, Err: err}
+	}
+	if prefix == "" || len(page.objects) > 0 || len(page.prefixes) > 0 {
+		return &objectInfo{name: path.Base(p), mode: fs.ModeDir | 0o755}, nil
+	}
+	info, err := b.store.stat(objectKey(p))
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: p, Err: err}
+	}
+	info.name = path.Base(p)
+	return info, nil
+}
+
+// Open implements scan.Source.
+func (b *bucket) Open(p string) (io.ReadCloser, error) {
+	r, err := b.store.get(objectKey(p))
+	if err != nil {
+		return nil, &fs.PathError{Op: "get", Path: p, Err: err}
+	}
+	return r, nil
+}
+
+// Readlink implements scan.Source. Object stores have no links.
+func (b *bucket) Readlink(p string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: p, Err: errors.ErrUnsupported}
+}
+
+// objectInfo is the fs.FileInfo of an object or key prefix.
+type objectInfo struct {
+	name   string
+	size   int64
+	mode   fs.FileMode
+	mtime  time.Time
+	values scan.SourceValues
+}
+
+// newObjectInfo describes an object with the columns every store fills.
+func newObjectInfo(key string, size int64, mtime time.Time, etag, class string) *objectInfo {
+	return &objectInfo{name: key, size: size, mode: 0o644, mtime: mtime,
+		values: scan.SourceValues{"etag": strings.Trim(etag, `"`), "storage_class": class}}
+}
+
+func (i *objectInfo) Name() string       { return i.name }
+func (i *objectInfo) Size() int64        { return i.size }
+func (i *objectInfo) Mode() fs.FileMode  { return i.mode }
+func (i *objectInfo) ModTime() time.Time { return i.mtime }
+func (i *objectInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *objectInfo) Sys() any           { return i.values }
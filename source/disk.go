@@ -0,0 +1,302 @@
+package source
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Disk is a Source over a disk image opened read-only: a raw image, a
+// qcow2 image or an ISO. ISO9660, FAT12/16/32 and ext2/3/4 filesystems
+// are read directly, so nothing needs mounting. A partitioned image has
+// one directory per partition, named p1, p2, ... as Linux numbers them;
+// a partition holding another filesystem can't be listed, which the scan
+// records as a skipped path naming the filesystem.
+type Disk struct {
+	*tree
+	files []*os.File
+}
+
+// openDisk opens a disk://path URL.
+func openDisk(name string) (scan.Source, string, error) {
+	if name == "" {
+		return nil, "", errors.New("disk: no image given: disk://path/to/image")
+	}
+	d := &Disk{tree: newTree()}
+	r, err := d.openImage(name, 0)
+	if err != nil {
+		d.Close()
+		return nil, "", fmt.Errorf("disk: %w", err)
+	}
+	if info, err := d.files[0].Stat(); err == nil {
+		d.nodes["/"].mtime = info.ModTime()
+	}
+	if err := d.load(r); err != nil {
+		d.Close()
+		return nil, "", fmt.Errorf("disk: %s: %w", name, err)
+	}
+	d.finish()
+	return d, "/", nil
+}
+
+// openImage opens a raw or qcow2 image file. depth counts qcow2 backing
+// files, which may chain.
+func (d *Disk) openImage(name string, depth int) (io.ReaderAt, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	d.files = append(d.files, f)
+	magic := make([]byte, 4)
+	if _, err := f.ReadAt(magic, 0); err == nil && string(magic) == qcow2Magic {
+		if depth > 8 {
+			return nil, fmt.Errorf("%s: too many backing files", name)
+		}
+		return d.openQcow2(f, name, depth)
+	}
+	return f, nil
+}
+
+// load reads the filesystem at the start of the image or, failing that,
+// its partition table.
+func (d *Disk) load(r io.ReaderAt) error {
+	if fsType := detectFS(r); fsType != "" {
+		return d.readFS(fsType, r, "/")
+	}
+	parts, err := readPartitions(r)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return errors.New("no filesystem or partition table found")
+	}
+	for _, p := range parts {
+		dir := fmt.Sprintf("/p%d", p.number)
+		sec := io.NewSectionReader(r, p.start, p.size)
+		if err := d.readFS(detectFS(sec), sec, dir); err != nil {
+			// The rest of the disk may still be readable
+			d.mkdir(dir).err = err
+		}
+	}
+	return nil
+}
+
+// readFS lists a filesystem into the tree under dir.
+func (d *Disk) readFS(fsType string, r io.ReaderAt, dir string) error {
+	switch fsType {
+	case "iso9660":
+		return readISO(r, d.tree, dir)
+	case "fat":
+		return readFAT(r, d.tree, dir)
+	case "ext":
+		return readExt(r, d.tree, dir)
+	case "":
+		return errors.New("no filesystem recognised")
+	}
+	return fmt.Errorf("unsupported filesystem %s", fsType)
+}
+
+// detectFS names the filesystem starting at the beginning of r: one of
+// those readFS reads, another recognised one, or "" when unknown.
+func detectFS(r io.ReaderAt) string {
+	buf := make([]byte, 0x10100)
+	n, _ := r.ReadAt(buf, 0)
+	buf = buf[:n]
+	at := func(off int, magic string) bool {
+		return len(buf) >= off+len(magic) && string(buf[off:off+len(magic)]) == magic
+	}
+	switch {
+	case at(0x8001, "CD001"):
+		return "iso9660"
+	case at(1024+56, "\x53\xef"):
+		return "ext"
+	case at(3, "NTFS    "):
+		return "ntfs"
+	case at(3, "EXFAT   "):
+		return "exfat"
+	case at(0, "XFSB"):
+		return "xfs"
+	case at(0x10040, "_BHRfS_M"):
+		return "btrfs"
+	case at(512, "LABELONE"):
+		return "LVM"
+	case at(0, "LUKS\xba\xbe"):
+		return "LUKS"
+	case at(4096-10, "SWAPSPACE2"):
+		return "swap"
+	case at(510, "\x55\xaa") && (at(0x36, "FAT") || at(0x52, "FAT32")):
+		return "fat"
+	}
+	return ""
+}
+
+// partition is a partition of a disk, in bytes.
+type partition struct {
+	number      int
+	start, size int64
+}
+
+// readPartitions reads a GPT, or an MBR with its logical partitions.
+// Sectors are taken to be 512 bytes.
+func readPartitions(r io.ReaderAt) ([]partition, error) {
+	mbr := make([]byte, 512)
+	if _, err := r.ReadAt(mbr, 0); err == io.EOF {
+		return nil, nil // Too small for a partition table
+	} else if err != nil {
+		return nil, err
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return nil, nil
+	}
+	var parts []partition
+	var extended []int64
+	for i := 0; i < 4; i++ {
+		e := mbr[446+16*i:]
+		typ := e[4]
+		start := int64(binary.LittleEndian.Uint32(e[8:])) * 512
+		size := int64(binary.LittleEndian.Uint32(e[12:])) * 512
+		switch {
+		case typ == 0xee:
+			return readGPT(r)
+		case typ == 0x05 || typ == 0x0f || typ == 0x85:
+			extended = append(extended, start)
+		case typ != 0 && size > 0:
+			parts = append(parts, partition{number: i + 1, start: start, size: size})
+		}
+	}
+	// Logical partitions are a chain of boot records, each with one
+	// partition relative to itself and a link relative to the first
+	number := 5
+	for _, base := range extended {
+		ebr := base
+		for hops := 0; hops < 128; hops++ {
+			if _, err := r.ReadAt(mbr, ebr); err != nil || mbr[510] != 0x55 || mbr[511] != 0xaa {
+				break
+			}
+			if size := int64(binary.LittleEndian.Uint32(mbr[446+12:])) * 512; size > 0 {
+				start := ebr + int64(binary.LittleEndian.Uint32(mbr[446+8:]))*512
+				parts = append(parts, partition{number: number, start: start, size: size})
+				number++
+			}
+			next := int64(binary.LittleEndian.Uint32(mbr[446+16+8:])) * 512
+			if next == 0 {
+				break
+			}
+			ebr = base + next
+		}
+	}
+	return parts, nil
+}
+
+func readGPT(r io.ReaderAt) ([]partition, error) {
+	hdr := make([]byte, 92)
+	if _, err := r.ReadAt(hdr, 512); err != nil {
+		return nil, err
+	}
+	if string(hdr[:8]) != "EFI PART" {
+		return nil, errors.New("protective MBR without a GPT header")
+	}
+	entries := int64(binary.LittleEndian.Uint64(hdr[72:])) * 512
+	count := binary.LittleEndian.Uint32(hdr[80:])
+	size := binary.LittleEndian.Uint32(hdr[84:])
+	if size < 128 || count > 1024 {
+		return nil, errors.New("bad GPT header")
+	}
+	table := make([]byte, int64(count)*int64(size))
+	if _, err := r.ReadAt(table, entries); err != nil {
+		return nil, err
+	}
+	var parts []partition
+	for i := uint32(0); i < count; i++ {
+		e := table[i*size:]
+		if bytes.Equal(e[:16], make([]byte, 16)) {
+			continue
+		}
+		first := int64(binary.LittleEndian.Uint64(e[32:]))
+		last := int64(binary.LittleEndian.Uint64(e[40:]))
+		parts = append(parts, partition{number: int(i) + 1, start: first * 512, size: (last - first + 1) * 512})
+	}
+	return parts, nil
+}
+
+// Close closes the image files.
+func (d *Disk) Close() error {
+	for _, f := range d.files {
+		f.Close()
+	}
+	d.files = nil
+	return nil
+}
+
+// dataRun maps length bytes of a file, from offset off, to image offset
+// disk; a negative disk offset is a hole, which reads as zeros.
+type dataRun struct {
+	off, length, disk int64
+}
+
+// runFile reads a file stored as runs of an image, in file order.
+type runFile struct {
+	r    io.ReaderAt
+	runs []dataRun
+}
+
+// appendRun adds the next length bytes of a file, merging runs that are
+// contiguous on disk.
+func appendRun(runs []dataRun, length, disk int64) []dataRun {
+	var off int64
+	if len(runs) > 0 {
+		last := &runs[len(runs)-1]
+		off = last.off + last.length
+		if (disk < 0 && last.disk < 0) || (disk >= 0 && last.disk >= 0 && last.disk+last.length == disk) {
+			last.length += length
+			return runs
+		}
+	}
+	return append(runs, dataRun{off: off, length: length, disk: disk})
+}
+
+func (f *runFile) ReadAt(p []byte, off int64) (int, error) {
+	i := sort.Search(len(f.runs), func(i int) bool { return f.runs[i].off+f.runs[i].length > off })
+	n := 0
+	for ; n < len(p) && i < len(f.runs); i++ {
+		run := f.runs[i]
+		start := off + int64(n) - run.off
+		chunk := p[n : n+int(min(int64(len(p)-n), run.length-start))]
+		if run.disk < 0 {
+			clear(chunk)
+		} else if _, err := f.r.ReadAt(chunk, run.disk+start); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// utf16String decodes little- or big-endian UTF-16, stopping at a NUL.
+func utf16String(b []byte, order binary.ByteOrder) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u := order.Uint16(b[i:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return string(utf16.Decode(units))
+}
+
+// cleanName makes a filesystem name safe as one path element.
+func cleanName(name string) string {
+	return strings.ReplaceAll(name, "/", "_")
+}
@@ -0,0 +1,336 @@
+package source
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// ext4 feature and inode flag bits the reader looks at.
+const (
+	extIncompat64Bit   = 0x80
+	extFlagExtents     = 0x80000
+	extFlagInlineData  = 0x10000000
+	extExtentMagic     = 0xf30a
+	extRootInode       = 2
+	extMaxExtentDepth  = 5
+	extMaxIndirectTree = 3
+)
+
+// extReader lists an ext2, ext3 or ext4 filesystem: block maps and
+// extent trees are both read, but inline data (tiny files kept in the
+// inode) is not, so those files are listed without content.
+type extReader struct {
+	r              io.ReaderAt
+	t              *tree
+	blockSize      int64
+	inodesPerGroup uint32
+	inodeSize      int64
+	descSize       int64
+	descStart      int64
+	seen           map[uint32]bool
+}
+
+// extInode is the part of an inode the reader uses.
+type extInode struct {
+	mode     uint16
+	uid, gid uint32
+	size     int64
+	mtime    time.Time
+	flags    uint32
+	block    []byte // i_block: block map, extent tree or fast symlink
+	blocks   uint32 // i_blocks_lo, zero for a fast symlink
+}
+
+func readExt(r io.ReaderAt, t *tree, dir string) error {
+	sb := make([]byte, 1024)
+	if _, err := r.ReadAt(sb, 1024); err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	e := &extReader{
+		r:              r,
+		t:              t,
+		blockSize:      1024 << le.Uint32(sb[24:]),
+		inodesPerGroup: le.Uint32(sb[40:]),
+		inodeSize:      128,
+		descSize:       32,
+		seen:           make(map[uint32]bool),
+	}
+	if le.Uint32(sb[76:]) >= 1 {
+		e.inodeSize = int64(le.Uint16(sb[88:]))
+	}
+	if le.Uint32(sb[96:])&extIncompat64Bit != 0 {
+		e.descSize = int64(le.Uint16(sb[254:]))
+	}
+	if e.blockSize > 65536 || e.inodesPerGroup == 0 || e.inodeSize < 128 || e.descSize < 32 {
+		return errors.New("ext: bad superblock")
+	}
+	// Group descriptors follow the block holding the superblock
+	e.descStart = (int64(le.Uint32(sb[20:])) + 1) * e.blockSize
+
+	root, err := e.inode(extRootInode)
+	if err != nil {
+		return err
+	}
+	t.mkdir(dir).mtime = root.mtime
+	e.seen[extRootInode] = true
+	return e.readDir(dir, root)
+}
+
+// inode reads inode number ino.
+func (e *extReader) inode(ino uint32) (*extInode, error) {
+	le := binary.LittleEndian
+	group := int64((ino - 1) / e.inodesPerGroup)
+	desc := make([]byte, e.descSize)
+	if _, err := e.r.ReadAt(desc, e.descStart+group*e.descSize); err != nil {
+		return nil, fmt.Errorf("ext: group descriptor %d: %w", group, err)
+	}
+	table := int64(le.Uint32(desc[8:]))
+	if e.descSize >= 64 {
+		table |= int64(le.Uint32(desc[0x28:])) << 32
+	}
+	raw := make([]byte, e.inodeSize)
+	off := table*e.blockSize + int64((ino-1)%e.inodesPerGroup)*e.inodeSize
+	if _, err := e.r.ReadAt(raw, off); err != nil {
+		return nil, fmt.Errorf("ext: inode %d: %w", ino, err)
+	}
+	return &extInode{
+		mode:   le.Uint16(raw[0:]),
+		uid:    uint32(le.Uint16(raw[2:])) | uint32(le.Uint16(raw[120:]))<<16,
+		gid:    uint32(le.Uint16(raw[24:])) | uint32(le.Uint16(raw[122:]))<<16,
+		size:   int64(le.Uint32(raw[4:])) | int64(le.Uint32(raw[108:]))<<32,
+		mtime:  time.Unix(int64(int32(le.Uint32(raw[16:]))), 0).UTC(),
+		flags:  le.Uint32(raw[32:]),
+		block:  raw[40:100],
+		blocks: le.Uint32(raw[28:]),
+	}, nil
+}
+
+// runs maps an inode's data to runs of the image.
+func (e *extReader) runs(in *extInode) ([]dataRun, error) {
+	if in.flags&extFlagInlineData != 0 {
+		return nil, errors.New("inline data is not supported")
+	}
+	blocks := (in.size + e.blockSize - 1) / e.blockSize
+	var runs []dataRun
+	var err error
+	if in.flags&extFlagExtents != 0 {
+		runs, err = e.extentRuns(nil, in.block, blocks, 0)
+	} else {
+		runs, err = e.mapRuns(in.block, blocks)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Pad with a hole to the file size, then trim the last block
+	var mapped int64
+	if len(runs) > 0 {
+		mapped = runs[len(runs)-1].off + runs[len(runs)-1].length
+	}
+	if mapped < in.size {
+		runs = appendRun(runs, in.size-mapped, -1)
+	}
+	for len(runs) > 0 && runs[len(runs)-1].off >= in.size {
+		runs = runs[:len(runs)-1]
+	}
+	if len(runs) > 0 {
+		last := &runs[len(runs)-1]
+		last.length = min(last.length, in.size-last.off)
+	}
+	return runs, nil
+}
+
+// extentRuns walks an extent tree node. Extents may leave gaps, which
+// are holes.
+func (e *extReader) extentRuns(runs []dataRun, node []byte, blocks int64, depth int) ([]dataRun, error) {
+	le := binary.LittleEndian
+	if len(node) < 12 || le.Uint16(node) != extExtentMagic || depth > extMaxExtentDepth {
+		return nil, errors.New("ext: bad extent tree")
+	}
+	entries := int(le.Uint16(node[2:]))
+	treeDepth := le.Uint16(node[6:])
+	for i := 0; i < entries && 12+12*(i+1) <= len(node); i++ {
+		ent := node[12+12*i:]
+		if treeDepth > 0 {
+			leaf := int64(le.Uint32(ent[4:])) | int64(le.Uint16(ent[8:]))<<32
+			child := make([]byte, e.blockSize)
+			if _, err := e.r.ReadAt(child, leaf*e.blockSize); err != nil {
+				return nil, err
+			}
+			var err error
+			if runs, err = e.extentRuns(runs, child, blocks, depth+1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		logical := int64(le.Uint32(ent[0:]))
+		length := int64(le.Uint16(ent[4:]))
+		start := int64(le.Uint32(ent[8:])) | int64(le.Uint16(ent[6:]))<<32
+		hole := false
+		if length > 32768 {
+			// Preallocated but unwritten, which reads as zeros
+			length -= 32768
+			hole = true
+		}
+		if logical >= blocks {
+			continue
+		}
+		length = min(length, blocks-logical)
+		var mapped int64
+		if len(runs) > 0 {
+			mapped = runs[len(runs)-1].off + runs[len(runs)-1].length
+		}
+		if gap := logical*e.blockSize - mapped; gap > 0 {
+			runs = appendRun(runs, gap, -1)
+		} else if gap < 0 {
+			continue // Out of order; not written by ext4
+		}
+		disk := start * e.blockSize
+		if hole {
+			disk = -1
+		}
+		runs = appendRun(runs, length*e.blockSize, disk)
+	}
+	return runs, nil
+}
+
+// mapRuns walks an ext2/3 block map: twelve direct blocks, then single,
+// double and triple indirect blocks. Zero entries are holes.
+func (e *extReader) mapRuns(iblock []byte, blocks int64) ([]dataRun, error) {
+	le := binary.LittleEndian
+	var runs []dataRun
+	var next int64
+	for i := 0; i < 12 && next < blocks; i++ {
+		runs = e.appendBlock(runs, int64(le.Uint32(iblock[4*i:])))
+		next++
+	}
+	for level := 1; level <= extMaxIndirectTree && next < blocks; level++ {
+		var err error
+		runs, next, err = e.indirect(runs, int64(le.Uint32(iblock[4*(11+level):])), level, next, blocks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+// indirect maps the blocks below an indirect block of the given level.
+func (e *extReader) indirect(runs []dataRun, block int64, level int, next, blocks int64) ([]dataRun, int64, error) {
+	perBlock := e.blockSize / 4
+	span := int64(1)
+	for i := 0; i < level; i++ {
+		span *= perBlock
+	}
+	if block == 0 {
+		// A hole covering everything below this block
+		n := min(span, blocks-next)
+		return appendRun(runs, n*e.blockSize, -1), next + n, nil
+	}
+	buf := make([]byte, e.blockSize)
+	if _, err := e.r.ReadAt(buf, block*e.blockSize); err != nil {
+		return nil, 0, err
+	}
+	for i := int64(0); i < perBlock && next < blocks; i++ {
+		child := int64(binary.LittleEndian.Uint32(buf[4*i:]))
+		if level == 1 {
+			runs = e.appendBlock(runs, child)
+			next++
+			continue
+		}
+		var err error
+		if runs, next, err = e.indirect(runs, child, level-1, next, blocks); err != nil {
+			return nil, 0, err
+		}
+	}
+	return runs, next, nil
+}
+
+func (e *extReader) appendBlock(runs []dataRun, block int64) []dataRun {
+	if block == 0 {
+		return appendRun(runs, e.blockSize, -1)
+	}
+	return appendRun(runs, e.blockSize, block*e.blockSize)
+}
+
+// readDir lists a directory's linear entries. Hashed (htree) directories
+// read the same way, since their index blocks look like empty entries.
+func (e *extReader) readDir(dir string, in *extInode) error {
+	runs, err := e.runs(in)
+	if err != nil {
+		return fmt.Errorf("ext: %s: %w", dir, err)
+	}
+	data := make([]byte, in.size)
+	if _, err := (&runFile{r: e.r, runs: runs}).ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
+	le := binary.LittleEndian
+	for off := 0; off+8 <= len(data); {
+		ino := le.Uint32(data[off:])
+		recLen := int(le.Uint16(data[off+4:]))
+		nameLen := int(data[off+6])
+		if recLen < 8 || off+recLen > len(data) {
+			break
+		}
+		name := string(data[off+8 : min(off+8+nameLen, off+recLen)])
+		off += recLen
+		if ino == 0 || name == "." || name == ".." {
+			continue
+		}
+		child, err := e.inode(ino)
+		if err != nil {
+			return err
+		}
+		if err := e.add(path.Join(dir, cleanName(name)), ino, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add puts an inode in the tree, descending into directories.
+func (e *extReader) add(p string, ino uint32, in *extInode) error {
+	n := &treeNode{
+		name:  path.Base(p),
+		mode:  unixMode(uint32(in.mode)),
+		mtime: in.mtime,
+		sys:   &scan.SourceSys{Owner: &scan.FileOwner{UID: int(in.uid), GID: int(in.gid)}},
+	}
+	switch {
+	case n.mode.IsDir():
+		n = e.t.add(p, n, nil)
+		if e.seen[ino] {
+			return nil
+		}
+		e.seen[ino] = true
+		if err := e.readDir(p, in); err != nil {
+			// One unreadable directory doesn't hide the rest
+			n.err = err
+		}
+		return nil
+	case n.mode&fs.ModeSymlink != 0:
+		n.size = in.size
+		if in.size < 60 && (in.blocks == 0 || in.flags&extFlagInlineData != 0) {
+			// Short targets are kept in the inode itself
+			n.link = string(in.block[:in.size])
+		} else if runs, err := e.runs(in); err == nil {
+			target := make([]byte, in.size)
+			if _, err := (&runFile{r: e.r, runs: runs}).ReadAt(target, 0); err == nil {
+				n.link = string(target)
+			}
+		}
+	case n.mode.IsRegular():
+		n.size = in.size
+		if runs, err := e.runs(in); err == nil {
+			n.content = &runFile{r: e.r, runs: runs}
+		}
+	}
+	e.t.add(p, n, nil)
+	return nil
+}
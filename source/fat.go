@@ -0,0 +1,260 @@
+package source
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// fatReader lists a FAT12, FAT16 or FAT32 filesystem, with VFAT long
+// names. FAT keeps local times without a zone; they are read as UTC.
+type fatReader struct {
+	r           io.ReaderAt
+	t           *tree
+	bits        int // 12, 16 or 32
+	clusterSize int64
+	dataStart   int64 // Offset of cluster 2
+	fat         []byte
+	clusters    uint32 // Highest valid cluster number + 1
+}
+
+func readFAT(r io.ReaderAt, t *tree, dir string) error {
+	bpb := make([]byte, 512)
+	if _, err := r.ReadAt(bpb, 0); err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	sectorSize := int64(le.Uint16(bpb[11:]))
+	perCluster := int64(bpb[13])
+	reserved := int64(le.Uint16(bpb[14:]))
+	fats := int64(bpb[16])
+	rootEntries := int64(le.Uint16(bpb[17:]))
+	total := int64(le.Uint16(bpb[19:]))
+	if total == 0 {
+		total = int64(le.Uint32(bpb[32:]))
+	}
+	fatSectors := int64(le.Uint16(bpb[22:]))
+	if fatSectors == 0 {
+		fatSectors = int64(le.Uint32(bpb[36:]))
+	}
+	if sectorSize < 512 || perCluster == 0 || fats == 0 {
+		return errors.New("fat: bad boot sector")
+	}
+	rootSectors := (rootEntries*32 + sectorSize - 1) / sectorSize
+	firstData := reserved + fats*fatSectors + rootSectors
+	count := (total - firstData) / perCluster
+
+	f := &fatReader{
+		r:           r,
+		t:           t,
+		clusterSize: perCluster * sectorSize,
+		dataStart:   firstData * sectorSize,
+		clusters:    uint32(count) + 2,
+	}
+	// The cluster count alone decides the FAT width
+	switch {
+	case count < 4085:
+		f.bits = 12
+	case count < 65525:
+		f.bits = 16
+	default:
+		f.bits = 32
+	}
+	f.fat = make([]byte, fatSectors*sectorSize)
+	if _, err := r.ReadAt(f.fat, reserved*sectorSize); err != nil {
+		return fmt.Errorf("fat: reading the allocation table: %w", err)
+	}
+
+	t.mkdir(dir)
+	if f.bits == 32 {
+		root := le.Uint32(bpb[44:])
+		return f.readDir(dir, f.chainRuns(root, -1), map[uint32]bool{root: true})
+	}
+	rootStart := (reserved + fats*fatSectors) * sectorSize
+	return f.readDir(dir, appendRun(nil, rootEntries*32, rootStart), nil)
+}
+
+// next returns the cluster after c in its chain, or 0 at the end.
+func (f *fatReader) next(c uint32) uint32 {
+	var v uint32
+	switch f.bits {
+	case 12:
+		i := int(c) * 3 / 2
+		if i+1 >= len(f.fat) {
+			return 0
+		}
+		v = uint32(binary.LittleEndian.Uint16(f.fat[i:]))
+		if c%2 == 1 {
+			v >>= 4
+		}
+		v &= 0xfff
+	case 16:
+		if int(c)*2+1 >= len(f.fat) {
+			return 0
+		}
+		v = uint32(binary.LittleEndian.Uint16(f.fat[c*2:]))
+	default:
+		if int(c)*4+3 >= len(f.fat) {
+			return 0
+		}
+		v = binary.LittleEndian.Uint32(f.fat[c*4:]) & 0x0fffffff
+	}
+	if v < 2 || v >= f.clusters {
+		return 0
+	}
+	return v
+}
+
+// chainRuns maps a cluster chain to runs, up to size bytes when size is
+// not negative. A chain that loops is cut where it does.
+func (f *fatReader) chainRuns(first uint32, size int64) []dataRun {
+	var runs []dataRun
+	seen := make(map[uint32]bool)
+	var total int64
+	for c := first; c >= 2 && c < f.clusters && !seen[c]; c = f.next(c) {
+		seen[c] = true
+		n := f.clusterSize
+		if size >= 0 {
+			if total >= size {
+				break
+			}
+			n = min(n, size-total)
+		}
+		runs = appendRun(runs, n, f.dataStart+int64(c-2)*f.clusterSize)
+		total += n
+	}
+	return runs
+}
+
+func (f *fatReader) readDir(dir string, runs []dataRun, seen map[uint32]bool) error {
+	var size int64
+	for _, run := range runs {
+		size += run.length
+	}
+	data := make([]byte, size)
+	if _, err := (&runFile{r: f.r, runs: runs}).ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
+	le := binary.LittleEndian
+	var long []uint16 // Long name parts, last first
+	for off := 0; off+32 <= len(data); off += 32 {
+		e := data[off : off+32]
+		if e[0] == 0 {
+			break
+		}
+		if e[0] == 0xe5 {
+			long = nil
+			continue // Deleted
+		}
+		attr := e[11]
+		if attr&0x3f == 0x0f {
+			// A long name entry holds 13 UTF-16 units in three pieces
+			var part []uint16
+			for _, span := range [][2]int{{1, 11}, {14, 26}, {28, 32}} {
+				for i := span[0]; i < span[1]; i += 2 {
+					part = append(part, le.Uint16(e[i:]))
+				}
+			}
+			if e[0]&0x40 != 0 {
+				long = nil
+			}
+			long = append(part, long...)
+			continue
+		}
+		if attr&0x08 != 0 {
+			long = nil
+			continue // Volume label
+		}
+		name := shortName(e)
+		if long != nil {
+			name = longName(long)
+			long = nil
+		}
+		if name == "." || name == ".." {
+			continue
+		}
+		name = cleanName(name)
+		cluster := uint32(le.Uint16(e[26:]))
+		if f.bits == 32 {
+			cluster |= uint32(le.Uint16(e[20:])) << 16
+		}
+		n := &treeNode{
+			name:  name,
+			mtime: fatTime(le.Uint16(e[24:]), le.Uint16(e[22:])),
+			mode:  0o644,
+			sys:   &scan.SourceSys{},
+		}
+		if attr&0x01 != 0 {
+			n.mode = 0o444
+		}
+		p := path.Join(dir, name)
+		if attr&0x10 != 0 {
+			n.mode = fs.ModeDir | 0o755
+			f.t.add(p, n, nil)
+			if seen == nil {
+				seen = make(map[uint32]bool)
+			}
+			if cluster >= 2 && !seen[cluster] {
+				seen[cluster] = true
+				if err := f.readDir(p, f.chainRuns(cluster, -1), seen); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		n.size = int64(le.Uint32(e[28:]))
+		n.content = &runFile{r: f.r, runs: f.chainRuns(cluster, n.size)}
+		f.t.add(p, n, nil)
+	}
+	return nil
+}
+
+// shortName decodes an 8.3 name. Windows records all-lowercase base
+// names and extensions in flag bits rather than as long names.
+func shortName(e []byte) string {
+	base := strings.TrimRight(string(e[0:8]), " ")
+	ext := strings.TrimRight(string(e[8:11]), " ")
+	if base != "" && base[0] == 0x05 {
+		base = "\xe5" + base[1:]
+	}
+	if e[12]&0x08 != 0 {
+		base = strings.ToLower(base)
+	}
+	if e[12]&0x10 != 0 {
+		ext = strings.ToLower(ext)
+	}
+	if ext == "" {
+		return base
+	}
+	return base + "." + ext
+}
+
+// longName decodes a long name, which ends at a NUL unless it fills its
+// entries.
+func longName(units []uint16) string {
+	for i, u := range units {
+		if u == 0 {
+			units = units[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// fatTime decodes a FAT date and time: years since 1980, and seconds in
+// two-second steps.
+func fatTime(date, clock uint16) time.Time {
+	if date == 0 {
+		return time.Time{}
+	}
+	return time.Date(1980+int(date>>9), time.Month(date>>5&0xf), int(date&0x1f),
+		int(clock>>11), int(clock>>5&0x3f), int(clock&0x1f)*2, 0, time.UTC)
+}
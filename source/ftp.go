@@ -0,0 +1,610 @@
+package source
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+const (
+	// ftpConns bounds the control connections opened to one server. FTP
+	// runs one command at a time per connection, so listings and
+	// downloads in parallel each need their own.
+	ftpConns = 4
+
+	ftpTimeout = 30 * time.Second
+)
+
+// FTP is a Source reading an FTP server, optionally over TLS. Directories
+// are listed with MLSD where the server supports it and LIST otherwise.
+type FTP struct {
+	addr     string
+	host     string
+	user     string
+	password string
+	tls      *tls.Config // Nil for plain FTP
+	implicit bool        // TLS from the first byte rather than after AUTH TLS
+
+	idle  chan *ftpConn
+	slots chan struct{} // One per open connection
+
+	mu    sync.Mutex
+	links map[string]string // Symlink targets seen in listings
+
+	prefetch *prefetcher
+}
+
+// openFTP opens an ftp://, ftps:// (implicit TLS) or ftpes:// (explicit
+// TLS) URL. The password is taken from the URL or FTP_PASSWORD; without a
+// user the login is anonymous.
+func openFTP(u *url.URL) (scan.Source, string, error) {
+	if u.Host == "" {
+		return nil, "", errors.New("ftp: URL has no host")
+	}
+	f := &FTP{
+		host:  u.Hostname(),
+		user:  "anonymous",
+		idle:  make(chan *ftpConn, ftpConns),
+		slots: make(chan struct{}, ftpConns),
+		links: make(map[string]string),
+	}
+	f.password = "anonymous@"
+	if u.User != nil {
+		f.user = u.User.Username()
+		f.password, _ = u.User.Password()
+		if f.password == "" {
+			f.password = os.Getenv("FTP_PASSWORD")
+		}
+	}
+	port := u.Port()
+	switch u.Scheme {
+	case "ftps":
+		f.implicit = true
+		if port == "" {
+			port = "990"
+		}
+		fallthrough
+	case "ftpes":
+		// Data connections resume the control session, which many
+		// servers insist on
+		f.tls = &tls.Config{ServerName: f.host, ClientSessionCache: tls.NewLRUClientSessionCache(ftpConns)}
+	}
+	if port == "" {
+		port = "21"
+	}
+	f.addr = net.JoinHostPort(f.host, port)
+	f.prefetch = newPrefetcher(ftpConns, f.readDir)
+
+	// Log in once up front so bad credentials fail the scan early
+	c, err := f.conn()
+	if err != nil {
+		return nil, "", err
+	}
+	dir := path.Clean("/" + u.Path)
+	if u.Path == "" || u.Path == "/" {
+		// The login directory, which isn't always /
+		if dir, err = c.pwd(); err != nil {
+			f.discard(c)
+			return nil, "", err
+		}
+	}
+	f.release(c)
+	return f, dir, nil
+}
+
+// Close logs out of every idle connection.
+func (f *FTP) Close() error {
+	for {
+		select {
+		case c := <-f.idle:
+			c.cmd(221, "QUIT")
+			c.close()
+		default:
+			return nil
+		}
+	}
+}
+
+// ftpConn is one logged-in control connection.
+type ftpConn struct {
+	f    *FTP
+	conn net.Conn
+	text *textproto.Conn
+	mlsd bool // Server lists with MLSD
+}
+
+// conn takes an idle connection or opens a new one.
+func (f *FTP) conn() (*ftpConn, error) {
+	select {
+	case c := <-f.idle:
+		return c, nil
+	case f.slots <- struct{}{}:
+	}
+	c, err := f.dial()
+	if err != nil {
+		<-f.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+// release returns a healthy connection to the pool.
+func (f *FTP) release(c *ftpConn) {
+	f.idle <- c
+}
+
+// discard closes a connection left in an unknown state.
+func (f *FTP) discard(c *ftpConn) {
+	c.close()
+	<-f.slots
+}
+
+func (f *FTP) dial() (*ftpConn, error) {
+	conn, err := net.DialTimeout("tcp", f.addr, ftpTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("ftp: %w", err)
+	}
+	if f.implicit {
+		conn = tls.Client(conn, f.tls)
+	}
+	c := &ftpConn{f: f, conn: conn, text: textproto.NewConn(conn)}
+	fail := func(err error) (*ftpConn, error) {
+		c.close()
+		return nil, fmt.Errorf("ftp: %s: %w", f.addr, err)
+	}
+	if _, _, err := c.read(220); err != nil {
+		return fail(err)
+	}
+	if f.tls != nil && !f.implicit {
+		if _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			return fail(err)
+		}
+		c.conn = tls.Client(conn, f.tls)
+		c.text = textproto.NewConn(c.conn)
+	}
+	code, _, err := c.exec("USER %s", f.user)
+	if err == nil && code == 331 {
+		_, err = c.cmd(230, "PASS %s", f.password)
+	} else if err == nil && code != 230 {
+		err = fmt.Errorf("login refused (%d)", code)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if f.tls != nil {
+		if _, err := c.cmd(200, "PBSZ 0"); err != nil {
+			return fail(err)
+		}
+		if _, err := c.cmd(200, "PROT P"); err != nil {
+			return fail(err)
+		}
+	}
+	if _, err := c.cmd(200, "TYPE I"); err != nil {
+		return fail(err)
+	}
+	c.exec("OPTS UTF8 ON")
+	_, feat, err := c.exec("FEAT")
+	c.mlsd = err == nil && strings.Contains(strings.ToUpper(feat), "MLST")
+	return c, nil
+}
+
+func (c *ftpConn) close() {
+	c.text.Close()
+}
+
+// read reads a reply, failing unless its code is want.
+func (c *ftpConn) read(want int) (int, string, error) {
+	c.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	return c.text.ReadResponse(want)
+}
+
+// exec sends a command and returns the reply whatever its code.
+func (c *ftpConn) exec(format string, args ...any) (int, string, error) {
+	c.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	if _, err := c.text.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.text.ReadResponse(0)
+}
+
+// cmd sends a command and fails unless the reply code is want.
+func (c *ftpConn) cmd(want int, format string, args ...any) (string, error) {
+	code, msg, err := c.exec(format, args...)
+	if err != nil {
+		return msg, err
+	}
+	if code != want {
+		return msg, ftpError(code, msg)
+	}
+	return msg, nil
+}
+
+// ftpError maps the reply codes for missing files and refused access to
+// their fs errors.
+func ftpError(code int, msg string) error {
+	err := &textproto.Error{Code: code, Msg: msg}
+	switch {
+	case code == 550 && strings.Contains(strings.ToLower(msg), "permission"):
+		return fmt.Errorf("%w: %w", fs.ErrPermission, err)
+	case code == 550:
+		return fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	case code == 530:
+		return fmt.Errorf("%w: %w", fs.ErrPermission, err)
+	}
+	return err
+}
+
+func (c *ftpConn) pwd() (string, error) {
+	msg, err := c.cmd(257, "PWD")
+	if err != nil {
+		return "", fmt.Errorf("ftp: PWD: %w", err)
+	}
+	// 257 "/home/user" is the current directory
+	start, end := strings.Index(msg, `"`), strings.LastIndex(msg, `"`)
+	if start < 0 || end <= start {
+		return "/", nil
+	}
+	return strings.ReplaceAll(msg[start+1:end], `""`, `"`), nil
+}
+
+// data opens a passive data connection, preferring EPSV.
+func (c *ftpConn) data() (net.Conn, error) {
+	var port string
+	if msg, err := c.cmd(229, "EPSV"); err == nil {
+		// Entering Extended Passive Mode (|||port|)
+		if start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)"); start >= 0 && end > start+4 {
+			port = msg[start+4 : end]
+		}
+	}
+	if port == "" {
+		msg, err := c.cmd(227, "PASV")
+		if err != nil {
+			return nil, err
+		}
+		// Entering Passive Mode (h1,h2,h3,h4,p1,p2); the address is
+		// ignored since servers behind NAT often report a private one
+		start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		if start < 0 || end <= start {
+			return nil, fmt.Errorf("bad PASV reply %q", msg)
+		}
+		parts := strings.Split(msg[start+1:end], ",")
+		if len(parts) != 6 {
+			return nil, fmt.Errorf("bad PASV reply %q", msg)
+		}
+		hi, _ := strconv.Atoi(parts[4])
+		lo, _ := strconv.Atoi(parts[5])
+		port = strconv.Itoa(hi<<8 | lo)
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.f.host, port), ftpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if c.f.tls != nil {
+		conn = tls.Client(conn, c.f.tls)
+	}
+	return conn, nil
+}
+
+// transfer opens a data connection and starts a command using it.
+func (c *ftpConn) transfer(format string, args ...any) (net.Conn, error) {
+	conn, err := c.data()
+	if err != nil {
+		return nil, err
+	}
+	code, msg, err := c.exec(format, args...)
+	if err == nil && code != 150 && code != 125 {
+		err = ftpError(code, msg)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(ftpTimeout))
+	return conn, nil
+}
+
+// ReadDir implements scan.Source.
+func (f *FTP) ReadDir(p string) ([]fs.DirEntry, error) {
+	return f.prefetch.ReadDir(p)
+}
+
+func (f *FTP) readDir(p string) ([]fs.DirEntry, error) {
+	c, err := f.conn()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := c.list(p)
+	if err != nil {
+		var te *textproto.Error
+		if errors.As(err, &te) {
+			// The server answered, so the connection is still in step
+			f.release(c)
+		} else {
+			f.discard(c)
+		}
+		return nil, &fs.PathError{Op: "list", Path: p, Err: err}
+	}
+	f.release(c)
+
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		if info.link != "" {
+			f.mu.Lock()
+			f.links[path.Join(p, info.name)] = info.link
+			f.mu.Unlock()
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// list reads a directory listing over a data connection.
+func (c *ftpConn) list(p string) ([]*ftpInfo, error) {
+	command := "LIST %s"
+	if c.mlsd {
+		command = "MLSD %s"
+	}
+	conn, err := c.transfer(command, p)
+	if err != nil {
+		return nil, err
+	}
+	var infos []*ftpInfo
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		var info *ftpInfo
+		if c.mlsd {
+			info = parseMLSx(line)
+		} else {
+			info = parseLIST(line)
+		}
+		if info != nil && info.name != "." && info.name != ".." {
+			infos = append(infos, info)
+		}
+	}
+	conn.Close()
+	if _, _, err := c.read(2); err != nil {
+		return nil, err
+	}
+	return infos, sc.Err()
+}
+
+// Lstat implements scan.Source.
+func (f *FTP) Lstat(p string) (fs.FileInfo, error) {
+	c, err := f.conn()
+	if err != nil {
+		return nil, err
+	}
+	defer f.release(c)
+	if c.mlsd {
+		// 250-Listing /path
+		//  type=dir;modify=...; /path
+		// 250 End
+		msg, err := c.cmd(250, "MLST %s", p)
+		if err != nil {
+			return nil, &fs.PathError{Op: "mlst", Path: p, Err: err}
+		}
+		for _, line := range strings.Split(msg, "\n") {
+			if info := parseMLSx(strings.TrimSpace(line)); info != nil {
+				info.name = path.Base(p)
+				return info, nil
+			}
+		}
+	}
+	// Without MLST, a directory is whatever CWD accepts
+	if _, err := c.cmd(250, "CWD %s", p); err == nil {
+		return &ftpInfo{name: path.Base(p), mode: fs.ModeDir | 0o755}, nil
+	}
+	msg, err := c.cmd(213, "SIZE %s", p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "size", Path: p, Err: err}
+	}
+	size, _ := strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+	return &ftpInfo{name: path.Base(p), size: size, mode: 0o644}, nil
+}
+
+// Open implements scan.Source. The connection is held until the file is
+// closed.
+func (f *FTP) Open(p string) (io.ReadCloser, error) {
+	c, err := f.conn()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.transfer("RETR %s", p)
+	if err != nil {
+		var te *textproto.Error
+		if errors.As(err, &te) {
+			f.release(c)
+		} else {
+			f.discard(c)
+		}
+		return nil, &fs.PathError{Op: "retr", Path: p, Err: err}
+	}
+	return &ftpFile{c: c, data: conn}, nil
+}
+
+// ftpFile is a download in progress.
+type ftpFile struct {
+	c    *ftpConn
+	data net.Conn
+	eof  bool
+}
+
+func (r *ftpFile) Read(p []byte) (int, error) {
+	r.data.SetDeadline(time.Now().Add(ftpTimeout))
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// Close ends the transfer. A transfer cut short leaves the control
+// connection in an unknown state, so it is dropped rather than reused.
+func (r *ftpFile) Close() error {
+	r.data.Close()
+	if !r.eof {
+		r.c.f.discard(r.c)
+		return nil
+	}
+	if _, _, err := r.c.read(2); err != nil {
+		r.c.f.discard(r.c)
+		return err
+	}
+	r.c.f.release(r.c)
+	return nil
+}
+
+// Readlink implements scan.Source, for links whose target the listing
+// showed.
+func (f *FTP) Readlink(p string) (string, error) {
+	f.mu.Lock()
+	target, ok := f.links[p]
+	f.mu.Unlock()
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: p, Err: errors.ErrUnsupported}
+	}
+	return target, nil
+}
+
+// ftpInfo is the fs.FileInfo of a listed entry.
+type ftpInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+	link  string
+	owner *scan.FileOwner
+}
+
+func (i *ftpInfo) Name() string       { return i.name }
+func (i *ftpInfo) Size() int64        { return i.size }
+func (i *ftpInfo) Mode() fs.FileMode  { return i.mode }
+func (i *ftpInfo) ModTime() time.Time { return i.mtime }
+func (i *ftpInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *ftpInfo) Sys() any           { return i.owner }
+
+// parseMLSx parses an RFC 3659 fact line: "type=file;size=12;modify=
+// 20240501100000; name". It returns nil for lines without facts.
+func parseMLSx(line string) *ftpInfo {
+	facts, name, ok := strings.Cut(line, " ")
+	if !ok || !strings.Contains(facts, "=") {
+		return nil
+	}
+	info := &ftpInfo{name: path.Base(name), mode: 0o644}
+	var uid, gid = -1, -1
+	for _, fact := range strings.Split(facts, ";") {
+		key, value, _ := strings.Cut(fact, "=")
+		switch strings.ToLower(key) {
+		case "type":
+			switch t := strings.ToLower(value); {
+			case t == "dir" || t == "cdir" || t == "pdir":
+				info.mode = fs.ModeDir | 0o755
+				if t != "dir" {
+					info.name = "."
+				}
+			case strings.HasPrefix(t, "os.unix=slink"), t == "os.unix=symlink":
+				info.mode = fs.ModeSymlink | 0o777
+				_, info.link, _ = strings.Cut(value[len("OS.unix="):], ":")
+			case t != "file":
+				info.mode = fs.ModeIrregular
+			}
+		case "size":
+			info.size, _ = strconv.ParseInt(value, 10, 64)
+		case "modify":
+			info.mtime, _ = time.Parse("20060102150405", value[:min(len(value), 14)])
+		case "unix.mode":
+			if m, err := strconv.ParseUint(value, 8, 32); err == nil {
+				info.mode = info.mode.Type() | fs.FileMode(m&0o777)
+			}
+		case "unix.uid", "unix.owner":
+			if n, err := strconv.Atoi(value); err == nil {
+				uid = n
+			}
+		case "unix.gid", "unix.group":
+			if n, err := strconv.Atoi(value); err == nil {
+				gid = n
+			}
+		}
+	}
+	if uid >= 0 || gid >= 0 {
+		info.owner = &scan.FileOwner{UID: uid, GID: gid}
+	}
+	return info
+}
+
+// parseLIST parses a Unix ls -l style LIST line, the near-universal
+// format:
+//
+//	drwxr-xr-x 2 user group 4096 May  1 10:00 name
+//	lrwxrwxrwx 1 user group    7 May  1  2023 link -> target
+func parseLIST(line string) *ftpInfo {
+	fields := strings.Fields(line)
+	if len(fields) < 9 || len(fields[0]) < 10 {
+		return nil
+	}
+	info := &ftpInfo{mode: fs.FileMode(0)}
+	perm := fields[0]
+	switch perm[0] {
+	case 'd':
+		info.mode = fs.ModeDir
+	case 'l':
+		info.mode = fs.ModeSymlink
+	case '-':
+	default:
+		info.mode = fs.ModeIrregular
+	}
+	for i, c := range perm[1:10] {
+		if c != '-' {
+			info.mode |= 1 << (8 - i)
+		}
+	}
+	info.size, _ = strconv.ParseInt(fields[4], 10, 64)
+	info.mtime = parseLISTTime(fields[5], fields[6], fields[7])
+
+	// The name is everything after the time, spaces included
+	rest := line
+	for i := 0; i < 8; i++ {
+		rest = strings.TrimLeft(rest, " ")
+		rest = rest[strings.IndexByte(rest, ' ')+1:]
+	}
+	info.name = strings.TrimLeft(rest, " ")
+	if info.mode&fs.ModeSymlink != 0 {
+		info.name, info.link, _ = strings.Cut(info.name, " -> ")
+	}
+	return info
+}
+
+// parseLISTTime parses "May 1 10:00" (within the last six months) or
+// "May 1 2023".
+func parseLISTTime(month, day, clock string) time.Time {
+	if strings.Contains(clock, ":") {
+		now := time.Now().UTC()
+		t, err := time.Parse("Jan 2 15:04 2006", month+" "+day+" "+clock+" "+strconv.Itoa(now.Year()))
+		if err != nil {
+			return time.Time{}
+		}
+		if t.After(now.Add(24 * time.Hour)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+		return t
+	}
+	t, _ := time.Parse("Jan 2 2006", month+" "+day+" "+clock)
+	return t
+}
@@ -0,0 +1,206 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// GitCommand is the git client used to read repositories.
+var GitCommand = "git"
+
+// Git is a Source over the tree of a commit in a local repository, read
+// from the object database rather than the worktree, so any commit,
+// branch or tag can be inventoried. Git keeps no file times; every entry
+// carries the commit's time. Submodules are empty directories, as in a
+// clone without --recurse-submodules.
+type Git struct {
+	*tree
+	repo string
+
+	mu    sync.Mutex // Held from Open until the reader is closed
+	batch *exec.Cmd  // git cat-file --batch, started on first use
+	in    io.WriteCloser
+	out   *bufio.Reader
+}
+
+// openGit opens a git://path#ref URL, reading ref (HEAD by default) of
+// the repository at path.
+func openGit(spec string) (scan.Source, string, error) {
+	repo, ref, _ := strings.Cut(spec, "#")
+	if repo == "" {
+		repo = "."
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	g := &Git{tree: newTree(), repo: repo}
+	out, err := g.git("log", "-1", "--format=%H %ct", "--end-of-options", ref+"^{commit}", "--")
+	if err != nil {
+		return nil, "", err
+	}
+	commit, unix, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	secs, _ := strconv.ParseInt(unix, 10, 64)
+	mtime := time.Unix(secs, 0)
+	g.nodes["/"].mtime = mtime
+
+	out, err = g.git("ls-tree", "-r", "-t", "-l", "-z", "--full-tree", commit)
+	if err != nil {
+		return nil, "", err
+	}
+	var links []*treeNode
+	for _, line := range bytes.Split(out, []byte{0}) {
+		// <mode> <type> <object> <size>\t<path>
+		meta, name, ok := strings.Cut(string(line), "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 4 {
+			continue
+		}
+		perm, _ := strconv.ParseUint(fields[0], 8, 32)
+		n := &treeNode{
+			name:  name[strings.LastIndex(name, "/")+1:],
+			mode:  fs.FileMode(perm & 0o777),
+			mtime: mtime,
+			sys:   &scan.SourceSys{Values: scan.SourceValues{"blob": fields[2]}},
+		}
+		n.size, _ = strconv.ParseInt(fields[3], 10, 64)
+		switch fields[0] {
+		case "040000", "160000":
+			n.mode = fs.ModeDir | 0o755
+		case "120000":
+			n.mode = fs.ModeSymlink | 0o777
+			links = append(links, n)
+		}
+		g.add("/"+name, n, nil)
+	}
+	g.finish()
+
+	// Symlink targets are the content of their blobs
+	for _, n := range links {
+		r, err := g.open(n.sys.Values["blob"].(string))
+		if err != nil {
+			g.Close()
+			return nil, "", err
+		}
+		target, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			g.Close()
+			return nil, "", err
+		}
+		n.link = string(target)
+	}
+	return g, "/", nil
+}
+
+// Columns implements scan.ColumnSource.
+func (g *Git) Columns() []scan.Column {
+	return []scan.Column{scan.SourceColumn("blob")}
+}
+
+// git runs a git command in the repository and returns its output.
+func (g *Git) git(args ...string) ([]byte, error) {
+	cmd := exec.Command(GitCommand, append([]string{"-C", g.repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git: %s: %s", g.repo, msg)
+		}
+		return nil, fmt.Errorf("git: %s: %w", g.repo, err)
+	}
+	return out, nil
+}
+
+// Open implements scan.Source.
+func (g *Git) Open(p string) (io.ReadCloser, error) {
+	n, err := g.node("open", p)
+	if err != nil {
+		return nil, err
+	}
+	r, err := g.open(n.sys.Values["blob"].(string))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: err}
+	}
+	return r, nil
+}
+
+// open reads an object through the cat-file process, which serves one
+// object at a time, so it stays locked until the reader is closed.
+func (g *Git) open(object string) (io.ReadCloser, error) {
+	g.mu.Lock()
+	if g.batch == nil {
+		cmd := exec.Command(GitCommand, "-C", g.repo, "cat-file", "--batch")
+		in, err := cmd.StdinPipe()
+		if err != nil {
+			g.mu.Unlock()
+			return nil, err
+		}
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			g.mu.Unlock()
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			g.mu.Unlock()
+			return nil, fmt.Errorf("git: starting %s: %w", GitCommand, err)
+		}
+		g.batch, g.in, g.out = cmd, in, bufio.NewReader(out)
+	}
+	// <object> <type> <size>\n<content>\n, or <object> missing\n
+	fmt.Fprintln(g.in, object)
+	header, err := g.out.ReadString('\n')
+	if err != nil {
+		g.mu.Unlock()
+		return nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		g.mu.Unlock()
+		return nil, fmt.Errorf("git: object %s: %s", object, strings.TrimSpace(header))
+	}
+	size, _ := strconv.ParseInt(fields[2], 10, 64)
+	return &gitBlob{g: g, r: io.LimitReader(g.out, size)}, nil
+}
+
+// gitBlob is an object being read from cat-file.
+type gitBlob struct {
+	g *Git
+	r io.Reader
+}
+
+func (b *gitBlob) Read(p []byte) (int, error) { return b.r.Read(p) }
+
+// Close skips what was not read, and the newline after the content, so
+// the next object starts in step.
+func (b *gitBlob) Close() error {
+	defer b.g.mu.Unlock()
+	if _, err := io.Copy(io.Discard, b.r); err != nil {
+		return err
+	}
+	_, err := b.g.out.ReadByte()
+	return err
+}
+
+// Close stops the cat-file process.
+func (g *Git) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.batch == nil {
+		return nil
+	}
+	g.in.Close()
+	err := g.batch.Wait()
+	g.batch = nil
+	return err
+}
@@ -0,0 +1,242 @@
+package source
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// googleScope is the OAuth scope requested for Cloud Storage.
+const googleScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// googleAuth hands out OAuth access tokens, fetching a new one shortly
+// before the current one expires. A nil fetch means anonymous requests.
+type googleAuth struct {
+	fetch func() (token string, ttl time.Duration, err error)
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// authorize sets the Authorization header of req.
+func (a *googleAuth) authorize(req *http.Request) error {
+	if a.fetch == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token == "" || time.Until(a.expiry) < time.Minute {
+		token, ttl, err := a.fetch()
+		if err != nil {
+			return fmt.Errorf("gs: getting an access token: %w", err)
+		}
+		a.token, a.expiry = token, time.Now().Add(ttl)
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// googleCredentials is a credentials file: a service account key or the
+// user credentials gcloud auth application-default login writes.
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// loadGoogleAuth finds credentials the way Google's client libraries do:
+// GOOGLE_OAUTH_ACCESS_TOKEN, then the GOOGLE_APPLICATION_CREDENTIALS file,
+// then gcloud's application default credentials, then the metadata server
+// on Google Cloud. Without any, requests are anonymous, which public
+// buckets allow.
+func loadGoogleAuth(client *http.Client) (*googleAuth, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return &googleAuth{fetch: func() (string, time.Duration, error) { return token, 24 * time.Hour, nil }}, nil
+	}
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		path = gcloudCredentialsPath()
+		if _, err := os.Stat(path); err != nil {
+			path = ""
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var creds googleCredentials
+		if err := json.Unmarshal(data, &creds); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		switch creds.Type {
+		case "service_account":
+			key, err := parseRSAKey(creds.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return &googleAuth{fetch: func() (string, time.Duration, error) {
+				return serviceAccountToken(client, creds, key)
+			}}, nil
+		case "authorized_user":
+			return &googleAuth{fetch: func() (string, time.Duration, error) {
+				return requestToken(client, "https://oauth2.googleapis.com/token", url.Values{
+					"grant_type":    {"refresh_token"},
+					"refresh_token": {creds.RefreshToken},
+					"client_id":     {creds.ClientID},
+					"client_secret": {creds.ClientSecret},
+				})
+			}}, nil
+		}
+		return nil, fmt.Errorf("%s: unsupported credentials type %q", path, creds.Type)
+	}
+	if onGoogleCloud(client) {
+		return &googleAuth{fetch: func() (string, time.Duration, error) { return metadataToken(client) }}, nil
+	}
+	return &googleAuth{}, nil
+}
+
+// gcloudCredentialsPath is where gcloud keeps application default
+// credentials.
+func gcloudCredentialsPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud", "application_default_credentials.json")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+func parseRSAKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// serviceAccountToken trades a signed JWT for an access token.
+func serviceAccountToken(client *http.Client, creds googleCredentials, key *rsa.PrivateKey) (string, time.Duration, error) {
+	aud := creds.TokenURI
+	if aud == "" {
+		aud = "https://oauth2.googleapis.com/token"
+	}
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss": creds.ClientEmail, "scope": googleScope, "aud": aud,
+		"iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", 0, err
+	}
+	return requestToken(client, aud, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	})
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func requestToken(client *http.Client, endpoint string, form url.Values) (string, time.Duration, error) {
+	resp, err := doHTTP(client, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		return req, err
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return decodeToken(resp)
+}
+
+func decodeToken(resp *http.Response) (string, time.Duration, error) {
+	defer resp.Body.Close()
+	var tok tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", 0, err
+	}
+	if tok.AccessToken == "" {
+		return "", 0, errors.New("no access token in response")
+	}
+	return tok.AccessToken, time.Duration(tok.ExpiresIn) * time.Second, nil
+}
+
+// metadataHost is the metadata server of Google Cloud VMs and containers.
+func metadataHost() string {
+	if h := os.Getenv("GCE_METADATA_HOST"); h != "" {
+		return h
+	}
+	return "metadata.google.internal"
+}
+
+// onGoogleCloud reports whether a metadata server answers.
+func onGoogleCloud(client *http.Client) bool {
+	probe := *client
+	probe.Timeout = 2 * time.Second
+	req, err := http.NewRequest(http.MethodGet, "http://"+metadataHost()+"/computeMetadata/v1/", nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := probe.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Metadata-Flavor") == "Google"
+}
+
+func metadataToken(client *http.Client) (string, time.Duration, error) {
+	resp, err := doHTTP(client, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet,
+			"http://"+metadataHost()+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+		return req, err
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return decodeToken(resp)
+}
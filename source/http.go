@@ -0,0 +1,88 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpRetries is how many times a throttled or failed request is retried.
+const httpRetries = 4
+
+// httpError is an unsuccessful HTTP response. 404 and 403 unwrap to
+// fs.ErrNotExist and fs.ErrPermission so the scanner treats them like
+// their filesystem counterparts.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	if e.msg == "" {
+		return http.StatusText(e.status)
+	}
+	return fmt.Sprintf("%s: %s", http.StatusText(e.status), e.msg)
+}
+
+func (e *httpError) Unwrap() error {
+	switch e.status {
+	case http.StatusNotFound:
+		return fs.ErrNotExist
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return fs.ErrPermission
+	}
+	return nil
+}
+
+// doHTTP sends the request built by newReq and returns a successful
+// response. Throttling, server errors and dropped connections are retried
+// with exponential backoff; newReq is called for every attempt so
+// signatures stay fresh.
+func doHTTP(client *http.Client, newReq func() (*http.Request, error)) (*http.Response, error) {
+	backoff := 200 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+		if err == nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			err = &httpError{status: resp.StatusCode, msg: errorMessage(body)}
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+				return nil, err
+			}
+		}
+		if attempt == httpRetries {
+			return nil, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// errorMessage extracts the message from an error body in the XML or
+// JSON shapes cloud storage APIs use.
+func errorMessage(body []byte) string {
+	s := string(body)
+	for _, tag := range []string{"Message", "message"} {
+		if _, rest, ok := strings.Cut(s, "<"+tag+">"); ok {
+			if msg, _, ok := strings.Cut(rest, "</"+tag+">"); ok {
+				return msg
+			}
+		}
+		if _, rest, ok := strings.Cut(s, `"`+tag+`": "`); ok {
+			if msg, _, ok := strings.Cut(rest, `"`); ok {
+				return msg
+			}
+		}
+	}
+	return ""
+}
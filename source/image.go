@@ -0,0 +1,385 @@
+package source
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// Image is a Source over a container image's layers. The root holds one
+// directory per layer, named by the first 12 hex digits of its diff ID
+// (the digest of the uncompressed layer, which stays the same however the
+// image was pulled or saved), so a layer shared by two images lines up in
+// their inventories. Files are recorded as each layer stores them,
+// whiteouts included, which is what supply-chain and bloat analysis
+// want; the merged filesystem is not reconstructed.
+//
+// Layers are read once when the image is opened; compressed layers are
+// unpacked to temporary files, removed by Close.
+type Image struct {
+	*tree
+	temps []*os.File
+	files []*os.File // Opened tarballs
+}
+
+// imageConfig is the part of an image configuration naming the layers.
+type imageConfig struct {
+	Created time.Time `json:"created"`
+	RootFS  struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+	History []struct {
+		Created    time.Time `json:"created"`
+		CreatedBy  string    `json:"created_by"`
+		EmptyLayer bool      `json:"empty_layer"`
+	} `json:"history"`
+}
+
+// imageLayer is what is known of a layer before its content is read.
+type imageLayer struct {
+	id      string // Diff ID, or the blob digest when the config lacks it
+	command string
+	created time.Time
+}
+
+// layersOf pairs the config's diff IDs and history with the count layers
+// of a manifest, whose blob digests stand in for missing diff IDs.
+func layersOf(config []byte, digests []string) ([]imageLayer, time.Time, error) {
+	var cfg imageConfig
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return nil, time.Time{}, fmt.Errorf("image config: %w", err)
+	}
+	layers := make([]imageLayer, len(digests))
+	for i := range layers {
+		layers[i].id = digests[i]
+		if len(cfg.RootFS.DiffIDs) == len(digests) {
+			layers[i].id = cfg.RootFS.DiffIDs[i]
+		}
+	}
+	// History has an entry per build step; only some made a layer
+	i := 0
+	for _, h := range cfg.History {
+		if h.EmptyLayer || i == len(layers) {
+			continue
+		}
+		layers[i].command, layers[i].created = h.CreatedBy, h.Created
+		i++
+	}
+	return layers, cfg.Created, nil
+}
+
+func newImage() *Image {
+	return &Image{tree: newTree()}
+}
+
+// Columns implements scan.ColumnSource.
+func (img *Image) Columns() []scan.Column {
+	return []scan.Column{scan.SourceColumn("layer"), scan.SourceColumn("layer_index"), scan.SourceColumn("layer_command")}
+}
+
+// addLayer indexes the index-th layer (from 1) read from r, which may be
+// gzip compressed. A plain tar in a local file is indexed in place when
+// local is its section; anything else is first copied to a temporary
+// file.
+func (img *Image) addLayer(index int, layer imageLayer, r io.Reader, local *io.SectionReader) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	content := local
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		if content, err = img.temp(zr); err != nil {
+			return err
+		}
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return errors.New("zstd compressed layers are not supported")
+	case content == nil:
+		var err error
+		if content, err = img.temp(br); err != nil {
+			return err
+		}
+	}
+
+	short := strings.TrimPrefix(layer.id, "sha256:")
+	short = short[:min(len(short), 12)]
+	dir := "/" + short
+	if _, ok := img.nodes[dir]; ok {
+		// The same layer can appear twice, e.g. one from an empty RUN
+		dir = fmt.Sprintf("/%s-%d", short, index)
+	}
+	values := scan.SourceValues{"layer": layer.id, "layer_index": index, "layer_command": layer.command}
+	root := &treeNode{name: path.Base(dir), mode: fs.ModeDir | 0o755, mtime: layer.created, sys: &scan.SourceSys{Values: values}}
+	img.add(dir, root, values)
+
+	// tar.Reader reads exactly the headers, so after Next the count is
+	// where the file's data starts
+	counter := &countingReader{r: content}
+	tr := tar.NewReader(counter)
+	var hardlinks []*treeNode
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", layer.id, err)
+		}
+		p := path.Clean("/" + hdr.Name)
+		if p == "/" {
+			continue
+		}
+		n := &treeNode{
+			name:  path.Base(p),
+			size:  hdr.Size,
+			mode:  hdr.FileInfo().Mode(),
+			mtime: hdr.ModTime,
+			sys:   &scan.SourceSys{Owner: &scan.FileOwner{UID: hdr.Uid, GID: hdr.Gid}, Values: values},
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeGNUSparse:
+			if _, sparse := hdr.PAXRecords["GNU.sparse.map"]; !sparse && hdr.Typeflag == tar.TypeReg {
+				n.content = io.NewSectionReader(content, counter.n, hdr.Size)
+			}
+		case tar.TypeSymlink:
+			n.link = hdr.Linkname
+		case tar.TypeLink:
+			// Resolved below, since the target may come later
+			n.link = path.Join(dir, path.Clean("/"+hdr.Linkname))
+		}
+		if n = img.add(path.Join(dir, p), n, values); hdr.Typeflag == tar.TypeLink {
+			hardlinks = append(hardlinks, n)
+		}
+	}
+	for _, n := range hardlinks {
+		if target, ok := img.nodes[n.link]; ok && target.mode.IsRegular() {
+			n.size, n.content = target.size, target.content
+		}
+		n.link = ""
+	}
+	return nil
+}
+
+// temp copies r to a temporary file Close removes.
+func (img *Image) temp(r io.Reader) (*io.SectionReader, error) {
+	f, err := os.CreateTemp("", "file_paths-layer-*")
+	if err != nil {
+		return nil, err
+	}
+	img.temps = append(img.temps, f)
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(f, 0, size), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Close removes the temporary layer files.
+func (img *Image) Close() error {
+	for _, f := range img.temps {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	for _, f := range img.files {
+		f.Close()
+	}
+	img.temps, img.files = nil, nil
+	return nil
+}
+
+// openImage opens a docker:// reference: a local image tarball, as
+// docker save or an OCI archive writes, or an image to pull from its
+// registry.
+func openImage(ref string) (scan.Source, string, error) {
+	if ref == "" {
+		return nil, "", errors.New("docker: no image given: docker://image[:tag] or docker://path/to/image.tar")
+	}
+	var img *Image
+	var err error
+	if info, statErr := os.Stat(ref); statErr == nil && info.Mode().IsRegular() {
+		img, err = openImageTarball(ref)
+	} else {
+		img, err = pullImage(ref)
+	}
+	if err != nil {
+		if img != nil {
+			img.Close()
+		}
+		return nil, "", fmt.Errorf("docker: %w", err)
+	}
+	img.finish()
+	return img, "/", nil
+}
+
+// imageManifest is an OCI or Docker v2 image manifest, or an index of
+// them.
+type imageManifest struct {
+	MediaType string `json:"mediaType"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Layers []struct {
+		Digest string `json:"digest"`
+	} `json:"layers"`
+}
+
+// pick chooses the entry of an index for DOCKER_DEFAULT_PLATFORM, or
+// linux on this machine's architecture, falling back to linux/amd64.
+func (m *imageManifest) pick() (string, error) {
+	want := os.Getenv("DOCKER_DEFAULT_PLATFORM")
+	if want == "" {
+		want = "linux/" + runtime.GOARCH
+	}
+	for _, platform := range []string{want, "linux/amd64"} {
+		for _, d := range m.Manifests {
+			p := d.Platform.OS + "/" + d.Platform.Architecture
+			if platform == p || platform == p+"/"+d.Platform.Variant {
+				return d.Digest, nil
+			}
+		}
+	}
+	if len(m.Manifests) == 1 {
+		return m.Manifests[0].Digest, nil
+	}
+	return "", fmt.Errorf("no image for platform %s", want)
+}
+
+// openImageTarball reads a docker save tarball through its manifest.json,
+// or an OCI image layout archive through its index.json.
+func openImageTarball(name string) (*Image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	img := newImage()
+	img.files = append(img.files, f)
+	members := make(map[string]*io.SectionReader)
+	counter := &countingReader{r: f}
+	tr := tar.NewReader(counter)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return img, fmt.Errorf("%s: %w", name, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			members[path.Clean(hdr.Name)] = io.NewSectionReader(f, counter.n, hdr.Size)
+		}
+	}
+	read := func(member string) ([]byte, error) {
+		sec, ok := members[path.Clean(member)]
+		if !ok {
+			return nil, fmt.Errorf("%s: no %s in the archive", name, member)
+		}
+		return io.ReadAll(io.NewSectionReader(sec, 0, sec.Size()))
+	}
+	blob := func(digest string) string {
+		algo, hex, _ := strings.Cut(digest, ":")
+		return "blobs/" + algo + "/" + hex
+	}
+
+	var configName string
+	var layerNames, digests []string
+	if data, err := read("manifest.json"); err == nil {
+		var saved []struct {
+			Config string
+			Layers []string
+		}
+		if err := json.Unmarshal(data, &saved); err != nil || len(saved) == 0 {
+			return img, fmt.Errorf("%s: bad manifest.json", name)
+		}
+		configName, layerNames = saved[0].Config, saved[0].Layers
+		for _, l := range layerNames {
+			// Layers are named by digest, as blobs/sha256/<hex> or <hex>/layer.tar
+			hex := path.Base(l)
+			if hex == "layer.tar" {
+				hex = path.Base(path.Dir(l))
+			}
+			digests = append(digests, "sha256:"+hex)
+		}
+	} else {
+		data, err := read("index.json")
+		if err != nil {
+			return img, fmt.Errorf("%s: neither a docker save nor an OCI archive", name)
+		}
+		var m imageManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return img, fmt.Errorf("%s: index.json: %w", name, err)
+		}
+		// Indexes may nest before reaching an image manifest
+		for len(m.Manifests) > 0 {
+			digest, err := m.pick()
+			if err != nil {
+				return img, err
+			}
+			if data, err = read(blob(digest)); err != nil {
+				return img, err
+			}
+			m = imageManifest{}
+			if err := json.Unmarshal(data, &m); err != nil {
+				return img, fmt.Errorf("%s: %s: %w", name, digest, err)
+			}
+		}
+		configName = blob(m.Config.Digest)
+		for _, l := range m.Layers {
+			layerNames = append(layerNames, blob(l.Digest))
+			digests = append(digests, l.Digest)
+		}
+	}
+
+	config, err := read(configName)
+	if err != nil {
+		return img, err
+	}
+	layers, created, err := layersOf(config, digests)
+	if err != nil {
+		return img, err
+	}
+	img.nodes["/"].mtime = created
+	for i, l := range layerNames {
+		sec, ok := members[path.Clean(l)]
+		if !ok {
+			return img, fmt.Errorf("%s: no %s in the archive", name, l)
+		}
+		if err := img.addLayer(i+1, layers[i], io.NewSectionReader(sec, 0, sec.Size()), sec); err != nil {
+			return img, err
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,303 @@
+package source
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// isoSector is the size of ISO9660 volume descriptors.
+const isoSector = 2048
+
+// isoReader lists an ISO9660 filesystem. Rock Ridge names, modes, owners
+// and symlinks are used when present, otherwise Joliet's long names,
+// otherwise the plain 8.3-style names.
+type isoReader struct {
+	r         io.ReaderAt
+	t         *tree
+	blockSize int64
+	joliet    bool
+	rrSkip    int // Bytes before the SUSP entries; -1 without Rock Ridge
+	seen      map[int64]bool
+}
+
+func readISO(r io.ReaderAt, t *tree, dir string) error {
+	iso := &isoReader{r: r, t: t, rrSkip: -1, seen: make(map[int64]bool)}
+	var primary, joliet []byte
+	for sector := int64(16); sector < 64; sector++ {
+		vd := make([]byte, isoSector)
+		if _, err := r.ReadAt(vd, sector*isoSector); err != nil {
+			return err
+		}
+		if string(vd[1:6]) != "CD001" || vd[0] == 255 {
+			break
+		}
+		switch vd[0] {
+		case 1:
+			primary = vd
+		case 2:
+			// Joliet marks its supplementary descriptor with a UCS-2 escape
+			if esc := string(vd[88:91]); esc == "%/@" || esc == "%/C" || esc == "%/E" {
+				joliet = vd
+			}
+		}
+	}
+	if primary == nil {
+		return errors.New("iso9660: no primary volume descriptor")
+	}
+	iso.blockSize = int64(binary.LittleEndian.Uint16(primary[128:]))
+	root := primary[156 : 156+34]
+	if iso.rrSkip = iso.rockRidge(root); iso.rrSkip < 0 && joliet != nil {
+		iso.joliet = true
+		root = joliet[156 : 156+34]
+	}
+	t.mkdir(dir).mtime = isoTime(root[18:])
+	return iso.readDir(dir, root)
+}
+
+// rockRidge checks the root directory's first record for the SUSP "SP"
+// entry and returns the bytes to skip in every system use area, or -1.
+func (iso *isoReader) rockRidge(root []byte) int {
+	recs, err := iso.records(root)
+	if err != nil || len(recs) == 0 {
+		return -1
+	}
+	su := systemUse(recs[0])
+	if len(su) >= 7 && string(su[:2]) == "SP" && su[4] == 0xbe && su[5] == 0xef {
+		return int(su[6])
+	}
+	return -1
+}
+
+// systemUse returns the system use area of a directory record.
+func systemUse(rec []byte) []byte {
+	end := 33 + int(rec[32])
+	if rec[32]%2 == 0 {
+		end++ // Padding after an even-length name
+	}
+	if end > len(rec) {
+		return nil
+	}
+	return rec[end:]
+}
+
+// records reads the directory records of the directory rec describes.
+func (iso *isoReader) records(rec []byte) ([][]byte, error) {
+	start := int64(binary.LittleEndian.Uint32(rec[2:])) * iso.blockSize
+	size := int64(binary.LittleEndian.Uint32(rec[10:]))
+	data := make([]byte, size)
+	if _, err := iso.r.ReadAt(data, start); err != nil {
+		return nil, err
+	}
+	var recs [][]byte
+	for off := int64(0); off < size; {
+		n := int64(data[off])
+		if n == 0 {
+			// Records don't cross sectors; the rest of this one is padding
+			off = (off/isoSector + 1) * isoSector
+			continue
+		}
+		if off+n > size || n < 34 {
+			break
+		}
+		recs = append(recs, data[off:off+n])
+		off += n
+	}
+	return recs, nil
+}
+
+func (iso *isoReader) readDir(dir string, rec []byte) error {
+	extent := int64(binary.LittleEndian.Uint32(rec[2:]))
+	if iso.seen[extent] {
+		return nil
+	}
+	iso.seen[extent] = true
+	recs, err := iso.records(rec)
+	if err != nil {
+		return err
+	}
+	var pending *treeNode // A file continued in the next record
+	for _, rec := range recs {
+		nameLen := int(rec[32])
+		raw := rec[33 : 33+nameLen]
+		if nameLen == 1 && (raw[0] == 0 || raw[0] == 1) {
+			continue // . and ..
+		}
+		flags := rec[25]
+		start := (int64(binary.LittleEndian.Uint32(rec[2:])) + int64(rec[1])) * iso.blockSize
+		size := int64(binary.LittleEndian.Uint32(rec[10:]))
+		if pending != nil {
+			// Later extents of a file larger than 4 GiB
+			rf := pending.content.(*runFile)
+			rf.runs = appendRun(rf.runs, size, start)
+			pending.size += size
+			if flags&0x80 == 0 {
+				pending = nil
+			}
+			continue
+		}
+
+		n := &treeNode{name: string(raw), mtime: isoTime(rec[18:]), mode: 0o444, sys: &scan.SourceSys{}}
+		if iso.joliet {
+			n.name = utf16String(raw, binary.BigEndian)
+		}
+		// Names carry a version, and plain ones a dot when extensionless
+		n.name, _, _ = strings.Cut(n.name, ";")
+		if !iso.joliet {
+			n.name = strings.TrimSuffix(n.name, ".")
+		}
+		if flags&0x02 != 0 {
+			n.mode = fs.ModeDir | 0o555
+		}
+		if iso.rrSkip >= 0 {
+			if err := iso.applyRockRidge(n, rec); err != nil {
+				return err
+			}
+		}
+		n.name = cleanName(n.name)
+		if n.name == "" || n.name == "." || n.name == ".." {
+			continue
+		}
+		p := path.Join(dir, n.name)
+		if n.mode.IsRegular() {
+			n.size = size
+			n.content = &runFile{r: iso.r, runs: appendRun(nil, size, start)}
+			if flags&0x80 != 0 {
+				pending = n
+			}
+		}
+		iso.t.add(p, n, nil)
+		if n.IsDir() {
+			if err := iso.readDir(p, rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyRockRidge reads the NM, PX, SL and TF entries of a record,
+// following CE continuation areas.
+func (iso *isoReader) applyRockRidge(n *treeNode, rec []byte) error {
+	su := systemUse(rec)
+	if len(su) < iso.rrSkip {
+		return nil
+	}
+	su = su[iso.rrSkip:]
+	var name strings.Builder
+	var link symlinkTarget
+	hasName := false
+	for hops := 0; hops < 16; hops++ {
+		var next []byte
+		for len(su) >= 4 {
+			n4 := int(su[2])
+			if n4 < 4 || n4 > len(su) {
+				break
+			}
+			entry := su[:n4]
+			su = su[n4:]
+			switch string(entry[:2]) {
+			case "NM":
+				if len(entry) > 5 && entry[4]&0x06 == 0 {
+					name.Write(entry[5:])
+					hasName = true
+				}
+			case "PX":
+				if len(entry) >= 36 {
+					mode := unixMode(binary.LittleEndian.Uint32(entry[4:]))
+					n.mode = mode
+					n.sys.Owner = &scan.FileOwner{
+						UID: int(binary.LittleEndian.Uint32(entry[20:])),
+						GID: int(binary.LittleEndian.Uint32(entry[28:])),
+					}
+				}
+			case "SL":
+				link.add(entry[5:])
+			case "TF":
+				if len(entry) > 5 && entry[4]&0x02 != 0 {
+					// Modify follows a creation time when one is recorded
+					width := 7
+					if entry[4]&0x80 != 0 {
+						width = 17
+					}
+					off := 5
+					if entry[4]&0x01 != 0 {
+						off += width
+					}
+					if width == 7 && off+7 <= len(entry) {
+						n.mtime = isoTime(entry[off:])
+					}
+				}
+			case "CE":
+				if len(entry) >= 28 {
+					block := int64(binary.LittleEndian.Uint32(entry[4:]))
+					off := int64(binary.LittleEndian.Uint32(entry[12:]))
+					length := binary.LittleEndian.Uint32(entry[20:])
+					next = make([]byte, length)
+					if _, err := iso.r.ReadAt(next, block*iso.blockSize+off); err != nil {
+						return err
+					}
+				}
+			case "ST":
+				su = nil
+			}
+		}
+		if next == nil {
+			break
+		}
+		su = next
+	}
+	if hasName {
+		n.name = name.String()
+	}
+	if n.mode&fs.ModeSymlink != 0 {
+		n.link = link.String()
+	}
+	return nil
+}
+
+// symlinkTarget assembles a link target from SL components.
+type symlinkTarget struct {
+	strings.Builder
+	glue bool // The last component continues in the next
+}
+
+func (l *symlinkTarget) add(comps []byte) {
+	for len(comps) >= 2 {
+		flags, n := comps[0], int(comps[1])
+		if 2+n > len(comps) {
+			return
+		}
+		text := comps[2 : 2+n]
+		comps = comps[2+n:]
+		if l.Len() > 0 && !l.glue && !strings.HasSuffix(l.String(), "/") {
+			l.WriteByte('/')
+		}
+		switch {
+		case flags&0x02 != 0:
+			l.WriteString(".")
+		case flags&0x04 != 0:
+			l.WriteString("..")
+		case flags&0x08 != 0:
+			l.WriteString("/")
+		default:
+			l.Write(text)
+		}
+		l.glue = flags&0x01 != 0
+	}
+}
+
+// isoTime decodes a 7-byte directory record date.
+func isoTime(b []byte) time.Time {
+	if len(b) < 7 || b[1] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone).UTC()
+}
@@ -0,0 +1,65 @@
+package source
+
+import (
+	"io/fs"
+	"path"
+	"sync"
+)
+
+// prefetcher lists the subdirectories of each directory the walker reads
+// in the background, since it will usually ask for them next. Remote
+// sources spend most of a listing waiting on the network, so overlapping
+// them keeps the walk moving.
+type prefetcher struct {
+	list func(dir string) ([]fs.DirEntry, error)
+
+	mu       sync.Mutex
+	listings map[string]*listing
+	slots    chan struct{} // Bounds the listings in flight
+}
+
+// listing is a directory read in the background.
+type listing struct {
+	done    chan struct{}
+	entries []fs.DirEntry
+	err     error
+}
+
+func newPrefetcher(parallel int, list func(dir string) ([]fs.DirEntry, error)) *prefetcher {
+	return &prefetcher{list: list, listings: make(map[string]*listing), slots: make(chan struct{}, parallel)}
+}
+
+// ReadDir returns the listing of dir, fetched ahead of time when possible,
+// and starts fetching its subdirectories.
+func (p *prefetcher) ReadDir(dir string) ([]fs.DirEntry, error) {
+	p.mu.Lock()
+	l, ok := p.listings[dir]
+	delete(p.listings, dir)
+	p.mu.Unlock()
+	var entries []fs.DirEntry
+	var err error
+	if ok {
+		<-l.done
+		entries, err = l.entries, l.err
+	} else {
+		entries, err = p.list(dir)
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		sub := &listing{done: make(chan struct{})}
+		child := path.Join(dir, e.Name())
+		p.mu.Lock()
+		p.listings[child] = sub
+		p.mu.Unlock()
+		go func() {
+			p.slots <- struct{}{}
+			sub.entries, sub.err = p.list(child)
+			<-p.slots
+			close(sub.done)
+		}()
+	}
+	return entries, err
+}
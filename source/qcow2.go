@@ -0,0 +1,196 @@
+package source
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const qcow2Magic = "QFI\xfb"
+
+// qcow2 L2 entry bits.
+const (
+	qcowCompressed = 1 << 62
+	qcowOffsetMask = 0x00fffffffffffe00
+	qcowZero       = 1 // Cluster reads as zeros (version 3)
+)
+
+// qcow2 is the guest view of a qcow2 image. Clusters the image doesn't
+// hold read from the backing image, or as zeros without one.
+type qcow2 struct {
+	f           *os.File
+	clusterBits uint
+	size        int64
+	l1          []uint64
+	backing     io.ReaderAt
+
+	mu       sync.Mutex
+	l2       map[uint64][]uint64 // L2 tables by offset
+	cluster  []byte              // Last decompressed cluster
+	clusterN uint64              // Its L2 entry
+}
+
+// openQcow2 reads a qcow2 header and L1 table. Encrypted images, external
+// data files and compression other than deflate are not supported.
+func (d *Disk) openQcow2(f *os.File, name string, depth int) (io.ReaderAt, error) {
+	hdr := make([]byte, 104)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		return nil, fmt.Errorf("%s: qcow2 header: %w", name, err)
+	}
+	be := binary.BigEndian
+	version := be.Uint32(hdr[4:])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("%s: unsupported qcow2 version %d", name, version)
+	}
+	q := &qcow2{
+		f:           f,
+		clusterBits: uint(be.Uint32(hdr[20:])),
+		size:        int64(be.Uint64(hdr[24:])),
+		l2:          make(map[uint64][]uint64),
+	}
+	if q.clusterBits < 9 || q.clusterBits > 21 {
+		return nil, fmt.Errorf("%s: bad qcow2 cluster size", name)
+	}
+	if be.Uint32(hdr[32:]) != 0 {
+		return nil, fmt.Errorf("%s: encrypted qcow2 images are not supported", name)
+	}
+	if version == 3 {
+		// Incompatible feature bits: 2 is an external data file, 3 a
+		// compression type other than deflate
+		features := be.Uint64(hdr[72:])
+		if features&(1<<2) != 0 {
+			return nil, fmt.Errorf("%s: qcow2 external data files are not supported", name)
+		}
+		if features&(1<<3) != 0 {
+			return nil, fmt.Errorf("%s: zstd compressed qcow2 images are not supported", name)
+		}
+	}
+	l1Size := be.Uint32(hdr[36:])
+	l1 := make([]byte, 8*int64(l1Size))
+	if _, err := f.ReadAt(l1, int64(be.Uint64(hdr[40:]))); err != nil {
+		return nil, fmt.Errorf("%s: qcow2 L1 table: %w", name, err)
+	}
+	q.l1 = make([]uint64, l1Size)
+	for i := range q.l1 {
+		q.l1[i] = be.Uint64(l1[8*i:])
+	}
+
+	if off, n := be.Uint64(hdr[8:]), be.Uint32(hdr[16:]); off != 0 && n > 0 {
+		b := make([]byte, n)
+		if _, err := f.ReadAt(b, int64(off)); err != nil {
+			return nil, fmt.Errorf("%s: qcow2 backing file name: %w", name, err)
+		}
+		backing := string(b)
+		if !filepath.IsAbs(backing) {
+			backing = filepath.Join(filepath.Dir(name), backing)
+		}
+		r, err := d.openImage(backing, depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("%s: backing file: %w", name, err)
+		}
+		q.backing = r
+	}
+	return q, nil
+}
+
+// entry returns the L2 entry of a guest cluster, 0 when unallocated.
+func (q *qcow2) entry(cluster uint64) (uint64, error) {
+	perL2 := uint64(1) << (q.clusterBits - 3)
+	i := cluster / perL2
+	if i >= uint64(len(q.l1)) {
+		return 0, nil
+	}
+	tableOff := q.l1[i] & qcowOffsetMask
+	if tableOff == 0 {
+		return 0, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	table, ok := q.l2[tableOff]
+	if !ok {
+		b := make([]byte, 8*perL2)
+		if _, err := q.f.ReadAt(b, int64(tableOff)); err != nil {
+			return 0, err
+		}
+		table = make([]uint64, perL2)
+		for j := range table {
+			table[j] = binary.BigEndian.Uint64(b[8*j:])
+		}
+		q.l2[tableOff] = table
+	}
+	return table[cluster%perL2], nil
+}
+
+func (q *qcow2) ReadAt(p []byte, off int64) (int, error) {
+	clusterSize := int64(1) << q.clusterBits
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= q.size {
+			return n, io.EOF
+		}
+		within := pos & (clusterSize - 1)
+		chunk := p[n : n+int(min(int64(len(p)-n), clusterSize-within, q.size-pos))]
+		e, err := q.entry(uint64(pos >> q.clusterBits))
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case e&qcowCompressed != 0:
+			data, err := q.decompress(e)
+			if err != nil {
+				return n, err
+			}
+			copy(chunk, data[within:])
+		case e&qcowZero != 0:
+			clear(chunk)
+		case e&qcowOffsetMask != 0:
+			if _, err := q.f.ReadAt(chunk, int64(e&qcowOffsetMask)+within); err != nil {
+				return n, err
+			}
+		case q.backing != nil:
+			// A backing image may be smaller; the rest reads as zeros
+			clear(chunk)
+			if _, err := q.backing.ReadAt(chunk, pos); err != nil && err != io.EOF {
+				return n, err
+			}
+		default:
+			clear(chunk)
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// decompress inflates a compressed cluster, keeping the last one since
+// reads usually continue within it.
+func (q *qcow2) decompress(e uint64) ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.cluster != nil && q.clusterN == e {
+		return q.cluster, nil
+	}
+	// The entry packs the host offset in its low bits and the count of
+	// extra 512-byte sectors holding compressed data above them
+	x := 62 - (q.clusterBits - 8)
+	host := int64(e & (1<<x - 1))
+	sectors := int64((e>>x)&(1<<(q.clusterBits-8)-1)) + 1
+	compressed := make([]byte, sectors*512-(host&511))
+	n, err := q.f.ReadAt(compressed, host)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	data := make([]byte, 1<<q.clusterBits)
+	zr := flate.NewReader(bytes.NewReader(compressed[:n]))
+	if _, err := io.ReadFull(zr, data); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("qcow2: compressed cluster: %w", err)
+	}
+	q.cluster, q.clusterN = data, e
+	return data, nil
+}
@@ -0,0 +1,277 @@
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestTypes are the manifest media types a registry may answer with.
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// registry pulls from one repository of a Docker Registry HTTP API v2
+// server.
+type registry struct {
+	client *http.Client
+	base   string // https://host, or http:// for localhost
+	repo   string
+	user   string
+	pass   string
+	token  string // Bearer token, once the registry asked for one
+}
+
+// parseImageRef splits a reference the way docker does: the first part
+// is a registry when it looks like a host, otherwise the image is on
+// Docker Hub, where official images live under library/.
+func parseImageRef(ref string) (host, repo, tag string) {
+	name, tag := ref, "latest"
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, tag = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	host, repo = "docker.io", name
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		host, repo = first, rest
+	}
+	if host == "docker.io" && !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	return host, repo, tag
+}
+
+// pullImage downloads an image's manifest, config and layers.
+func pullImage(ref string) (*Image, error) {
+	host, repo, tag := parseImageRef(ref)
+	r := &registry{client: &http.Client{}, base: "https://" + host, repo: repo}
+	if host == "docker.io" {
+		r.base = "https://registry-1.docker.io"
+	}
+	if h, _, _ := strings.Cut(host, ":"); h == "localhost" || h == "127.0.0.1" {
+		// Like docker, local registries are plain HTTP
+		r.base = "http://" + host
+	}
+	r.user, r.pass = dockerCredentials(host)
+	if err := r.login(); err != nil {
+		return nil, fmt.Errorf("%s: %w", host, err)
+	}
+
+	var m imageManifest
+	if err := r.getJSON("manifests/"+tag, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	for len(m.Manifests) > 0 {
+		digest, err := m.pick()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ref, err)
+		}
+		m = imageManifest{}
+		if err := r.getJSON("manifests/"+digest, &m); err != nil {
+			return nil, fmt.Errorf("%s: %w", ref, err)
+		}
+	}
+	if m.Config.Digest == "" {
+		return nil, fmt.Errorf("%s: unsupported manifest type %q", ref, m.MediaType)
+	}
+	resp, err := r.get("blobs/"+m.Config.Digest, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: config: %w", ref, err)
+	}
+	config, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	digests := make([]string, len(m.Layers))
+	for i, l := range m.Layers {
+		digests[i] = l.Digest
+	}
+	layers, created, err := layersOf(config, digests)
+	if err != nil {
+		return nil, err
+	}
+
+	img := newImage()
+	img.nodes["/"].mtime = created
+	for i, digest := range digests {
+		if err := r.pullLayer(img, i+1, layers[i], digest); err != nil {
+			return img, fmt.Errorf("%s: layer %s: %w", ref, digest, err)
+		}
+	}
+	return img, nil
+}
+
+// pullLayer downloads a layer blob, checking it against its digest.
+func (r *registry) pullLayer(img *Image, index int, layer imageLayer, digest string) error {
+	resp, err := r.get("blobs/"+digest, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var sum hash.Hash
+	body := io.Reader(resp.Body)
+	if strings.HasPrefix(digest, "sha256:") {
+		sum = sha256.New()
+		body = io.TeeReader(resp.Body, sum)
+	}
+	if err := img.addLayer(index, layer, body, nil); err != nil {
+		return err
+	}
+	if sum != nil {
+		// Unpacking may stop short of the end, e.g. at tar padding
+		// after the archive's end marker
+		io.Copy(io.Discard, body)
+		if got := "sha256:" + hex.EncodeToString(sum.Sum(nil)); got != digest {
+			return fmt.Errorf("digest mismatch: got %s", got)
+		}
+	}
+	return nil
+}
+
+// get fetches a path under /v2/<repo>/.
+func (r *registry) get(p string, accept []string) (*http.Response, error) {
+	return doHTTP(r.client, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, r.base+"/v2/"+r.repo+"/"+p, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range accept {
+			req.Header.Add("Accept", t)
+		}
+		if r.token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		} else if r.user != "" {
+			req.SetBasicAuth(r.user, r.pass)
+		}
+		return req, nil
+	})
+}
+
+func (r *registry) getJSON(p string, v any) error {
+	resp, err := r.get(p, manifestTypes)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// login asks the registry how to authenticate and, for token auth,
+// fetches a pull token for the repository, anonymously when there are no
+// credentials.
+func (r *registry) login() error {
+	resp, err := r.client.Get(r.base + "/v2/")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil
+	}
+	scheme, params, _ := strings.Cut(resp.Header.Get("Www-Authenticate"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		// Basic auth is sent with every request
+		return nil
+	}
+	challenge := parseChallenge(params)
+	q := url.Values{"scope": {"repository:" + r.repo + ":pull"}}
+	if s := challenge["service"]; s != "" {
+		q.Set("service", s)
+	}
+	resp, err = doHTTP(r.client, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, challenge["realm"]+"?"+q.Encode(), nil)
+		if err == nil && r.user != "" {
+			req.SetBasicAuth(r.user, r.pass)
+		}
+		return req, err
+	})
+	if err != nil {
+		return fmt.Errorf("getting a token: %w", err)
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return fmt.Errorf("getting a token: %w", err)
+	}
+	r.token = tok.Token
+	if r.token == "" {
+		r.token = tok.AccessToken
+	}
+	return nil
+}
+
+// parseChallenge parses the key="value" pairs of a WWW-Authenticate
+// header.
+func parseChallenge(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		key, rest, ok := strings.Cut(strings.TrimLeft(s, ", "), "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+		s = rest
+	}
+	return params
+}
+
+// dockerCredentials reads the login docker login stored for host in
+// $DOCKER_CONFIG/config.json or ~/.docker/config.json. Credential
+// helpers are not consulted.
+func dockerCredentials(host string) (user, pass string) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+	var cfg struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(data, &cfg) != nil {
+		return "", ""
+	}
+	keys := []string{host, "https://" + host, "http://" + host}
+	if host == "docker.io" {
+		keys = append(keys, "https://index.docker.io/v1/", "index.docker.io")
+	}
+	for _, k := range keys {
+		if a, ok := cfg.Auths[k]; ok {
+			decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				continue
+			}
+			user, pass, _ = strings.Cut(string(decoded), ":")
+			return user, pass
+		}
+	}
+	return "", ""
+}
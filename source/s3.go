@@ -0,0 +1,164 @@
+package source
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// s3Store lists a bucket through the S3 API, on AWS or any service
+// speaking it.
+type s3Store struct {
+	client   *http.Client
+	endpoint *url.URL // Custom endpoint addressed path-style, or nil for AWS
+	bucket   string
+	region   string
+	creds    awsCredentials
+}
+
+// openS3 opens an s3://bucket/prefix URL. Credentials and region come
+// from the usual AWS variables and files; AWS_ENDPOINT_URL_S3 or
+// AWS_ENDPOINT_URL points it at another S3-compatible service.
+func openS3(u *url.URL) (scan.Source, string, error) {
+	if u.Host == "" {
+		return nil, "", errors.New("s3: URL has no bucket")
+	}
+	s := &s3Store{client: &http.Client{}, bucket: u.Host}
+	s.creds, s.region = loadAWSConfig()
+	for _, name := range []string{"AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"} {
+		if v := os.Getenv(name); v != "" {
+			ep, err := url.Parse(v)
+			if err != nil {
+				return nil, "", fmt.Errorf("s3: %s: %w", name, err)
+			}
+			s.endpoint = ep
+			break
+		}
+	}
+	if s.endpoint == nil {
+		s.findRegion()
+	}
+	return newBucket(s), path.Clean("/" + u.Path), nil
+}
+
+// findRegion asks AWS where the bucket lives, since requests signed for
+// another region are rejected. Any response carries the answer, even a
+// denial.
+func (s *s3Store) findRegion() {
+	resp, err := s.client.Head("https://" + s.bucket + ".s3.amazonaws.com/")
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if r := resp.Header.Get("X-Amz-Bucket-Region"); r != "" {
+		s.region = r
+	}
+}
+
+// objectURL returns the URL of a key, or of the bucket when key is empty.
+func (s *s3Store) objectURL(key string, query url.Values) *url.URL {
+	u := &url.URL{Scheme: "https", Host: s.bucket + ".s3." + s.region + ".amazonaws.com", Path: "/" + key}
+	switch {
+	case s.endpoint != nil:
+		u = &url.URL{Scheme: s.endpoint.Scheme, Host: s.endpoint.Host,
+			Path: strings.TrimSuffix(s.endpoint.Path, "/") + "/" + s.bucket + "/" + key}
+	case strings.Contains(s.bucket, "."):
+		// Dotted names don't match the wildcard certificate
+		u.Host = "s3." + s.region + ".amazonaws.com"
+		u.Path = "/" + s.bucket + "/" + key
+	}
+	u.RawPath = awsEscapePath(u.Path)
+	// Sent exactly as signed
+	u.RawQuery = awsCanonicalQuery(query)
+	return u
+}
+
+func (s *s3Store) do(method, key string, query url.Values) (*http.Response, error) {
+	return doHTTP(s.client, func() (*http.Request, error) {
+		req, err := http.NewRequest(method, s.objectURL(key, query).String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		signV4(req, s.creds, s.region, "s3", time.Now())
+		return req, nil
+	})
+}
+
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+// list makes one ListObjectsV2 call.
+func (s *s3Store) list(prefix, token string, max int) (*objectPage, error) {
+	q := url.Values{"list-type": {"2"}, "delimiter": {"/"}, "prefix": {prefix}}
+	if token != "" {
+		q.Set("continuation-token", token)
+	}
+	if max > 0 {
+		q.Set("max-keys", strconv.Itoa(max))
+	}
+	resp, err := s.do(http.MethodGet, "", q)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res s3ListResult
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("s3: listing %s: %w", prefix, err)
+	}
+	page := &objectPage{}
+	for _, c := range res.Contents {
+		page.objects = append(page.objects, newObjectInfo(c.Key, c.Size, c.LastModified, c.ETag, c.StorageClass))
+	}
+	for _, cp := range res.CommonPrefixes {
+		page.prefixes = append(page.prefixes, cp.Prefix)
+	}
+	if res.IsTruncated {
+		page.next = res.NextContinuationToken
+	}
+	return page, nil
+}
+
+func (s *s3Store) stat(key string) (*objectInfo, error) {
+	resp, err := s.do(http.MethodHead, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	mtime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	class := resp.Header.Get("X-Amz-Storage-Class")
+	if class == "" {
+		// Only sent for the other classes
+		class = "STANDARD"
+	}
+	return newObjectInfo(key, resp.ContentLength, mtime, resp.Header.Get("ETag"), class), nil
+}
+
+func (s *s3Store) get(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
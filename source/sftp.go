@@ -0,0 +1,596 @@
+package source
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// SSHCommand is the ssh client used to reach SFTP servers. Authentication,
+// host keys and proxies come from the user's ssh configuration.
+var SSHCommand = "ssh"
+
+// SFTP packet types (draft-ietf-secsh-filexfer-02, protocol version 3).
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpLstat    = 7
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRealpath = 16
+	sftpReadlink = 19
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+)
+
+// SFTP status codes.
+const (
+	sftpOK               = 0
+	sftpEOF              = 1
+	sftpNoSuchFile       = 2
+	sftpPermissionDenied = 3
+)
+
+const (
+	// sftpChunk is the size of each read request. OpenSSH serves up to
+	// 255KiB, but 32KiB is what every server must accept.
+	sftpChunk = 32 << 10
+
+	// sftpReadAhead is how many reads are in flight per open file, so
+	// hashing runs at bandwidth rather than one round trip per chunk
+	sftpReadAhead = 16
+
+	// sftpPrefetch bounds the subdirectory listings fetched ahead of the
+	// walker
+	sftpPrefetch = 16
+)
+
+// SFTP is a Source reading a remote tree over SFTP. Requests from many
+// goroutines share one connection and are answered out of order, so
+// workers and read-ahead overlap their round trips.
+type SFTP struct {
+	cmd *exec.Cmd
+	w   io.WriteCloser
+
+	wmu sync.Mutex // Serializes packet writes
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan sftpPacket
+	err     error // Set once the connection has failed
+
+	prefetch *prefetcher
+}
+
+type sftpPacket struct {
+	typ  byte
+	data []byte // After the request id
+}
+
+// openSFTP connects to the host of an sftp:// URL. A path starting with
+// /~/ is relative to the remote home directory.
+func openSFTP(u *url.URL) (scan.Source, string, error) {
+	if u.Host == "" {
+		return nil, "", errors.New("sftp: URL has no host")
+	}
+	dest := u.Hostname()
+	if u.User != nil {
+		dest = u.User.Username() + "@" + dest
+	}
+	c, err := DialSFTP(dest, u.Port())
+	if err != nil {
+		return nil, "", err
+	}
+	dir := u.Path
+	switch {
+	case dir == "" || dir == "/~":
+		dir = "."
+	case strings.HasPrefix(dir, "/~/"):
+		dir = dir[3:]
+	}
+	if dir, err = c.realpath(dir); err != nil {
+		c.Close()
+		return nil, "", err
+	}
+	return c, dir, nil
+}
+
+// DialSFTP starts the sftp subsystem on dest ([user@]host) through ssh.
+// An empty port uses the ssh default.
+func DialSFTP(dest, port string) (*SFTP, error) {
+	args := []string{"-s"}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	cmd := exec.Command(SSHCommand, append(args, "--", dest, "sftp")...)
+	cmd.Stderr = os.Stderr
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("sftp: starting %s: %w", SSHCommand, err)
+	}
+	c := &SFTP{cmd: cmd, w: w, pending: make(map[uint32]chan sftpPacket)}
+	c.prefetch = newPrefetcher(sftpPrefetch, c.readDir)
+
+	br := bufio.NewReaderSize(r, 64<<10)
+	var hello [9]byte
+	binary.BigEndian.PutUint32(hello[:], 5)
+	hello[4] = sftpInit
+	binary.BigEndian.PutUint32(hello[5:], 3)
+	if _, err := w.Write(hello[:]); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("sftp: %w", err)
+	}
+	typ, _, err := readPacket(br)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("sftp: handshake with %s: %w", dest, err)
+	}
+	if typ != sftpVersion {
+		c.Close()
+		return nil, fmt.Errorf("sftp: handshake with %s: unexpected packet %d", dest, typ)
+	}
+	go c.readLoop(br)
+	return c, nil
+}
+
+// Close ends the session and waits for ssh to exit.
+func (c *SFTP) Close() error {
+	c.w.Close()
+	return c.cmd.Wait()
+}
+
+// readPacket reads one length-prefixed packet.
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 1 || n > 1<<24 {
+		return 0, nil, fmt.Errorf("bad packet length %d", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return body[0], body[1:], nil
+}
+
+// readLoop hands each response to the request waiting for its id.
+func (c *SFTP) readLoop(r io.Reader) {
+	for {
+		typ, body, err := readPacket(r)
+		if err == nil && len(body) < 4 {
+			err = fmt.Errorf("short packet of type %d", typ)
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			c.fail(fmt.Errorf("sftp: connection lost: %w", err))
+			return
+		}
+		id := binary.BigEndian.Uint32(body)
+		c.mu.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- sftpPacket{typ: typ, data: body[4:]}
+		}
+	}
+}
+
+// fail fails every outstanding and future request with err.
+func (c *SFTP) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// send writes a request and returns the channel its response arrives on.
+func (c *SFTP) send(typ byte, body []byte) (<-chan sftpPacket, error) {
+	ch := make(chan sftpPacket, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	id := c.nextID
+	c.nextID++
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	pkt := make([]byte, 9, 9+len(body))
+	binary.BigEndian.PutUint32(pkt, uint32(5+len(body)))
+	pkt[4] = typ
+	binary.BigEndian.PutUint32(pkt[5:], id)
+	c.wmu.Lock()
+	_, err := c.w.Write(append(pkt, body...))
+	c.wmu.Unlock()
+	if err != nil {
+		c.fail(fmt.Errorf("sftp: connection lost: %w", err))
+	}
+	return ch, nil
+}
+
+// wait returns the response arriving on ch.
+func (c *SFTP) wait(ch <-chan sftpPacket) (sftpPacket, error) {
+	p, ok := <-ch
+	if !ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return p, c.err
+	}
+	return p, nil
+}
+
+// call sends a request and waits for its response.
+func (c *SFTP) call(typ byte, body []byte) (sftpPacket, error) {
+	ch, err := c.send(typ, body)
+	if err != nil {
+		return sftpPacket{}, err
+	}
+	return c.wait(ch)
+}
+
+// expect checks that a response has the wanted type, turning status
+// responses into errors for op on p.
+func expect(resp sftpPacket, typ byte, op, p string) (*decoder, error) {
+	d := &decoder{buf: resp.data}
+	if resp.typ == typ {
+		return d, nil
+	}
+	if resp.typ != sftpStatus {
+		return nil, fmt.Errorf("sftp: %s %s: unexpected packet %d", op, p, resp.typ)
+	}
+	code, msg := d.u32(), d.str()
+	var err error
+	switch code {
+	case sftpEOF:
+		err = io.EOF
+	case sftpNoSuchFile:
+		err = fs.ErrNotExist
+	case sftpPermissionDenied:
+		err = fs.ErrPermission
+	default:
+		err = fmt.Errorf("sftp status %d: %s", code, msg)
+	}
+	return nil, &fs.PathError{Op: op, Path: p, Err: err}
+}
+
+// Lstat implements scan.Source.
+func (c *SFTP) Lstat(p string) (fs.FileInfo, error) {
+	resp, err := c.call(sftpLstat, appendString(nil, p))
+	if err != nil {
+		return nil, err
+	}
+	d, err := expect(resp, sftpAttrs, "lstat", p)
+	if err != nil {
+		return nil, err
+	}
+	info := d.attrs(path.Base(p))
+	return info, d.err
+}
+
+// Readlink implements scan.Source.
+func (c *SFTP) Readlink(p string) (string, error) {
+	return c.name(sftpReadlink, "readlink", p)
+}
+
+func (c *SFTP) realpath(p string) (string, error) {
+	return c.name(sftpRealpath, "realpath", p)
+}
+
+// name makes a request answered with a single name.
+func (c *SFTP) name(typ byte, op, p string) (string, error) {
+	resp, err := c.call(typ, appendString(nil, p))
+	if err != nil {
+		return "", err
+	}
+	d, err := expect(resp, sftpName, op, p)
+	if err != nil {
+		return "", err
+	}
+	if d.u32() < 1 {
+		return "", &fs.PathError{Op: op, Path: p, Err: errors.New("empty reply")}
+	}
+	name := d.str()
+	return name, d.err
+}
+
+// ReadDir implements scan.Source.
+func (c *SFTP) ReadDir(p string) ([]fs.DirEntry, error) {
+	return c.prefetch.ReadDir(p)
+}
+
+func (c *SFTP) readDir(p string) ([]fs.DirEntry, error) {
+	resp, err := c.call(sftpOpendir, appendString(nil, p))
+	if err != nil {
+		return nil, err
+	}
+	d, err := expect(resp, sftpHandle, "opendir", p)
+	if err != nil {
+		return nil, err
+	}
+	handle := d.str()
+	defer c.closeHandle(handle)
+
+	var entries []fs.DirEntry
+	for {
+		resp, err := c.call(sftpReaddir, appendString(nil, handle))
+		if err != nil {
+			return entries, err
+		}
+		d, err := expect(resp, sftpName, "readdir", p)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return entries, err
+		}
+		for n := d.u32(); n > 0 && d.err == nil; n-- {
+			name := d.str()
+			d.str() // longname, an ls -l line meant for people
+			info := d.attrs(name)
+			if name != "." && name != ".." {
+				entries = append(entries, fs.FileInfoToDirEntry(info))
+			}
+		}
+		if d.err != nil {
+			return entries, &fs.PathError{Op: "readdir", Path: p, Err: d.err}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// closeHandle releases a file or directory handle without waiting.
+func (c *SFTP) closeHandle(handle string) {
+	c.send(sftpClose, appendString(nil, handle))
+}
+
+// Open implements scan.Source.
+func (c *SFTP) Open(p string) (io.ReadCloser, error) {
+	body := appendString(nil, p)
+	body = binary.BigEndian.AppendUint32(body, 1) // SSH_FXF_READ
+	body = binary.BigEndian.AppendUint32(body, 0) // No attributes
+	resp, err := c.call(sftpOpen, body)
+	if err != nil {
+		return nil, err
+	}
+	d, err := expect(resp, sftpHandle, "open", p)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{c: c, path: p, handle: d.str()}, nil
+}
+
+// sftpFile reads a remote file with several reads in flight.
+type sftpFile struct {
+	c      *SFTP
+	path   string
+	handle string
+	next   uint64 // Offset of the next read to request
+	queue  []sftpReadReq
+	buf    []byte
+	eof    bool
+	err    error
+}
+
+type sftpReadReq struct {
+	offset uint64
+	size   uint32
+	ch     <-chan sftpPacket
+}
+
+func (f *sftpFile) request(offset uint64, size uint32) sftpReadReq {
+	body := appendString(nil, f.handle)
+	body = binary.BigEndian.AppendUint64(body, offset)
+	body = binary.BigEndian.AppendUint32(body, size)
+	ch, err := f.c.send(sftpRead, body)
+	if err != nil {
+		f.err = err
+	}
+	return sftpReadReq{offset: offset, size: size, ch: ch}
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+		if f.eof {
+			return 0, io.EOF
+		}
+		for len(f.queue) < sftpReadAhead && f.err == nil {
+			f.queue = append(f.queue, f.request(f.next, sftpChunk))
+			f.next += sftpChunk
+		}
+		if f.err != nil {
+			return 0, f.err
+		}
+		req := f.queue[0]
+		f.queue = f.queue[1:]
+		resp, err := f.c.wait(req.ch)
+		if err != nil {
+			f.err = err
+			return 0, err
+		}
+		d, err := expect(resp, sftpData, "read", f.path)
+		if errors.Is(err, io.EOF) {
+			f.eof = true
+			continue
+		}
+		if err != nil {
+			f.err = err
+			return 0, err
+		}
+		f.buf = []byte(d.str())
+		if got := uint32(len(f.buf)); got < req.size && got > 0 {
+			// Servers may return less than asked; fetch the gap before
+			// the reads already queued behind it
+			f.queue = append([]sftpReadReq{f.request(req.offset+uint64(got), req.size-got)}, f.queue...)
+		}
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// Close drains the reads still in flight and releases the handle.
+func (f *sftpFile) Close() error {
+	for _, req := range f.queue {
+		if req.ch != nil {
+			f.c.wait(req.ch)
+		}
+	}
+	f.queue = nil
+	f.c.closeHandle(f.handle)
+	return nil
+}
+
+// sftpInfo is the fs.FileInfo of a remote file.
+type sftpInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+	owner *scan.FileOwner
+}
+
+func (i *sftpInfo) Name() string       { return i.name }
+func (i *sftpInfo) Size() int64        { return i.size }
+func (i *sftpInfo) Mode() fs.FileMode  { return i.mode }
+func (i *sftpInfo) ModTime() time.Time { return i.mtime }
+func (i *sftpInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *sftpInfo) Sys() any           { return i.owner }
+
+// decoder reads SFTP wire fields, remembering the first error.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || len(d.buf) < n {
+		d.err = errors.New("sftp: truncated packet")
+		return make([]byte, n)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) u32() uint32 { return binary.BigEndian.Uint32(d.take(4)) }
+func (d *decoder) u64() uint64 { return binary.BigEndian.Uint64(d.take(8)) }
+
+func (d *decoder) str() string {
+	n := d.u32()
+	if d.err != nil || uint32(len(d.buf)) < n {
+		d.err = errors.New("sftp: truncated packet")
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+// Attribute flags.
+const (
+	attrSize        = 0x1
+	attrUIDGID      = 0x2
+	attrPermissions = 0x4
+	attrACModTime   = 0x8
+	attrExtended    = 0x80000000
+)
+
+// attrs decodes an ATTRS structure into the FileInfo of name.
+func (d *decoder) attrs(name string) *sftpInfo {
+	info := &sftpInfo{name: name}
+	flags := d.u32()
+	if flags&attrSize != 0 {
+		info.size = int64(d.u64())
+	}
+	if flags&attrUIDGID != 0 {
+		info.owner = &scan.FileOwner{UID: int(d.u32()), GID: int(d.u32())}
+	}
+	if flags&attrPermissions != 0 {
+		info.mode = unixMode(d.u32())
+	}
+	if flags&attrACModTime != 0 {
+		d.u32() // atime
+		info.mtime = time.Unix(int64(d.u32()), 0)
+	}
+	if flags&attrExtended != 0 {
+		for n := d.u32(); n > 0 && d.err == nil; n-- {
+			d.str()
+			d.str()
+		}
+	}
+	return info
+}
+
+// unixMode converts st_mode bits to an fs.FileMode.
+func unixMode(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0o777)
+	switch m & 0o170000 {
+	case 0o040000:
+		mode |= fs.ModeDir
+	case 0o120000:
+		mode |= fs.ModeSymlink
+	case 0o010000:
+		mode |= fs.ModeNamedPipe
+	case 0o140000:
+		mode |= fs.ModeSocket
+	case 0o060000:
+		mode |= fs.ModeDevice
+	case 0o020000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	}
+	if m&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
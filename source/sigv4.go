@@ -0,0 +1,201 @@
+package source
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsCredentials are the keys requests are signed with. An empty access
+// key means anonymous requests, which public buckets accept.
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// loadAWSConfig finds credentials and a region the way the AWS CLI does
+// for static keys: environment variables first, then the AWS_PROFILE (or
+// default) profile in ~/.aws/credentials and ~/.aws/config.
+func loadAWSConfig() (awsCredentials, string) {
+	creds := awsCredentials{
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	home, _ := os.UserHomeDir()
+	if creds.accessKey == "" {
+		path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+		if path == "" {
+			path = filepath.Join(home, ".aws", "credentials")
+		}
+		keys := readINISection(path, profile)
+		creds = awsCredentials{
+			accessKey:    keys["aws_access_key_id"],
+			secretKey:    keys["aws_secret_access_key"],
+			sessionToken: keys["aws_session_token"],
+		}
+	}
+	if region == "" {
+		path := os.Getenv("AWS_CONFIG_FILE")
+		if path == "" {
+			path = filepath.Join(home, ".aws", "config")
+		}
+		section := "profile " + profile
+		if profile == "default" {
+			section = profile
+		}
+		region = readINISection(path, section)["region"]
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return creds, region
+}
+
+// readINISection returns the keys of one [section] of an INI file, or nil
+// when the file or section is missing.
+func readINISection(path, section string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var keys map[string]string
+	in := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			in = strings.TrimSpace(strings.Trim(line, "[]")) == section
+		case in:
+			if k, v, ok := strings.Cut(line, "="); ok {
+				if keys == nil {
+					keys = make(map[string]string)
+				}
+				keys[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			}
+		}
+	}
+	return keys
+}
+
+// emptySHA256 is the payload hash of a request without a body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signV4 signs a body-less request with AWS Signature Version 4.
+func signV4(req *http.Request, creds awsCredentials, region, service string, now time.Time) {
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if creds.accessKey == "" {
+		return
+	}
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := []string{"host"}
+	for name := range req.Header {
+		headers = append(headers, strings.ToLower(name))
+	}
+	sort.Strings(headers)
+	var canonHeaders strings.Builder
+	for _, name := range headers {
+		value := req.Host
+		if value == "" {
+			value = req.URL.Host
+		}
+		if name != "host" {
+			value = strings.Join(req.Header.Values(name), ",")
+		}
+		canonHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signed := strings.Join(headers, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		awsEscapePath(req.URL.EscapedPath()),
+		awsCanonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		emptySHA256,
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.accessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape percent-encodes everything but the unreserved characters, as
+// SigV4 requires.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+// awsEscapePath re-encodes an escaped URL path segment by segment.
+func awsEscapePath(escaped string) string {
+	if escaped == "" {
+		return "/"
+	}
+	parts := strings.Split(escaped, "/")
+	for i, p := range parts {
+		if u, err := url.PathUnescape(p); err == nil {
+			parts[i] = awsEscape(u)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func awsCanonicalQuery(q url.Values) string {
+	var pairs []string
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
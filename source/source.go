@@ -0,0 +1,81 @@
+// Package source opens remote trees named by URLs, such as
+// sftp://user@host/path, as scan.Source values the scanner can walk.
+package source
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// RemoteWorkers is the default worker count for remote sources. Workers
+// there mostly wait on round trips rather than CPU, so more of them keep
+// the connection busy.
+const RemoteWorkers = 32
+
+// IsURL reports whether a scan argument names a remote source rather than
+// a local directory.
+func IsURL(arg string) bool {
+	scheme, _, ok := strings.Cut(arg, "://")
+	return ok && scheme != "" && !strings.ContainsAny(scheme, `/\`)
+}
+
+// Open connects to the source named by rawURL. It returns the source and
+// the directory to walk within it. Sources holding a connection implement
+// io.Closer; see Close.
+func Open(rawURL string) (scan.Source, string, error) {
+	// Image references and local paths are not URLs: docker://alpine:3.19
+	// has no port, git://../repo#main and disk://sda.img no host
+	if ref, ok := strings.CutPrefix(rawURL, "docker://"); ok {
+		return openImage(ref)
+	}
+	if spec, ok := strings.CutPrefix(rawURL, "git://"); ok {
+		return openGit(spec)
+	}
+	if name, ok := strings.CutPrefix(rawURL, "disk://"); ok {
+		return openDisk(name)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	switch u.Scheme {
+	case "sftp":
+		return openSFTP(u)
+	case "s3":
+		return openS3(u)
+	case "gs":
+		return openGCS(u)
+	case "azblob":
+		return openAzure(u)
+	case "dav", "davs":
+		return openWebDAV(u)
+	case "ftp", "ftps", "ftpes":
+		return openFTP(u)
+	}
+	return nil, "", fmt.Errorf("unsupported source %q (want sftp, s3, gs, azblob, dav, davs, ftp, ftps, ftpes, docker, git or disk)", u.Scheme)
+}
+
+// Redact removes a password from a source URL, so it can be recorded.
+func Redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); !ok {
+		return rawURL
+	}
+	u.User = url.User(u.User.Username())
+	return u.String()
+}
+
+// Close releases the connection of a source returned by Open.
+func Close(src scan.Source) error {
+	if c, ok := src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package source
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// tree is an in-memory directory tree, for sources that read their whole
+// listing up front, such as container images and git commits. It serves
+// Lstat, ReadDir and Readlink, and Open for files whose content it holds.
+type tree struct {
+	nodes map[string]*treeNode
+}
+
+// treeNode is a file or directory of a tree.
+type treeNode struct {
+	name     string
+	size     int64
+	mode     fs.FileMode
+	mtime    time.Time
+	sys      *scan.SourceSys
+	link     string
+	content  io.ReaderAt // File data, when the source keeps it locally
+	children []fs.DirEntry
+	err      error // Why a directory can't be listed
+}
+
+func (n *treeNode) Name() string       { return n.name }
+func (n *treeNode) Size() int64        { return n.size }
+func (n *treeNode) Mode() fs.FileMode  { return n.mode }
+func (n *treeNode) ModTime() time.Time { return n.mtime }
+func (n *treeNode) IsDir() bool        { return n.mode.IsDir() }
+func (n *treeNode) Sys() any           { return n.sys }
+
+func newTree() *tree {
+	t := &tree{nodes: make(map[string]*treeNode)}
+	t.nodes["/"] = &treeNode{name: "/", mode: fs.ModeDir | 0o755, sys: &scan.SourceSys{}}
+	return t
+}
+
+// add stores a node, creating missing parents, which tar layers need not
+// list. A node already present, such as a parent created for an earlier
+// entry, takes the new one's metadata and keeps its children. add
+// returns the stored node.
+func (t *tree) add(p string, n *treeNode, values scan.SourceValues) *treeNode {
+	if old, ok := t.nodes[p]; ok {
+		n.children = old.children
+		*old = *n
+		return old
+	}
+	t.nodes[p] = n
+	parent := path.Dir(p)
+	if _, ok := t.nodes[parent]; !ok {
+		t.add(parent, &treeNode{name: path.Base(parent), mode: fs.ModeDir | 0o755, sys: &scan.SourceSys{Values: values}}, values)
+	}
+	t.nodes[parent].children = append(t.nodes[parent].children, fs.FileInfoToDirEntry(n))
+	return n
+}
+
+// mkdir returns the directory at p, adding it when missing.
+func (t *tree) mkdir(p string) *treeNode {
+	if n, ok := t.nodes[p]; ok {
+		return n
+	}
+	return t.add(p, &treeNode{name: path.Base(p), mode: fs.ModeDir | 0o755, sys: &scan.SourceSys{}}, nil)
+}
+
+// finish sorts directory listings once every node is in.
+func (t *tree) finish() {
+	for _, n := range t.nodes {
+		sort.Slice(n.children, func(i, j int) bool { return n.children[i].Name() < n.children[j].Name() })
+	}
+}
+
+func (t *tree) node(op, p string) (*treeNode, error) {
+	n, ok := t.nodes[p]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+// Lstat implements scan.Source.
+func (t *tree) Lstat(p string) (fs.FileInfo, error) {
+	return t.node("lstat", p)
+}
+
+// ReadDir implements scan.Source.
+func (t *tree) ReadDir(p string) ([]fs.DirEntry, error) {
+	n, err := t.node("readdir", p)
+	if err != nil {
+		return nil, err
+	}
+	if !n.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: p, Err: errors.New("not a directory")}
+	}
+	if n.err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: p, Err: n.err}
+	}
+	return n.children, nil
+}
+
+// Open implements scan.Source.
+func (t *tree) Open(p string) (io.ReadCloser, error) {
+	n, err := t.node("open", p)
+	if err != nil {
+		return nil, err
+	}
+	if n.content == nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: errors.ErrUnsupported}
+	}
+	return io.NopCloser(io.NewSectionReader(n.content, 0, n.size)), nil
+}
+
+// Readlink implements scan.Source.
+func (t *tree) Readlink(p string) (string, error) {
+	n, err := t.node("readlink", p)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: p, Err: errors.New("not a symlink")}
+	}
+	return n.link, nil
+}
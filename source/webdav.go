@@ -0,0 +1,194 @@
+package source
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// davPrefetch bounds the collection listings fetched ahead of the walker.
+const davPrefetch = 8
+
+// davProps is the PROPFIND body asking for what the scanner records.
+const davProps = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/><getetag/></prop></propfind>`
+
+// WebDAV is a Source reading a WebDAV server with PROPFIND requests, one
+// per collection.
+type WebDAV struct {
+	client   *http.Client
+	base     *url.URL // Server root; source paths are its paths
+	user     string
+	password string
+	prefetch *prefetcher
+}
+
+// openWebDAV opens a dav:// (HTTP) or davs:// (HTTPS) URL. The password
+// is taken from the URL or DAV_PASSWORD.
+func openWebDAV(u *url.URL) (scan.Source, string, error) {
+	if u.Host == "" {
+		return nil, "", errors.New("webdav: URL has no host")
+	}
+	scheme := "https"
+	if u.Scheme == "dav" {
+		scheme = "http"
+	}
+	// Redirects are followed by hand, since clients turn them into GETs
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	d := &WebDAV{client: client, base: &url.URL{Scheme: scheme, Host: u.Host}}
+	if u.User != nil {
+		d.user = u.User.Username()
+		d.password, _ = u.User.Password()
+		if d.password == "" {
+			d.password = os.Getenv("DAV_PASSWORD")
+		}
+	}
+	d.prefetch = newPrefetcher(davPrefetch, d.readDir)
+	return d, path.Clean("/" + u.Path), nil
+}
+
+// Columns implements scan.ColumnSource.
+func (d *WebDAV) Columns() []scan.Column {
+	return []scan.Column{scan.SourceColumn("etag")}
+}
+
+func (d *WebDAV) do(method, p string, depth string) (*http.Response, error) {
+	u := *d.base
+	u.Path = p
+	return doHTTP(d.client, func() (*http.Request, error) {
+		var body io.Reader
+		if method == "PROPFIND" {
+			body = strings.NewReader(davProps)
+		}
+		req, err := http.NewRequest(method, u.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		if depth != "" {
+			req.Header.Set("Depth", depth)
+			req.Header.Set("Content-Type", "application/xml")
+		}
+		if d.user != "" {
+			req.SetBasicAuth(d.user, d.password)
+		}
+		return req, nil
+	})
+}
+
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+				ETag          string `xml:"getetag"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// propfind describes p, and with depth 1 the members of a collection.
+// Each info is named by its full path.
+func (d *WebDAV) propfind(p, depth string) ([]*objectInfo, error) {
+	resp, err := d.do("PROPFIND", p, depth)
+	var he *httpError
+	if errors.As(err, &he) && he.status/100 == 3 && !strings.HasSuffix(p, "/") {
+		// Servers redirect collections to their slash form
+		return d.propfind(p+"/", depth)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("webdav: %s: %w", p, err)
+	}
+	var infos []*objectInfo
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		info := &objectInfo{name: path.Clean("/" + href.Path), mode: 0o644, values: scan.SourceValues{}}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200") {
+				continue
+			}
+			if ps.Prop.ResourceType.Collection != nil {
+				info.mode = fs.ModeDir | 0o755
+			}
+			info.size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			info.mtime, _ = http.ParseTime(ps.Prop.LastModified)
+			info.values["etag"] = strings.Trim(strings.TrimPrefix(ps.Prop.ETag, "W/"), `"`)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// ReadDir implements scan.Source.
+func (d *WebDAV) ReadDir(p string) ([]fs.DirEntry, error) {
+	return d.prefetch.ReadDir(p)
+}
+
+func (d *WebDAV) readDir(p string) ([]fs.DirEntry, error) {
+	infos, err := d.propfind(strings.TrimSuffix(p, "/")+"/", "1")
+	if err != nil {
+		return nil, &fs.PathError{Op: "propfind", Path: p, Err: err}
+	}
+	var entries []fs.DirEntry
+	for _, info := range infos {
+		// The collection itself is listed among its members
+		if info.name == p || path.Dir(info.name) != p {
+			continue
+		}
+		info.name = path.Base(info.name)
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// Lstat implements scan.Source.
+func (d *WebDAV) Lstat(p string) (fs.FileInfo, error) {
+	infos, err := d.propfind(p, "0")
+	if err != nil {
+		return nil, &fs.PathError{Op: "propfind", Path: p, Err: err}
+	}
+	if len(infos) == 0 {
+		return nil, &fs.PathError{Op: "propfind", Path: p, Err: fs.ErrNotExist}
+	}
+	infos[0].name = path.Base(p)
+	return infos[0], nil
+}
+
+// Open implements scan.Source.
+func (d *WebDAV) Open(p string) (io.ReadCloser, error) {
+	resp, err := d.do(http.MethodGet, p, "")
+	if err != nil {
+		return nil, &fs.PathError{Op: "get", Path: p, Err: err}
+	}
+	return resp.Body, nil
+}
+
+// Readlink implements scan.Source. WebDAV has no links.
+func (d *WebDAV) Readlink(p string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: p, Err: errors.ErrUnsupported}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pcoelho00/read_file_paths/scan"
+)
+
+// printSummary writes the end-of-scan statistics block.
+func printSummary(w io.Writer, res scan.Result) {
+	st := res.Stats
+	if res.ScanID != "" {
+		fmt.Fprintf(w, "  Scan ID:      %s\n", res.ScanID)
+	}
+	fmt.Fprintf(w, "  Files:        %d\n", st.Files)
+	fmt.Fprintf(w, "  Directories:  %d\n", st.Dirs)
+	if st.BytesKnown {
+		fmt.Fprintf(w, "  Total size:   %s (%d bytes)\n", scan.FormatBytes(st.Bytes), st.Bytes)
+		if st.HardLinks > 0 {
+			fmt.Fprintf(w, "  Unique size:  %s (%d bytes, %d extra hard links not counted)\n", scan.FormatBytes(st.UniqueBytes), st.UniqueBytes, st.HardLinks)
+		}
+	} else {
+		fmt.Fprintf(w, "  Total size:   not collected (add size to --columns)\n")
+	}
+	fmt.Fprintf(w, "  Path length:  min %d / avg %.1f / max %d\n", st.MinPathLen, st.AvgPathLen(), st.MaxPathLen)
+	if len(res.Skipped) > 0 {
+		fmt.Fprintf(w, "  Skipped:      %d (%s)\n", st.Skipped, formatClassCounts(res.Skipped))
+	} else {
+		fmt.Fprintf(w, "  Skipped:      %d\n", st.Skipped)
+	}
+	if st.Truncated > 0 {
+		fmt.Fprintf(w, "  Truncated:    %d (over --max-per-dir)\n", st.Truncated)
+	}
+	fmt.Fprintf(w, "  Elapsed:      %s (%.0f files/s)\n", st.Elapsed.Round(time.Millisecond), st.FilesPerSecond())
+}
+
+// writeSummaryJSON writes the scan summary to path atomically.
+func writeSummaryJSON(path string, summary scanSummary) error {
+	return writeJSONFile(path, summary)
+}
+
+// writeJSONFile writes v as indented JSON to path atomically.
+func writeJSONFile(path string, v any) error {
+	f, err := scan.CreateAtomic(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
+
+// writeSchema writes a schema of the scanner's columns to path atomically.
+func writeSchema(path, format string, scanner *scan.Scanner) error {
+	columns, err := scanner.Columns()
+	if err != nil {
+		return err
+	}
+	f, err := scan.CreateAtomic(path)
+	if err != nil {
+		return err
+	}
+	if err := scan.WriteSchema(f, format, columns, schemaVersion); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
+
+// scanSummaryExt names the machine-readable summary written next to an
+// output, as the daemon names its job summaries.
+const scanSummaryExt = ".summary.json"
+
+// scanSummary is the machine-readable record of a scan: how it went, what
+// it skipped and why, where the time went, and how it was configured.
+type scanSummary struct {
+	Status string `json:"status"` // "ok" or "failed"
+	scan.Result
+	Roots      []string          `json:"roots"`
+	Output     string            `json:"output"`
+	ErrorsFile string            `json:"errors_file,omitempty"`
+	Config     map[string]string `json:"config,omitempty"` // The summaryConfigFlags, set or defaulted
+}
+
+// summaryConfigFlags are the scan flags recorded in the summary's config:
+// those shaping what was scanned and collected. Flags that can carry
+// credentials, commands or key material, such as --notify-url, --exec and
+// --encrypt, are left out.
+var summaryConfigFlags = []string{
+	"workers", "order", "hash", "format", "columns", "deterministic",
+	"archives", "no-stat", "no-hydrate", "retries", "invalid-utf8",
+	"errors-format", "realpath", "clean", "relative", "transform", "detect",
+	"exclude", "trash", "skip-mac-files", "name", "include-dirs",
+	"include-snapshots", "aliases", "skip-fstype", "max-per-dir", "first",
+	"action", "dry-run", "safe-csv", "cache-dirs", "report",
+}
+
+func newScanSummary(res scan.Result, roots []string, output, errorsFile string, config map[string]string) scanSummary {
+	summary := scanSummary{Status: "ok", Result: res, Roots: roots, Output: output, ErrorsFile: errorsFile, Config: config}
+	if res.Error != "" {
+		summary.Status = "failed"
+	}
+	return summary
+}
+
+// summaryConfig returns the summaryConfigFlags of flags with their values.
+func summaryConfig(flags *flag.FlagSet) map[string]string {
+	config := make(map[string]string)
+	for _, name := range summaryConfigFlags {
+		if f := flags.Lookup(name); f != nil {
+			config[name] = f.Value.String()
+		}
+	}
+	return config
+}
+
+// classified renders a skip reason or scan error with its errors manifest
+// class, e.g. "open /srv/x: permission denied [permission]", unless it
+// has none more telling than "error".
+func classified(err error) string {
+	if class := scan.ErrorClass(err); class != "error" {
+		return fmt.Sprintf("%v [%s]", err, class)
+	}
+	return err.Error()
+}